@@ -18,12 +18,17 @@ import (
 	"time"
 
 	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/relabel"
 	"github.com/prometheus/prometheus/promql/parser"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/util/httputil"
+	"github.com/prometheus/prometheus/util/stats"
+	"github.com/timescale/promscale/pkg/limits"
 	"github.com/timescale/promscale/pkg/log"
 	pgmodel "github.com/timescale/promscale/pkg/pgmodel/model"
+	"github.com/timescale/promscale/pkg/pgmodel/querier"
 	"github.com/timescale/promscale/pkg/promql"
+	"github.com/timescale/promscale/pkg/querycache"
 	"github.com/timescale/promscale/pkg/tenancy"
 )
 
@@ -92,6 +97,16 @@ type Config struct {
 	Auth         *Auth
 	MultiTenancy tenancy.Authorizer
 
+	// WriteRelabelConfigs are applied to all the incoming series. They can
+	// only be set from the config file.
+	WriteRelabelConfigs []*relabel.Config
+	// IngestLimiter rejects the series exceeding the ingest limits, nil if
+	// no limit is configured.
+	IngestLimiter *limits.IngestLimiter
+	// QueryCache caches the results of range queries, nil if the cache is
+	// disabled.
+	QueryCache *querycache.Cache
+
 	// PromQL configuration.
 	EnableFeatures       string
 	EnabledFeaturesList  []string
@@ -180,8 +195,44 @@ func setResponseHeaders(w http.ResponseWriter, samples *promql.Result, isExempla
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func respondQuery(w http.ResponseWriter, res *promql.Result, warnings storage.Warnings) {
+// queryStats are the statistics returned along with the result of a query, if
+// requested with the stats or explain parameters.
+type queryStats struct {
+	*stats.QueryStats
+	Selectors []querier.SelectorStats `json:"selectors"`
+}
+
+// parseQueryStats returns the collector of the statistics of the query if they
+// are requested, nil otherwise. Like in Prometheus, any value of the stats
+// parameter requests them.
+func parseQueryStats(r *http.Request) (*querier.QueryStats, error) {
+	explain := false
+	if v := r.FormValue("explain"); v != "" {
+		var err error
+		if explain, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("invalid explain parameter %q: %w", v, err)
+		}
+	}
+	if !explain && r.FormValue("stats") == "" {
+		return nil, nil
+	}
+	return querier.NewQueryStats(explain), nil
+}
+
+func newQueryStats(qry promql.Query, qs *querier.QueryStats) *queryStats {
+	if qs == nil {
+		return nil
+	}
+	return &queryStats{QueryStats: stats.NewQueryStats(qry.Stats()), Selectors: qs.Selectors()}
+}
+
+func respondQuery(w http.ResponseWriter, res *promql.Result, warnings storage.Warnings, qs *queryStats) {
 	setResponseHeaders(w, res, false, warnings)
+	if qs != nil {
+		// The statistics are rare enough not to need the streaming marshalers.
+		respondQueryData(w, res, qs)
+		return
+	}
 	switch resVal := res.Value.(type) {
 	case promql.Vector:
 		warnings := make([]string, 0, len(res.Warnings))
@@ -196,18 +247,23 @@ func respondQuery(w http.ResponseWriter, res *promql.Result, warnings storage.Wa
 		}
 		_ = marshalMatrixResponse(w, resVal, warnings)
 	default:
-		resp := &response{
-			Status: "success",
-			Data: &queryData{
-				ResultType: res.Value.Type(),
-				Result:     res.Value,
-			},
-		}
-		for _, warn := range res.Warnings {
-			resp.Warnings = append(resp.Warnings, warn.Error())
-		}
-		_ = json.NewEncoder(w).Encode(resp)
+		respondQueryData(w, res, nil)
+	}
+}
+
+func respondQueryData(w http.ResponseWriter, res *promql.Result, qs *queryStats) {
+	resp := &response{
+		Status: "success",
+		Data: &queryData{
+			ResultType: res.Value.Type(),
+			Result:     res.Value,
+			Stats:      qs,
+		},
+	}
+	for _, warn := range res.Warnings {
+		resp.Warnings = append(resp.Warnings, warn.Error())
 	}
+	_ = json.NewEncoder(w).Encode(resp)
 }
 
 func respondExemplar(w http.ResponseWriter, data []pgmodel.ExemplarQueryResult) {
@@ -276,6 +332,7 @@ type response struct {
 type queryData struct {
 	ResultType parser.ValueType `json:"resultType"`
 	Result     parser.Value     `json:"result"`
+	Stats      *queryStats      `json:"stats,omitempty"`
 }
 
 func marshalMatrixResponse(writer io.Writer, data promql.Matrix, warnings []string) error {
@@ -62,6 +62,10 @@ func deleteHandler(config *Config, client *pgclient.Client) http.HandlerFunc {
 			respondError(w, http.StatusBadRequest, errors.ErrTimeBasedDeletion, "bad_data")
 			return
 		}
+		if config.QueryCache != nil {
+			// The cached results may hold the deleted series.
+			defer config.QueryCache.Invalidate()
+		}
 		for _, s := range r.Form["match[]"] {
 			matchers, err := parser.ParseMetricSelector(s)
 			if err != nil {
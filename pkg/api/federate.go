@@ -0,0 +1,259 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/NYTimes/gziphandler"
+	"github.com/gogo/protobuf/proto"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgmodel/querier"
+	"github.com/timescale/promscale/pkg/promql"
+)
+
+// Federate returns the handler of the /federate endpoint, exposing the latest
+// sample of the series matching the match[] selectors in the Prometheus text
+// or OpenMetrics format, so that they can be scraped by other Prometheus
+// servers.
+func Federate(conf *Config, queryable promql.Queryable) http.Handler {
+	return gziphandler.GzipHandler(federate(conf, queryable))
+}
+
+func federate(conf *Config, queryable promql.Queryable) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, fmt.Sprintf("error parsing form values: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		if len(r.Form["match[]"]) == 0 {
+			http.Error(w, "no match[] parameter provided", http.StatusBadRequest)
+			return
+		}
+		var matcherSets [][]*labels.Matcher
+		for _, s := range r.Form["match[]"] {
+			matchers, err := parser.ParseMetricSelector(s)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			matcherSets = append(matcherSets, matchers)
+		}
+
+		var (
+			maxt = timestamp.FromTime(time.Now())
+			mint = maxt - conf.LookBackDelta.Milliseconds()
+		)
+		q, err := queryable.SamplesQuerier(r.Context(), mint, maxt)
+		if err != nil {
+			log.Error("msg", "Federation failed", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer q.Close()
+
+		names, err := federatedMetricNames(q, matcherSets)
+		if err != nil {
+			log.Error("msg", "Federation select failed", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// The metric families are encoded and flushed one at a time, as
+		// their series are fetched. Once the first family is written, errors
+		// can no longer be reported with a status code, so the response is
+		// aborted instead for the scrape to fail rather than be partial.
+		var (
+			format  = expfmt.NegotiateIncludingOpenMetrics(r.Header)
+			enc     expfmt.Encoder
+			flusher = func() {}
+		)
+		if f, ok := w.(http.Flusher); ok {
+			flusher = f.Flush
+		}
+		for _, name := range names {
+			vec, err := selectLatestSamples(q, matcherSets, name, mint, maxt)
+			if err != nil {
+				log.Error("msg", "Federation select failed", "err", err)
+				if enc == nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				panic(http.ErrAbortHandler)
+			}
+			if len(vec) == 0 {
+				continue
+			}
+			if enc == nil {
+				w.Header().Set("Content-Type", string(format))
+				enc = expfmt.NewEncoder(w, format)
+			}
+			if err := encodeFederated(enc, vec); err != nil {
+				log.Error("msg", "Federation failed", "err", err)
+				return
+			}
+			flusher()
+		}
+		if enc == nil {
+			w.Header().Set("Content-Type", string(format))
+			enc = expfmt.NewEncoder(w, format)
+		}
+		if closer, ok := enc.(expfmt.Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.Error("msg", "Federation failed", "err", err)
+			}
+		}
+	}
+}
+
+// federatedMetricNames returns the sorted names of the metrics matched by any
+// of the matcher sets.
+func federatedMetricNames(q promql.SamplesQuerier, matcherSets [][]*labels.Matcher) ([]string, error) {
+	seen := make(map[string]struct{})
+	for _, mset := range matcherSets {
+		values, warnings, err := q.LabelValues(labels.MetricName, mset...)
+		if err != nil {
+			return nil, err
+		}
+		for _, warning := range warnings {
+			log.Warn("msg", "Federation select returned warning", "warning", warning)
+		}
+		for _, v := range values {
+			seen[v] = struct{}{}
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// selectLatestSamples returns the latest sample of the series of the metric
+// matched by any of the matcher sets.
+func selectLatestSamples(q promql.SamplesQuerier, matcherSets [][]*labels.Matcher, name string, mint, maxt int64) (promql.Vector, error) {
+	// Only the latest sample of every series is fetched.
+	hints := &storage.SelectHints{Start: mint, End: maxt, Func: querier.LatestSampleFunc}
+	nameMatcher := labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, name)
+	sets := make([]storage.SeriesSet, 0, len(matcherSets))
+	for _, mset := range matcherSets {
+		ms := make([]*labels.Matcher, 0, len(mset)+1)
+		ms = append(append(ms, mset...), nameMatcher)
+		s, topNode := q.Select(true, hints, nil, nil, ms...)
+		if topNode != nil {
+			// Samples must not be processed by a pushed down function.
+			return nil, fmt.Errorf("unexpected pushdown of %s", topNode)
+		}
+		sets = append(sets, s)
+	}
+	set := storage.NewMergeSeriesSet(sets, storage.ChainedSeriesMerge)
+
+	vec, err := latestSamples(set, maxt)
+	for _, s := range sets {
+		if err != nil {
+			break
+		}
+		err = s.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, warning := range set.Warnings() {
+		log.Warn("msg", "Federation select returned warning", "warning", warning)
+	}
+	return vec, nil
+}
+
+// latestSamples returns the latest sample of every series of the set which is
+// not after maxt. Series whose latest sample is a staleness marker are skipped.
+func latestSamples(set storage.SeriesSet, maxt int64) (promql.Vector, error) {
+	var vec promql.Vector
+	for set.Next() {
+		s := set.At()
+		var (
+			latest promql.Point
+			found  bool
+		)
+		it := s.Iterator()
+		for it.Next() {
+			t, v := it.At()
+			if t > maxt {
+				break
+			}
+			latest = promql.Point{T: t, V: v}
+			found = true
+		}
+		if err := it.Err(); err != nil {
+			return nil, err
+		}
+		if !found || value.IsStaleNaN(latest.V) {
+			continue
+		}
+		vec = append(vec, promql.Sample{Metric: s.Labels(), Point: latest})
+	}
+	return vec, set.Err()
+}
+
+// encodeFederated encodes the samples as untyped metric families, one per
+// metric name.
+func encodeFederated(enc expfmt.Encoder, vec promql.Vector) error {
+	// Samples are grouped by metric name.
+	sort.Slice(vec, func(i, j int) bool {
+		ni, nj := vec[i].Metric.Get(labels.MetricName), vec[j].Metric.Get(labels.MetricName)
+		if ni != nj {
+			return ni < nj
+		}
+		return labels.Compare(vec[i].Metric, vec[j].Metric) < 0
+	})
+
+	var mf *dto.MetricFamily
+	for _, s := range vec {
+		name := s.Metric.Get(labels.MetricName)
+		if mf != nil && mf.GetName() != name {
+			if err := enc.Encode(mf); err != nil {
+				return err
+			}
+			mf = nil
+		}
+		if mf == nil {
+			mf = &dto.MetricFamily{
+				Name: proto.String(name),
+				Type: dto.MetricType_UNTYPED.Enum(),
+			}
+		}
+
+		m := &dto.Metric{
+			Label:       make([]*dto.LabelPair, 0, len(s.Metric)),
+			Untyped:     &dto.Untyped{Value: proto.Float64(s.V)},
+			TimestampMs: proto.Int64(s.T),
+		}
+		for _, l := range s.Metric {
+			if l.Name == labels.MetricName {
+				continue
+			}
+			m.Label = append(m.Label, &dto.LabelPair{
+				Name:  proto.String(l.Name),
+				Value: proto.String(l.Value),
+			})
+		}
+		mf.Metric = append(mf.Metric, m)
+	}
+	if mf != nil {
+		return enc.Encode(mf)
+	}
+	return nil
+}
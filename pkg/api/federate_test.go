@@ -0,0 +1,186 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/pgmodel/querier"
+	"github.com/timescale/promscale/pkg/promql"
+)
+
+func TestFederate(t *testing.T) {
+	storage := promql.NewTestStorage(t)
+	defer storage.Close()
+
+	now := timestamp.FromTime(time.Now())
+	samples := []struct {
+		lset labels.Labels
+		t    int64
+		v    float64
+	}{
+		{labels.FromStrings("__name__", "up", "job", "api", "instance", "a"), now - 120000, 0},
+		{labels.FromStrings("__name__", "up", "job", "api", "instance", "a"), now - 60000, 1},
+		{labels.FromStrings("__name__", "up", "job", "db", "instance", "b"), now - 60000, 1},
+		// Outside of the lookback window.
+		{labels.FromStrings("__name__", "up", "job", "old", "instance", "c"), now - 3600000, 1},
+		// Stale series.
+		{labels.FromStrings("__name__", "up", "job", "gone", "instance", "d"), now - 120000, 1},
+		{labels.FromStrings("__name__", "up", "job", "gone", "instance", "d"), now - 60000, math.Float64frombits(value.StaleNaN)},
+		{labels.FromStrings("__name__", "job:up:sum", "job", "api"), now - 30000, 1},
+	}
+	app := storage.Appender(context.Background())
+	for _, s := range samples {
+		_, err := app.Append(0, s.lset, s.t, s.v)
+		require.NoError(t, err)
+	}
+	require.NoError(t, app.Commit())
+
+	handler := federate(&Config{LookBackDelta: 5 * time.Minute}, storage)
+	do := func(accept string, matchers ...string) *httptest.ResponseRecorder {
+		params := url.Values{"match[]": matchers}
+		req, err := http.NewRequestWithContext(context.Background(), "GET", "http://localhost:9201/federate?"+params.Encode(), nil)
+		require.NoError(t, err)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("", `{__name__=~"up|job:up:sum"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, string(expfmt.FmtText), w.Header().Get("Content-Type"))
+	expected := strings.Join([]string{
+		"# TYPE job:up:sum untyped",
+		`job:up:sum{job="api"} 1 ` + formatMs(now-30000),
+		"# TYPE up untyped",
+		`up{instance="a",job="api"} 1 ` + formatMs(now-60000),
+		`up{instance="b",job="db"} 1 ` + formatMs(now-60000),
+		"",
+	}, "\n")
+	require.Equal(t, expected, w.Body.String())
+
+	// Series matched by several selectors are only returned once.
+	w = do("", `up{job="api"}`, `up{instance="a"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "# TYPE up untyped\n"+`up{instance="a",job="api"} 1 `+formatMs(now-60000)+"\n", w.Body.String())
+
+	w = do("application/openmetrics-text; version=0.0.1", `job:up:sum`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, string(expfmt.FmtOpenMetrics), w.Header().Get("Content-Type"))
+	require.True(t, strings.HasSuffix(w.Body.String(), "# EOF\n"), w.Body.String())
+
+	w = do("")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = do("", `up{`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func formatMs(ms int64) string {
+	return strconv.FormatInt(ms, 10)
+}
+
+// errFederateQueryable returns queriers whose selects fail.
+type errFederateQueryable struct {
+	err error
+}
+
+func (q errFederateQueryable) SamplesQuerier(context.Context, int64, int64) (promql.SamplesQuerier, error) {
+	return q, nil
+}
+
+func (errFederateQueryable) ExemplarsQuerier(context.Context) querier.ExemplarQuerier {
+	return nil
+}
+
+func (q errFederateQueryable) Select(bool, *storage.SelectHints, *querier.QueryHints, []parser.Node, ...*labels.Matcher) (storage.SeriesSet, parser.Node) {
+	return storage.ErrSeriesSet(q.err), nil
+}
+
+func (errFederateQueryable) LabelValues(string, ...*labels.Matcher) ([]string, storage.Warnings, error) {
+	return []string{"up"}, nil, nil
+}
+
+func (errFederateQueryable) LabelNames(...*labels.Matcher) ([]string, storage.Warnings, error) {
+	return nil, nil, nil
+}
+
+func (errFederateQueryable) Close() error { return nil }
+
+func TestFederateSelectError(t *testing.T) {
+	handler := federate(&Config{LookBackDelta: 5 * time.Minute}, errFederateQueryable{err: fmt.Errorf("select failed")})
+	req, err := http.NewRequestWithContext(context.Background(), "GET", "http://localhost:9201/federate?match[]=up", nil)
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	require.Contains(t, w.Body.String(), "select failed")
+}
+
+// failingFederateQueryable fails the selects of a single metric.
+type failingFederateQueryable struct {
+	promql.Queryable
+	metric string
+}
+
+func (q failingFederateQueryable) SamplesQuerier(ctx context.Context, mint, maxt int64) (promql.SamplesQuerier, error) {
+	sq, err := q.Queryable.SamplesQuerier(ctx, mint, maxt)
+	return failingFederateQuerier{SamplesQuerier: sq, metric: q.metric}, err
+}
+
+type failingFederateQuerier struct {
+	promql.SamplesQuerier
+	metric string
+}
+
+func (q failingFederateQuerier) Select(sortSeries bool, hints *storage.SelectHints, qh *querier.QueryHints, path []parser.Node, ms ...*labels.Matcher) (storage.SeriesSet, parser.Node) {
+	for _, m := range ms {
+		if m.Name == labels.MetricName && m.Type == labels.MatchEqual && m.Value == q.metric {
+			return storage.ErrSeriesSet(fmt.Errorf("select failed")), nil
+		}
+	}
+	return q.SamplesQuerier.Select(sortSeries, hints, qh, path, ms...)
+}
+
+func TestFederateStreamError(t *testing.T) {
+	storage := promql.NewTestStorage(t)
+	defer storage.Close()
+	now := timestamp.FromTime(time.Now())
+	app := storage.Appender(context.Background())
+	_, err := app.Append(0, labels.FromStrings("__name__", "job:up:sum", "job", "api"), now, 1)
+	require.NoError(t, err)
+	_, err = app.Append(0, labels.FromStrings("__name__", "up", "job", "api"), now, 1)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+
+	// The families are written as they are fetched, so the response is
+	// aborted when a later family fails.
+	handler := federate(&Config{LookBackDelta: 5 * time.Minute}, failingFederateQueryable{Queryable: storage, metric: "up"})
+	req, err := http.NewRequestWithContext(context.Background(), "GET", "http://localhost:9201/federate?match[]=%7Bjob%3D%22api%22%7D", nil)
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	require.PanicsWithValue(t, http.ErrAbortHandler, func() { handler.ServeHTTP(w, req) })
+	require.Equal(t, "# TYPE job:up:sum untyped\n"+`job:up:sum{job="api"} 1 `+formatMs(now)+"\n", w.Body.String())
+	require.True(t, w.Flushed)
+}
@@ -5,14 +5,15 @@
 package api
 
 import (
-	"context"
 	"fmt"
-	"math"
 	"net/http"
 
 	"github.com/NYTimes/gziphandler"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/common/route"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/timescale/promscale/pkg/log"
 	"github.com/timescale/promscale/pkg/promql"
 )
 
@@ -29,13 +30,22 @@ func labelValues(queryable promql.Queryable) http.HandlerFunc {
 			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid label name: %s", name), "bad_data")
 			return
 		}
-		querier, err := queryable.SamplesQuerier(context.Background(), math.MinInt64, math.MaxInt64)
+		params, err := parseLabelsParams(r)
+		if err != nil {
+			log.Info("msg", "Query bad request:"+err.Error())
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		querier, err := queryable.SamplesQuerier(ctx, params.start, params.end)
 		if err != nil {
 			respondError(w, http.StatusInternalServerError, err, "internal")
 			return
 		}
-		var values labelsValue
-		values, warnings, err := querier.LabelValues(name)
+		defer querier.Close()
+
+		values, warnings, err := params.collect(func(matchers ...*labels.Matcher) ([]string, storage.Warnings, error) {
+			return querier.LabelValues(name, matchers...)
+		})
 		if err != nil {
 			respondError(w, http.StatusInternalServerError, err, "internal")
 			return
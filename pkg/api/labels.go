@@ -5,15 +5,19 @@
 package api
 
 import (
-	"context"
 	"encoding/json"
-	"math"
 	"net/http"
+	"sort"
 	"strings"
 
 	"github.com/NYTimes/gziphandler"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
 	"github.com/prometheus/prometheus/promql/parser"
 	"github.com/prometheus/prometheus/storage"
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgmodel/model"
 	"github.com/timescale/promscale/pkg/promql"
 )
 
@@ -34,13 +38,20 @@ func Labels(conf *Config, queryable promql.Queryable) http.Handler {
 
 func labelsHandler(queryable promql.Queryable) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		querier, err := queryable.SamplesQuerier(context.Background(), math.MinInt64, math.MaxInt64)
+		params, err := parseLabelsParams(r)
+		if err != nil {
+			log.Info("msg", "Query bad request:"+err.Error())
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		querier, err := queryable.SamplesQuerier(r.Context(), params.start, params.end)
 		if err != nil {
 			respondError(w, http.StatusInternalServerError, err, "internal")
 			return
 		}
-		var names labelsValue
-		names, warnings, err := querier.LabelNames()
+		defer querier.Close()
+
+		names, warnings, err := params.collect(querier.LabelNames)
 		if err != nil {
 			respondError(w, http.StatusInternalServerError, err, "internal")
 			return
@@ -51,6 +62,67 @@ func labelsHandler(queryable promql.Queryable) http.HandlerFunc {
 	}
 }
 
+// labelsParams holds the time range and the series selectors of a request
+// for label names or label values.
+type labelsParams struct {
+	start, end  int64
+	matcherSets [][]*labels.Matcher
+}
+
+func parseLabelsParams(r *http.Request) (*labelsParams, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, errors.Wrap(err, "error parsing form values")
+	}
+	start, err := parseTimeParam(r, "start", model.MinTime)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseTimeParam(r, "end", model.MaxTime)
+	if err != nil {
+		return nil, err
+	}
+	if end.Before(start) {
+		return nil, errors.New("end timestamp must not be before start time")
+	}
+
+	params := &labelsParams{start: timestamp.FromTime(start), end: timestamp.FromTime(end)}
+	for _, s := range r.Form["match[]"] {
+		matchers, err := parser.ParseMetricSelector(s)
+		if err != nil {
+			return nil, err
+		}
+		params.matcherSets = append(params.matcherSets, matchers)
+	}
+	return params, nil
+}
+
+// collect calls the lookup for each series selector, or once without matchers
+// if there are none, and returns the sorted union of the results.
+func (p *labelsParams) collect(lookup func(...*labels.Matcher) ([]string, storage.Warnings, error)) (labelsValue, storage.Warnings, error) {
+	if len(p.matcherSets) == 0 {
+		return lookup()
+	}
+
+	var warnings storage.Warnings
+	set := make(map[string]struct{})
+	for _, matchers := range p.matcherSets {
+		res, w, err := lookup(matchers...)
+		if err != nil {
+			return nil, nil, err
+		}
+		warnings = append(warnings, w...)
+		for _, s := range res {
+			set[s] = struct{}{}
+		}
+	}
+	res := make(labelsValue, 0, len(set))
+	for s := range set {
+		res = append(res, s)
+	}
+	sort.Strings(res)
+	return res, warnings, nil
+}
+
 func respondLabels(w http.ResponseWriter, res *promql.Result, warnings storage.Warnings) {
 	setResponseHeaders(w, res, false, warnings)
 	resp := &response{
@@ -44,7 +44,7 @@ func TestLabels(t *testing.T) {
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			handler := labelsHandler(query.NewQueryable(nil, tc.labelsReader))
+			handler := labelsHandler(query.NewQueryable(tc.querier, tc.labelsReader))
 			w := doLabels(t, handler)
 
 			if w.Code != tc.expectCode {
@@ -91,3 +91,29 @@ func doLabels(t *testing.T, queryHandler http.Handler) *httptest.ResponseRecorde
 	queryHandler.ServeHTTP(w, req)
 	return w
 }
+
+func TestLabelsBadParams(t *testing.T) {
+	queryable := query.NewQueryable(&mockQuerier{}, &mockLabelsReader{})
+	handlers := map[string]http.Handler{
+		"labels":       labelsHandler(queryable),
+		"label values": labelValues(queryable),
+	}
+	for _, params := range []string{
+		"start=foo",
+		"end=foo",
+		"start=2&end=1",
+		"match[]=up{",
+	} {
+		for name, handler := range handlers {
+			req, err := http.NewRequestWithContext(context.Background(), "GET", "http://localhost:9090/labels?"+params, nil)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("%s with %s: unexpected HTTP status code received: got %d wanted %d", name, params, w.Code, http.StatusBadRequest)
+			}
+		}
+	}
+}
@@ -33,6 +33,8 @@ type Metrics struct {
 	InvalidReadReqs       prometheus.Counter
 	InvalidWriteReqs      prometheus.Counter
 	InvalidQueryReqs      prometheus.Counter
+	RejectedSeries        *prometheus.CounterVec
+	ThrottledWriteReqs    prometheus.Counter
 	HTTPRequestDuration   *prometheus.HistogramVec
 }
 
@@ -55,6 +57,8 @@ func InitMetrics() *Metrics {
 		metrics.FailedQueries,
 		metrics.InvalidReadReqs,
 		metrics.InvalidWriteReqs,
+		metrics.RejectedSeries,
+		metrics.ThrottledWriteReqs,
 		metrics.SentBatchDuration,
 		metrics.QueryBatchDuration,
 		metrics.QueryDuration,
@@ -177,6 +181,21 @@ func createMetrics() *Metrics {
 				Help:      "Total number of invalid query requests with invalid metadata.",
 			},
 		),
+		RejectedSeries: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: util.PromNamespace,
+				Name:      "rejected_series_total",
+				Help:      "Total number of series rejected from remote write requests, by rejection reason.",
+			},
+			[]string{"reason"},
+		),
+		ThrottledWriteReqs: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: util.PromNamespace,
+				Name:      "throttled_write_requests_total",
+				Help:      "Total number of write requests answered with 429 because some of their series exceeded the ingest limits.",
+			},
+		),
 		ReceivedQueries: prometheus.NewCounter(
 			prometheus.CounterOpts{
 				Namespace: util.PromNamespace,
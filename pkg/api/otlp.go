@@ -24,3 +24,41 @@ type tracesServer struct {
 func (t *tracesServer) Export(ctx context.Context, tr otlpgrpc.TracesRequest) (otlpgrpc.TracesResponse, error) {
 	return otlpgrpc.NewTracesResponse(), t.ingestor.IngestTraces(ctx, tr.Traces())
 }
+
+// NewMetricsServer returns an OTLP metrics server which converts the received
+// metrics into Prometheus time-series and ingests them.
+func NewMetricsServer(i ingestor.DBInserter) otlpgrpc.MetricsServer {
+	return &metricsServer{
+		ingestor: i,
+	}
+}
+
+type metricsServer struct {
+	ingestor ingestor.DBInserter
+}
+
+func (m *metricsServer) Export(ctx context.Context, mr otlpgrpc.MetricsRequest) (otlpgrpc.MetricsResponse, error) {
+	req := ingestor.NewWriteRequest()
+	if err := otlpMetricsToWriteRequest(ctx, mr.Metrics(), req); err != nil {
+		ingestor.FinishWriteRequest(req)
+		return otlpgrpc.NewMetricsResponse(), err
+	}
+	if len(req.Timeseries) == 0 {
+		ingestor.FinishWriteRequest(req)
+		return otlpgrpc.NewMetricsResponse(), nil
+	}
+
+	var receivedSamplesCount uint64
+	for _, ts := range req.Timeseries {
+		receivedSamplesCount += uint64(len(ts.Samples))
+	}
+	metrics.ReceivedSamples.Add(float64(receivedSamplesCount))
+
+	numSamples, _, err := m.ingestor.Ingest(ctx, req)
+	if err != nil {
+		metrics.FailedSamples.Add(float64(receivedSamplesCount - numSamples))
+		return otlpgrpc.NewMetricsResponse(), err
+	}
+	metrics.SentSamples.Add(float64(numSamples))
+	return otlpgrpc.NewMetricsResponse(), nil
+}
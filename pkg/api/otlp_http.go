@@ -0,0 +1,158 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgmodel/ingestor"
+	"go.opentelemetry.io/collector/model/otlp"
+	"go.opentelemetry.io/collector/model/otlpgrpc"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+const (
+	otlpProtobufContentType = "application/x-protobuf"
+	otlpJSONContentType     = "application/json"
+
+	// maxOTLPRequestBytes bounds the size of the OTLP requests, both as sent
+	// and once decompressed.
+	maxOTLPRequestBytes = 32 << 20
+)
+
+var (
+	otlpProtobufTracesUnmarshaler = otlp.NewProtobufTracesUnmarshaler()
+	otlpJSONTracesUnmarshaler     = otlp.NewJSONTracesUnmarshaler()
+)
+
+// OTLPTraces returns an http.Handler that ingests traces sent using the
+// OTLP/HTTP protocol, encoded either as protobuf or JSON.
+func OTLPTraces(inserter ingestor.DBInserter) http.Handler {
+	wh := writeHandler{}
+	wh.addStages(
+		validateOTLPHeaders,
+		limitOTLPBody,
+		decodeGzip,
+		ingestOTLPTraces(inserter),
+	)
+	return wh.handler()
+}
+
+func validateOTLPHeaders(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != "POST" {
+		validateError(w, fmt.Sprintf("HTTP Method %s instead of POST", r.Method), metrics)
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		validateError(w, "Error parsing media type from Content-Type header", metrics)
+		return false
+	}
+	switch mediaType {
+	case otlpProtobufContentType, otlpJSONContentType:
+	default:
+		http.Error(w, fmt.Sprintf("unsupported content type %s, expected %s or %s", mediaType, otlpProtobufContentType, otlpJSONContentType), http.StatusUnsupportedMediaType)
+		metrics.InvalidWriteReqs.Inc()
+		return false
+	}
+
+	return true
+}
+
+// limitOTLPBody bounds the size of the request body as sent. The size of the
+// decompressed body is bounded when it is read.
+func limitOTLPBody(w http.ResponseWriter, r *http.Request) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxOTLPRequestBytes)
+	return true
+}
+
+// isRequestTooLarge reports whether err was returned by the reader of
+// http.MaxBytesReader once its limit was hit.
+func isRequestTooLarge(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "http: request body too large")
+}
+
+func requestTooLargeError(w http.ResponseWriter) {
+	http.Error(w, fmt.Sprintf("request body larger than %d bytes", maxOTLPRequestBytes), http.StatusRequestEntityTooLarge)
+	metrics.InvalidWriteReqs.Inc()
+}
+
+func decodeGzip(w http.ResponseWriter, r *http.Request) bool {
+	if !strings.Contains(r.Header.Get("Content-Encoding"), "gzip") {
+		return true
+	}
+	gr, err := gzip.NewReader(r.Body)
+	if err != nil {
+		invalidRequestError(w, "gzip decode error", err.Error(), metrics)
+		return false
+	}
+	originalBody := r.Body
+	r.Body = &readCloser{
+		reader: gr,
+		closer: funcCloser(func() error {
+			_ = gr.Close()
+			return originalBody.Close()
+		}),
+	}
+	return true
+}
+
+func ingestOTLPTraces(inserter ingestor.DBInserter) func(http.ResponseWriter, *http.Request) bool {
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxOTLPRequestBytes+1))
+		if isRequestTooLarge(err) || len(body) > maxOTLPRequestBytes {
+			requestTooLargeError(w)
+			return false
+		}
+		if err != nil {
+			invalidRequestError(w, "request body read error", err.Error(), metrics)
+			return false
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		var (
+			traces       pdata.Traces
+			unmarshaler  = otlpProtobufTracesUnmarshaler
+			marshalReply = otlpgrpc.NewTracesResponse().Marshal
+		)
+		if mediaType == otlpJSONContentType {
+			unmarshaler = otlpJSONTracesUnmarshaler
+			marshalReply = otlpgrpc.NewTracesResponse().MarshalJSON
+		}
+		if traces, err = unmarshaler.UnmarshalTraces(body); err != nil {
+			invalidRequestError(w, "OTLP traces decode error", err.Error(), metrics)
+			return false
+		}
+
+		if traces.SpanCount() > 0 {
+			if err = inserter.IngestTraces(r.Context(), traces); err != nil {
+				log.Warn("msg", "Error sending traces to remote storage", "err", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return false
+			}
+		}
+
+		reply, err := marshalReply()
+		if err != nil {
+			log.Error("msg", "error marshalling OTLP response", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return false
+		}
+		w.Header().Set("Content-Type", mediaType)
+		w.WriteHeader(http.StatusOK)
+		if n, err := w.Write(reply); err != nil {
+			log.Error("msg", "error writing response", "bytesWritten", n, "err", err)
+		}
+		return true
+	}
+}
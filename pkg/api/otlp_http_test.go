@@ -0,0 +1,158 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/model/otlpgrpc"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+func newTestTraces() pdata.Traces {
+	td := pdata.NewTraces()
+	rs := td.ResourceSpans().AppendEmpty()
+	rs.Resource().Attributes().InsertString("service.name", "checkout")
+	span := rs.InstrumentationLibrarySpans().AppendEmpty().Spans().AppendEmpty()
+	span.SetName("GET /cart")
+	span.SetTraceID(pdata.NewTraceID([16]byte{1}))
+	span.SetSpanID(pdata.NewSpanID([8]byte{2}))
+	return td
+}
+
+func TestOTLPTraces(t *testing.T) {
+	req := otlpgrpc.NewTracesRequest()
+	req.SetTraces(newTestTraces())
+	protoBody, err := req.Marshal()
+	require.NoError(t, err)
+	jsonBody, err := req.MarshalJSON()
+	require.NoError(t, err)
+
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	_, err = gw.Write(protoBody)
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+
+	var bomb bytes.Buffer
+	gw = gzip.NewWriter(&bomb)
+	_, err = gw.Write(make([]byte, maxOTLPRequestBytes+1))
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+
+	testCases := []struct {
+		name         string
+		method       string
+		body         string
+		headers      map[string]string
+		inserterErr  error
+		responseCode int
+		spans        int
+	}{
+		{
+			name:         "protobuf",
+			body:         string(protoBody),
+			headers:      map[string]string{"Content-Type": "application/x-protobuf"},
+			responseCode: http.StatusOK,
+			spans:        1,
+		},
+		{
+			name:         "JSON",
+			body:         string(jsonBody),
+			headers:      map[string]string{"Content-Type": "application/json"},
+			responseCode: http.StatusOK,
+			spans:        1,
+		},
+		{
+			name: "gzipped protobuf",
+			body: gzipped.String(),
+			headers: map[string]string{
+				"Content-Type":     "application/x-protobuf",
+				"Content-Encoding": "gzip",
+			},
+			responseCode: http.StatusOK,
+			spans:        1,
+		},
+		{
+			name: "invalid gzip",
+			body: string(protoBody),
+			headers: map[string]string{
+				"Content-Type":     "application/x-protobuf",
+				"Content-Encoding": "gzip",
+			},
+			responseCode: http.StatusBadRequest,
+		},
+		{
+			name: "gzip bomb",
+			body: bomb.String(),
+			headers: map[string]string{
+				"Content-Type":     "application/x-protobuf",
+				"Content-Encoding": "gzip",
+			},
+			responseCode: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:         "body too large",
+			body:         string(make([]byte, maxOTLPRequestBytes+1)),
+			headers:      map[string]string{"Content-Type": "application/x-protobuf"},
+			responseCode: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:         "wrong method",
+			method:       "GET",
+			headers:      map[string]string{"Content-Type": "application/x-protobuf"},
+			responseCode: http.StatusBadRequest,
+		},
+		{
+			name:         "unsupported content type",
+			body:         string(protoBody),
+			headers:      map[string]string{"Content-Type": "text/plain"},
+			responseCode: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:         "malformed JSON",
+			body:         "{",
+			headers:      map[string]string{"Content-Type": "application/json"},
+			responseCode: http.StatusBadRequest,
+		},
+		{
+			name:         "ingest error",
+			body:         string(protoBody),
+			headers:      map[string]string{"Content-Type": "application/x-protobuf"},
+			inserterErr:  fmt.Errorf("some error"),
+			responseCode: http.StatusInternalServerError,
+			spans:        1,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			metrics = &Metrics{InvalidWriteReqs: &mockMetric{}}
+			mock := &mockInserter{err: c.inserterErr}
+			method := c.method
+			if method == "" {
+				method = "POST"
+			}
+
+			test := GenerateWriteHandleTester(t, OTLPTraces(mock), c.headers)
+			w := test(method, bytes.NewBufferString(c.body))
+
+			require.Equal(t, c.responseCode, w.Code, w.Body.String())
+			if c.spans == 0 {
+				require.Equal(t, pdata.Traces{}, mock.traces)
+				return
+			}
+			require.Equal(t, c.spans, mock.traces.SpanCount())
+			if c.responseCode == http.StatusOK {
+				require.Equal(t, c.headers["Content-Type"], w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
@@ -0,0 +1,316 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/timescale/promscale/pkg/prompb"
+	"github.com/timescale/promscale/pkg/util"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+const (
+	otlpServiceNameAttr       = "service.name"
+	otlpServiceNamespaceAttr  = "service.namespace"
+	otlpServiceInstanceIDAttr = "service.instance.id"
+
+	metricNameLabel = "__name__"
+	jobLabel        = "job"
+	instanceLabel   = "instance"
+	bucketLabel     = "le"
+	quantileLabel   = "quantile"
+	traceIDLabel    = "trace_id"
+	spanIDLabel     = "span_id"
+
+	bucketSuffix = "_bucket"
+	sumSuffix    = "_sum"
+	countSuffix  = "_count"
+)
+
+var staleNaN = math.Float64frombits(value.StaleNaN)
+
+// otlpMetricsToWriteRequest converts OTLP metrics into Prometheus time-series
+// and metadata, appending them to the write request. The conversion follows the
+// same rules as the OpenTelemetry Collector Prometheus remote-write exporter:
+// gauges and cumulative sums become a single series, cumulative histograms and
+// summaries are fanned out into the classic _bucket/_sum/_count series. Delta
+// temporality data cannot be represented in Prometheus and is skipped. The
+// conversion stops when ctx is done.
+func otlpMetricsToWriteRequest(ctx context.Context, md pdata.Metrics, wr *prompb.WriteRequest) error {
+	rms := md.ResourceMetrics()
+	for i := 0; i < rms.Len(); i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rm := rms.At(i)
+		resourceLabels := otlpResourceLabels(rm.Resource())
+		ilms := rm.InstrumentationLibraryMetrics()
+		for j := 0; j < ilms.Len(); j++ {
+			metricSlice := ilms.At(j).Metrics()
+			for k := 0; k < metricSlice.Len(); k++ {
+				addOTLPMetric(wr, metricSlice.At(k), resourceLabels)
+			}
+		}
+	}
+	return nil
+}
+
+func addOTLPMetric(wr *prompb.WriteRequest, metric pdata.Metric, resourceLabels []prompb.Label) {
+	name := util.SanitizeName(metric.Name(), true)
+	if name == "" {
+		return
+	}
+	var metricType prompb.MetricMetadata_MetricType
+
+	switch metric.DataType() {
+	case pdata.MetricDataTypeGauge:
+		metricType = prompb.MetricMetadata_GAUGE
+		addNumberDataPoints(wr, name, metric.Gauge().DataPoints(), resourceLabels)
+	case pdata.MetricDataTypeSum:
+		sum := metric.Sum()
+		if sum.AggregationTemporality() != pdata.MetricAggregationTemporalityCumulative {
+			return
+		}
+		metricType = prompb.MetricMetadata_GAUGE
+		if sum.IsMonotonic() {
+			metricType = prompb.MetricMetadata_COUNTER
+		}
+		addNumberDataPoints(wr, name, sum.DataPoints(), resourceLabels)
+	case pdata.MetricDataTypeHistogram:
+		histogram := metric.Histogram()
+		if histogram.AggregationTemporality() != pdata.MetricAggregationTemporalityCumulative {
+			return
+		}
+		metricType = prompb.MetricMetadata_HISTOGRAM
+		addHistogramDataPoints(wr, name, histogram.DataPoints(), resourceLabels)
+	case pdata.MetricDataTypeSummary:
+		metricType = prompb.MetricMetadata_SUMMARY
+		addSummaryDataPoints(wr, name, metric.Summary().DataPoints(), resourceLabels)
+	default:
+		return
+	}
+
+	wr.Metadata = append(wr.Metadata, prompb.MetricMetadata{
+		Type:             metricType,
+		MetricFamilyName: name,
+		Help:             metric.Description(),
+		Unit:             metric.Unit(),
+	})
+}
+
+func addNumberDataPoints(wr *prompb.WriteRequest, name string, points pdata.NumberDataPointSlice, resourceLabels []prompb.Label) {
+	for i := 0; i < points.Len(); i++ {
+		pt := points.At(i)
+		v := staleNaN
+		if !pt.Flags().HasFlag(pdata.MetricDataPointFlagNoRecordedValue) {
+			v = numberDataPointValue(pt)
+		}
+		ts := prompb.TimeSeries{
+			Labels:    otlpLabels(name, resourceLabels, pt.Attributes()),
+			Samples:   []prompb.Sample{{Timestamp: otlpTimestamp(pt.Timestamp()), Value: v}},
+			Exemplars: otlpExemplars(pt.Exemplars()),
+		}
+		wr.Timeseries = append(wr.Timeseries, ts)
+	}
+}
+
+func addHistogramDataPoints(wr *prompb.WriteRequest, name string, points pdata.HistogramDataPointSlice, resourceLabels []prompb.Label) {
+	for i := 0; i < points.Len(); i++ {
+		pt := points.At(i)
+		t := otlpTimestamp(pt.Timestamp())
+		stale := pt.Flags().HasFlag(pdata.MetricDataPointFlagNoRecordedValue)
+		sample := func(v float64) []prompb.Sample {
+			if stale {
+				v = staleNaN
+			}
+			return []prompb.Sample{{Timestamp: t, Value: v}}
+		}
+
+		wr.Timeseries = append(wr.Timeseries,
+			prompb.TimeSeries{
+				Labels:  otlpLabels(name+sumSuffix, resourceLabels, pt.Attributes()),
+				Samples: sample(pt.Sum()),
+			},
+			prompb.TimeSeries{
+				Labels:  otlpLabels(name+countSuffix, resourceLabels, pt.Attributes()),
+				Samples: sample(float64(pt.Count())),
+			},
+		)
+
+		var (
+			bounds    = pt.ExplicitBounds()
+			counts    = pt.BucketCounts()
+			exemplars = otlpExemplars(pt.Exemplars())
+			// Buckets in OTLP are not cumulative, whereas Prometheus 'le' buckets are.
+			cumulative uint64
+		)
+		for b := 0; b <= len(bounds); b++ {
+			upperBound := math.Inf(1)
+			if b < len(bounds) {
+				upperBound = bounds[b]
+			}
+			if b < len(counts) {
+				cumulative += counts[b]
+			}
+			// The +Inf bucket always equals the total count.
+			if math.IsInf(upperBound, 1) {
+				cumulative = pt.Count()
+			}
+			labels := otlpLabels(name+bucketSuffix, resourceLabels, pt.Attributes(),
+				prompb.Label{Name: bucketLabel, Value: strconv.FormatFloat(upperBound, 'f', -1, 64)})
+
+			// Attach each exemplar to the first bucket that contains its value.
+			var bucketExemplars []prompb.Exemplar
+			remaining := exemplars[:0]
+			for _, e := range exemplars {
+				if e.Value <= upperBound {
+					bucketExemplars = append(bucketExemplars, e)
+					continue
+				}
+				remaining = append(remaining, e)
+			}
+			exemplars = remaining
+
+			wr.Timeseries = append(wr.Timeseries, prompb.TimeSeries{
+				Labels:    labels,
+				Samples:   sample(float64(cumulative)),
+				Exemplars: bucketExemplars,
+			})
+		}
+	}
+}
+
+func addSummaryDataPoints(wr *prompb.WriteRequest, name string, points pdata.SummaryDataPointSlice, resourceLabels []prompb.Label) {
+	for i := 0; i < points.Len(); i++ {
+		pt := points.At(i)
+		t := otlpTimestamp(pt.Timestamp())
+		stale := pt.Flags().HasFlag(pdata.MetricDataPointFlagNoRecordedValue)
+		sample := func(v float64) []prompb.Sample {
+			if stale {
+				v = staleNaN
+			}
+			return []prompb.Sample{{Timestamp: t, Value: v}}
+		}
+
+		wr.Timeseries = append(wr.Timeseries,
+			prompb.TimeSeries{
+				Labels:  otlpLabels(name+sumSuffix, resourceLabels, pt.Attributes()),
+				Samples: sample(pt.Sum()),
+			},
+			prompb.TimeSeries{
+				Labels:  otlpLabels(name+countSuffix, resourceLabels, pt.Attributes()),
+				Samples: sample(float64(pt.Count())),
+			},
+		)
+
+		quantiles := pt.QuantileValues()
+		for q := 0; q < quantiles.Len(); q++ {
+			quantile := quantiles.At(q)
+			wr.Timeseries = append(wr.Timeseries, prompb.TimeSeries{
+				Labels: otlpLabels(name, resourceLabels, pt.Attributes(),
+					prompb.Label{Name: quantileLabel, Value: strconv.FormatFloat(quantile.Quantile(), 'f', -1, 64)}),
+				Samples: sample(quantile.Value()),
+			})
+		}
+	}
+}
+
+func numberDataPointValue(pt pdata.NumberDataPoint) float64 {
+	if pt.Type() == pdata.MetricValueTypeInt {
+		return float64(pt.IntVal())
+	}
+	return pt.DoubleVal()
+}
+
+func otlpExemplars(exemplars pdata.ExemplarSlice) []prompb.Exemplar {
+	if exemplars.Len() == 0 {
+		return nil
+	}
+	result := make([]prompb.Exemplar, 0, exemplars.Len())
+	for i := 0; i < exemplars.Len(); i++ {
+		e := exemplars.At(i)
+		v := e.DoubleVal()
+		if e.Type() == pdata.MetricValueTypeInt {
+			v = float64(e.IntVal())
+		}
+
+		var labels []prompb.Label
+		if traceID := e.TraceID(); !traceID.IsEmpty() {
+			labels = append(labels, prompb.Label{Name: traceIDLabel, Value: traceID.HexString()})
+		}
+		if spanID := e.SpanID(); !spanID.IsEmpty() {
+			labels = append(labels, prompb.Label{Name: spanIDLabel, Value: spanID.HexString()})
+		}
+		e.FilteredAttributes().Range(func(k string, v pdata.AttributeValue) bool {
+			if name := util.SanitizeLabelName(k); name != "" {
+				labels = append(labels, prompb.Label{Name: name, Value: v.AsString()})
+			}
+			return true
+		})
+		sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+
+		result = append(result, prompb.Exemplar{
+			Labels:    labels,
+			Value:     v,
+			Timestamp: otlpTimestamp(e.Timestamp()),
+		})
+	}
+	return result
+}
+
+// otlpResourceLabels maps the resource attributes which identify the source
+// of the metrics to the Prometheus 'job' and 'instance' target labels.
+func otlpResourceLabels(resource pdata.Resource) []prompb.Label {
+	var (
+		labels = make([]prompb.Label, 0, 2)
+		attrs  = resource.Attributes()
+	)
+	if serviceName, ok := attrs.Get(otlpServiceNameAttr); ok {
+		job := serviceName.AsString()
+		if namespace, ok := attrs.Get(otlpServiceNamespaceAttr); ok {
+			job = namespace.AsString() + "/" + job
+		}
+		labels = append(labels, prompb.Label{Name: jobLabel, Value: job})
+	}
+	if instance, ok := attrs.Get(otlpServiceInstanceIDAttr); ok {
+		labels = append(labels, prompb.Label{Name: instanceLabel, Value: instance.AsString()})
+	}
+	return labels
+}
+
+func otlpLabels(name string, resourceLabels []prompb.Label, attrs pdata.AttributeMap, extra ...prompb.Label) []prompb.Label {
+	labels := make([]prompb.Label, 0, 1+len(resourceLabels)+attrs.Len()+len(extra))
+	labels = append(labels, resourceLabels...)
+	attrs.Range(func(k string, v pdata.AttributeValue) bool {
+		if name := util.SanitizeLabelName(k); name != "" {
+			labels = append(labels, prompb.Label{Name: name, Value: v.AsString()})
+		}
+		return true
+	})
+	labels = append(labels, extra...)
+	labels = append(labels, prompb.Label{Name: metricNameLabel, Value: name})
+	sort.SliceStable(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+
+	// Data point attributes take precedence over the resource labels and
+	// the reserved labels take precedence over both.
+	deduped := labels[:0]
+	for i, l := range labels {
+		if i+1 < len(labels) && labels[i+1].Name == l.Name {
+			continue
+		}
+		deduped = append(deduped, l)
+	}
+	return deduped
+}
+
+// otlpTimestamp converts an OTLP nanosecond timestamp into Prometheus milliseconds.
+func otlpTimestamp(ts pdata.Timestamp) int64 {
+	return int64(ts) / 1e6
+}
@@ -0,0 +1,260 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/prompb"
+	"go.opentelemetry.io/collector/model/otlpgrpc"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+var otlpTestTime = time.Unix(1600000000, 0)
+
+func newTestOTLPMetrics() (pdata.Metrics, pdata.MetricSlice) {
+	md := pdata.NewMetrics()
+	rm := md.ResourceMetrics().AppendEmpty()
+	rm.Resource().Attributes().InsertString(otlpServiceNameAttr, "checkout")
+	rm.Resource().Attributes().InsertString(otlpServiceInstanceIDAttr, "pod-1")
+	return md, rm.InstrumentationLibraryMetrics().AppendEmpty().Metrics()
+}
+
+func TestOTLPMetricsToWriteRequest(t *testing.T) {
+	ts := pdata.NewTimestampFromTime(otlpTestTime)
+	tsMs := otlpTestTime.UnixNano() / 1e6
+
+	testCases := []struct {
+		name     string
+		setup    func(pdata.MetricSlice)
+		expected []prompb.TimeSeries
+		metadata []prompb.MetricMetadata
+	}{
+		{
+			name: "gauge",
+			setup: func(ms pdata.MetricSlice) {
+				m := ms.AppendEmpty()
+				m.SetName("memory.usage")
+				m.SetDataType(pdata.MetricDataTypeGauge)
+				dp := m.Gauge().DataPoints().AppendEmpty()
+				dp.SetTimestamp(ts)
+				dp.SetIntVal(42)
+				dp.Attributes().InsertString("host.name", "a")
+			},
+			expected: []prompb.TimeSeries{
+				{
+					Labels: []prompb.Label{
+						{Name: "__name__", Value: "memory_usage"},
+						{Name: "host_name", Value: "a"},
+						{Name: "instance", Value: "pod-1"},
+						{Name: "job", Value: "checkout"},
+					},
+					Samples: []prompb.Sample{{Timestamp: tsMs, Value: 42}},
+				},
+			},
+			metadata: []prompb.MetricMetadata{{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "memory_usage"}},
+		},
+		{
+			name: "monotonic cumulative sum with exemplar",
+			setup: func(ms pdata.MetricSlice) {
+				m := ms.AppendEmpty()
+				m.SetName("requests")
+				m.SetDataType(pdata.MetricDataTypeSum)
+				m.Sum().SetIsMonotonic(true)
+				m.Sum().SetAggregationTemporality(pdata.MetricAggregationTemporalityCumulative)
+				dp := m.Sum().DataPoints().AppendEmpty()
+				dp.SetTimestamp(ts)
+				dp.SetDoubleVal(10.5)
+				e := dp.Exemplars().AppendEmpty()
+				e.SetTimestamp(ts)
+				e.SetDoubleVal(1)
+				e.SetTraceID(pdata.NewTraceID([16]byte{1}))
+			},
+			expected: []prompb.TimeSeries{
+				{
+					Labels: []prompb.Label{
+						{Name: "__name__", Value: "requests"},
+						{Name: "instance", Value: "pod-1"},
+						{Name: "job", Value: "checkout"},
+					},
+					Samples: []prompb.Sample{{Timestamp: tsMs, Value: 10.5}},
+					Exemplars: []prompb.Exemplar{
+						{
+							Labels:    []prompb.Label{{Name: "trace_id", Value: "01000000000000000000000000000000"}},
+							Value:     1,
+							Timestamp: tsMs,
+						},
+					},
+				},
+			},
+			metadata: []prompb.MetricMetadata{{Type: prompb.MetricMetadata_COUNTER, MetricFamilyName: "requests"}},
+		},
+		{
+			name: "delta sum is skipped",
+			setup: func(ms pdata.MetricSlice) {
+				m := ms.AppendEmpty()
+				m.SetName("requests")
+				m.SetDataType(pdata.MetricDataTypeSum)
+				m.Sum().SetAggregationTemporality(pdata.MetricAggregationTemporalityDelta)
+				m.Sum().DataPoints().AppendEmpty().SetDoubleVal(1)
+			},
+		},
+		{
+			name: "histogram",
+			setup: func(ms pdata.MetricSlice) {
+				m := ms.AppendEmpty()
+				m.SetName("latency")
+				m.SetDataType(pdata.MetricDataTypeHistogram)
+				m.Histogram().SetAggregationTemporality(pdata.MetricAggregationTemporalityCumulative)
+				dp := m.Histogram().DataPoints().AppendEmpty()
+				dp.SetTimestamp(ts)
+				dp.SetCount(6)
+				dp.SetSum(20)
+				dp.SetExplicitBounds([]float64{1, 5})
+				dp.SetBucketCounts([]uint64{1, 2, 3})
+			},
+			expected: []prompb.TimeSeries{
+				{
+					Labels:  []prompb.Label{{Name: "__name__", Value: "latency_sum"}, {Name: "instance", Value: "pod-1"}, {Name: "job", Value: "checkout"}},
+					Samples: []prompb.Sample{{Timestamp: tsMs, Value: 20}},
+				},
+				{
+					Labels:  []prompb.Label{{Name: "__name__", Value: "latency_count"}, {Name: "instance", Value: "pod-1"}, {Name: "job", Value: "checkout"}},
+					Samples: []prompb.Sample{{Timestamp: tsMs, Value: 6}},
+				},
+				{
+					Labels:  []prompb.Label{{Name: "__name__", Value: "latency_bucket"}, {Name: "instance", Value: "pod-1"}, {Name: "job", Value: "checkout"}, {Name: "le", Value: "1"}},
+					Samples: []prompb.Sample{{Timestamp: tsMs, Value: 1}},
+				},
+				{
+					Labels:  []prompb.Label{{Name: "__name__", Value: "latency_bucket"}, {Name: "instance", Value: "pod-1"}, {Name: "job", Value: "checkout"}, {Name: "le", Value: "5"}},
+					Samples: []prompb.Sample{{Timestamp: tsMs, Value: 3}},
+				},
+				{
+					Labels:  []prompb.Label{{Name: "__name__", Value: "latency_bucket"}, {Name: "instance", Value: "pod-1"}, {Name: "job", Value: "checkout"}, {Name: "le", Value: "+Inf"}},
+					Samples: []prompb.Sample{{Timestamp: tsMs, Value: 6}},
+				},
+			},
+			metadata: []prompb.MetricMetadata{{Type: prompb.MetricMetadata_HISTOGRAM, MetricFamilyName: "latency"}},
+		},
+		{
+			name: "summary",
+			setup: func(ms pdata.MetricSlice) {
+				m := ms.AppendEmpty()
+				m.SetName("rpc")
+				m.SetDataType(pdata.MetricDataTypeSummary)
+				dp := m.Summary().DataPoints().AppendEmpty()
+				dp.SetTimestamp(ts)
+				dp.SetCount(2)
+				dp.SetSum(3)
+				q := dp.QuantileValues().AppendEmpty()
+				q.SetQuantile(0.5)
+				q.SetValue(1.5)
+			},
+			expected: []prompb.TimeSeries{
+				{
+					Labels:  []prompb.Label{{Name: "__name__", Value: "rpc_sum"}, {Name: "instance", Value: "pod-1"}, {Name: "job", Value: "checkout"}},
+					Samples: []prompb.Sample{{Timestamp: tsMs, Value: 3}},
+				},
+				{
+					Labels:  []prompb.Label{{Name: "__name__", Value: "rpc_count"}, {Name: "instance", Value: "pod-1"}, {Name: "job", Value: "checkout"}},
+					Samples: []prompb.Sample{{Timestamp: tsMs, Value: 2}},
+				},
+				{
+					Labels:  []prompb.Label{{Name: "__name__", Value: "rpc"}, {Name: "instance", Value: "pod-1"}, {Name: "job", Value: "checkout"}, {Name: "quantile", Value: "0.5"}},
+					Samples: []prompb.Sample{{Timestamp: tsMs, Value: 1.5}},
+				},
+			},
+			metadata: []prompb.MetricMetadata{{Type: prompb.MetricMetadata_SUMMARY, MetricFamilyName: "rpc"}},
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			md, ms := newTestOTLPMetrics()
+			c.setup(ms)
+			wr := &prompb.WriteRequest{}
+			require.NoError(t, otlpMetricsToWriteRequest(context.Background(), md, wr))
+			require.Equal(t, c.expected, wr.Timeseries)
+			require.Equal(t, c.metadata, wr.Metadata)
+		})
+	}
+}
+
+func TestOTLPHistogramExemplarsAndStaleness(t *testing.T) {
+	md, ms := newTestOTLPMetrics()
+	m := ms.AppendEmpty()
+	m.SetName("latency")
+	m.SetDataType(pdata.MetricDataTypeHistogram)
+	m.Histogram().SetAggregationTemporality(pdata.MetricAggregationTemporalityCumulative)
+	dp := m.Histogram().DataPoints().AppendEmpty()
+	dp.SetExplicitBounds([]float64{1, 5})
+	dp.SetBucketCounts([]uint64{0, 1, 0})
+	dp.SetCount(1)
+	dp.Exemplars().AppendEmpty().SetDoubleVal(3)
+	dp.SetFlags(pdata.NewMetricDataPointFlags(pdata.MetricDataPointFlagNoRecordedValue))
+
+	wr := &prompb.WriteRequest{}
+	require.NoError(t, otlpMetricsToWriteRequest(context.Background(), md, wr))
+	require.Len(t, wr.Timeseries, 5)
+	for _, ts := range wr.Timeseries {
+		require.True(t, value.IsStaleNaN(ts.Samples[0].Value))
+	}
+	require.Len(t, wr.Timeseries[2].Exemplars, 0)
+	require.Len(t, wr.Timeseries[3].Exemplars, 1)
+	require.Len(t, wr.Timeseries[4].Exemplars, 0)
+}
+
+func TestOTLPMetricsCanceled(t *testing.T) {
+	md, ms := newTestOTLPMetrics()
+	m := ms.AppendEmpty()
+	m.SetName("requests")
+	m.SetDataType(pdata.MetricDataTypeGauge)
+	m.Gauge().DataPoints().AppendEmpty().SetDoubleVal(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	wr := &prompb.WriteRequest{}
+	require.Equal(t, context.Canceled, otlpMetricsToWriteRequest(ctx, md, wr))
+	require.Len(t, wr.Timeseries, 0)
+}
+
+func TestMetricsServerExport(t *testing.T) {
+	metrics = &Metrics{
+		ReceivedSamples: &mockMetric{},
+		FailedSamples:   &mockMetric{},
+		SentSamples:     &mockMetric{},
+	}
+
+	md, ms := newTestOTLPMetrics()
+	m := ms.AppendEmpty()
+	m.SetName("up")
+	m.SetDataType(pdata.MetricDataTypeGauge)
+	m.Gauge().DataPoints().AppendEmpty().SetDoubleVal(1)
+	req := otlpgrpc.NewMetricsRequest()
+	req.SetMetrics(md)
+
+	mock := &mockInserter{result: 1}
+	_, err := NewMetricsServer(mock).Export(context.Background(), req)
+	require.NoError(t, err)
+	require.Len(t, mock.ts, 1)
+	require.Equal(t, float64(1), metrics.SentSamples.(*mockMetric).value)
+
+	mock = &mockInserter{err: fmt.Errorf("some error")}
+	_, err = NewMetricsServer(mock).Export(context.Background(), req)
+	require.Error(t, err)
+	require.Equal(t, float64(1), metrics.FailedSamples.(*mockMetric).value)
+
+	// Empty requests should not reach the inserter.
+	mock = &mockInserter{}
+	_, err = NewMetricsServer(mock).Export(context.Background(), otlpgrpc.NewMetricsRequest())
+	require.NoError(t, err)
+	require.Nil(t, mock.ts)
+}
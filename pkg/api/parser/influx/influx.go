@@ -0,0 +1,227 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package influx
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/timescale/promscale/pkg/prompb"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+// ContentType is the media type under which the InfluxDB line protocol
+// format parser is registered.
+const ContentType = "application/x-influxdb-line-protocol"
+
+// defaultFieldKey is the field name which does not get appended to the
+// measurement when building the metric name, same as in Telegraf.
+const defaultFieldKey = "value"
+
+var timeProvider = time.Now
+
+// ParseRequest parses an incoming HTTP request as InfluxDB line protocol.
+// Every numeric field of a point is converted into a series named
+// <measurement>_<field>, with the point tags as labels. String fields
+// are not supported and are ignored.
+func ParseRequest(r *http.Request, wr *prompb.WriteRequest) error {
+	toMillis, err := precisionConverter(r.URL.Query().Get("precision"))
+	if err != nil {
+		return err
+	}
+	defTime := timeProvider().UnixNano() / int64(time.Millisecond)
+
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		p, err := parseLine(line)
+		if err != nil {
+			return fmt.Errorf("error parsing line %d: %w", lineNum, err)
+		}
+
+		t := defTime
+		if p.timestamp != "" {
+			ts, err := strconv.ParseInt(p.timestamp, 10, 64)
+			if err != nil {
+				return fmt.Errorf("error parsing line %d: invalid timestamp %q", lineNum, p.timestamp)
+			}
+			t = toMillis(ts)
+		}
+
+		measurement := util.SanitizeName(p.measurement, true)
+		samples, err := fieldSamples(measurement, p.fields)
+		if err != nil {
+			return fmt.Errorf("error parsing line %d: %w", lineNum, err)
+		}
+		for _, f := range samples {
+			wr.Timeseries = append(wr.Timeseries, prompb.TimeSeries{
+				Labels:  buildLabels(f.name, p.tags),
+				Samples: []prompb.Sample{{Timestamp: t, Value: f.value}},
+			})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading request body: %w", err)
+	}
+	return nil
+}
+
+// precisionConverter returns a function converting timestamps in the given
+// precision into milliseconds. Both InfluxDB 1.x and 2.x precision values
+// are supported.
+func precisionConverter(precision string) (func(int64) int64, error) {
+	switch precision {
+	case "", "n", "ns":
+		return func(t int64) int64 { return t / int64(time.Millisecond) }, nil
+	case "u", "us", "µ":
+		return func(t int64) int64 { return t / int64(time.Microsecond) }, nil
+	case "ms":
+		return func(t int64) int64 { return t }, nil
+	case "s":
+		return func(t int64) int64 { return t * 1000 }, nil
+	case "m":
+		return func(t int64) int64 { return t * 60 * 1000 }, nil
+	case "h":
+		return func(t int64) int64 { return t * 60 * 60 * 1000 }, nil
+	default:
+		return nil, fmt.Errorf("invalid precision %q", precision)
+	}
+}
+
+// fieldSample is the sample of a numeric field of a point.
+type fieldSample struct {
+	name  string
+	key   string
+	value float64
+}
+
+// fieldSamples returns the samples of the numeric fields of a point. The
+// fields whose keys sanitize to the same metric name are deduplicated, keeping
+// the field with the lowest key.
+func fieldSamples(measurement string, fields []keyValue) ([]fieldSample, error) {
+	var (
+		samples = make([]fieldSample, 0, len(fields))
+		byName  = make(map[string]int, len(fields))
+	)
+	for _, f := range fields {
+		v, ok, err := parseFieldValue(f.value)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", f.key, err)
+		}
+		if !ok {
+			continue
+		}
+		name := measurement
+		if f.key != defaultFieldKey {
+			name = measurement + "_" + util.SanitizeName(f.key, true)
+		}
+		if i, ok := byName[name]; ok {
+			if f.key < samples[i].key {
+				samples[i] = fieldSample{name: name, key: f.key, value: v}
+			}
+			continue
+		}
+		byName[name] = len(samples)
+		samples = append(samples, fieldSample{name: name, key: f.key, value: v})
+	}
+	return samples, nil
+}
+
+// buildLabels returns the labels of a series of the given metric with the
+// point tags. The tags whose keys sanitize to the same label name are
+// deduplicated, keeping the tag with the lowest key, and the tag keys which
+// would be reserved label names are prefixed the same way as the OTLP
+// attribute keys.
+func buildLabels(name string, tags []keyValue) []prompb.Label {
+	labels := make([]prompb.Label, 0, len(tags)+1)
+	keys := make([]string, 0, len(tags)+1)
+	labels = append(labels, prompb.Label{Name: model.MetricNameLabel, Value: name})
+	keys = append(keys, "")
+	for _, tag := range tags {
+		labelName := util.SanitizeLabelName(tag.key)
+		if labelName == "" || tag.value == "" {
+			continue
+		}
+		labels = append(labels, prompb.Label{Name: labelName, Value: tag.value})
+		keys = append(keys, tag.key)
+	}
+	sort.Sort(labelsByName{labels: labels, keys: keys})
+
+	deduped := labels[:0]
+	for i, l := range labels {
+		if i > 0 && labels[i-1].Name == l.Name {
+			continue
+		}
+		deduped = append(deduped, l)
+	}
+	return deduped
+}
+
+// labelsByName sorts labels by name, then by the key they were built from.
+type labelsByName struct {
+	labels []prompb.Label
+	keys   []string
+}
+
+func (l labelsByName) Len() int { return len(l.labels) }
+
+func (l labelsByName) Less(i, j int) bool {
+	if l.labels[i].Name != l.labels[j].Name {
+		return l.labels[i].Name < l.labels[j].Name
+	}
+	return l.keys[i] < l.keys[j]
+}
+
+func (l labelsByName) Swap(i, j int) {
+	l.labels[i], l.labels[j] = l.labels[j], l.labels[i]
+	l.keys[i], l.keys[j] = l.keys[j], l.keys[i]
+}
+
+// parseFieldValue converts a field value into a float. Returns false if the
+// field type cannot be represented as a sample value (i.e. strings).
+func parseFieldValue(value string) (float64, bool, error) {
+	if value == "" {
+		return 0, false, fmt.Errorf("empty field value")
+	}
+	if value[0] == '"' {
+		return 0, false, nil
+	}
+	switch value {
+	case "t", "T", "true", "True", "TRUE":
+		return 1, true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return 0, true, nil
+	}
+	switch value[len(value)-1] {
+	case 'i':
+		i, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid integer %q", value)
+		}
+		return float64(i), true, nil
+	case 'u':
+		u, err := strconv.ParseUint(value[:len(value)-1], 10, 64)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid unsigned integer %q", value)
+		}
+		return float64(u), true, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false, fmt.Errorf("invalid float %q", value)
+	}
+	return f, true, nil
+}
@@ -0,0 +1,174 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package influx
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+func TestParseRequest(t *testing.T) {
+	defaultTime := time.Unix(1600000000, 0)
+	timeProvider = func() time.Time {
+		return defaultTime
+	}
+
+	testCases := []struct {
+		name      string
+		input     string
+		precision string
+		result    []prompb.TimeSeries
+		err       string
+	}{
+		{
+			name:  "single field with tags and timestamp",
+			input: "cpu,host=a,region=eu-west usage_user=12.5 1600000000000000000",
+			result: []prompb.TimeSeries{
+				{
+					Labels: []prompb.Label{
+						{Name: "__name__", Value: "cpu_usage_user"},
+						{Name: "host", Value: "a"},
+						{Name: "region", Value: "eu-west"},
+					},
+					Samples: []prompb.Sample{{Timestamp: 1600000000000, Value: 12.5}},
+				},
+			},
+		},
+		{
+			name:  "multiple fields of different types",
+			input: `disk,path=/ free=10i,total=20u,healthy=true,label="ignored",value=3`,
+			result: []prompb.TimeSeries{
+				{
+					Labels:  []prompb.Label{{Name: "__name__", Value: "disk_free"}, {Name: "path", Value: "/"}},
+					Samples: []prompb.Sample{{Timestamp: 1600000000000, Value: 10}},
+				},
+				{
+					Labels:  []prompb.Label{{Name: "__name__", Value: "disk_total"}, {Name: "path", Value: "/"}},
+					Samples: []prompb.Sample{{Timestamp: 1600000000000, Value: 20}},
+				},
+				{
+					Labels:  []prompb.Label{{Name: "__name__", Value: "disk_healthy"}, {Name: "path", Value: "/"}},
+					Samples: []prompb.Sample{{Timestamp: 1600000000000, Value: 1}},
+				},
+				{
+					Labels:  []prompb.Label{{Name: "__name__", Value: "disk"}, {Name: "path", Value: "/"}},
+					Samples: []prompb.Sample{{Timestamp: 1600000000000, Value: 3}},
+				},
+			},
+		},
+		{
+			name:  "escaped characters and sanitized names",
+			input: `http\ requests,status\=code=2\,00,dc-name=x bytes.in=1 1600000000000000000` + "\n\n# comment\n",
+			result: []prompb.TimeSeries{
+				{
+					Labels: []prompb.Label{
+						{Name: "__name__", Value: "http_requests_bytes_in"},
+						{Name: "dc_name", Value: "x"},
+						{Name: "status_code", Value: "2,00"},
+					},
+					Samples: []prompb.Sample{{Timestamp: 1600000000000, Value: 1}},
+				},
+			},
+		},
+		{
+			name:  "colliding and reserved names",
+			input: `cpu,b-c=1,b_c=2,__name__=x,__tenant=y a.b=1,a_b=2,a-b=3 1600000000000000000`,
+			result: []prompb.TimeSeries{
+				{
+					Labels: []prompb.Label{
+						{Name: "__name__", Value: "cpu_a_b"},
+						{Name: "b_c", Value: "1"},
+						{Name: "key__name__", Value: "x"},
+						{Name: "key__tenant", Value: "y"},
+					},
+					Samples: []prompb.Sample{{Timestamp: 1600000000000, Value: 3}},
+				},
+			},
+		},
+		{
+			name:  "string field with spaces and commas",
+			input: `log msg="a, b=c \"d\"",count=2i 1600000000000000000`,
+			result: []prompb.TimeSeries{
+				{
+					Labels:  []prompb.Label{{Name: "__name__", Value: "log_count"}},
+					Samples: []prompb.Sample{{Timestamp: 1600000000000, Value: 2}},
+				},
+			},
+		},
+		{
+			name:      "seconds precision",
+			input:     "up value=1 1600000001",
+			precision: "s",
+			result: []prompb.TimeSeries{
+				{
+					Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+					Samples: []prompb.Sample{{Timestamp: 1600000001000, Value: 1}},
+				},
+			},
+		},
+		{
+			name:      "millisecond precision",
+			input:     "up value=1 1600000001234",
+			precision: "ms",
+			result: []prompb.TimeSeries{
+				{
+					Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+					Samples: []prompb.Sample{{Timestamp: 1600000001234, Value: 1}},
+				},
+			},
+		},
+		{
+			name:      "invalid precision",
+			input:     "up value=1",
+			precision: "d",
+			err:       `invalid precision "d"`,
+		},
+		{
+			name:  "missing fields",
+			input: "cpu,host=a",
+			err:   "error parsing line 1: missing fields",
+		},
+		{
+			name:  "invalid field value",
+			input: "cpu usage=abc",
+			err:   `error parsing line 1: field "usage": invalid float "abc"`,
+		},
+		{
+			name:  "invalid timestamp",
+			input: "up value=1\nup value=1 abc",
+			err:   `error parsing line 2: invalid timestamp "abc"`,
+		},
+		{
+			name:  "unterminated string",
+			input: `cpu msg="abc`,
+			err:   "error parsing line 1: unterminated string field value",
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			url := "http://localhost/influx/write"
+			if c.precision != "" {
+				url += "?precision=" + c.precision
+			}
+			req, err := http.NewRequest("POST", url, strings.NewReader(c.input))
+			require.NoError(t, err)
+
+			wr := &prompb.WriteRequest{}
+			err = ParseRequest(req, wr)
+			if c.err != "" {
+				require.EqualError(t, err, c.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.result, wr.Timeseries)
+		})
+	}
+}
@@ -0,0 +1,129 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package influx
+
+import (
+	"fmt"
+	"strings"
+)
+
+type keyValue struct {
+	key   string
+	value string
+}
+
+// point is a single line of the line protocol:
+//
+//	<measurement>[,<tag_key>=<tag_value>...] <field_key>=<field_value>[,...] [<timestamp>]
+type point struct {
+	measurement string
+	tags        []keyValue
+	fields      []keyValue
+	timestamp   string
+}
+
+func parseLine(line string) (point, error) {
+	var (
+		p   point
+		pos int
+	)
+
+	p.measurement, pos = scanToken(line, 0, ", ")
+	if p.measurement == "" {
+		return p, fmt.Errorf("missing measurement")
+	}
+
+	for pos < len(line) && line[pos] == ',' {
+		var kv keyValue
+		kv.key, pos = scanToken(line, pos+1, "=")
+		if pos >= len(line) || kv.key == "" {
+			return p, fmt.Errorf("invalid tag set")
+		}
+		kv.value, pos = scanToken(line, pos+1, ", ")
+		p.tags = append(p.tags, kv)
+	}
+
+	if pos >= len(line) {
+		return p, fmt.Errorf("missing fields")
+	}
+	pos = skipSpaces(line, pos)
+
+	for {
+		var kv keyValue
+		kv.key, pos = scanToken(line, pos, "=")
+		if pos >= len(line) || kv.key == "" {
+			return p, fmt.Errorf("invalid field set")
+		}
+		pos++
+		if pos < len(line) && line[pos] == '"' {
+			end, err := scanQuoted(line, pos)
+			if err != nil {
+				return p, err
+			}
+			kv.value = line[pos:end]
+			pos = end
+		} else {
+			kv.value, pos = scanToken(line, pos, ", ")
+		}
+		p.fields = append(p.fields, kv)
+
+		if pos >= len(line) || line[pos] != ',' {
+			break
+		}
+		pos++
+	}
+
+	if pos < len(line) {
+		if line[pos] != ' ' {
+			return p, fmt.Errorf("unexpected character %q after field set", line[pos])
+		}
+		p.timestamp = strings.TrimSpace(line[pos:])
+		if strings.ContainsAny(p.timestamp, " \t") {
+			return p, fmt.Errorf("unexpected data after timestamp")
+		}
+	}
+	return p, nil
+}
+
+// scanToken reads an unescaped token starting at pos until one of the stop
+// characters is found. It returns the token and the position of the stop
+// character (or the end of the line).
+func scanToken(line string, pos int, stops string) (string, int) {
+	var sb strings.Builder
+	for ; pos < len(line); pos++ {
+		c := line[pos]
+		if c == '\\' && pos+1 < len(line) && strings.IndexByte(`,= "\`, line[pos+1]) >= 0 {
+			sb.WriteByte(line[pos+1])
+			pos++
+			continue
+		}
+		if strings.IndexByte(stops, c) >= 0 {
+			break
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String(), pos
+}
+
+// scanQuoted returns the position right after the closing quote of the string
+// field value starting at pos.
+func scanQuoted(line string, pos int) (int, error) {
+	for i := pos + 1; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("unterminated string field value")
+}
+
+func skipSpaces(line string, pos int) int {
+	for pos < len(line) && line[pos] == ' ' {
+		pos++
+	}
+	return pos
+}
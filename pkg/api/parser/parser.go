@@ -5,6 +5,7 @@ import (
 	"mime"
 	"net/http"
 
+	"github.com/timescale/promscale/pkg/api/parser/influx"
 	"github.com/timescale/promscale/pkg/api/parser/json"
 	"github.com/timescale/promscale/pkg/api/parser/protobuf"
 	"github.com/timescale/promscale/pkg/api/parser/text"
@@ -34,6 +35,7 @@ func NewParser() *DefaultParser {
 			"application/json":             json.ParseRequest,
 			"text/plain":                   text.ParseRequest,
 			"application/openmetrics-text": text.ParseRequest,
+			influx.ContentType:             influx.ParseRequest,
 		},
 	}
 }
@@ -53,6 +55,12 @@ func (d DefaultParser) ParseRequest(r *http.Request, req *prompb.WriteRequest) e
 	if err != nil {
 		return fmt.Errorf("parser error: unable to parse format: %w", err)
 	}
+	return d.ParseRequestAs(r, req, mediaType)
+}
+
+// ParseRequestAs runs the parser of the given format on the request, ignoring
+// the request Content-Type, and runs the preprocessors on the payload afterwards.
+func (d DefaultParser) ParseRequestAs(r *http.Request, req *prompb.WriteRequest, mediaType string) error {
 	parser, ok := d.formatParsers[mediaType]
 	if !ok {
 		return fmt.Errorf("parser error: unsupported format")
@@ -62,11 +70,17 @@ func (d DefaultParser) ParseRequest(r *http.Request, req *prompb.WriteRequest) e
 		return fmt.Errorf("parser error: %w", err)
 	}
 
+	return d.Preprocess(r, req)
+}
+
+// Preprocess runs the preprocessors on an already parsed write request. It is
+// used by ingestion paths which do not receive their data through one of the
+// HTTP format parsers.
+func (d DefaultParser) Preprocess(r *http.Request, req *prompb.WriteRequest) error {
 	if len(req.Timeseries) == 0 {
 		return nil
 	}
 
-	// run preprocessors
 	for _, p := range d.preprocessors {
 		err := p.Process(r, req)
 
@@ -11,6 +11,7 @@ import (
 
 	"github.com/NYTimes/gziphandler"
 	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgmodel/querier"
 	"github.com/timescale/promscale/pkg/promql"
 )
 
@@ -46,6 +47,17 @@ func queryHandler(queryEngine *promql.Engine, queryable promql.Queryable, metric
 			defer cancel()
 		}
 
+		qs, err := parseQueryStats(r)
+		if err != nil {
+			log.Error("msg", "Query error", "err", err.Error())
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			metrics.InvalidQueryReqs.Add(1)
+			return
+		}
+		if qs != nil {
+			ctx = querier.WithQueryStats(ctx, qs)
+		}
+
 		metrics.ReceivedQueries.Add(1)
 		begin := time.Now()
 		qry, err := queryEngine.NewInstantQuery(queryable, r.FormValue("query"), ts)
@@ -77,6 +89,6 @@ func queryHandler(queryEngine *promql.Engine, queryable promql.Queryable, metric
 			return
 		}
 
-		respondQuery(w, res, res.Warnings)
+		respondQuery(w, res, res.Warnings, newQueryStats(qry, qs))
 	}
 }
@@ -13,6 +13,7 @@ import (
 	"github.com/NYTimes/gziphandler"
 	"github.com/pkg/errors"
 	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgmodel/querier"
 	"github.com/timescale/promscale/pkg/promql"
 )
 
@@ -87,6 +88,17 @@ func queryRange(conf *Config, queryEngine *promql.Engine, queryable promql.Query
 			defer cancel()
 		}
 
+		qs, err := parseQueryStats(r)
+		if err != nil {
+			log.Info("msg", "Query bad request"+err.Error())
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			metrics.InvalidQueryReqs.Add(1)
+			return
+		}
+		if qs != nil {
+			ctx = querier.WithQueryStats(ctx, qs)
+		}
+
 		metrics.ReceivedQueries.Add(1)
 		begin := time.Now()
 		qry, err := queryEngine.NewRangeQuery(
@@ -103,7 +115,13 @@ func queryRange(conf *Config, queryEngine *promql.Engine, queryable promql.Query
 			return
 		}
 
-		res := qry.Exec(ctx)
+		var res *promql.Result
+		// Cached results have no statistics.
+		if conf.QueryCache != nil && qs == nil {
+			res = conf.QueryCache.Exec(ctx, queryEngine, queryable, qry)
+		} else {
+			res = qry.Exec(ctx)
+		}
 		metrics.QueryDuration.Observe(time.Since(begin).Seconds())
 
 		if res.Err != nil {
@@ -124,6 +142,6 @@ func queryRange(conf *Config, queryEngine *promql.Engine, queryable promql.Query
 			return
 		}
 
-		respondQuery(w, res, res.Warnings)
+		respondQuery(w, res, res.Warnings, newQueryStats(qry, qs))
 	}
 }
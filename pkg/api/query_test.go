@@ -20,6 +20,7 @@ import (
 	"github.com/prometheus/prometheus/promql/parser"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgmodel/lreader"
 	"github.com/timescale/promscale/pkg/pgmodel/model"
 	"github.com/timescale/promscale/pkg/pgmodel/querier"
 	"github.com/timescale/promscale/pkg/prompb"
@@ -60,7 +61,11 @@ func (m mockQuerier) Query(*prompb.Query) ([]*prompb.TimeSeries, error) {
 	panic("implement me")
 }
 
-func (m mockQuerier) SamplesQuerier() querier.SamplesQuerier {
+func (m mockQuerier) ReadSeries(context.Context, *prompb.Query) (querier.SeriesSet, error) {
+	panic("implement me")
+}
+
+func (m mockQuerier) SamplesQuerier(context.Context) querier.SamplesQuerier {
 	return m
 }
 
@@ -74,6 +79,10 @@ func (m mockQuerier) ExemplarsQuerier(_ context.Context) querier.ExemplarQuerier
 	return mockExemplarQuerier{}
 }
 
+func (m mockQuerier) SeriesFilter(int64, int64, ...*labels.Matcher) (*lreader.SeriesFilter, error) {
+	return nil, nil
+}
+
 type mockExemplarQuerier struct{}
 
 // Select implements the querier.ExemplarQuerier interface.
@@ -94,6 +103,14 @@ func (m mockLabelsReader) LabelValues(string) ([]string, error) {
 	return nil, nil
 }
 
+func (m mockLabelsReader) FilteredLabelNames(*lreader.SeriesFilter) ([]string, error) {
+	return m.labelNames, m.labelNamesErr
+}
+
+func (m mockLabelsReader) FilteredLabelValues(string, *lreader.SeriesFilter) ([]string, error) {
+	return nil, nil
+}
+
 func (m mockLabelsReader) LabelsForIdMap(idMap map[int64]labels.Label) (err error) {
 	return nil
 }
@@ -253,6 +270,90 @@ func TestQuery(t *testing.T) {
 
 }
 
+func TestQueryStats(t *testing.T) {
+	testCases := []struct {
+		name        string
+		params      string
+		expectCode  int
+		expectStats bool
+	}{
+		{
+			name:       "No stats",
+			expectCode: http.StatusOK,
+		}, {
+			name:        "Stats",
+			params:      "&stats=all",
+			expectCode:  http.StatusOK,
+			expectStats: true,
+		}, {
+			name:        "Explain",
+			params:      "&explain=true",
+			expectCode:  http.StatusOK,
+			expectStats: true,
+		}, {
+			name:       "Explain disabled",
+			params:     "&explain=false",
+			expectCode: http.StatusOK,
+		}, {
+			name:       "Explain is unparsable",
+			params:     "&explain=unparsable",
+			expectCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			engine := promql.NewEngine(
+				promql.EngineOpts{
+					Logger:     log.GetLogger(),
+					Reg:        prometheus.NewRegistry(),
+					MaxSamples: math.MaxInt32,
+					Timeout:    time.Minute,
+				},
+			)
+			metrics := &Metrics{
+				FailedQueries:    &mockMetric{},
+				ReceivedQueries:  &mockMetric{},
+				InvalidQueryReqs: &mockMetric{},
+				QueryDuration:    &mockMetric{},
+			}
+			handler := queryHandler(engine, query.NewQueryable(&mockQuerier{}, &mockLabelsReader{}), metrics)
+			w := doQuery(t, handler, constructQuery("m", "1", "30s")+tc.params, false)
+			if w.Code != tc.expectCode {
+				t.Fatalf("Unexpected HTTP status code received: got %d wanted %d", w.Code, tc.expectCode)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Data struct {
+					Stats *struct {
+						Timings   map[string]float64      `json:"timings"`
+						Selectors []querier.SelectorStats `json:"selectors"`
+					} `json:"stats"`
+				} `json:"data"`
+			}
+			if err := json.NewDecoder(bytes.NewReader(w.Body.Bytes())).Decode(&resp); err != nil {
+				t.Fatalf("unexpected error decoding the response: %v", err)
+			}
+			if !tc.expectStats {
+				if resp.Data.Stats != nil {
+					t.Fatalf("unexpected stats in the response: %s", w.Body.String())
+				}
+				return
+			}
+			if resp.Data.Stats == nil {
+				t.Fatalf("missing stats in the response: %s", w.Body.String())
+			}
+			if _, ok := resp.Data.Stats.Timings["execTotalTime"]; !ok {
+				t.Errorf("missing execTotalTime in the timings: %v", resp.Data.Stats.Timings)
+			}
+			if resp.Data.Stats.Selectors == nil {
+				t.Errorf("missing selectors in the stats: %s", w.Body.String())
+			}
+		})
+	}
+}
+
 func constructQuery(metric, time string, timeout string) string {
 	return fmt.Sprintf("http://localhost:9090/query?query=%s&time=%s&timeout=%s", metric, time, timeout)
 }
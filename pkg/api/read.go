@@ -64,6 +64,31 @@ func Read(config *Config, reader querier.Reader, metrics *Metrics) http.Handler
 			}
 		}
 
+		responseType, err := negotiateResponseType(req.AcceptedResponseTypes)
+		if err != nil {
+			log.Error("msg", "Read response type error", "err", err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if responseType == prompb.ReadRequest_STREAMED_XOR_CHUNKS {
+			if sent, err := streamChunkedRead(r.Context(), w, reader, &req); err != nil {
+				log.Warn("msg", "Error executing query", "query", req, "storage", "PostgreSQL", "err", err)
+				metrics.FailedQueries.Add(queryCount)
+				if sent {
+					// The error can only be reported in the status code
+					// if no frame was sent yet. Otherwise the connection
+					// is aborted, so that the client does not take the
+					// frames sent so far for the complete response.
+					panic(http.ErrAbortHandler)
+				}
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			metrics.QueryBatchDuration.Observe(time.Since(begin).Seconds())
+			return
+		}
+
 		var resp *prompb.ReadResponse
 		resp, err = reader.Read(&req)
 		if err != nil {
@@ -0,0 +1,183 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/timescale/promscale/pkg/pgmodel/querier"
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+const (
+	// maxBytesInFrame is the size above which the chunks of a series are
+	// split over several frames of a streamed response. It is the default
+	// of Prometheus.
+	maxBytesInFrame = 1024 * 1024
+
+	streamedContentType = "application/x-streamed-protobuf; proto=prometheus.ChunkedReadResponse"
+)
+
+// negotiateResponseType returns the first response type accepted by the client
+// which is supported. Samples are returned if the client does not tell.
+func negotiateResponseType(accepted []prompb.ReadRequest_ResponseType) (prompb.ReadRequest_ResponseType, error) {
+	if len(accepted) == 0 {
+		return prompb.ReadRequest_SAMPLES, nil
+	}
+	for _, t := range accepted {
+		switch t {
+		case prompb.ReadRequest_SAMPLES, prompb.ReadRequest_STREAMED_XOR_CHUNKS:
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("none of the accepted response types %v is supported", accepted)
+}
+
+// streamChunkedRead writes the series of each query as XOR chunks, flushing
+// a ChunkedReadResponse frame at a time. The series are read from the
+// database while they are sent, so only the series being encoded is held in
+// memory. It returns whether frames were sent, in which case the error can no
+// longer be reported in the response.
+func streamChunkedRead(ctx context.Context, w http.ResponseWriter, reader querier.Reader, req *prompb.ReadRequest) (bool, error) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		return false, fmt.Errorf("response writer does not support flushing")
+	}
+	w.Header().Set("Content-Type", streamedContentType)
+	stream := newChunkedWriter(w, f)
+	for i, q := range req.Queries {
+		if err := streamQuery(ctx, stream, int64(i), reader, q); err != nil {
+			return stream.sent, err
+		}
+	}
+	return stream.sent, nil
+}
+
+func streamQuery(ctx context.Context, stream io.Writer, queryIndex int64, reader querier.Reader, q *prompb.Query) error {
+	ss, err := reader.ReadSeries(ctx, q)
+	if err != nil {
+		return err
+	}
+	defer ss.Close()
+	return streamChunkedReadResponses(stream, queryIndex, storage.NewSeriesSetToChunkSet(ss), maxBytesInFrame)
+}
+
+// streamChunkedReadResponses is the counterpart of the Prometheus function of
+// the same name, for our protobuf types. At most one series is sent per frame,
+// and a series is split over several frames when its chunks exceed
+// maxBytesInFrame.
+func streamChunkedReadResponses(stream io.Writer, queryIndex int64, ss storage.ChunkSeriesSet, maxBytesInFrame int) error {
+	var chks []prompb.Chunk
+	for ss.Next() {
+		series := ss.At()
+		lbls := labelsToLabelsProto(series.Labels())
+		frameBytesLeft := maxBytesInFrame
+		for _, l := range lbls {
+			frameBytesLeft -= l.Size()
+		}
+
+		iter := series.Iterator()
+		isNext := iter.Next()
+		for isNext {
+			chk := iter.At()
+			if chk.Chunk == nil {
+				return fmt.Errorf("found not populated chunk at ref %v", chk.Ref)
+			}
+			chks = append(chks, prompb.Chunk{
+				MinTimeMs: chk.MinTime,
+				MaxTimeMs: chk.MaxTime,
+				Type:      prompb.Chunk_Encoding(chk.Chunk.Encoding()),
+				Data:      chk.Chunk.Bytes(),
+			})
+			frameBytesLeft -= chks[len(chks)-1].Size()
+
+			// The frames may exceed maxBytesInFrame by up to a chunk.
+			isNext = iter.Next()
+			if frameBytesLeft > 0 && isNext {
+				continue
+			}
+
+			b, err := proto.Marshal(&prompb.ChunkedReadResponse{
+				ChunkedSeries: []*prompb.ChunkedSeries{{Labels: lbls, Chunks: chks}},
+				QueryIndex:    queryIndex,
+			})
+			if err != nil {
+				return fmt.Errorf("marshal ChunkedReadResponse: %w", err)
+			}
+			if _, err := stream.Write(b); err != nil {
+				return fmt.Errorf("write to stream: %w", err)
+			}
+			chks = chks[:0]
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+	}
+	return ss.Err()
+}
+
+func labelsToLabelsProto(lset labels.Labels) []prompb.Label {
+	result := make([]prompb.Label, 0, len(lset))
+	for _, l := range lset {
+		result = append(result, prompb.Label{Name: l.Name, Value: l.Value})
+	}
+	return result
+}
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// chunkedWriter writes the frames of a streamed remote read response, in the
+// format of the Prometheus ChunkedWriter, which cannot be imported since it
+// registers the same protobuf types as our prompb package. Each frame is made
+// of the uvarint size of the data, the big-endian Castagnoli CRC-32 of the
+// data and the data. The response is flushed after each frame.
+type chunkedWriter struct {
+	writer  io.Writer
+	flusher http.Flusher
+	crc32   hash.Hash32
+	// sent is set once something was written.
+	sent bool
+}
+
+func newChunkedWriter(w io.Writer, f http.Flusher) *chunkedWriter {
+	return &chunkedWriter{writer: w, flusher: f, crc32: crc32.New(castagnoliTable)}
+}
+
+// Write writes b as a frame. The returned size does not include the size and
+// checksum of the frame.
+func (w *chunkedWriter) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	w.sent = true
+	var buf [binary.MaxVarintLen64]byte
+	v := binary.PutUvarint(buf[:], uint64(len(b)))
+	if _, err := w.writer.Write(buf[:v]); err != nil {
+		return 0, err
+	}
+
+	w.crc32.Reset()
+	_, _ = w.crc32.Write(b)
+	if err := binary.Write(w.writer, binary.BigEndian, w.crc32.Sum32()); err != nil {
+		return 0, err
+	}
+
+	n, err := w.writer.Write(b)
+	if err != nil {
+		return n, err
+	}
+	w.flusher.Flush()
+	return n, nil
+}
@@ -0,0 +1,163 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+func TestReadStreamed(t *testing.T) {
+	reader := &mockReader{
+		series: []storage.Series{
+			newTestSeries(labels.FromStrings("__name__", "up", "job", "a"), 250),
+			newTestSeries(labels.FromStrings("__name__", "up", "job", "b"), 1),
+		},
+	}
+	metrics := &Metrics{
+		QueryBatchDuration: &mockMetric{},
+		FailedQueries:      &mockMetric{},
+		ReceivedQueries:    &mockMetric{},
+		InvalidReadReqs:    &mockMetric{},
+	}
+	test := GenerateReadHandleTester(t, Read(&Config{}, reader, metrics), false)
+
+	w := test("POST", getReader(readRequestToString(&prompb.ReadRequest{
+		Queries:               []*prompb.Query{{}, {}},
+		AcceptedResponseTypes: []prompb.ReadRequest_ResponseType{prompb.ReadRequest_STREAMED_XOR_CHUNKS},
+	})))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, streamedContentType, w.Header().Get("Content-Type"))
+
+	type frameSummary struct {
+		queryIndex int64
+		labels     string
+		samples    []int
+	}
+	var frames []frameSummary
+	for _, res := range readFrames(t, w.Body) {
+		require.Len(t, res.ChunkedSeries, 1)
+		frame := frameSummary{queryIndex: res.QueryIndex}
+		for _, l := range res.ChunkedSeries[0].Labels {
+			frame.labels += l.Name + "=" + l.Value + ","
+		}
+		for _, c := range res.ChunkedSeries[0].Chunks {
+			require.Equal(t, prompb.Chunk_XOR, c.Type)
+			chk, err := chunkenc.FromData(chunkenc.EncXOR, c.Data)
+			require.NoError(t, err)
+			frame.samples = append(frame.samples, chk.NumSamples())
+		}
+		frames = append(frames, frame)
+	}
+	expected := []frameSummary{
+		{0, "__name__=up,job=a,", []int{120, 120, 10}},
+		{0, "__name__=up,job=b,", []int{1}},
+		{1, "__name__=up,job=a,", []int{120, 120, 10}},
+		{1, "__name__=up,job=b,", []int{1}},
+	}
+	require.Equal(t, expected, frames)
+	require.Equal(t, float64(2), metrics.ReceivedQueries.(*mockMetric).value)
+
+	// Unsupported response types are rejected.
+	w = test("POST", getReader(readRequestToString(&prompb.ReadRequest{
+		Queries:               []*prompb.Query{{}},
+		AcceptedResponseTypes: []prompb.ReadRequest_ResponseType{5},
+	})))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestReadStreamedError(t *testing.T) {
+	series := []storage.Series{newTestSeries(labels.FromStrings("__name__", "up", "job", "a"), 1)}
+	metrics := &Metrics{
+		QueryBatchDuration: &mockMetric{},
+		FailedQueries:      &mockMetric{},
+		ReceivedQueries:    &mockMetric{},
+		InvalidReadReqs:    &mockMetric{},
+	}
+	body := readRequestToString(&prompb.ReadRequest{
+		Queries:               []*prompb.Query{{}},
+		AcceptedResponseTypes: []prompb.ReadRequest_ResponseType{prompb.ReadRequest_STREAMED_XOR_CHUNKS},
+	})
+
+	// Before any frame is sent, the error is reported in the status code.
+	test := GenerateReadHandleTester(t, Read(&Config{}, &mockReader{seriesErr: fmt.Errorf("some error")}, metrics), false)
+	w := test("POST", getReader(body))
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+
+	// Once frames were sent, the response is aborted so that the client does
+	// not take the partial response for a complete one.
+	reader := &mockReader{series: series, seriesErr: fmt.Errorf("some error")}
+	server := httptest.NewServer(Read(&Config{}, reader, metrics))
+	defer server.Close()
+	req, err := http.NewRequest("POST", server.URL, getReader(body))
+	require.NoError(t, err)
+	req.Header.Add("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Read-Version", "0.1.0")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	_, err = ioutil.ReadAll(resp.Body)
+	require.Error(t, err)
+	require.Equal(t, float64(2), metrics.FailedQueries.(*mockMetric).value)
+}
+
+func TestStreamChunkedReadResponsesFrameSize(t *testing.T) {
+	ss := &listSeriesSet{series: []storage.Series{newTestSeries(labels.FromStrings("__name__", "up"), 250)}, idx: -1}
+	var buf bytes.Buffer
+	stream := newChunkedWriter(&buf, httptest.NewRecorder())
+	// A frame is cut as soon as it exceeds the limit, so each chunk is sent
+	// in its own frame.
+	require.NoError(t, streamChunkedReadResponses(stream, 0, storage.NewSeriesSetToChunkSet(ss), 1))
+
+	frames := readFrames(t, &buf)
+	require.Len(t, frames, 3)
+	for _, res := range frames {
+		require.Len(t, res.ChunkedSeries[0].Chunks, 1)
+		require.Equal(t, "up", res.ChunkedSeries[0].Labels[0].Value)
+	}
+}
+
+// readFrames decodes the frames of a streamed response, checking their
+// checksums.
+func readFrames(t *testing.T, r io.Reader) []prompb.ChunkedReadResponse {
+	var (
+		br     = bufio.NewReader(r)
+		frames []prompb.ChunkedReadResponse
+	)
+	for {
+		size, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return frames
+		}
+		require.NoError(t, err)
+		var checksum uint32
+		require.NoError(t, binary.Read(br, binary.BigEndian, &checksum))
+		data := make([]byte, size)
+		_, err = io.ReadFull(br, data)
+		require.NoError(t, err)
+		require.Equal(t, crc32.Checksum(data, castagnoliTable), checksum)
+
+		var res prompb.ChunkedReadResponse
+		require.NoError(t, proto.Unmarshal(data, &res))
+		frames = append(frames, res)
+	}
+}
@@ -5,6 +5,7 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -13,6 +14,10 @@ import (
 
 	"github.com/gogo/protobuf/proto"
 	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/timescale/promscale/pkg/pgmodel/querier"
 	"github.com/timescale/promscale/pkg/prompb"
 )
 
@@ -117,9 +122,11 @@ func readRequestToString(r *prompb.ReadRequest) string {
 }
 
 type mockReader struct {
-	request  *prompb.ReadRequest
-	response *prompb.ReadResponse
-	err      error
+	request   *prompb.ReadRequest
+	response  *prompb.ReadResponse
+	series    []storage.Series
+	seriesErr error
+	err       error
 }
 
 func (m *mockReader) Read(r *prompb.ReadRequest) (*prompb.ReadResponse, error) {
@@ -127,6 +134,50 @@ func (m *mockReader) Read(r *prompb.ReadRequest) (*prompb.ReadResponse, error) {
 	return m.response, m.err
 }
 
+func (m *mockReader) ReadSeries(context.Context, *prompb.Query) (querier.SeriesSet, error) {
+	return &listSeriesSet{series: m.series, idx: -1, err: m.seriesErr}, m.err
+}
+
+type listSeriesSet struct {
+	series []storage.Series
+	idx    int
+	// err is returned once all the series were read.
+	err error
+}
+
+func (l *listSeriesSet) Next() bool {
+	l.idx++
+	return l.idx < len(l.series)
+}
+
+func (l *listSeriesSet) At() storage.Series { return l.series[l.idx] }
+
+func (l *listSeriesSet) Err() error {
+	if l.idx < len(l.series) {
+		return nil
+	}
+	return l.err
+}
+
+func (l *listSeriesSet) Warnings() storage.Warnings { return nil }
+func (l *listSeriesSet) Close()                     {}
+
+type testSample struct {
+	t int64
+	v float64
+}
+
+func (s testSample) T() int64   { return s.t }
+func (s testSample) V() float64 { return s.v }
+
+func newTestSeries(lset labels.Labels, numSamples int) storage.Series {
+	samples := make([]tsdbutil.Sample, 0, numSamples)
+	for i := 0; i < numSamples; i++ {
+		samples = append(samples, testSample{t: int64(i) * 1000, v: float64(i)})
+	}
+	return storage.NewListSeries(lset, samples)
+}
+
 func GenerateReadHandleTester(t *testing.T, handleFunc http.Handler, badHeader bool) HandleTester {
 	return func(method string, body io.Reader) *httptest.ResponseRecorder {
 		req, err := http.NewRequest(method, "", body)
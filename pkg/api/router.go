@@ -21,11 +21,23 @@ import (
 	"github.com/timescale/promscale/pkg/log"
 	"github.com/timescale/promscale/pkg/pgclient"
 	"github.com/timescale/promscale/pkg/query"
+	"github.com/timescale/promscale/pkg/relabel"
 	"github.com/timescale/promscale/pkg/util"
 )
 
-func GenerateRouter(apiConf *Config, client *pgclient.Client, elector *util.Elector) (http.Handler, error) {
+// NewWriteParser returns the parser used for all incoming write requests, set
+// up with the relabeling, HA, multi-tenancy and ingest limits preprocessors
+// enabled in the configuration. Relabeling runs first, same as Prometheus
+// applies its write relabeling before sending, so the others see the final
+// labels. The ingest limiter runs last, since its error leaves a valid request
+// behind and must not prevent the other preprocessors from running.
+// The same parser must be shared by all write paths so that they see the same
+// HA lease state.
+func NewWriteParser(apiConf *Config, client *pgclient.Client) *parser.DefaultParser {
 	var writePreprocessors []parser.Preprocessor
+	if relabeler := relabel.NewRelabeler(apiConf.WriteRelabelConfigs); relabeler != nil {
+		writePreprocessors = append(writePreprocessors, relabeler)
+	}
 	if apiConf.HighAvailability {
 		service := ha.NewService(haClient.NewLeaseClient(client.Connection))
 		writePreprocessors = append(writePreprocessors, ha.NewFilter(service))
@@ -33,17 +45,28 @@ func GenerateRouter(apiConf *Config, client *pgclient.Client, elector *util.Elec
 	if apiConf.MultiTenancy != nil {
 		writePreprocessors = append(writePreprocessors, apiConf.MultiTenancy.WriteAuthorizer())
 	}
+	if apiConf.IngestLimiter != nil {
+		writePreprocessors = append(writePreprocessors, apiConf.IngestLimiter)
+	}
 
 	dataParser := parser.NewParser()
 	for _, preproc := range writePreprocessors {
 		dataParser.AddPreprocessor(preproc)
 	}
+	return dataParser
+}
 
+func GenerateRouter(apiConf *Config, client *pgclient.Client, dataParser *parser.DefaultParser, elector *util.Elector) (http.Handler, error) {
 	writeHandler := timeHandler(metrics.HTTPRequestDuration, "write", Write(client, dataParser, elector))
 
+	influxWriteHandler := timeHandler(metrics.HTTPRequestDuration, "influx/write", InfluxWrite(client, dataParser, elector))
+	otlpTracesHandler := timeHandler(metrics.HTTPRequestDuration, "v1/traces", OTLPTraces(client))
+
 	// If we are running in read-only mode, log and send NotFound status.
 	if apiConf.ReadOnly {
 		writeHandler = withWarnLog("trying to send metrics to write API while connector is in read-only mode", http.NotFoundHandler())
+		influxWriteHandler = withWarnLog("trying to send metrics to Influx write API while connector is in read-only mode", http.NotFoundHandler())
+		otlpTracesHandler = withWarnLog("trying to send traces to OTLP traces API while connector is in read-only mode", http.NotFoundHandler())
 	}
 
 	authWrapper := func(name string, h http.HandlerFunc) http.HandlerFunc {
@@ -53,6 +76,8 @@ func GenerateRouter(apiConf *Config, client *pgclient.Client, elector *util.Elec
 	router := route.New().WithInstrumentation(authWrapper)
 
 	router.Post("/write", writeHandler)
+	router.Post("/influx/write", influxWriteHandler)
+	router.Post("/v1/traces", otlpTracesHandler)
 
 	readHandler := timeHandler(metrics.HTTPRequestDuration, "read", Read(apiConf, client, metrics))
 	router.Get("/read", readHandler)
@@ -91,9 +116,15 @@ func GenerateRouter(apiConf *Config, client *pgclient.Client, elector *util.Elec
 	router.Get("/api/v1/metadata", metadataHandler)
 	router.Post("/api/v1/metadata", metadataHandler)
 
+	tsdbStatusHandler := timeHandler(metrics.HTTPRequestDuration, "status/tsdb", TSDBStatus(apiConf, client))
+	router.Get("/api/v1/status/tsdb", tsdbStatusHandler)
+
 	labelValuesHandler := timeHandler(metrics.HTTPRequestDuration, "label/:name/values", LabelValues(apiConf, queryable))
 	router.Get("/api/v1/label/:name/values", labelValuesHandler)
 
+	federateHandler := timeHandler(metrics.HTTPRequestDuration, "federate", Federate(apiConf, queryable))
+	router.Get("/federate", federateHandler)
+
 	healthChecker := func() error { return client.HealthCheck() }
 	router.Get("/healthz", Health(healthChecker))
 
@@ -0,0 +1,85 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/NYTimes/gziphandler"
+	"github.com/jackc/pgconn"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgclient"
+	"github.com/timescale/promscale/pkg/pgmodel/cardinality"
+	"github.com/timescale/promscale/pkg/pgmodel/lreader"
+	"github.com/timescale/promscale/pkg/pgxconn"
+)
+
+// defaultTSDBStatusLimit is the number of entries returned for each statistic,
+// the same as in Prometheus.
+const defaultTSDBStatusLimit = 10
+
+// seriesFilterer returns the filter selecting the series of the authorized
+// tenants.
+type seriesFilterer interface {
+	SeriesFilter(mint, maxt int64, ms ...*labels.Matcher) (*lreader.SeriesFilter, error)
+}
+
+func TSDBStatus(conf *Config, client *pgclient.Client) http.Handler {
+	hf := corsWrapper(conf, tsdbStatusHandler(conf, client.Connection, client))
+	return gziphandler.GzipHandler(hf)
+}
+
+func tsdbStatusHandler(conf *Config, conn pgxconn.PgxConn, filterer seriesFilterer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		limit := defaultTSDBStatusLimit
+		if s := r.FormValue("limit"); s != "" {
+			l, err := strconv.Atoi(s)
+			if err != nil || l <= 0 {
+				respondError(w, http.StatusBadRequest, fmt.Errorf("limit must be a positive number"), "bad_data")
+				return
+			}
+			limit = l
+		}
+		// With multi-tenancy, the statistics only cover the series of the
+		// authorized tenants.
+		filter, err := filterer.SeriesFilter(math.MinInt64, math.MaxInt64)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err, "internal")
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), conf.MaxQueryTimeout)
+		defer cancel()
+		status, err := cardinality.Status(ctx, conn, limit, filter)
+		if err != nil {
+			log.Error("msg", err, "endpoint", "status/tsdb")
+			if pgconn.Timeout(err) {
+				respondError(w, http.StatusServiceUnavailable, err, "timeout")
+				return
+			}
+			respondError(w, http.StatusInternalServerError, err, "internal")
+			return
+		}
+		respondTSDBStatus(w, status)
+	}
+}
+
+func respondTSDBStatus(w http.ResponseWriter, status *cardinality.TSDBStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(&response{
+		Status: "success",
+		Data:   status,
+	})
+}
@@ -0,0 +1,36 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/pgmodel/lreader"
+	"github.com/timescale/promscale/pkg/pgmodel/model"
+)
+
+type mockSeriesFilterer struct {
+	filter *lreader.SeriesFilter
+	err    error
+}
+
+func (m mockSeriesFilterer) SeriesFilter(int64, int64, ...*labels.Matcher) (*lreader.SeriesFilter, error) {
+	return m.filter, m.err
+}
+
+func TestTSDBStatusLimit(t *testing.T) {
+	for _, limit := range []string{"-1", "0", "foo"} {
+		req, err := http.NewRequestWithContext(context.Background(), "GET", "http://localhost:9201/api/v1/status/tsdb?limit="+limit, nil)
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		tsdbStatusHandler(&Config{}, model.NewSqlRecorder(nil, t), mockSeriesFilterer{}).ServeHTTP(w, req)
+		require.Equal(t, http.StatusBadRequest, w.Code, "limit %s", limit)
+	}
+}
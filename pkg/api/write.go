@@ -6,10 +6,13 @@ package api
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -17,8 +20,11 @@ import (
 
 	"github.com/golang/snappy"
 	"github.com/timescale/promscale/pkg/api/parser"
+	"github.com/timescale/promscale/pkg/api/parser/influx"
+	"github.com/timescale/promscale/pkg/limits"
 	"github.com/timescale/promscale/pkg/log"
 	"github.com/timescale/promscale/pkg/pgmodel/ingestor"
+	"github.com/timescale/promscale/pkg/prompb"
 	"github.com/timescale/promscale/pkg/util"
 )
 
@@ -54,11 +60,44 @@ func Write(inserter ingestor.DBInserter, dataParser *parser.DefaultParser, elect
 		validateWriteHeaders,
 		checkLegacyHA(elector),
 		decodeSnappy,
-		ingest(inserter, dataParser),
+		ingest(inserter, dataParser.ParseRequest),
 	)
 	return wh.handler()
 }
 
+// InfluxWrite returns an http.Handler that is responsible for ingesting data
+// sent using the InfluxDB line protocol write API. The request body is parsed
+// as line protocol regardless of its Content-Type, since Influx clients
+// usually send it as plain text.
+func InfluxWrite(inserter ingestor.DBInserter, dataParser *parser.DefaultParser, elector *util.Elector) http.Handler {
+	parseInflux := func(r *http.Request, req *prompb.WriteRequest) error {
+		return dataParser.ParseRequestAs(r, req, influx.ContentType)
+	}
+	wh := writeHandler{}
+	wh.addStages(
+		validateInfluxWriteRequest,
+		checkLegacyHA(elector),
+		decodeGzip,
+		ingest(inserter, parseInflux),
+		respondNoContent,
+	)
+	return wh.handler()
+}
+
+func validateInfluxWriteRequest(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != "POST" {
+		validateError(w, fmt.Sprintf("HTTP Method %s instead of POST", r.Method), metrics)
+		return false
+	}
+	return true
+}
+
+// respondNoContent responds the same way as InfluxDB does on successful writes.
+func respondNoContent(w http.ResponseWriter, _ *http.Request) bool {
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
 func validateWriteHeaders(w http.ResponseWriter, r *http.Request) bool {
 	// validate headers from https://github.com/prometheus/prometheus/blob/2bd077ed9724548b6a631b6ddba48928704b5c34/storage/remote/client.go
 	if r.Method != "POST" {
@@ -78,22 +117,24 @@ func validateWriteHeaders(w http.ResponseWriter, r *http.Request) bool {
 			return false
 		}
 
-		remoteWriteVersion := r.Header.Get("X-Prometheus-Remote-Write-Version")
+		remoteWriteVersion := r.Header.Get(remoteWriteVersionHeader)
 		if remoteWriteVersion == "" {
 			validateError(w, "Missing X-Prometheus-Remote-Write-Version header", metrics)
 			return false
 		}
 
-		if !strings.HasPrefix(remoteWriteVersion, "0.1.") {
-			validateError(w, fmt.Sprintf("unexpected Remote-Write-Version %s, expected 0.1.X", remoteWriteVersion), metrics)
+		if !strings.HasPrefix(remoteWriteVersion, legacyRemoteWriteVersionPrefix) && !strings.HasPrefix(remoteWriteVersion, remoteWriteVersionPrefix) {
+			validateError(w, fmt.Sprintf("unexpected Remote-Write-Version %s, expected 0.1.X or 1.X", remoteWriteVersion), metrics)
 			return false
 		}
 	case "application/json":
 		// Don't need any other header checks for JSON content type.
 	case "text/plain", "application/openmetrics":
 		// Don't need any other header checks for text content type.
+	case influx.ContentType:
+		// Don't need any other header checks for InfluxDB line protocol.
 	default:
-		validateError(w, "unsupported data format (not protobuf, JSON, or text format)", metrics)
+		validateError(w, "unsupported data format (not protobuf, JSON, text or InfluxDB line protocol format)", metrics)
 		return false
 	}
 
@@ -219,34 +260,63 @@ var decodedBufPool = sync.Pool{
 	},
 }
 
-func ingest(inserter ingestor.DBInserter, dataParser *parser.DefaultParser) func(http.ResponseWriter, *http.Request) bool {
+func ingest(inserter ingestor.DBInserter, parse func(*http.Request, *prompb.WriteRequest) error) func(http.ResponseWriter, *http.Request) bool {
 	return func(w http.ResponseWriter, r *http.Request) bool {
 		req := ingestor.NewWriteRequest()
-		err := dataParser.ParseRequest(r, req)
+		err := parse(r, req)
+
+		// Series exceeding the ingest limits were removed from the request.
+		// The rest is ingested before asking the sender to back off.
+		var throttled *limits.ThrottledError
+		if errors.As(err, &throttled) {
+			err = nil
+		}
 		if err != nil {
 			ingestor.FinishWriteRequest(req)
 			invalidRequestError(w, "parser error", err.Error(), metrics)
 			return false
 		}
 
+		// Remote-write 1.x senders get per-series error reporting: invalid
+		// series are dropped from the request and reported back, while the
+		// rest of the request is ingested.
+		var rejected []rejectedSeries
+		partialWrites := supportsPartialWrites(r)
+		if partialWrites {
+			w.Header().Set(remoteWriteVersionHeader, negotiatedRemoteWriteVersion)
+			rejected = filterInvalidSeries(req)
+			for _, rs := range rejected {
+				metrics.RejectedSeries.WithLabelValues(rs.Reason).Inc()
+			}
+		}
+
 		// if samples in write request are empty the we do not need to
-		// proceed further
+		// ingest anything, the write is still successful unless series
+		// were throttled or rejected
 		if len(req.Timeseries) == 0 && len(req.Metadata) == 0 {
 			ingestor.FinishWriteRequest(req)
-			return false
+			if throttled != nil {
+				respondThrottled(w, throttled)
+				return false
+			}
+			if len(rejected) > 0 {
+				respondPartialWrite(w, 0, rejected)
+				return false
+			}
+			return true
 		}
 
 		var receivedSamplesCount, receivedMetadataCount int64
 
 		for _, ts := range req.Timeseries {
-			receivedSamplesCount += int64(len(ts.Samples))
+			receivedSamplesCount += int64(len(ts.Samples) + len(ts.Histograms))
 		}
 		receivedMetadataCount += int64(len(req.Metadata))
 
 		metrics.ReceivedSamples.Add(float64(receivedSamplesCount))
 		begin := time.Now()
 
-		numSamples, numMetadata, err := inserter.Ingest(req)
+		numSamples, numMetadata, err := inserter.Ingest(r.Context(), req)
 		if err != nil {
 			log.Warn("msg", "Error sending samples to remote storage", "err", err, "num_samples", numSamples)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -259,10 +329,51 @@ func ingest(inserter ingestor.DBInserter, dataParser *parser.DefaultParser) func
 		metrics.SentSamples.Add(float64(numSamples))
 		metrics.SentMetadata.Add(float64(numMetadata))
 		metrics.SentBatchDuration.Observe(duration)
+
+		if partialWrites {
+			w.Header().Set(remoteWriteSamplesWrittenHeader, strconv.FormatUint(numSamples, 10))
+		}
+		if throttled != nil {
+			respondThrottled(w, throttled)
+			return false
+		}
+		if partialWrites {
+			if len(rejected) > 0 {
+				respondPartialWrite(w, numSamples, rejected)
+				return false
+			}
+		}
 		return true
 	}
 }
 
+// respondPartialWrite reports the rejected series back to the sender. A client
+// error status is used since retrying the same request cannot succeed.
+func respondPartialWrite(w http.ResponseWriter, samplesWritten uint64, rejected []rejectedSeries) {
+	log.Warn("msg", "Rejected invalid series from write request", "num_rejected", len(rejected), "first_err", rejected[0].Error)
+	w.Header().Set(remoteWriteSamplesWrittenHeader, strconv.FormatUint(samplesWritten, 10))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	if err := json.NewEncoder(w).Encode(&partialWriteResponse{
+		Status:         "partial_success",
+		SamplesWritten: samplesWritten,
+		Rejected:       rejected,
+	}); err != nil {
+		log.Error("msg", "error writing response", "err", err)
+	}
+}
+
+// respondThrottled asks the sender to back off, since some of the series
+// were rejected because of the ingest limits. Retrying the whole request
+// sends the samples already ingested again, which are handled by the
+// duplicate policy of their metric: ignored by default, but dropped and
+// counted as rejected under the reject policy.
+func respondThrottled(w http.ResponseWriter, err *limits.ThrottledError) {
+	log.WarnRateLimited("msg", "Rejected series exceeding the ingest limits", "err", err)
+	http.Error(w, err.Error(), http.StatusTooManyRequests)
+	metrics.ThrottledWriteReqs.Inc()
+}
+
 func invalidRequestError(w http.ResponseWriter, msg, err string, m *Metrics) {
 	log.Error("msg", msg, "err", err)
 	http.Error(w, err, http.StatusBadRequest)
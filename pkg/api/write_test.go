@@ -13,6 +13,7 @@ import (
 	"strings"
 	"testing"
 	"testing/iotest"
+	"time"
 
 	"github.com/gogo/protobuf/proto"
 	"github.com/golang/snappy"
@@ -23,6 +24,7 @@ import (
 	"go.opentelemetry.io/collector/model/pdata"
 
 	"github.com/timescale/promscale/pkg/api/parser"
+	"github.com/timescale/promscale/pkg/limits"
 	"github.com/timescale/promscale/pkg/log"
 	"github.com/timescale/promscale/pkg/prompb"
 	"github.com/timescale/promscale/pkg/util"
@@ -198,6 +200,29 @@ func TestWrite(t *testing.T) {
 				},
 			),
 		},
+		{
+			name:             "happy path remote write 1.0",
+			isLeader:         true,
+			responseCode:     http.StatusOK,
+			inserterResponse: 3,
+			requestBody: writeRequestToString(
+				&prompb.WriteRequest{
+					Timeseries: []prompb.TimeSeries{
+						{
+							Labels: []prompb.Label{{Name: "__name__", Value: "up"}},
+							Samples: []prompb.Sample{
+								{},
+							},
+						},
+					},
+				},
+			),
+			customHeaders: map[string]string{
+				"Content-Type":                      "application/x-protobuf",
+				"Content-Encoding":                  "snappy",
+				"X-Prometheus-Remote-Write-Version": "1.0.0",
+			},
+		},
 		{
 			name:          "malformed JSON",
 			isLeader:      true,
@@ -353,15 +378,17 @@ func (m *mockElection) Resign() error {
 
 type mockInserter struct {
 	ts     []prompb.TimeSeries
+	traces pdata.Traces
 	result int64
 	err    error
 }
 
-func (m *mockInserter) IngestTraces(_ context.Context, _ pdata.Traces) error {
-	panic("not implemented") // TODO: Implement
+func (m *mockInserter) IngestTraces(_ context.Context, tr pdata.Traces) error {
+	m.traces = tr
+	return m.err
 }
 
-func (m *mockInserter) Ingest(r *prompb.WriteRequest) (uint64, uint64, error) {
+func (m *mockInserter) Ingest(_ context.Context, r *prompb.WriteRequest) (uint64, uint64, error) {
 	m.ts = r.Timeseries
 	return uint64(m.result), 0, m.err
 }
@@ -436,3 +463,106 @@ func (m *mockMetric) Sub(f float64) {
 func (m *mockMetric) SetToCurrentTime() {
 	panic("implement me")
 }
+
+func TestInfluxWrite(t *testing.T) {
+	testCases := []struct {
+		name         string
+		method       string
+		body         string
+		inserterErr  error
+		responseCode int
+		numSeries    int
+	}{
+		{
+			name:         "happy path",
+			body:         "cpu,host=a usage=1,idle=2 1600000000000000000",
+			responseCode: http.StatusNoContent,
+			numSeries:    2,
+		},
+		{
+			name:         "wrong method",
+			method:       "GET",
+			responseCode: http.StatusBadRequest,
+		},
+		{
+			name:         "parse error",
+			body:         "cpu,host=a",
+			responseCode: http.StatusBadRequest,
+		},
+		{
+			name:         "empty write",
+			body:         "",
+			responseCode: http.StatusNoContent,
+		},
+		{
+			name:         "write error",
+			body:         "cpu usage=1",
+			inserterErr:  fmt.Errorf("some error"),
+			responseCode: http.StatusInternalServerError,
+			numSeries:    1,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			metrics = &Metrics{
+				ReceivedSamples:   &mockMetric{},
+				ReceivedMetadata:  &mockMetric{},
+				FailedSamples:     &mockMetric{},
+				FailedMetadata:    &mockMetric{},
+				SentSamples:       &mockMetric{},
+				SentMetadata:      &mockMetric{},
+				SentBatchDuration: &mockMetric{},
+				InvalidWriteReqs:  &mockMetric{},
+			}
+			mock := &mockInserter{err: c.inserterErr}
+			method := c.method
+			if method == "" {
+				method = "POST"
+			}
+
+			handler := InfluxWrite(mock, parser.NewParser(), nil)
+			test := GenerateWriteHandleTester(t, handler, map[string]string{"Content-Type": "text/plain; charset=utf-8"})
+			w := test(method, strings.NewReader(c.body))
+
+			require.Equal(t, c.responseCode, w.Code)
+			require.Len(t, mock.ts, c.numSeries)
+		})
+	}
+}
+
+func TestWriteIngestLimits(t *testing.T) {
+	throttledWriteReqs := &mockMetric{}
+	metrics = &Metrics{
+		ReceivedSamples:    &mockMetric{},
+		ReceivedMetadata:   &mockMetric{},
+		FailedSamples:      &mockMetric{},
+		FailedMetadata:     &mockMetric{},
+		SentSamples:        &mockMetric{},
+		SentMetadata:       &mockMetric{},
+		SentBatchDuration:  &mockMetric{},
+		InvalidWriteReqs:   &mockMetric{},
+		ThrottledWriteReqs: throttledWriteReqs,
+	}
+	dataParser := parser.NewParser()
+	dataParser.AddPreprocessor(limits.NewIngestLimiter(limits.Config{MetricMaxActiveSeries: 1, ActiveSeriesWindow: time.Hour}))
+	mock := &mockInserter{}
+	handler := Write(mock, dataParser, nil)
+	test := GenerateWriteHandleTester(t, handler, map[string]string{"Content-Type": "application/json"})
+
+	w := test("POST", strings.NewReader(`{"labels":{"__name__":"m","a":"1"},"samples":[[1,2]]}{"labels":{"__name__":"m","a":"2"},"samples":[[1,2]]}`))
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Contains(t, w.Body.String(), `metric_active_series (tenant "", metric "m"): 1 samples`)
+	require.Len(t, mock.ts, 1)
+	require.Contains(t, mock.ts[0].Labels, prompb.Label{Name: "a", Value: "1"})
+
+	mock.ts = nil
+	w = test("POST", strings.NewReader(`{"labels":{"__name__":"m","a":"3"},"samples":[[1,2]]}`))
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Len(t, mock.ts, 0)
+	require.Equal(t, float64(2), throttledWriteReqs.value)
+
+	w = test("POST", strings.NewReader(`{"labels":{"__name__":"m","a":"1"},"samples":[[2,2]]}`))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Len(t, mock.ts, 1)
+}
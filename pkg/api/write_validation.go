@@ -0,0 +1,131 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/prometheus/common/model"
+	"github.com/timescale/promscale/pkg/pgmodel/common/errors"
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+const (
+	remoteWriteVersionHeader        = "X-Prometheus-Remote-Write-Version"
+	remoteWriteSamplesWrittenHeader = "X-Prometheus-Remote-Write-Samples-Written"
+
+	legacyRemoteWriteVersionPrefix = "0.1."
+	remoteWriteVersionPrefix       = "1."
+	negotiatedRemoteWriteVersion   = "1.0.0"
+)
+
+// Reasons for which a series can be rejected from a write request.
+const (
+	rejectNoLabels           = "no_labels"
+	rejectMissingMetricName  = "missing_metric_name"
+	rejectInvalidMetricName  = "invalid_metric_name"
+	rejectInvalidLabelName   = "invalid_label_name"
+	rejectInvalidLabelValue  = "invalid_label_value"
+	rejectDuplicateLabelName = "duplicate_label_name"
+)
+
+// rejectedSeries describes a series which was dropped from a write request
+// because it failed validation.
+type rejectedSeries struct {
+	// Index of the series in the original write request.
+	Index  int               `json:"index"`
+	Labels map[string]string `json:"labels"`
+	Reason string            `json:"reason"`
+	Error  string            `json:"error"`
+}
+
+// partialWriteResponse is sent back to remote-write 1.x senders when some of
+// the series in a write request were rejected. The valid series are ingested,
+// so senders must not retry the request.
+type partialWriteResponse struct {
+	Status         string           `json:"status"`
+	SamplesWritten uint64           `json:"samplesWritten"`
+	Rejected       []rejectedSeries `json:"rejected"`
+}
+
+// supportsPartialWrites returns true if the request was sent using the newer
+// remote-write protocol, which supports per-series error reporting.
+func supportsPartialWrites(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get(remoteWriteVersionHeader), remoteWriteVersionPrefix)
+}
+
+// filterInvalidSeries removes all the invalid series from the write request
+// and returns the description of every removed series.
+func filterInvalidSeries(req *prompb.WriteRequest) []rejectedSeries {
+	var (
+		rejected []rejectedSeries
+		valid    = req.Timeseries[:0]
+	)
+	for i := range req.Timeseries {
+		ts := req.Timeseries[i]
+		reason, err := validateSeries(ts.Labels)
+		if err == nil {
+			valid = append(valid, ts)
+			continue
+		}
+		labels := make(map[string]string, len(ts.Labels))
+		for _, l := range ts.Labels {
+			labels[l.Name] = l.Value
+		}
+		rejected = append(rejected, rejectedSeries{
+			Index:  i,
+			Labels: labels,
+			Reason: reason,
+			Error:  err.Error(),
+		})
+	}
+	// The request is pooled, so the series past the valid ones must not
+	// share their labels and samples with the valid ones.
+	for i := len(valid); i < len(req.Timeseries); i++ {
+		req.Timeseries[i] = prompb.TimeSeries{}
+	}
+	req.Timeseries = valid
+	return rejected
+}
+
+// validateSeries checks if the series labels can be ingested. If not, the
+// reason for the rejection is returned along with the detailed error.
+func validateSeries(labels []prompb.Label) (string, error) {
+	if len(labels) == 0 {
+		return rejectNoLabels, fmt.Errorf("series has no labels")
+	}
+
+	var (
+		metricName string
+		seen       = make(map[string]struct{}, len(labels))
+	)
+	for _, l := range labels {
+		if _, ok := seen[l.Name]; ok {
+			return rejectDuplicateLabelName, fmt.Errorf("duplicate label name %q", l.Name)
+		}
+		seen[l.Name] = struct{}{}
+
+		if !model.LabelName(l.Name).IsValid() {
+			return rejectInvalidLabelName, fmt.Errorf("invalid label name %q", l.Name)
+		}
+		if !utf8.ValidString(l.Value) {
+			return rejectInvalidLabelValue, fmt.Errorf("invalid UTF-8 in value of label %q", l.Name)
+		}
+		if l.Name == model.MetricNameLabel {
+			metricName = l.Value
+		}
+	}
+
+	if metricName == "" {
+		return rejectMissingMetricName, errors.ErrNoMetricName
+	}
+	if !model.IsValidMetricName(model.LabelValue(metricName)) {
+		return rejectInvalidMetricName, fmt.Errorf("invalid metric name %q", metricName)
+	}
+	return "", nil
+}
@@ -0,0 +1,200 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/timescale/promscale/pkg/api/parser"
+	"github.com/timescale/promscale/pkg/pgmodel/ingestor"
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+func TestValidateSeries(t *testing.T) {
+	testCases := []struct {
+		name   string
+		labels []prompb.Label
+		reason string
+	}{
+		{
+			name:   "valid",
+			labels: []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "prometheus"}},
+		},
+		{
+			name:   "no labels",
+			reason: rejectNoLabels,
+		},
+		{
+			name:   "missing metric name",
+			labels: []prompb.Label{{Name: "job", Value: "prometheus"}},
+			reason: rejectMissingMetricName,
+		},
+		{
+			name:   "empty metric name",
+			labels: []prompb.Label{{Name: "__name__", Value: ""}},
+			reason: rejectMissingMetricName,
+		},
+		{
+			name:   "invalid metric name",
+			labels: []prompb.Label{{Name: "__name__", Value: "http.requests"}},
+			reason: rejectInvalidMetricName,
+		},
+		{
+			name:   "invalid label name",
+			labels: []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "host-name", Value: "a"}},
+			reason: rejectInvalidLabelName,
+		},
+		{
+			name:   "invalid label value",
+			labels: []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "\xff"}},
+			reason: rejectInvalidLabelValue,
+		},
+		{
+			name:   "duplicate label name",
+			labels: []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "a"}, {Name: "job", Value: "b"}},
+			reason: rejectDuplicateLabelName,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			reason, err := validateSeries(c.labels)
+			require.Equal(t, c.reason, reason)
+			if c.reason == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestFilterInvalidSeriesPooledReuse(t *testing.T) {
+	newSeries := func(name string, value float64) prompb.TimeSeries {
+		return prompb.TimeSeries{
+			Labels:  []prompb.Label{{Name: "__name__", Value: name}},
+			Samples: []prompb.Sample{{Timestamp: 1, Value: value}},
+		}
+	}
+	invalid := prompb.TimeSeries{
+		Labels:  []prompb.Label{{Name: "job", Value: "prometheus"}},
+		Samples: []prompb.Sample{{Timestamp: 1, Value: 0}},
+	}
+	first, err := (&prompb.WriteRequest{Timeseries: []prompb.TimeSeries{invalid, newSeries("a", 1), newSeries("b", 2)}}).Marshal()
+	require.NoError(t, err)
+	expected := []prompb.TimeSeries{newSeries("x", 3), newSeries("y", 4), newSeries("z", 5)}
+	second, err := (&prompb.WriteRequest{Timeseries: expected}).Marshal()
+	require.NoError(t, err)
+
+	// The request is reused the way the pooled requests are.
+	req := &prompb.WriteRequest{}
+	require.NoError(t, req.Unmarshal(first))
+	require.Len(t, filterInvalidSeries(req), 1)
+	require.Equal(t, []prompb.TimeSeries{newSeries("a", 1), newSeries("b", 2)}, req.Timeseries)
+	ingestor.FinishWriteRequest(req)
+
+	require.NoError(t, req.Unmarshal(second))
+	require.Equal(t, expected, req.Timeseries)
+}
+
+func TestWritePartialSuccess(t *testing.T) {
+	headers := map[string]string{
+		"Content-Encoding":                  "snappy",
+		"Content-Type":                      "application/x-protobuf",
+		"X-Prometheus-Remote-Write-Version": "1.0.0",
+	}
+	validSeries := prompb.TimeSeries{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+		Samples: []prompb.Sample{{Timestamp: 1, Value: 1}},
+	}
+	invalidSeries := prompb.TimeSeries{
+		Labels:  []prompb.Label{{Name: "job", Value: "prometheus"}},
+		Samples: []prompb.Sample{{Timestamp: 1, Value: 1}},
+	}
+
+	testCases := []struct {
+		name           string
+		series         []prompb.TimeSeries
+		inserterErr    error
+		responseCode   int
+		ingested       int
+		rejectedIndex  []int
+		samplesWritten string
+	}{
+		{
+			name:           "all valid",
+			series:         []prompb.TimeSeries{validSeries, validSeries},
+			responseCode:   http.StatusOK,
+			ingested:       2,
+			samplesWritten: "2",
+		},
+		{
+			name:           "some invalid",
+			series:         []prompb.TimeSeries{invalidSeries, validSeries, invalidSeries},
+			responseCode:   http.StatusBadRequest,
+			ingested:       1,
+			rejectedIndex:  []int{0, 2},
+			samplesWritten: "1",
+		},
+		{
+			name:           "all invalid",
+			series:         []prompb.TimeSeries{invalidSeries},
+			responseCode:   http.StatusBadRequest,
+			rejectedIndex:  []int{0},
+			samplesWritten: "0",
+		},
+		{
+			name:         "ingest error is retryable",
+			series:       []prompb.TimeSeries{invalidSeries, validSeries},
+			inserterErr:  fmt.Errorf("some error"),
+			responseCode: http.StatusInternalServerError,
+			ingested:     1,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			metrics = &Metrics{
+				ReceivedSamples:   &mockMetric{},
+				ReceivedMetadata:  &mockMetric{},
+				FailedSamples:     &mockMetric{},
+				FailedMetadata:    &mockMetric{},
+				SentSamples:       &mockMetric{},
+				SentMetadata:      &mockMetric{},
+				SentBatchDuration: &mockMetric{},
+				InvalidWriteReqs:  &mockMetric{},
+				RejectedSeries:    prometheus.NewCounterVec(prometheus.CounterOpts{Name: "rejected"}, []string{"reason"}),
+			}
+			mock := &mockInserter{result: int64(c.ingested), err: c.inserterErr}
+			handler := Write(mock, parser.NewParser(), nil)
+
+			test := GenerateWriteHandleTester(t, handler, headers)
+			w := test("POST", getReader(writeRequestToString(&prompb.WriteRequest{Timeseries: c.series})))
+
+			require.Equal(t, c.responseCode, w.Code)
+			require.Len(t, mock.ts, c.ingested)
+			require.Equal(t, c.samplesWritten, w.Header().Get("X-Prometheus-Remote-Write-Samples-Written"))
+			require.Equal(t, "1.0.0", w.Header().Get("X-Prometheus-Remote-Write-Version"))
+
+			if len(c.rejectedIndex) == 0 {
+				return
+			}
+			var resp partialWriteResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+			require.Len(t, resp.Rejected, len(c.rejectedIndex))
+			for i, idx := range c.rejectedIndex {
+				require.Equal(t, idx, resp.Rejected[i].Index)
+				require.Equal(t, rejectMissingMetricName, resp.Rejected[i].Reason)
+				require.Equal(t, map[string]string{"job": "prometheus"}, resp.Rejected[i].Labels)
+			}
+		})
+	}
+}
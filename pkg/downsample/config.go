@@ -0,0 +1,92 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package downsample
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// Resolution is a downsampling resolution, along with the retention period
+// of the data downsampled at it.
+type Resolution struct {
+	Resolution      time.Duration
+	RetentionPeriod time.Duration
+}
+
+func (r Resolution) String() string {
+	return model.Duration(r.Resolution).String() + ":" + model.Duration(r.RetentionPeriod).String()
+}
+
+// Config holds the downsampling resolutions applied to all the metrics.
+type Config struct {
+	Resolutions []Resolution
+}
+
+// Enabled returns true if downsampling resolutions are configured.
+func (cfg *Config) Enabled() bool {
+	return len(cfg.Resolutions) > 0
+}
+
+// resolutionsFlag is a repeatable flag of resolutions in the form
+// <resolution>:<retention>, also accepting comma separated values.
+type resolutionsFlag []Resolution
+
+func (f *resolutionsFlag) Set(val string) error {
+	for _, v := range strings.Split(val, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		parts := strings.Split(v, ":")
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid downsampling resolution %q: must be in the form <resolution>:<retention>", v)
+		}
+		resolution, err := model.ParseDuration(parts[0])
+		if err != nil {
+			return fmt.Errorf("invalid downsampling resolution %q: %w", v, err)
+		}
+		retention, err := model.ParseDuration(parts[1])
+		if err != nil {
+			return fmt.Errorf("invalid downsampling retention period %q: %w", v, err)
+		}
+		*f = append(*f, Resolution{Resolution: time.Duration(resolution), RetentionPeriod: time.Duration(retention)})
+	}
+	return nil
+}
+
+func (f *resolutionsFlag) String() string {
+	s := make([]string, 0, len(*f))
+	for _, r := range *f {
+		s = append(s, r.String())
+	}
+	return strings.Join(s, ",")
+}
+
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.Var((*resolutionsFlag)(&cfg.Resolutions), "downsample", "Resolution at which all the metrics are downsampled, and retention period of the downsampled data, "+
+		"in the form '<resolution>:<retention>', e.g. '5m:90d'. Can be repeated. Downsampling is disabled if no resolution is set.")
+	return cfg
+}
+
+func Validate(cfg *Config) error {
+	seen := make(map[time.Duration]bool, len(cfg.Resolutions))
+	for _, r := range cfg.Resolutions {
+		if r.Resolution < time.Second || r.Resolution%time.Second != 0 {
+			return fmt.Errorf("invalid downsampling resolution %s: must be a whole number of seconds", r)
+		}
+		if r.RetentionPeriod <= r.Resolution {
+			return fmt.Errorf("invalid downsampling resolution %s: the retention period must be longer than the resolution", r)
+		}
+		if seen[r.Resolution] {
+			return fmt.Errorf("duplicate downsampling resolution %s", model.Duration(r.Resolution))
+		}
+		seen[r.Resolution] = true
+	}
+	return nil
+}
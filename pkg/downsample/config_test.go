@@ -0,0 +1,78 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package downsample
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFlags(t *testing.T) {
+	testCases := []struct {
+		name        string
+		args        []string
+		expected    []Resolution
+		shouldError bool
+	}{
+		{
+			name: "disabled",
+		},
+		{
+			name: "repeated and comma separated",
+			args: []string{"-downsample", "5m:90d", "-downsample", "1h:2y, 1d:5y"},
+			expected: []Resolution{
+				{Resolution: 5 * time.Minute, RetentionPeriod: 90 * 24 * time.Hour},
+				{Resolution: time.Hour, RetentionPeriod: 2 * 365 * 24 * time.Hour},
+				{Resolution: 24 * time.Hour, RetentionPeriod: 5 * 365 * 24 * time.Hour},
+			},
+		},
+		{
+			name:        "missing retention",
+			args:        []string{"-downsample", "5m"},
+			shouldError: true,
+		},
+		{
+			name:        "invalid resolution",
+			args:        []string{"-downsample", "5:90d"},
+			shouldError: true,
+		},
+		{
+			name:        "fraction of second",
+			args:        []string{"-downsample", "1500ms:90d"},
+			shouldError: true,
+		},
+		{
+			name:        "retention shorter than resolution",
+			args:        []string{"-downsample", "1d:1h"},
+			shouldError: true,
+		},
+		{
+			name:        "duplicate resolution",
+			args:        []string{"-downsample", "60m:90d,1h:1y"},
+			shouldError: true,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			cfg := ParseFlags(fs, &Config{})
+			err := fs.Parse(c.args)
+			if err == nil {
+				err = Validate(cfg)
+			}
+			if c.shouldError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expected, cfg.Resolutions)
+			require.Equal(t, len(c.expected) > 0, cfg.Enabled())
+		})
+	}
+}
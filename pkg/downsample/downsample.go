@@ -0,0 +1,32 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package downsample declares the downsampling resolutions of the
+// configuration in the database. The downsampled data itself is computed by
+// the maintenance jobs.
+package downsample
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgmodel/common/schema"
+	"github.com/timescale/promscale/pkg/pgxconn"
+)
+
+const setDownsamplingSQL = "SELECT " + schema.Prom + ".set_downsampling($1::INTERVAL, $2::INTERVAL)"
+
+// Apply declares the configured resolutions, or updates their retention
+// period. Resolutions declared through the SQL API which are not part of the
+// configuration are left untouched.
+func Apply(conn pgxconn.PgxConn, cfg *Config) error {
+	for _, r := range cfg.Resolutions {
+		if _, err := conn.Exec(context.Background(), setDownsamplingSQL, r.Resolution, r.RetentionPeriod); err != nil {
+			return fmt.Errorf("set downsampling %s: %w", r, err)
+		}
+		log.Info("msg", "Downsampling metrics", "resolution", r.Resolution, "retention", r.RetentionPeriod)
+	}
+	return nil
+}
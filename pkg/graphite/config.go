@@ -0,0 +1,59 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package graphite
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const defaultIdleTimeout = 5 * time.Minute
+
+// Config holds the configuration of the Graphite listeners.
+type Config struct {
+	ListenAddr       string
+	PickleListenAddr string
+	Templates        []string
+	IdleTimeout      time.Duration
+}
+
+// Enabled returns true if at least one of the Graphite listeners is configured.
+func (cfg *Config) Enabled() bool {
+	return cfg.ListenAddr != "" || cfg.PickleListenAddr != ""
+}
+
+// templatesFlag is a repeatable flag collecting all the mapping templates.
+type templatesFlag []string
+
+func (t *templatesFlag) Set(val string) error {
+	*t = append(*t, val)
+	return nil
+}
+
+func (t *templatesFlag) String() string {
+	return strings.Join(*t, "; ")
+}
+
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.StringVar(&cfg.ListenAddr, "graphite-listen-address", "", "TCP address to listen on for metrics sent using the Graphite plaintext protocol. Disabled by default.")
+	fs.StringVar(&cfg.PickleListenAddr, "graphite-pickle-listen-address", "", "TCP address to listen on for metrics sent using the Graphite pickle protocol. Disabled by default.")
+	fs.DurationVar(&cfg.IdleTimeout, "graphite-idle-timeout", defaultIdleTimeout, "Duration after which the Graphite connections which sent no data are closed. 0 disables the timeout.")
+	fs.Var((*templatesFlag)(&cfg.Templates), "graphite-template", "Template mapping dotted Graphite paths into a metric name and labels, in the form '[filter] pattern [label=value,...]', "+
+		"e.g. 'servers.* .host.measurement*'. Can be repeated, the first template whose filter matches a path is used. "+
+		"Paths not matched by any template are converted into a metric name by replacing dots with underscores.")
+	return cfg
+}
+
+func Validate(cfg *Config) error {
+	if cfg.IdleTimeout < 0 {
+		return fmt.Errorf("invalid graphite idle timeout: %s", cfg.IdleTimeout)
+	}
+	if _, err := newMapper(cfg.Templates); err != nil {
+		return fmt.Errorf("invalid graphite template: %w", err)
+	}
+	return nil
+}
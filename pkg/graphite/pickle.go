@@ -0,0 +1,590 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package graphite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Pickle opcodes used by the Graphite pickle clients. Only the subset needed
+// to decode lists of (path, (timestamp, value)) tuples is supported.
+const (
+	opMark            = '('
+	opStop            = '.'
+	opPop             = '0'
+	opPopMark         = '1'
+	opDup             = '2'
+	opFloat           = 'F'
+	opInt             = 'I'
+	opBinInt          = 'J'
+	opBinInt1         = 'K'
+	opLong            = 'L'
+	opBinInt2         = 'M'
+	opNone            = 'N'
+	opString          = 'S'
+	opBinString       = 'T'
+	opShortBinString  = 'U'
+	opUnicode         = 'V'
+	opBinUnicode      = 'X'
+	opAppend          = 'a'
+	opBinBytes        = 'B'
+	opShortBinBytes   = 'C'
+	opAppends         = 'e'
+	opGet             = 'g'
+	opBinGet          = 'h'
+	opLongBinGet      = 'j'
+	opList            = 'l'
+	opPut             = 'p'
+	opBinPut          = 'q'
+	opLongBinPut      = 'r'
+	opTuple           = 't'
+	opEmptyList       = ']'
+	opEmptyTuple      = ')'
+	opBinFloat        = 'G'
+	opProto           = 0x80
+	opTuple1          = 0x85
+	opTuple2          = 0x86
+	opTuple3          = 0x87
+	opNewTrue         = 0x88
+	opNewFalse        = 0x89
+	opLong1           = 0x8a
+	opShortBinUnicode = 0x8c
+	opBinUnicode8     = 0x8d
+	opMemoize         = 0x94
+	opFrame           = 0x95
+)
+
+// Limits of the decoded objects. The memo lets a small message reference the
+// same list many times, or a list contain itself, so the size of the decoded
+// object is not bounded by the size of the message.
+const (
+	maxPickleDepth    = 16
+	maxPickleElements = 1 << 20
+)
+
+// errPickleLimit is returned when a message exceeds the limits of the decoded
+// objects. The sender is not to be trusted anymore.
+var errPickleLimit = errors.New("pickle: message exceeds the decoding limits")
+
+// pyList is a mutable python list. Lists are appended to after being put in
+// the memo, so they have to be shared by reference.
+type pyList struct {
+	items []interface{}
+}
+
+type unpickler struct {
+	data  []byte
+	pos   int
+	stack []interface{}
+	marks []int
+	memo  map[int]interface{}
+}
+
+// unpickle decodes a pickled object. Strings are returned as string, integers
+// as int64, floats as float64 and both lists and tuples as []interface{}.
+func unpickle(data []byte) (interface{}, error) {
+	u := &unpickler{data: data, memo: make(map[int]interface{})}
+	for {
+		op, err := u.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if op == opStop {
+			v, err := u.pop()
+			if err != nil {
+				return nil, err
+			}
+			r := &resolver{resolving: make(map[*pyList]struct{})}
+			return r.resolve(v, 0)
+		}
+		if err = u.exec(op); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// resolver replaces the lists by their items, counting the elements of the
+// resolved object and detecting the lists which contain themselves.
+type resolver struct {
+	resolving map[*pyList]struct{}
+	elements  int
+}
+
+func (r *resolver) resolve(v interface{}, depth int) (interface{}, error) {
+	if depth > maxPickleDepth {
+		return nil, fmt.Errorf("%w: nested deeper than %d", errPickleLimit, maxPickleDepth)
+	}
+	switch v := v.(type) {
+	case *pyList:
+		if _, ok := r.resolving[v]; ok {
+			return nil, fmt.Errorf("%w: list contains itself", errPickleLimit)
+		}
+		r.resolving[v] = struct{}{}
+		defer delete(r.resolving, v)
+		return r.resolve(v.items, depth)
+	case []interface{}:
+		if r.elements += len(v); r.elements > maxPickleElements {
+			return nil, fmt.Errorf("%w: more than %d elements", errPickleLimit, maxPickleElements)
+		}
+		res := make([]interface{}, len(v))
+		for i := range v {
+			var err error
+			if res[i], err = r.resolve(v[i], depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	default:
+		return v, nil
+	}
+}
+
+func (u *unpickler) exec(op byte) error {
+	switch op {
+	case opProto:
+		_, err := u.read(1)
+		return err
+	case opFrame:
+		_, err := u.read(8)
+		return err
+	case opMark:
+		u.marks = append(u.marks, len(u.stack))
+	case opPop:
+		_, err := u.pop()
+		return err
+	case opPopMark:
+		_, err := u.popMark()
+		return err
+	case opDup:
+		if len(u.stack) == 0 {
+			return fmt.Errorf("pickle: stack underflow")
+		}
+		u.push(u.stack[len(u.stack)-1])
+	case opNone:
+		u.push(nil)
+	case opNewTrue:
+		u.push(true)
+	case opNewFalse:
+		u.push(false)
+	case opInt:
+		line, err := u.readLine()
+		if err != nil {
+			return err
+		}
+		switch line {
+		case "00":
+			u.push(false)
+		case "01":
+			u.push(true)
+		default:
+			i, err := strconv.ParseInt(line, 10, 64)
+			if err != nil {
+				return fmt.Errorf("pickle: invalid int %q", line)
+			}
+			u.push(i)
+		}
+	case opLong:
+		line, err := u.readLine()
+		if err != nil {
+			return err
+		}
+		i, err := strconv.ParseInt(strings.TrimSuffix(line, "L"), 10, 64)
+		if err != nil {
+			return fmt.Errorf("pickle: invalid long %q", line)
+		}
+		u.push(i)
+	case opBinInt:
+		b, err := u.read(4)
+		if err != nil {
+			return err
+		}
+		u.push(int64(int32(binary.LittleEndian.Uint32(b))))
+	case opBinInt1:
+		b, err := u.read(1)
+		if err != nil {
+			return err
+		}
+		u.push(int64(b[0]))
+	case opBinInt2:
+		b, err := u.read(2)
+		if err != nil {
+			return err
+		}
+		u.push(int64(binary.LittleEndian.Uint16(b)))
+	case opLong1:
+		n, err := u.readByte()
+		if err != nil {
+			return err
+		}
+		b, err := u.read(int(n))
+		if err != nil {
+			return err
+		}
+		u.push(decodeLong(b))
+	case opFloat:
+		line, err := u.readLine()
+		if err != nil {
+			return err
+		}
+		f, err := strconv.ParseFloat(line, 64)
+		if err != nil {
+			return fmt.Errorf("pickle: invalid float %q", line)
+		}
+		u.push(f)
+	case opBinFloat:
+		b, err := u.read(8)
+		if err != nil {
+			return err
+		}
+		u.push(math.Float64frombits(binary.BigEndian.Uint64(b)))
+	case opString:
+		line, err := u.readLine()
+		if err != nil {
+			return err
+		}
+		s, err := unquotePython(line)
+		if err != nil {
+			return err
+		}
+		u.push(s)
+	case opUnicode:
+		line, err := u.readLine()
+		if err != nil {
+			return err
+		}
+		s, err := decodeRawUnicodeEscape(line)
+		if err != nil {
+			return err
+		}
+		u.push(s)
+	case opShortBinString, opShortBinBytes, opShortBinUnicode:
+		n, err := u.readByte()
+		if err != nil {
+			return err
+		}
+		return u.pushString(uint64(n))
+	case opBinString, opBinBytes, opBinUnicode:
+		b, err := u.read(4)
+		if err != nil {
+			return err
+		}
+		return u.pushString(uint64(binary.LittleEndian.Uint32(b)))
+	case opBinUnicode8:
+		b, err := u.read(8)
+		if err != nil {
+			return err
+		}
+		return u.pushString(binary.LittleEndian.Uint64(b))
+	case opEmptyList:
+		u.push(&pyList{})
+	case opList:
+		items, err := u.popMark()
+		if err != nil {
+			return err
+		}
+		u.push(&pyList{items: items})
+	case opAppend:
+		v, err := u.pop()
+		if err != nil {
+			return err
+		}
+		return u.appendToList(v)
+	case opAppends:
+		items, err := u.popMark()
+		if err != nil {
+			return err
+		}
+		return u.appendToList(items...)
+	case opEmptyTuple:
+		u.push([]interface{}{})
+	case opTuple:
+		items, err := u.popMark()
+		if err != nil {
+			return err
+		}
+		u.push(items)
+	case opTuple1, opTuple2, opTuple3:
+		n := int(op-opTuple1) + 1
+		if len(u.stack) < n {
+			return fmt.Errorf("pickle: stack underflow")
+		}
+		items := make([]interface{}, n)
+		copy(items, u.stack[len(u.stack)-n:])
+		u.stack = u.stack[:len(u.stack)-n]
+		u.push(items)
+	case opPut:
+		line, err := u.readLine()
+		if err != nil {
+			return err
+		}
+		idx, err := strconv.Atoi(line)
+		if err != nil {
+			return fmt.Errorf("pickle: invalid memo index %q", line)
+		}
+		return u.memoize(idx)
+	case opBinPut:
+		b, err := u.read(1)
+		if err != nil {
+			return err
+		}
+		return u.memoize(int(b[0]))
+	case opLongBinPut:
+		b, err := u.read(4)
+		if err != nil {
+			return err
+		}
+		return u.memoize(int(binary.LittleEndian.Uint32(b)))
+	case opMemoize:
+		return u.memoize(len(u.memo))
+	case opGet:
+		line, err := u.readLine()
+		if err != nil {
+			return err
+		}
+		idx, err := strconv.Atoi(line)
+		if err != nil {
+			return fmt.Errorf("pickle: invalid memo index %q", line)
+		}
+		return u.pushMemo(idx)
+	case opBinGet:
+		b, err := u.read(1)
+		if err != nil {
+			return err
+		}
+		return u.pushMemo(int(b[0]))
+	case opLongBinGet:
+		b, err := u.read(4)
+		if err != nil {
+			return err
+		}
+		return u.pushMemo(int(binary.LittleEndian.Uint32(b)))
+	default:
+		return fmt.Errorf("pickle: unsupported opcode 0x%02x", op)
+	}
+	return nil
+}
+
+func (u *unpickler) push(v interface{}) {
+	u.stack = append(u.stack, v)
+}
+
+func (u *unpickler) pop() (interface{}, error) {
+	if len(u.stack) == 0 {
+		return nil, fmt.Errorf("pickle: stack underflow")
+	}
+	v := u.stack[len(u.stack)-1]
+	u.stack = u.stack[:len(u.stack)-1]
+	return v, nil
+}
+
+// popMark pops all the objects pushed after the last mark.
+func (u *unpickler) popMark() ([]interface{}, error) {
+	if len(u.marks) == 0 {
+		return nil, fmt.Errorf("pickle: mark not found")
+	}
+	mark := u.marks[len(u.marks)-1]
+	u.marks = u.marks[:len(u.marks)-1]
+	items := make([]interface{}, len(u.stack)-mark)
+	copy(items, u.stack[mark:])
+	u.stack = u.stack[:mark]
+	return items, nil
+}
+
+func (u *unpickler) appendToList(items ...interface{}) error {
+	if len(u.stack) == 0 {
+		return fmt.Errorf("pickle: stack underflow")
+	}
+	l, ok := u.stack[len(u.stack)-1].(*pyList)
+	if !ok {
+		return fmt.Errorf("pickle: append to a non-list object")
+	}
+	l.items = append(l.items, items...)
+	return nil
+}
+
+func (u *unpickler) memoize(idx int) error {
+	if len(u.stack) == 0 {
+		return fmt.Errorf("pickle: stack underflow")
+	}
+	u.memo[idx] = u.stack[len(u.stack)-1]
+	return nil
+}
+
+func (u *unpickler) pushMemo(idx int) error {
+	v, ok := u.memo[idx]
+	if !ok {
+		return fmt.Errorf("pickle: memo index %d not found", idx)
+	}
+	u.push(v)
+	return nil
+}
+
+func (u *unpickler) pushString(n uint64) error {
+	if n > uint64(len(u.data)-u.pos) {
+		return fmt.Errorf("pickle: unexpected end of data")
+	}
+	b, _ := u.read(int(n))
+	u.push(string(b))
+	return nil
+}
+
+func (u *unpickler) readByte() (byte, error) {
+	b, err := u.read(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (u *unpickler) read(n int) ([]byte, error) {
+	if n < 0 || u.pos+n > len(u.data) {
+		return nil, fmt.Errorf("pickle: unexpected end of data")
+	}
+	b := u.data[u.pos : u.pos+n]
+	u.pos += n
+	return b, nil
+}
+
+func (u *unpickler) readLine() (string, error) {
+	i := bytes.IndexByte(u.data[u.pos:], '\n')
+	if i < 0 {
+		return "", fmt.Errorf("pickle: unexpected end of data")
+	}
+	line := string(u.data[u.pos : u.pos+i])
+	u.pos += i + 1
+	return line, nil
+}
+
+// decodeLong decodes a little-endian two's complement integer. Values which
+// do not fit into an int64 are returned as float64.
+func decodeLong(b []byte) interface{} {
+	if len(b) == 0 {
+		return int64(0)
+	}
+	be := make([]byte, len(b))
+	for i := range b {
+		be[len(b)-1-i] = b[i]
+	}
+	n := new(big.Int).SetBytes(be)
+	if b[len(b)-1]&0x80 != 0 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+	}
+	if n.IsInt64() {
+		return n.Int64()
+	}
+	f, _ := new(big.Float).SetInt(n).Float64()
+	return f
+}
+
+// unquotePython decodes the repr of a python string, quoted with either
+// single or double quotes.
+func unquotePython(s string) (string, error) {
+	if len(s) < 2 || (s[0] != '\'' && s[0] != '"') || s[len(s)-1] != s[0] {
+		return "", fmt.Errorf("pickle: invalid string %q", s)
+	}
+	quote, s := s[0], s[1:len(s)-1]
+	var sb strings.Builder
+	for len(s) > 0 {
+		r, _, tail, err := strconv.UnquoteChar(s, quote)
+		if err != nil {
+			return "", fmt.Errorf("pickle: invalid string %q", s)
+		}
+		sb.WriteRune(r)
+		s = tail
+	}
+	return sb.String(), nil
+}
+
+// decodeRawUnicodeEscape decodes the python raw-unicode-escape encoding, in
+// which only \uXXXX and \UXXXXXXXX sequences are escaped.
+func decodeRawUnicodeEscape(s string) (string, error) {
+	if !strings.Contains(s, `\u`) && !strings.Contains(s, `\U`) {
+		return s, nil
+	}
+	var sb strings.Builder
+	for len(s) > 0 {
+		if len(s) > 1 && s[0] == '\\' && (s[1] == 'u' || s[1] == 'U') {
+			r, _, tail, err := strconv.UnquoteChar(s, 0)
+			if err != nil {
+				return "", fmt.Errorf("pickle: invalid unicode string %q", s)
+			}
+			sb.WriteRune(r)
+			s = tail
+			continue
+		}
+		sb.WriteByte(s[0])
+		s = s[1:]
+	}
+	return sb.String(), nil
+}
+
+// parsePickle decodes the payload of a pickle protocol message, which is a
+// list of (path, (timestamp, value)) tuples.
+func parsePickle(data []byte, now int64) ([]metric, error) {
+	obj, err := unpickle(data)
+	if err != nil {
+		return nil, err
+	}
+	items, ok := obj.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a list of metrics, got %T", obj)
+	}
+
+	metrics := make([]metric, 0, len(items))
+	for i, item := range items {
+		tuple, ok := item.([]interface{})
+		if !ok || len(tuple) != 2 {
+			return nil, fmt.Errorf("metric %d: expected (path, (timestamp, value))", i)
+		}
+		datapoint, ok := tuple[1].([]interface{})
+		if !ok || len(datapoint) != 2 {
+			return nil, fmt.Errorf("metric %d: expected (path, (timestamp, value))", i)
+		}
+		pathStr, ok := tuple[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("metric %d: invalid path %v", i, tuple[0])
+		}
+
+		var m metric
+		if m.path, m.tags, err = parsePath(pathStr); err != nil {
+			return nil, fmt.Errorf("metric %d: %w", i, err)
+		}
+		ts, err := toFloat(datapoint[0])
+		if err != nil || math.IsNaN(ts) || math.IsInf(ts, 0) {
+			return nil, fmt.Errorf("metric %d: invalid timestamp %v", i, datapoint[0])
+		}
+		m.timestamp = secondsToMillis(ts, now)
+		if m.value, err = toFloat(datapoint[1]); err != nil {
+			return nil, fmt.Errorf("metric %d: invalid value %v", i, datapoint[1])
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, nil
+}
+
+func toFloat(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+	default:
+		return 0, fmt.Errorf("unexpected type %T", v)
+	}
+}
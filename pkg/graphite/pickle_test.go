@@ -0,0 +1,140 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package graphite
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+func TestParsePickle(t *testing.T) {
+	const now = int64(1600000000000)
+	expected := []metric{
+		{path: "servers.a.cpu.user", value: 1.5, timestamp: 1600000000000},
+		{path: "disk.used", tags: []prompb.Label{{Name: "host", Value: "b"}}, value: 2, timestamp: 1600000000500},
+		{path: "x.y", value: 3, timestamp: 1600000000000},
+	}
+
+	// Payloads generated with python's pickle.dumps(data, protocol=N), where
+	// data = [('servers.a.cpu.user', (1600000000, 1.5)),
+	//         ('disk.used;host=b', (1600000000.5, 2)),
+	//         ('x.y', (1600000000, '3'))]
+	testCases := []struct {
+		name    string
+		payload string
+		metrics []metric
+		err     string
+	}{
+		{
+			name:    "protocol 0",
+			payload: "(lp0\n(Vservers.a.cpu.user\np1\n(I1600000000\nF1.5\ntp2\ntp3\na(Vdisk.used;host=b\np4\n(F1600000000.5\nI2\ntp5\ntp6\na(Vx.y\np7\n(I1600000000\nV3\np8\ntp9\ntp10\na.",
+			metrics: expected,
+		},
+		{
+			name:    "protocol 2",
+			payload: "\x80\x02]q\x00(X\x12\x00\x00\x00servers.a.cpu.userq\x01J\x00\x10^_G?\xf8\x00\x00\x00\x00\x00\x00\x86q\x02\x86q\x03X\x10\x00\x00\x00disk.used;host=bq\x04GA\xd7\xd7\x84\x00 \x00\x00K\x02\x86q\x05\x86q\x06X\x03\x00\x00\x00x.yq\x07J\x00\x10^_X\x01\x00\x00\x003q\x08\x86q\t\x86q\ne.",
+			metrics: expected,
+		},
+		{
+			name:    "protocol 4",
+			payload: "\x80\x04\x95a\x00\x00\x00\x00\x00\x00\x00]\x94(\x8c\x12servers.a.cpu.user\x94J\x00\x10^_G?\xf8\x00\x00\x00\x00\x00\x00\x86\x94\x86\x94\x8c\x10disk.used;host=b\x94GA\xd7\xd7\x84\x00 \x00\x00K\x02\x86\x94\x86\x94\x8c\x03x.y\x94J\x00\x10^_\x8c\x013\x94\x86\x94\x86\x94e.",
+			metrics: expected,
+		},
+		{
+			name:    "python 2 strings and longs",
+			payload: "(lp0\n(S'a.b'\np1\n(L1600000000L\nF1.5\ntp2\ntp3\na.",
+			metrics: []metric{{path: "a.b", value: 1.5, timestamp: 1600000000000}},
+		},
+		{
+			name:    "empty list",
+			payload: "\x80\x02]q\x00.",
+			metrics: []metric{},
+		},
+		{
+			name:    "truncated payload",
+			payload: "\x80\x02]q\x00(X\x12\x00\x00\x00servers",
+			err:     "pickle: unexpected end of data",
+		},
+		{
+			name:    "unsupported opcode",
+			payload: "\x80\x02cos\nsystem\n.",
+			err:     "pickle: unsupported opcode 0x63",
+		},
+		{
+			name:    "not a list",
+			payload: "\x80\x02K\x01.",
+			err:     "expected a list of metrics, got int64",
+		},
+		{
+			name:    "invalid metric",
+			payload: "\x80\x02]q\x00X\x03\x00\x00\x00x.ya.",
+			err:     "metric 0: expected (path, (timestamp, value))",
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			metrics, err := parsePickle([]byte(c.payload), now)
+			if c.err != "" {
+				require.EqualError(t, err, c.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.metrics, metrics)
+		})
+	}
+}
+
+func TestUnpickleLimits(t *testing.T) {
+	// A list of 64 references to the list of the previous level, which
+	// expands to 64^levels elements.
+	expanding := func(levels int) string {
+		var sb strings.Builder
+		sb.WriteString("\x80\x02K\x01")
+		for i := 0; i < levels; i++ {
+			sb.WriteString("q\x000(h\x00")
+			sb.WriteString(strings.Repeat("2", 63))
+			sb.WriteString("l")
+		}
+		sb.WriteString(".")
+		return sb.String()
+	}
+
+	testCases := []struct {
+		name    string
+		payload string
+		err     string
+	}{
+		{
+			name:    "list containing itself",
+			payload: "]q\x00h\x00a.",
+			err:     "pickle: message exceeds the decoding limits: list contains itself",
+		},
+		{
+			name:    "too deep",
+			payload: strings.Repeat("]", maxPickleDepth+2) + strings.Repeat("a", maxPickleDepth+1) + ".",
+			err:     "pickle: message exceeds the decoding limits: nested deeper than 16",
+		},
+		{
+			name:    "too many elements",
+			payload: expanding(4),
+			err:     "pickle: message exceeds the decoding limits: more than 1048576 elements",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := unpickle([]byte(c.payload))
+			require.EqualError(t, err, c.err)
+			require.ErrorIs(t, err, errPickleLimit)
+		})
+	}
+
+	v, err := unpickle([]byte(expanding(3)))
+	require.NoError(t, err)
+	require.Len(t, v, 64)
+}
@@ -0,0 +1,78 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package graphite
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+// metric is a single data point received through one of the Graphite protocols.
+type metric struct {
+	path      string
+	tags      []prompb.Label
+	value     float64
+	timestamp int64 // milliseconds
+}
+
+// parsePlaintextLine parses a line of the Graphite plaintext protocol:
+//
+//	<path>[;<tag>=<value>...] <value> [<timestamp>]
+//
+// The timestamp is in seconds. If it is missing or negative, now (in
+// milliseconds) is used instead.
+func parsePlaintextLine(line string, now int64) (metric, error) {
+	var m metric
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 {
+		return m, fmt.Errorf("expected '<path> <value> [<timestamp>]'")
+	}
+
+	var err error
+	if m.path, m.tags, err = parsePath(fields[0]); err != nil {
+		return m, err
+	}
+	if m.value, err = strconv.ParseFloat(fields[1], 64); err != nil {
+		return m, fmt.Errorf("invalid value %q", fields[1])
+	}
+	m.timestamp = now
+	if len(fields) == 3 {
+		ts, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil || math.IsNaN(ts) || math.IsInf(ts, 0) {
+			return m, fmt.Errorf("invalid timestamp %q", fields[2])
+		}
+		m.timestamp = secondsToMillis(ts, now)
+	}
+	return m, nil
+}
+
+// parsePath splits a path using the Graphite tag format
+// (e.g. "disk.used;host=a;dc=eu") into the path itself and its tags.
+func parsePath(s string) (string, []prompb.Label, error) {
+	parts := strings.Split(s, ";")
+	if parts[0] == "" {
+		return "", nil, fmt.Errorf("empty metric path")
+	}
+	var tags []prompb.Label
+	for _, tag := range parts[1:] {
+		i := strings.IndexByte(tag, '=')
+		if i <= 0 {
+			return "", nil, fmt.Errorf("invalid tag %q", tag)
+		}
+		tags = append(tags, prompb.Label{Name: tag[:i], Value: tag[i+1:]})
+	}
+	return parts[0], tags, nil
+}
+
+func secondsToMillis(ts float64, now int64) int64 {
+	if ts < 0 {
+		return now
+	}
+	return int64(math.Round(ts * 1000))
+}
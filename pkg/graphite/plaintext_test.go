@@ -0,0 +1,70 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package graphite
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+func TestParsePlaintextLine(t *testing.T) {
+	const now = int64(1600000000000)
+	testCases := []struct {
+		name   string
+		line   string
+		metric metric
+		err    string
+	}{
+		{
+			name:   "with timestamp",
+			line:   "servers.web01.cpu 12.5 1600000001",
+			metric: metric{path: "servers.web01.cpu", value: 12.5, timestamp: 1600000001000},
+		},
+		{
+			name:   "fractional timestamp",
+			line:   "servers.web01.cpu 1 1600000001.25",
+			metric: metric{path: "servers.web01.cpu", value: 1, timestamp: 1600000001250},
+		},
+		{
+			name:   "without timestamp",
+			line:   "servers.web01.cpu 1",
+			metric: metric{path: "servers.web01.cpu", value: 1, timestamp: now},
+		},
+		{
+			name:   "negative timestamp",
+			line:   "servers.web01.cpu 1 -1",
+			metric: metric{path: "servers.web01.cpu", value: 1, timestamp: now},
+		},
+		{
+			name: "tagged metric",
+			line: "disk.used;host=a;dc=eu 3 1600000001",
+			metric: metric{
+				path:      "disk.used",
+				tags:      []prompb.Label{{Name: "host", Value: "a"}, {Name: "dc", Value: "eu"}},
+				value:     3,
+				timestamp: 1600000001000,
+			},
+		},
+		{name: "missing value", line: "servers.web01.cpu", err: "expected '<path> <value> [<timestamp>]'"},
+		{name: "invalid value", line: "servers.web01.cpu abc", err: `invalid value "abc"`},
+		{name: "invalid timestamp", line: "servers.web01.cpu 1 abc", err: `invalid timestamp "abc"`},
+		{name: "invalid tag", line: "disk.used;host 1", err: `invalid tag "host"`},
+		{name: "empty path", line: ";host=a 1", err: "empty metric path"},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			m, err := parsePlaintextLine(c.line, now)
+			if c.err != "" {
+				require.EqualError(t, err, c.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.metric, m)
+		})
+	}
+}
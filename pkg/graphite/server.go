@@ -0,0 +1,357 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package graphite
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/timescale/promscale/pkg/limits"
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgmodel/ingestor"
+	"github.com/timescale/promscale/pkg/prompb"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+const (
+	protocolPlaintext = "plaintext"
+	protocolPickle    = "pickle"
+
+	// maxBatchSize is the maximum number of samples ingested at once.
+	maxBatchSize = 5000
+	// maxPlaintextLineSize is the maximum accepted length of a plaintext line.
+	maxPlaintextLineSize = 64 * 1024
+	// maxPickleMessageSize is the maximum accepted size of a pickle message,
+	// same as the default one in carbon.
+	maxPickleMessageSize = 1 << 20
+)
+
+var (
+	receivedSamples = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "graphite",
+			Name:      "received_samples_total",
+			Help:      "Total number of samples received through the Graphite listeners.",
+		}, []string{"protocol"},
+	)
+	invalidSamples = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "graphite",
+			Name:      "invalid_samples_total",
+			Help:      "Total number of samples received through the Graphite listeners which could not be parsed.",
+		}, []string{"protocol"},
+	)
+	failedSamples = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "graphite",
+			Name:      "failed_samples_total",
+			Help:      "Total number of samples received through the Graphite listeners which failed to be ingested.",
+		}, []string{"protocol"},
+	)
+
+	timeProvider = time.Now
+)
+
+func init() {
+	prometheus.MustRegister(
+		receivedSamples,
+		invalidSamples,
+		failedSamples,
+	)
+}
+
+// Preprocessor runs the write preprocessors (e.g. HA and multi-tenancy) on a
+// write request. It is implemented by *parser.DefaultParser.
+type Preprocessor interface {
+	Preprocess(*http.Request, *prompb.WriteRequest) error
+}
+
+// Server accepts metrics sent using the Graphite plaintext and pickle
+// protocols, maps them into Prometheus series and ingests them.
+type Server struct {
+	mapper       *mapper
+	inserter     ingestor.DBInserter
+	preprocessor Preprocessor
+	idleTimeout  time.Duration
+
+	// mux protects the listeners and connections being served, which are
+	// closed when the server is closed.
+	mux       sync.Mutex
+	closed    bool
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	handlers  sync.WaitGroup
+}
+
+// NewServer returns a new Graphite server which ingests metrics using the
+// given inserter, after running them through the preprocessor.
+func NewServer(cfg *Config, inserter ingestor.DBInserter, preprocessor Preprocessor) (*Server, error) {
+	m, err := newMapper(cfg.Templates)
+	if err != nil {
+		return nil, fmt.Errorf("invalid graphite template: %w", err)
+	}
+	return &Server{
+		mapper:       m,
+		inserter:     inserter,
+		preprocessor: preprocessor,
+		idleTimeout:  cfg.IdleTimeout,
+		listeners:    make(map[net.Listener]struct{}),
+		conns:        make(map[net.Conn]struct{}),
+	}, nil
+}
+
+// ServePlaintext accepts connections on the listener and handles them using
+// the plaintext protocol. It returns when the listener fails, or when the
+// server is closed.
+func (s *Server) ServePlaintext(l net.Listener) error {
+	return s.serve(l, s.handlePlaintext)
+}
+
+// ServePickle accepts connections on the listener and handles them using the
+// pickle protocol. It returns when the listener fails, or when the server is
+// closed.
+func (s *Server) ServePickle(l net.Listener) error {
+	return s.serve(l, s.handlePickle)
+}
+
+// Close closes the listeners and the connections being served, and waits for
+// the metrics read from the connections to be ingested.
+func (s *Server) Close() {
+	s.mux.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		_ = l.Close()
+	}
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mux.Unlock()
+	s.handlers.Wait()
+}
+
+func (s *Server) isClosed() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.closed
+}
+
+func (s *Server) serve(l net.Listener, handle func(net.Conn)) error {
+	s.mux.Lock()
+	if s.closed {
+		s.mux.Unlock()
+		return l.Close()
+	}
+	s.listeners[l] = struct{}{}
+	s.mux.Unlock()
+	defer func() {
+		s.mux.Lock()
+		delete(s.listeners, l)
+		s.mux.Unlock()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return nil
+			}
+			return err
+		}
+		s.mux.Lock()
+		if s.closed {
+			s.mux.Unlock()
+			_ = conn.Close()
+			return nil
+		}
+		s.conns[conn] = struct{}{}
+		s.handlers.Add(1)
+		s.mux.Unlock()
+		go func() {
+			defer s.handlers.Done()
+			handle(conn)
+			s.mux.Lock()
+			delete(s.conns, conn)
+			s.mux.Unlock()
+		}()
+	}
+}
+
+// waitForData sets the deadline of the next read from the connection, which
+// is closed after idleTimeout without data.
+func (s *Server) waitForData(conn net.Conn) error {
+	if s.idleTimeout == 0 {
+		return nil
+	}
+	return conn.SetReadDeadline(time.Now().Add(s.idleTimeout))
+}
+
+// logReadError logs the error which ended the read of a connection, unless
+// the connection was closed by the sender, for being idle or by the server.
+func (s *Server) logReadError(protocol string, err error) {
+	var netErr net.Error
+	switch {
+	case err == io.EOF, s.isClosed():
+	case errors.As(err, &netErr) && netErr.Timeout():
+		log.Debug("msg", "Closing idle Graphite connection", "protocol", protocol, "idle_timeout", s.idleTimeout)
+	default:
+		log.Warn("msg", "Error reading Graphite connection", "protocol", protocol, "err", err)
+	}
+}
+
+// handlePlaintext reads newline delimited metrics. Metrics are ingested in
+// batches, whenever there is no more buffered data to be parsed. The
+// connection is closed on lines longer than maxPlaintextLineSize.
+func (s *Server) handlePlaintext(conn net.Conn) {
+	defer conn.Close()
+	var (
+		r     = bufio.NewReaderSize(conn, maxPlaintextLineSize)
+		batch = make([]metric, 0, 128)
+	)
+	for {
+		if r.Buffered() == 0 {
+			if err := s.waitForData(conn); err != nil {
+				s.logReadError(protocolPlaintext, err)
+				return
+			}
+		}
+		raw, err := r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			s.ingest(protocolPlaintext, batch)
+			invalidSamples.WithLabelValues(protocolPlaintext).Inc()
+			log.Warn("msg", "Graphite plaintext line too long, closing connection", "max", maxPlaintextLineSize)
+			return
+		}
+		if line := strings.TrimSpace(string(raw)); line != "" {
+			m, perr := parsePlaintextLine(line, timeProvider().UnixNano()/int64(time.Millisecond))
+			if perr != nil {
+				invalidSamples.WithLabelValues(protocolPlaintext).Inc()
+				log.WarnRateLimited("msg", "Invalid Graphite plaintext line", "line", line, "err", perr)
+			} else {
+				batch = append(batch, m)
+			}
+		}
+		if err != nil || len(batch) >= maxBatchSize || r.Buffered() == 0 {
+			s.ingest(protocolPlaintext, batch)
+			batch = batch[:0]
+		}
+		if err != nil {
+			s.logReadError(protocolPlaintext, err)
+			return
+		}
+	}
+}
+
+// handlePickle reads pickle messages, each prefixed by its length as a 4 byte
+// big-endian unsigned integer.
+func (s *Server) handlePickle(conn net.Conn) {
+	defer conn.Close()
+	var (
+		r      = bufio.NewReader(conn)
+		header = make([]byte, 4)
+	)
+	for {
+		if r.Buffered() == 0 {
+			if err := s.waitForData(conn); err != nil {
+				s.logReadError(protocolPickle, err)
+				return
+			}
+		}
+		if _, err := io.ReadFull(r, header); err != nil {
+			s.logReadError(protocolPickle, err)
+			return
+		}
+		size := binary.BigEndian.Uint32(header)
+		if size > maxPickleMessageSize {
+			invalidSamples.WithLabelValues(protocolPickle).Inc()
+			log.Warn("msg", "Graphite pickle message too large, closing connection", "size", size, "max", maxPickleMessageSize)
+			return
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			s.logReadError(protocolPickle, err)
+			return
+		}
+
+		batch, err := parsePickle(payload, timeProvider().UnixNano()/int64(time.Millisecond))
+		if errors.Is(err, errPickleLimit) {
+			invalidSamples.WithLabelValues(protocolPickle).Inc()
+			log.Warn("msg", "Graphite pickle message exceeds the decoding limits, closing connection", "err", err)
+			return
+		}
+		if err != nil {
+			invalidSamples.WithLabelValues(protocolPickle).Inc()
+			log.WarnRateLimited("msg", "Invalid Graphite pickle message", "err", err)
+			continue
+		}
+		for len(batch) > maxBatchSize {
+			s.ingest(protocolPickle, batch[:maxBatchSize])
+			batch = batch[maxBatchSize:]
+		}
+		s.ingest(protocolPickle, batch)
+	}
+}
+
+// ingest converts the metrics into a write request which goes through the
+// same preprocessors as the requests received by the write endpoint.
+func (s *Server) ingest(protocol string, batch []metric) {
+	if len(batch) == 0 {
+		return
+	}
+	receivedSamples.WithLabelValues(protocol).Add(float64(len(batch)))
+
+	req := ingestor.NewWriteRequest()
+	for _, m := range batch {
+		req.Timeseries = append(req.Timeseries, prompb.TimeSeries{
+			Labels:  s.mapper.seriesLabels(m.path, m.tags),
+			Samples: []prompb.Sample{{Timestamp: m.timestamp, Value: m.value}},
+		})
+	}
+
+	// Preprocessors only know about HTTP requests, so they get an empty one
+	// which carries no tenant or HA information besides the series labels.
+	httpReq := (&http.Request{
+		Method: "POST",
+		URL:    &url.URL{},
+		Header: make(http.Header),
+	}).WithContext(context.Background())
+	err := s.preprocessor.Preprocess(httpReq, req)
+	var throttled *limits.ThrottledError
+	if errors.As(err, &throttled) {
+		// The series within the ingest limits are still ingested, there is
+		// no way to ask Graphite senders to back off.
+		log.WarnRateLimited("msg", "Graphite samples rejected by ingest limits", "err", err)
+		err = nil
+	}
+	if err != nil {
+		ingestor.FinishWriteRequest(req)
+		failedSamples.WithLabelValues(protocol).Add(float64(len(batch)))
+		log.WarnRateLimited("msg", "Graphite samples rejected by preprocessor", "err", err)
+		return
+	}
+	if len(req.Timeseries) == 0 {
+		ingestor.FinishWriteRequest(req)
+		return
+	}
+
+	numSamples, _, err := s.inserter.Ingest(httpReq.Context(), req)
+	if err != nil {
+		failedSamples.WithLabelValues(protocol).Add(float64(uint64(len(batch)) - numSamples))
+		log.Warn("msg", "Error ingesting Graphite samples", "err", err, "num_samples", numSamples)
+	}
+}
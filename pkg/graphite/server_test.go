@@ -0,0 +1,247 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package graphite
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/prompb"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+type mockInserter struct {
+	mu     sync.Mutex
+	series []prompb.TimeSeries
+}
+
+func (m *mockInserter) Ingest(_ context.Context, r *prompb.WriteRequest) (uint64, uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.series = append(m.series, r.Timeseries...)
+	return uint64(len(r.Timeseries)), 0, nil
+}
+
+func (m *mockInserter) IngestTraces(_ context.Context, _ pdata.Traces) error {
+	return nil
+}
+
+func (m *mockInserter) received() []prompb.TimeSeries {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := make([]prompb.TimeSeries, len(m.series))
+	copy(res, m.series)
+	sort.Slice(res, func(i, j int) bool { return res[i].Labels[0].Value < res[j].Labels[0].Value })
+	return res
+}
+
+// mockPreprocessor adds a label to all the series, or fails if err is set.
+type mockPreprocessor struct {
+	err error
+}
+
+func (m mockPreprocessor) Preprocess(_ *http.Request, wr *prompb.WriteRequest) error {
+	if m.err != nil {
+		return m.err
+	}
+	for i := range wr.Timeseries {
+		wr.Timeseries[i].Labels = append(wr.Timeseries[i].Labels, prompb.Label{Name: "preprocessed", Value: "true"})
+	}
+	return nil
+}
+
+func TestServer(t *testing.T) {
+	pickled := "\x80\x02]q\x00(X\x12\x00\x00\x00servers.a.cpu.userq\x01J\x00\x10^_G?\xf8\x00\x00\x00\x00\x00\x00\x86q\x02\x86q\x03e."
+	pickleMessage := make([]byte, 4, 4+len(pickled))
+	binary.BigEndian.PutUint32(pickleMessage, uint32(len(pickled)))
+	pickleMessage = append(pickleMessage, pickled...)
+
+	testCases := []struct {
+		name         string
+		pickle       bool
+		payload      string
+		preprocessor mockPreprocessor
+		series       []prompb.TimeSeries
+	}{
+		{
+			name:    "plaintext",
+			payload: "servers.a.cpu.user 1 1600000000\ninvalid line\nservers.b.mem 2 1600000000\n",
+			series: []prompb.TimeSeries{
+				{
+					Labels: []prompb.Label{
+						{Name: "__name__", Value: "cpu_user"},
+						{Name: "host", Value: "a"},
+						{Name: "preprocessed", Value: "true"},
+					},
+					Samples: []prompb.Sample{{Timestamp: 1600000000000, Value: 1}},
+				},
+				{
+					Labels: []prompb.Label{
+						{Name: "__name__", Value: "mem"},
+						{Name: "host", Value: "b"},
+						{Name: "preprocessed", Value: "true"},
+					},
+					Samples: []prompb.Sample{{Timestamp: 1600000000000, Value: 2}},
+				},
+			},
+		},
+		{
+			name:    "pickle",
+			pickle:  true,
+			payload: string(pickleMessage),
+			series: []prompb.TimeSeries{
+				{
+					Labels: []prompb.Label{
+						{Name: "__name__", Value: "cpu_user"},
+						{Name: "host", Value: "a"},
+						{Name: "preprocessed", Value: "true"},
+					},
+					Samples: []prompb.Sample{{Timestamp: 1600000000000, Value: 1.5}},
+				},
+			},
+		},
+		{
+			name:         "rejected by preprocessor",
+			payload:      "servers.a.cpu.user 1 1600000000\n",
+			preprocessor: mockPreprocessor{err: fmt.Errorf("unauthorized")},
+			series:       []prompb.TimeSeries{},
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			inserter := &mockInserter{}
+			server, err := NewServer(&Config{Templates: []string{"servers.* .host.measurement*"}}, inserter, c.preprocessor)
+			require.NoError(t, err)
+
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer listener.Close()
+			serve := server.ServePlaintext
+			if c.pickle {
+				serve = server.ServePickle
+			}
+			go func() { _ = serve(listener) }()
+
+			conn, err := net.Dial("tcp", listener.Addr().String())
+			require.NoError(t, err)
+			_, err = conn.Write([]byte(c.payload))
+			require.NoError(t, err)
+			require.NoError(t, conn.Close())
+
+			if len(c.series) == 0 {
+				time.Sleep(100 * time.Millisecond)
+				require.Empty(t, inserter.received())
+				return
+			}
+			require.Eventually(t, func() bool { return len(inserter.received()) == len(c.series) }, 5*time.Second, 10*time.Millisecond)
+			require.Equal(t, c.series, inserter.received())
+		})
+	}
+}
+
+func TestServerPickleLimits(t *testing.T) {
+	var payload []byte
+	for _, pickled := range []string{"]q\x00h\x00a.", "\x80\x02]q\x00(X\x03\x00\x00\x00x.yK\x01K\x01\x86\x86e."} {
+		header := make([]byte, 4)
+		binary.BigEndian.PutUint32(header, uint32(len(pickled)))
+		payload = append(append(payload, header...), pickled...)
+	}
+
+	inserter := &mockInserter{}
+	server, err := NewServer(&Config{}, inserter, mockPreprocessor{})
+	require.NoError(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() { _ = server.ServePickle(listener) }()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write(payload)
+	require.NoError(t, err)
+
+	// The connection is closed on the message exceeding the limits, and the
+	// messages after it are not ingested.
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = conn.Read(make([]byte, 1))
+	require.Equal(t, io.EOF, err)
+	require.Empty(t, inserter.received())
+}
+
+func TestServerPlaintextLimits(t *testing.T) {
+	inserter := &mockInserter{}
+	server, err := NewServer(&Config{IdleTimeout: 100 * time.Millisecond}, inserter, mockPreprocessor{})
+	require.NoError(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() { _ = server.ServePlaintext(listener) }()
+
+	// The connection is closed on the line longer than the limit, and the
+	// lines before it are ingested.
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("a.b 1 1600000000\n" + strings.Repeat("x", maxPlaintextLineSize)))
+	require.NoError(t, err)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = conn.Read(make([]byte, 1))
+	require.Equal(t, io.EOF, err)
+	require.Len(t, inserter.received(), 1)
+
+	// The idle connections are closed.
+	idle, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer idle.Close()
+	require.NoError(t, idle.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = idle.Read(make([]byte, 1))
+	require.Equal(t, io.EOF, err)
+}
+
+func TestServerClose(t *testing.T) {
+	inserter := &mockInserter{}
+	server, err := NewServer(&Config{}, inserter, mockPreprocessor{})
+	require.NoError(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	served := make(chan error, 1)
+	go func() { served <- server.ServePlaintext(listener) }()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("a.b 1 1600000000\n"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return len(inserter.received()) == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	server.Close()
+	select {
+	case err := <-served:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("serving did not return after the server was closed")
+	}
+	// The open connections are closed too.
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = conn.Read(make([]byte, 1))
+	require.Equal(t, io.EOF, err)
+}
+
+func TestNewServerInvalidTemplate(t *testing.T) {
+	_, err := NewServer(&Config{Templates: []string{"host.service"}}, &mockInserter{}, mockPreprocessor{})
+	require.EqualError(t, err, `invalid graphite template: template "host.service": pattern has no measurement node`)
+}
@@ -0,0 +1,212 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package graphite
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"github.com/timescale/promscale/pkg/prompb"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+const (
+	// measurementNode marks the template nodes which make up the metric name.
+	measurementNode = "measurement"
+	// greedyMeasurementNode makes all the remaining path nodes part of the metric name.
+	greedyMeasurementNode = measurementNode + "*"
+)
+
+// template maps the nodes of a dotted Graphite path into a metric name and
+// labels. The format is the same as the one used by Telegraf and InfluxDB:
+//
+//	[filter] pattern [label1=value1,label2=value2]
+//
+// The filter is a dotted glob which has to match the beginning of the path.
+// Each node of the pattern is either "measurement" (part of the metric name),
+// "measurement*" (the rest of the path is part of the metric name), empty
+// (the node is skipped) or the name of the label the node is assigned to.
+// The optional extra labels are added to every series mapped by the template.
+type template struct {
+	filter []string
+	nodes  []string
+	labels []prompb.Label
+}
+
+func parseTemplate(spec string) (template, error) {
+	var (
+		t      template
+		parts  = strings.Fields(spec)
+		labels string
+	)
+	switch len(parts) {
+	case 1:
+		t.nodes = strings.Split(parts[0], ".")
+	case 2:
+		if strings.Contains(parts[1], "=") {
+			t.nodes, labels = strings.Split(parts[0], "."), parts[1]
+		} else {
+			t.filter, t.nodes = strings.Split(parts[0], "."), strings.Split(parts[1], ".")
+		}
+	case 3:
+		t.filter, t.nodes, labels = strings.Split(parts[0], "."), strings.Split(parts[1], "."), parts[2]
+	default:
+		return t, fmt.Errorf("template %q: expected '[filter] pattern [labels]'", spec)
+	}
+
+	for _, node := range t.filter {
+		if _, err := path.Match(node, ""); err != nil || node == "" {
+			return t, fmt.Errorf("template %q: invalid filter node %q", spec, node)
+		}
+	}
+
+	hasMeasurement := false
+	for i, node := range t.nodes {
+		switch {
+		case node == "":
+		case node == measurementNode:
+			hasMeasurement = true
+		case node == greedyMeasurementNode:
+			if i != len(t.nodes)-1 {
+				return t, fmt.Errorf("template %q: %s must be the last node", spec, greedyMeasurementNode)
+			}
+			hasMeasurement = true
+		case node == model.MetricNameLabel || !model.LabelName(node).IsValid():
+			return t, fmt.Errorf("template %q: invalid label name %q", spec, node)
+		}
+	}
+	if !hasMeasurement {
+		return t, fmt.Errorf("template %q: pattern has no %s node", spec, measurementNode)
+	}
+
+	if labels != "" {
+		for _, kv := range strings.Split(labels, ",") {
+			i := strings.IndexByte(kv, '=')
+			if i <= 0 || i == len(kv)-1 {
+				return t, fmt.Errorf("template %q: invalid label %q", spec, kv)
+			}
+			name := kv[:i]
+			if name == model.MetricNameLabel || !model.LabelName(name).IsValid() {
+				return t, fmt.Errorf("template %q: invalid label name %q", spec, name)
+			}
+			t.labels = append(t.labels, prompb.Label{Name: name, Value: kv[i+1:]})
+		}
+	}
+	return t, nil
+}
+
+// matches returns true if the filter of the template matches the path nodes.
+// Templates without a filter match every path.
+func (t template) matches(nodes []string) bool {
+	if len(t.filter) > len(nodes) {
+		return false
+	}
+	for i, f := range t.filter {
+		if ok, _ := path.Match(f, nodes[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// apply maps the path nodes into a metric name and a set of labels. Labels
+// taken from the path take precedence over the extra template labels.
+func (t template) apply(nodes []string) (string, map[string]string) {
+	var (
+		nameParts []string
+		labels    = make(map[string]string, len(t.nodes)+len(t.labels))
+	)
+
+loop:
+	for i, node := range t.nodes {
+		if i >= len(nodes) {
+			break
+		}
+		switch node {
+		case "":
+		case measurementNode:
+			nameParts = append(nameParts, nodes[i])
+		case greedyMeasurementNode:
+			nameParts = append(nameParts, nodes[i:]...)
+			break loop
+		default:
+			if nodes[i] == "" {
+				continue
+			}
+			// Same as in InfluxDB, nodes mapped to the same label are joined.
+			if v, ok := labels[node]; ok {
+				labels[node] = v + "." + nodes[i]
+			} else {
+				labels[node] = nodes[i]
+			}
+		}
+	}
+
+	for _, l := range t.labels {
+		if _, ok := labels[l.Name]; !ok {
+			labels[l.Name] = l.Value
+		}
+	}
+	return strings.Join(nameParts, "_"), labels
+}
+
+// mapper converts Graphite paths into Prometheus series labels using the
+// first matching template.
+type mapper struct {
+	templates []template
+}
+
+func newMapper(specs []string) (*mapper, error) {
+	m := &mapper{templates: make([]template, 0, len(specs))}
+	for _, spec := range specs {
+		t, err := parseTemplate(spec)
+		if err != nil {
+			return nil, err
+		}
+		m.templates = append(m.templates, t)
+	}
+	return m, nil
+}
+
+// seriesLabels returns the sorted series labels of the given Graphite path
+// and tags. Paths without a matching template use the whole sanitized path
+// as the metric name. Tags take precedence over labels from the templates.
+func (m *mapper) seriesLabels(graphitePath string, tags []prompb.Label) []prompb.Label {
+	var (
+		name   string
+		labels map[string]string
+		nodes  = strings.Split(graphitePath, ".")
+	)
+	for _, t := range m.templates {
+		if t.matches(nodes) {
+			name, labels = t.apply(nodes)
+			break
+		}
+	}
+	if name == "" {
+		name = strings.Join(nodes, "_")
+	}
+	if labels == nil {
+		labels = make(map[string]string, len(tags))
+	}
+	for _, tag := range tags {
+		labels[util.SanitizeName(tag.Name, false)] = tag.Value
+	}
+	delete(labels, model.MetricNameLabel)
+
+	result := make([]prompb.Label, 0, len(labels)+1)
+	result = append(result, prompb.Label{Name: model.MetricNameLabel, Value: util.SanitizeName(name, true)})
+	for n, v := range labels {
+		if v == "" {
+			continue
+		}
+		result = append(result, prompb.Label{Name: n, Value: v})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
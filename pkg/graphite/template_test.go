@@ -0,0 +1,128 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package graphite
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+func TestParseTemplate(t *testing.T) {
+	testCases := []struct {
+		name string
+		spec string
+		err  string
+	}{
+		{name: "pattern only", spec: "service.host.measurement*"},
+		{name: "filter and pattern", spec: "servers.* .host.measurement.field"},
+		{name: "pattern and labels", spec: "measurement.host env=prod,dc=eu"},
+		{name: "filter, pattern and labels", spec: "servers.* .host.measurement* env=prod"},
+		{name: "too many parts", spec: "a b c d", err: `template "a b c d": expected '[filter] pattern [labels]'`},
+		{name: "no measurement", spec: "service.host", err: `template "service.host": pattern has no measurement node`},
+		{name: "greedy measurement not last", spec: "measurement*.host", err: `template "measurement*.host": measurement* must be the last node`},
+		{name: "invalid label node", spec: "measurement.host-name", err: `template "measurement.host-name": invalid label name "host-name"`},
+		{name: "metric name label node", spec: "measurement.__name__", err: `template "measurement.__name__": invalid label name "__name__"`},
+		{name: "invalid filter", spec: "servers.[ measurement", err: `template "servers.[ measurement": invalid filter node "["`},
+		{name: "invalid extra label", spec: "measurement env", err: `template "measurement env": pattern has no measurement node`},
+		{name: "extra label without value", spec: "measurement env=", err: `template "measurement env=": invalid label "env="`},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := parseTemplate(c.spec)
+			if c.err != "" {
+				require.EqualError(t, err, c.err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestMapperSeriesLabels(t *testing.T) {
+	m, err := newMapper([]string{
+		"servers.* .host.measurement*",
+		"apps.*.*.* .app.measurement.measurement.instance env=prod",
+		"dc.* .dc.dc.measurement",
+		"*.*.cpu.* service.host.measurement.measurement",
+	})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name   string
+		path   string
+		tags   []prompb.Label
+		labels []prompb.Label
+	}{
+		{
+			name: "greedy measurement",
+			path: "servers.web01.cpu.load.1m",
+			labels: []prompb.Label{
+				{Name: "__name__", Value: "cpu_load_1m"},
+				{Name: "host", Value: "web01"},
+			},
+		},
+		{
+			name: "multiple measurement nodes and extra labels",
+			path: "apps.shop.http.requests.pod-1",
+			labels: []prompb.Label{
+				{Name: "__name__", Value: "http_requests"},
+				{Name: "app", Value: "shop"},
+				{Name: "env", Value: "prod"},
+				{Name: "instance", Value: "pod-1"},
+			},
+		},
+		{
+			name: "nodes mapped to the same label are joined",
+			path: "dc.eu.west.temperature",
+			labels: []prompb.Label{
+				{Name: "__name__", Value: "temperature"},
+				{Name: "dc", Value: "eu.west"},
+			},
+		},
+		{
+			name: "filter with glob in the middle",
+			path: "svc.host.cpu.user",
+			labels: []prompb.Label{
+				{Name: "__name__", Value: "cpu_user"},
+				{Name: "host", Value: "host"},
+				{Name: "service", Value: "svc"},
+			},
+		},
+		{
+			name: "path shorter than the template",
+			path: "servers.web01",
+			labels: []prompb.Label{
+				{Name: "__name__", Value: "servers_web01"},
+				{Name: "host", Value: "web01"},
+			},
+		},
+		{
+			name: "no matching template",
+			path: "some.other-metric.count",
+			labels: []prompb.Label{
+				{Name: "__name__", Value: "some_other_metric_count"},
+			},
+		},
+		{
+			name: "tags override template labels",
+			path: "servers.web01.mem",
+			tags: []prompb.Label{{Name: "host", Value: "web02"}, {Name: "data-center", Value: "eu"}, {Name: "__name__", Value: "x"}},
+			labels: []prompb.Label{
+				{Name: "__name__", Value: "mem"},
+				{Name: "data_center", Value: "eu"},
+				{Name: "host", Value: "web02"},
+			},
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			require.Equal(t, c.labels, m.seriesLabels(c.path, c.tags))
+		})
+	}
+}
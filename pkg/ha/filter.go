@@ -148,6 +148,16 @@ func filterOutSampleRange(wr *prompb.WriteRequest, timeStartIncl, timeEndExcl in
 			t.Samples[j] = prompb.Sample{}
 		}
 		t.Samples = t.Samples[:numAccepted]
+
+		numAccepted = 0
+		for j := range t.Histograms {
+			if ts := t.Histograms[j].Timestamp; ts >= timeStartIncl && ts < timeEndExcl {
+				continue
+			}
+			t.Histograms[numAccepted] = t.Histograms[j]
+			numAccepted++
+		}
+		t.Histograms = t.Histograms[:numAccepted]
 	}
 }
 
@@ -159,7 +169,7 @@ func finalFiltering(wr *prompb.WriteRequest) {
 	numAccepted := 0
 	for i := range wr.Timeseries {
 		t := &wr.Timeseries[i]
-		if len(t.Samples) == 0 {
+		if len(t.Samples) == 0 && len(t.Histograms) == 0 {
 			continue
 		}
 		wr.Timeseries[numAccepted] = *t
@@ -181,28 +191,31 @@ func finalFiltering(wr *prompb.WriteRequest) {
 }
 
 // findDataTimeRange finds the minimum and maximum timestamps in a set of samples
+// and native histograms.
 func findDataTimeRange(tts []prompb.TimeSeries) (minTUnix int64, maxTUnix int64) {
 	timesWereSet := false
-	for i := range tts {
-		t := &tts[i]
-		if len(t.Samples) == 0 {
-			continue
+	update := func(ts int64) {
+		if !timesWereSet {
+			timesWereSet = true
+			minTUnix = ts
+			maxTUnix = ts
+			return
+		}
+		if ts < minTUnix {
+			minTUnix = ts
 		}
 
+		if ts > maxTUnix {
+			maxTUnix = ts
+		}
+	}
+	for i := range tts {
+		t := &tts[i]
 		for _, sample := range t.Samples {
-			if !timesWereSet {
-				timesWereSet = true
-				minTUnix = sample.Timestamp
-				maxTUnix = sample.Timestamp
-				continue
-			}
-			if sample.Timestamp < minTUnix {
-				minTUnix = sample.Timestamp
-			}
-
-			if sample.Timestamp > maxTUnix {
-				maxTUnix = sample.Timestamp
-			}
+			update(sample.Timestamp)
+		}
+		for j := range t.Histograms {
+			update(t.Histograms[j].Timestamp)
 		}
 	}
 	return minTUnix, maxTUnix
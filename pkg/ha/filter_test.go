@@ -552,6 +552,32 @@ func TestFilterSamples(t *testing.T) {
 				},
 			},
 		},
+		{
+			wr: &prompb.WriteRequest{
+				Timeseries: []prompb.TimeSeries{
+					{
+						Histograms: []prompb.Histogram{
+							{Timestamp: 4},
+							{Timestamp: 5},
+							{Timestamp: 9},
+							{Timestamp: 10},
+						},
+					},
+				},
+			},
+			timeStart: 5,
+			timeEnd:   10,
+			expected: &prompb.WriteRequest{
+				Timeseries: []prompb.TimeSeries{
+					{
+						Histograms: []prompb.Histogram{
+							{Timestamp: 4},
+							{Timestamp: 10},
+						},
+					},
+				},
+			},
+		},
 	}
 
 	for _, tc := range testCases {
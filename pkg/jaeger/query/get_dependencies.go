@@ -0,0 +1,50 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package query
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/timescale/promscale/pkg/pgxconn"
+)
+
+const getDependenciesSQL = `
+SELECT
+	parent_service,
+	child_service,
+	call_count
+FROM
+	ps_trace.service_dependencies($1, $2)`
+
+func getDependencies(ctx context.Context, conn pgxconn.PgxConn, endTs time.Time, lookback time.Duration) ([]model.DependencyLink, error) {
+	rows, err := conn.Query(ctx, getDependenciesSQL, endTs.Add(-lookback), endTs)
+	if err != nil {
+		return nil, fmt.Errorf("fetching dependencies: %w", err)
+	}
+	defer rows.Close()
+
+	depLinks := make([]model.DependencyLink, 0)
+	for rows.Next() {
+		var (
+			parent, child string
+			callCount     int64
+		)
+		if err := rows.Scan(&parent, &child, &callCount); err != nil {
+			return nil, fmt.Errorf("dependencies: scanning row: %w", err)
+		}
+		depLinks = append(depLinks, model.DependencyLink{
+			Parent:    parent,
+			Child:     child,
+			CallCount: uint64(callCount),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("fetching dependencies: %w", err)
+	}
+	return depLinks, nil
+}
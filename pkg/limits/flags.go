@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/timescale/promscale/pkg/limits/mem"
@@ -101,6 +102,27 @@ func (t *PercentageAbsoluteBytesFlag) String() string {
 type Config struct {
 	targetMemoryFlag  PercentageAbsoluteBytesFlag
 	TargetMemoryBytes uint64
+
+	// Ingest limits, a zero value disables the limit.
+	TenantSamplesPerSecond float64
+	MetricSamplesPerSecond float64
+	// Bursts of samples allowed above the rate limits, a zero value
+	// defaults to DefaultSamplesBurstSeconds worth of samples.
+	TenantSamplesBurst    float64
+	MetricSamplesBurst    float64
+	TenantMaxActiveSeries int
+	MetricMaxActiveSeries int
+	ActiveSeriesWindow    time.Duration
+}
+
+// DefaultSamplesBurstSeconds is the number of seconds worth of samples
+// allowed in a burst when the burst of a samples rate limit is not set.
+const DefaultSamplesBurstSeconds = 10
+
+// IngestLimitsEnabled returns true if any of the ingest limits is set.
+func (cfg Config) IngestLimitsEnabled() bool {
+	return cfg.TenantSamplesPerSecond > 0 || cfg.MetricSamplesPerSecond > 0 ||
+		cfg.TenantMaxActiveSeries > 0 || cfg.MetricMaxActiveSeries > 0
 }
 
 // ParseFlags parses the configuration flags for logging.
@@ -117,6 +139,20 @@ func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
 
 	fs.Var(&cfg.targetMemoryFlag, "memory-target", "Target for max amount of memory to use. "+
 		"Specified in bytes or as a percentage of system memory (e.g. 80%).")
+
+	fs.Float64Var(&cfg.TenantSamplesPerSecond, "ingest-limit-tenant-samples-per-second", 0, "Maximum rate of samples ingested per tenant (series with the same __tenant__ label, or without it). "+
+		"Samples above the limit are rejected with a 429 status code. 0 disables the limit.")
+	fs.Float64Var(&cfg.MetricSamplesPerSecond, "ingest-limit-metric-samples-per-second", 0, "Maximum rate of samples ingested per metric name of each tenant. "+
+		"Samples above the limit are rejected with a 429 status code. 0 disables the limit.")
+	fs.Float64Var(&cfg.TenantSamplesBurst, "ingest-limit-tenant-samples-burst", 0, "Maximum number of samples ingested at once per tenant above the tenant samples rate. "+
+		fmt.Sprintf("0 defaults to %d seconds worth of samples at the tenant samples rate.", DefaultSamplesBurstSeconds))
+	fs.Float64Var(&cfg.MetricSamplesBurst, "ingest-limit-metric-samples-burst", 0, "Maximum number of samples ingested at once per metric name of each tenant above the metric samples rate. "+
+		fmt.Sprintf("0 defaults to %d seconds worth of samples at the metric samples rate.", DefaultSamplesBurstSeconds))
+	fs.IntVar(&cfg.TenantMaxActiveSeries, "ingest-limit-tenant-active-series", 0, "Maximum number of active series per tenant. "+
+		"Samples of new series above the limit are rejected with a 429 status code. 0 disables the limit.")
+	fs.IntVar(&cfg.MetricMaxActiveSeries, "ingest-limit-metric-active-series", 0, "Maximum number of active series per metric name of each tenant. "+
+		"Samples of new series above the limit are rejected with a 429 status code. 0 disables the limit.")
+	fs.DurationVar(&cfg.ActiveSeriesWindow, "ingest-limit-active-series-window", time.Hour, "Duration after which a series which has not received any sample stops counting as active for the active series limits.")
 	return cfg
 }
 
@@ -134,5 +170,13 @@ func Validate(cfg *Config) error {
 		return fmt.Errorf("Unknown kind of input")
 	}
 	MemoryTargetMetric.Set(float64(cfg.TargetMemoryBytes))
+
+	if cfg.TenantSamplesPerSecond < 0 || cfg.MetricSamplesPerSecond < 0 || cfg.TenantSamplesBurst < 0 || cfg.MetricSamplesBurst < 0 ||
+		cfg.TenantMaxActiveSeries < 0 || cfg.MetricMaxActiveSeries < 0 {
+		return fmt.Errorf("ingest limits cannot be negative")
+	}
+	if cfg.ActiveSeriesWindow <= 0 {
+		return fmt.Errorf("ingest-limit-active-series-window must be positive")
+	}
 	return nil
 }
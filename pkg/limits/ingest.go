@@ -0,0 +1,348 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license
+
+package limits
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/timescale/promscale/pkg/prompb"
+	"github.com/timescale/promscale/pkg/tenancy"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+// Names of the ingest limits, used in the metrics and in the errors reported
+// back to the senders.
+const (
+	limitTenantSamplesRate  = "tenant_samples_rate"
+	limitMetricSamplesRate  = "metric_samples_rate"
+	limitTenantActiveSeries = "tenant_active_series"
+	limitMetricActiveSeries = "metric_active_series"
+
+	// maxReportedRejections caps the number of rejections detailed in
+	// the error message sent back to the sender.
+	maxReportedRejections = 10
+)
+
+var (
+	ThrottledSamples = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "ingest_limits",
+			Name:      "throttled_samples_total",
+			Help:      "Total number of samples rejected because of the ingest limits, by limit and tenant.",
+		}, []string{"limit", "tenant"},
+	)
+	ActiveSeries = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "ingest_limits",
+			Name:      "active_series",
+			Help:      "Number of active series tracked for the ingest limits, by tenant.",
+		}, []string{"tenant"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		ThrottledSamples,
+		ActiveSeries,
+	)
+}
+
+// Rejection describes the samples of a tenant metric rejected because of one
+// of the ingest limits.
+type Rejection struct {
+	Limit   string
+	Tenant  string
+	Metric  string
+	Samples int
+}
+
+// ThrottledError is returned by the IngestLimiter when some of the series of a
+// write request were rejected. The other series are left in the request and
+// can still be ingested.
+type ThrottledError struct {
+	Rejections []Rejection
+}
+
+func (e *ThrottledError) Error() string {
+	var (
+		total   int
+		details = make([]string, 0, maxReportedRejections)
+	)
+	for i, r := range e.Rejections {
+		total += r.Samples
+		if i < maxReportedRejections {
+			details = append(details, fmt.Sprintf("%s (tenant %q, metric %q): %d samples", r.Limit, r.Tenant, r.Metric, r.Samples))
+		}
+	}
+	if len(e.Rejections) > maxReportedRejections {
+		details = append(details, fmt.Sprintf("%d more", len(e.Rejections)-maxReportedRejections))
+	}
+	return fmt.Sprintf("ingest limits exceeded, %d samples rejected: %s", total, strings.Join(details, ", "))
+}
+
+// tokenBucket allows a given rate of samples per second, with bursts of up to
+// a given number of samples.
+type tokenBucket struct {
+	tokens     float64
+	lastRefill time.Time
+}
+
+func (b *tokenBucket) allow(rate, burst, n float64, now time.Time) bool {
+	if b.lastRefill.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens += rate * now.Sub(b.lastRefill).Seconds()
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+	b.lastRefill = now
+	return b.tokens >= n
+}
+
+// samplesBurst returns the burst of a samples rate limit.
+func samplesBurst(rate, burst float64) float64 {
+	if burst > 0 {
+		return burst
+	}
+	return rate * DefaultSamplesBurstSeconds
+}
+
+type metricState struct {
+	bucket tokenBucket
+	// series maps the hashes of the active series to when they were last
+	// seen. Series are only tracked when an active series limit is set.
+	series map[uint64]time.Time
+	// lastSeen is when the metric last received samples. Idle metrics are
+	// evicted after the active series window.
+	lastSeen time.Time
+}
+
+type tenantState struct {
+	bucket       tokenBucket
+	activeSeries int
+	metrics      map[string]*metricState
+}
+
+// IngestLimiter is a write preprocessor enforcing rate and active series
+// limits per tenant and per metric of each tenant. Limits are tracked in
+// memory, so they apply to each Promscale instance separately.
+type IngestLimiter struct {
+	cfg         Config
+	tenantBurst float64
+	metricBurst float64
+	trackSeries bool
+	now         func() time.Time
+	mu          sync.Mutex
+	tenants     map[string]*tenantState
+	lastPurge   time.Time
+	purgeEvery  time.Duration
+}
+
+// NewIngestLimiter returns the ingest limiter for the configured limits, or
+// nil if no ingest limit is set.
+func NewIngestLimiter(cfg Config) *IngestLimiter {
+	if !cfg.IngestLimitsEnabled() {
+		return nil
+	}
+	return newIngestLimiter(cfg, time.Now)
+}
+
+func newIngestLimiter(cfg Config, now func() time.Time) *IngestLimiter {
+	purgeEvery := cfg.ActiveSeriesWindow / 10
+	if purgeEvery > time.Minute {
+		purgeEvery = time.Minute
+	}
+	return &IngestLimiter{
+		cfg:         cfg,
+		tenantBurst: samplesBurst(cfg.TenantSamplesPerSecond, cfg.TenantSamplesBurst),
+		metricBurst: samplesBurst(cfg.MetricSamplesPerSecond, cfg.MetricSamplesBurst),
+		trackSeries: cfg.TenantMaxActiveSeries > 0 || cfg.MetricMaxActiveSeries > 0,
+		now:         now,
+		tenants:     make(map[string]*tenantState),
+		lastPurge:   now(),
+		purgeEvery:  purgeEvery,
+	}
+}
+
+// seriesKey identifies the series of a write request for the limits.
+type seriesKey struct {
+	tenant  string
+	metric  string
+	hash    uint64
+	samples int
+}
+
+// Process implements the Preprocessor interface. The series exceeding any of
+// the limits are removed from the write request and reported back with a
+// *ThrottledError. Since the rest of the request is still valid, the limiter
+// has to be the last preprocessor.
+func (l *IngestLimiter) Process(_ *http.Request, wr *prompb.WriteRequest) error {
+	// The series are identified before locking, so that the lock is only
+	// held while accounting for their samples.
+	var (
+		keys = make([]seriesKey, len(wr.Timeseries))
+		buf  labels.Labels
+	)
+	for i := range wr.Timeseries {
+		ts := &wr.Timeseries[i]
+		k := &keys[i]
+		k.tenant, k.metric = tenantAndMetric(ts.Labels)
+		// Each native histogram counts as a single sample.
+		k.samples = len(ts.Samples) + len(ts.Histograms)
+		if l.trackSeries {
+			k.hash, buf = seriesHash(ts.Labels, buf)
+		}
+	}
+
+	var (
+		now        = l.now()
+		rejections = make(map[Rejection]int)
+		kept       = wr.Timeseries[:0]
+	)
+	l.mu.Lock()
+	if now.Sub(l.lastPurge) >= l.purgeEvery {
+		l.purge(now)
+	}
+	for i, ts := range wr.Timeseries {
+		k := keys[i]
+		limit := l.check(k, now)
+		if limit == "" {
+			kept = append(kept, ts)
+			continue
+		}
+		rejections[Rejection{Limit: limit, Tenant: k.tenant, Metric: k.metric}] += k.samples
+		ThrottledSamples.WithLabelValues(limit, k.tenant).Add(float64(k.samples))
+	}
+	l.mu.Unlock()
+	for i := len(kept); i < len(wr.Timeseries); i++ {
+		wr.Timeseries[i] = prompb.TimeSeries{}
+	}
+	wr.Timeseries = kept
+
+	if len(rejections) == 0 {
+		return nil
+	}
+	err := &ThrottledError{Rejections: make([]Rejection, 0, len(rejections))}
+	for r, samples := range rejections {
+		r.Samples = samples
+		err.Rejections = append(err.Rejections, r)
+	}
+	sort.Slice(err.Rejections, func(i, j int) bool {
+		a, b := err.Rejections[i], err.Rejections[j]
+		if a.Samples != b.Samples {
+			return a.Samples > b.Samples
+		}
+		return a.Limit+a.Tenant+a.Metric < b.Limit+b.Tenant+b.Metric
+	})
+	return err
+}
+
+// check returns the name of the limit exceeded by the series, if any,
+// otherwise it accounts for the series samples.
+func (l *IngestLimiter) check(k seriesKey, now time.Time) string {
+	t, ok := l.tenants[k.tenant]
+	if !ok {
+		t = &tenantState{metrics: make(map[string]*metricState)}
+		l.tenants[k.tenant] = t
+	}
+	m, ok := t.metrics[k.metric]
+	if !ok {
+		m = &metricState{series: make(map[uint64]time.Time)}
+		t.metrics[k.metric] = m
+	}
+
+	var active bool
+	if l.trackSeries {
+		_, active = m.series[k.hash]
+	}
+	if l.trackSeries && !active {
+		if l.cfg.TenantMaxActiveSeries > 0 && t.activeSeries >= l.cfg.TenantMaxActiveSeries {
+			return limitTenantActiveSeries
+		}
+		if l.cfg.MetricMaxActiveSeries > 0 && len(m.series) >= l.cfg.MetricMaxActiveSeries {
+			return limitMetricActiveSeries
+		}
+	}
+
+	samples := float64(k.samples)
+	if l.cfg.TenantSamplesPerSecond > 0 && !t.bucket.allow(l.cfg.TenantSamplesPerSecond, l.tenantBurst, samples, now) {
+		return limitTenantSamplesRate
+	}
+	if l.cfg.MetricSamplesPerSecond > 0 && !m.bucket.allow(l.cfg.MetricSamplesPerSecond, l.metricBurst, samples, now) {
+		return limitMetricSamplesRate
+	}
+	t.bucket.tokens -= samples
+	m.bucket.tokens -= samples
+	m.lastSeen = now
+
+	if !l.trackSeries {
+		return ""
+	}
+	if !active {
+		t.activeSeries++
+		ActiveSeries.WithLabelValues(k.tenant).Inc()
+	}
+	m.series[k.hash] = now
+	return ""
+}
+
+// purge removes the series and the metrics which have not been seen during
+// the active series window.
+func (l *IngestLimiter) purge(now time.Time) {
+	l.lastPurge = now
+	deadline := now.Add(-l.cfg.ActiveSeriesWindow)
+	for tenant, t := range l.tenants {
+		for metric, m := range t.metrics {
+			for hash, lastSeen := range m.series {
+				if lastSeen.Before(deadline) {
+					delete(m.series, hash)
+					t.activeSeries--
+					ActiveSeries.WithLabelValues(tenant).Dec()
+				}
+			}
+			if len(m.series) == 0 && !m.lastSeen.After(deadline) {
+				delete(t.metrics, metric)
+			}
+		}
+		if len(t.metrics) == 0 {
+			delete(l.tenants, tenant)
+			ActiveSeries.DeleteLabelValues(tenant)
+		}
+	}
+}
+
+// seriesHash returns the hash of the series labels, regardless of their order.
+// buf is reused to sort the labels and returned for the next call.
+func seriesHash(ls []prompb.Label, buf labels.Labels) (uint64, labels.Labels) {
+	buf = buf[:0]
+	for _, lbl := range ls {
+		buf = append(buf, labels.Label{Name: lbl.Name, Value: lbl.Value})
+	}
+	sort.Sort(buf)
+	return buf.Hash(), buf
+}
+
+func tenantAndMetric(ls []prompb.Label) (tenant, metric string) {
+	for _, l := range ls {
+		switch l.Name {
+		case tenancy.TenantLabelKey:
+			tenant = l.Value
+		case model.MetricNameLabel:
+			metric = l.Value
+		}
+	}
+	return tenant, metric
+}
@@ -0,0 +1,236 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license
+
+package limits
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+func limitsSeries(tenant, metric, id string, samples int) prompb.TimeSeries {
+	ts := prompb.TimeSeries{
+		Labels: []prompb.Label{{Name: "__name__", Value: metric}, {Name: "id", Value: id}},
+	}
+	if tenant != "" {
+		ts.Labels = append(ts.Labels, prompb.Label{Name: "__tenant__", Value: tenant})
+	}
+	for i := 0; i < samples; i++ {
+		ts.Samples = append(ts.Samples, prompb.Sample{Timestamp: int64(i), Value: 1})
+	}
+	return ts
+}
+
+// limitsHistogramSeries returns a series with the given number of native
+// histogram samples.
+func limitsHistogramSeries(tenant, metric, id string, histograms int) prompb.TimeSeries {
+	ts := limitsSeries(tenant, metric, id, 0)
+	for i := 0; i < histograms; i++ {
+		ts.Histograms = append(ts.Histograms, prompb.Histogram{Timestamp: int64(i)})
+	}
+	return ts
+}
+
+func TestNewIngestLimiterDisabled(t *testing.T) {
+	require.Nil(t, NewIngestLimiter(Config{ActiveSeriesWindow: time.Hour}))
+}
+
+func TestIngestLimiter(t *testing.T) {
+	type step struct {
+		advance    time.Duration
+		input      []prompb.TimeSeries
+		kept       []string // ids of the series left in the request
+		rejections []Rejection
+	}
+	testCases := []struct {
+		name  string
+		cfg   Config
+		steps []step
+	}{
+		{
+			name: "tenant samples rate",
+			cfg:  Config{TenantSamplesPerSecond: 10, TenantSamplesBurst: 10, ActiveSeriesWindow: time.Hour},
+			steps: []step{
+				{
+					input: []prompb.TimeSeries{
+						limitsSeries("a", "m1", "1", 6),
+						limitsSeries("a", "m2", "2", 6),
+						limitsSeries("b", "m1", "3", 6),
+						limitsSeries("a", "m3", "4", 4),
+					},
+					kept:       []string{"1", "3", "4"},
+					rejections: []Rejection{{Limit: limitTenantSamplesRate, Tenant: "a", Metric: "m2", Samples: 6}},
+				},
+				{
+					advance: 500 * time.Millisecond,
+					input:   []prompb.TimeSeries{limitsSeries("a", "m1", "1", 5), limitsSeries("a", "m1", "1", 1)},
+					kept:    []string{"1"},
+					rejections: []Rejection{
+						{Limit: limitTenantSamplesRate, Tenant: "a", Metric: "m1", Samples: 1},
+					},
+				},
+			},
+		},
+		{
+			name: "metric samples rate",
+			cfg:  Config{MetricSamplesPerSecond: 5, MetricSamplesBurst: 5, ActiveSeriesWindow: time.Hour},
+			steps: []step{
+				{
+					input: []prompb.TimeSeries{
+						limitsSeries("", "m1", "1", 5),
+						limitsSeries("", "m1", "2", 1),
+						limitsSeries("", "m2", "3", 5),
+						limitsSeries("a", "m1", "4", 5),
+					},
+					kept:       []string{"1", "3", "4"},
+					rejections: []Rejection{{Limit: limitMetricSamplesRate, Tenant: "", Metric: "m1", Samples: 1}},
+				},
+				{
+					advance: time.Second,
+					input:   []prompb.TimeSeries{limitsSeries("", "m1", "2", 5)},
+					kept:    []string{"2"},
+				},
+			},
+		},
+		{
+			name: "native histograms",
+			cfg:  Config{TenantSamplesPerSecond: 5, TenantSamplesBurst: 5, MetricSamplesPerSecond: 3, MetricSamplesBurst: 3, ActiveSeriesWindow: time.Hour},
+			steps: []step{
+				{
+					input: []prompb.TimeSeries{
+						limitsHistogramSeries("a", "m1", "1", 4),
+						limitsHistogramSeries("a", "m2", "2", 3),
+						limitsHistogramSeries("a", "m3", "3", 3),
+					},
+					kept: []string{"2"},
+					rejections: []Rejection{
+						{Limit: limitMetricSamplesRate, Tenant: "a", Metric: "m1", Samples: 4},
+						{Limit: limitTenantSamplesRate, Tenant: "a", Metric: "m3", Samples: 3},
+					},
+				},
+			},
+		},
+		{
+			name: "default samples burst",
+			cfg:  Config{MetricSamplesPerSecond: 5, ActiveSeriesWindow: time.Hour},
+			steps: []step{
+				{
+					input:      []prompb.TimeSeries{limitsSeries("", "m1", "1", 40), limitsSeries("", "m1", "1", 11)},
+					kept:       []string{"1"},
+					rejections: []Rejection{{Limit: limitMetricSamplesRate, Tenant: "", Metric: "m1", Samples: 11}},
+				},
+				{
+					advance: time.Second,
+					input:   []prompb.TimeSeries{limitsSeries("", "m1", "1", 15)},
+					kept:    []string{"1"},
+				},
+			},
+		},
+		{
+			name: "tenant active series",
+			cfg:  Config{TenantMaxActiveSeries: 2, ActiveSeriesWindow: time.Hour},
+			steps: []step{
+				{
+					input: []prompb.TimeSeries{
+						limitsSeries("a", "m1", "1", 1),
+						limitsSeries("a", "m2", "2", 1),
+						limitsSeries("a", "m2", "3", 2),
+						limitsSeries("b", "m2", "3", 1),
+					},
+					kept:       []string{"1", "2", "3"},
+					rejections: []Rejection{{Limit: limitTenantActiveSeries, Tenant: "a", Metric: "m2", Samples: 2}},
+				},
+				{
+					// Existing series are still accepted.
+					advance: 30 * time.Minute,
+					input:   []prompb.TimeSeries{limitsSeries("a", "m1", "1", 1), limitsSeries("a", "m2", "4", 1)},
+					kept:    []string{"1"},
+					rejections: []Rejection{
+						{Limit: limitTenantActiveSeries, Tenant: "a", Metric: "m2", Samples: 1},
+					},
+				},
+				{
+					// Series 2 is no longer active.
+					advance: 45 * time.Minute,
+					input:   []prompb.TimeSeries{limitsSeries("a", "m2", "4", 1)},
+					kept:    []string{"4"},
+				},
+			},
+		},
+		{
+			name: "metric active series",
+			cfg:  Config{MetricMaxActiveSeries: 1, ActiveSeriesWindow: time.Hour},
+			steps: []step{
+				{
+					input: []prompb.TimeSeries{
+						limitsSeries("", "m1", "1", 1),
+						limitsSeries("", "m1", "2", 3),
+						limitsSeries("", "m2", "3", 1),
+						limitsSeries("", "m2", "4", 1),
+					},
+					kept: []string{"1", "3"},
+					rejections: []Rejection{
+						{Limit: limitMetricActiveSeries, Tenant: "", Metric: "m1", Samples: 3},
+						{Limit: limitMetricActiveSeries, Tenant: "", Metric: "m2", Samples: 1},
+					},
+				},
+			},
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			now := time.Unix(1600000000, 0)
+			limiter := newIngestLimiter(c.cfg, func() time.Time { return now })
+			for i, s := range c.steps {
+				now = now.Add(s.advance)
+				wr := &prompb.WriteRequest{Timeseries: s.input}
+				err := limiter.Process(nil, wr)
+
+				kept := make([]string, 0, len(wr.Timeseries))
+				for _, ts := range wr.Timeseries {
+					kept = append(kept, ts.Labels[1].Value)
+				}
+				require.Equal(t, s.kept, kept, "step %d", i)
+
+				if len(s.rejections) == 0 {
+					require.NoError(t, err, "step %d", i)
+					continue
+				}
+				var throttled *ThrottledError
+				require.True(t, errors.As(err, &throttled), "step %d", i)
+				require.Equal(t, s.rejections, throttled.Rejections, "step %d", i)
+			}
+		})
+	}
+}
+
+func TestIngestLimiterEviction(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	limiter := newIngestLimiter(Config{MetricSamplesPerSecond: 100, ActiveSeriesWindow: time.Hour}, func() time.Time { return now })
+
+	require.NoError(t, limiter.Process(nil, &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{
+		limitsSeries("", "m1", "1", 1),
+		limitsSeries("", "m1", "2", 1),
+	}}))
+	require.Empty(t, limiter.tenants[""].metrics["m1"].series, "series are not tracked without active series limits")
+
+	now = now.Add(time.Hour + time.Minute)
+	require.NoError(t, limiter.Process(nil, &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{limitsSeries("", "m2", "1", 1)}}))
+	require.NotContains(t, limiter.tenants[""].metrics, "m1", "idle metrics are evicted")
+	require.Contains(t, limiter.tenants[""].metrics, "m2")
+}
+
+func TestThrottledErrorMessage(t *testing.T) {
+	err := &ThrottledError{}
+	for i := 0; i < maxReportedRejections+2; i++ {
+		err.Rejections = append(err.Rejections, Rejection{Limit: limitMetricSamplesRate, Tenant: "a", Metric: "m", Samples: 1})
+	}
+	require.Contains(t, err.Error(), "ingest limits exceeded, 12 samples rejected: metric_samples_rate (tenant \"a\", metric \"m\"): 1 samples, ")
+	require.Contains(t, err.Error(), ", 2 more")
+}
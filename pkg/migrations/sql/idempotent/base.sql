@@ -248,6 +248,12 @@ BEGIN
         RETURN NEW;
    END IF;
 
+   -- Note: downsampled metrics create their own tables and share the series
+   -- of the raw metric.
+   IF NEW.table_schema IN (SELECT d.schema_name FROM SCHEMA_CATALOG.downsample d) THEN
+        RETURN NEW;
+   END IF;
+
    EXECUTE format('CREATE TABLE %I.%I(time TIMESTAMPTZ NOT NULL, value DOUBLE PRECISION NOT NULL, series_id BIGINT NOT NULL) WITH (autovacuum_vacuum_threshold = 50000, autovacuum_analyze_threshold = 50000)',
                     NEW.table_schema, NEW.table_name);
    EXECUTE format('GRANT SELECT ON TABLE %I.%I TO prom_reader', NEW.table_schema, NEW.table_name);
@@ -798,13 +804,23 @@ $$
     DECLARE
         hypertable_name TEXT;
         deletable_metric_id INTEGER;
+        downsample_schema NAME;
     BEGIN
         IF (SELECT NOT pg_try_advisory_xact_lock(SCHEMA_LOCK_ID)) THEN
             RAISE NOTICE 'drop_metric can run only when no Promscale connectors are running. Please shutdown the Promscale connectors';
             PERFORM pg_advisory_xact_lock(SCHEMA_LOCK_ID);
         END IF;
-        SELECT table_name, id INTO hypertable_name, deletable_metric_id FROM SCHEMA_CATALOG.metric WHERE metric_name=metric_name_to_be_dropped;
+        SELECT table_name, id INTO hypertable_name, deletable_metric_id FROM SCHEMA_CATALOG.metric WHERE metric_name=metric_name_to_be_dropped AND table_schema='SCHEMA_DATA';
         RAISE NOTICE 'deleting "%" metric with metric_id as "%" and table_name as "%"', metric_name_to_be_dropped, deletable_metric_id, hypertable_name;
+        FOR downsample_schema IN
+            SELECT m.table_schema
+            FROM SCHEMA_CATALOG.metric m
+            INNER JOIN SCHEMA_CATALOG.downsample d ON (d.schema_name = m.table_schema)
+            WHERE m.metric_name=metric_name_to_be_dropped
+        LOOP
+            EXECUTE FORMAT('DROP TABLE %1$I.%2$I;', downsample_schema, hypertable_name);
+            DELETE FROM SCHEMA_CATALOG.metric WHERE metric_name=metric_name_to_be_dropped AND table_schema=downsample_schema;
+        END LOOP;
         EXECUTE FORMAT('DROP VIEW SCHEMA_SERIES.%1$I;', hypertable_name);
         EXECUTE FORMAT('DROP VIEW SCHEMA_METRIC.%1$I;', hypertable_name);
         EXECUTE FORMAT('DROP TABLE SCHEMA_DATA_SERIES.%1$I;', hypertable_name);
@@ -1430,6 +1446,83 @@ COMMENT ON FUNCTION SCHEMA_PROM.reset_metric_retention_period(TEXT)
 IS 'resets the retention period for a specific raw metric in the default schema to using the default retention period';
 GRANT EXECUTE ON FUNCTION SCHEMA_PROM.reset_metric_retention_period(TEXT) TO prom_admin;
 
+CREATE OR REPLACE FUNCTION SCHEMA_CATALOG.get_default_duplicate_policy()
+RETURNS TEXT
+AS $$
+    SELECT value FROM SCHEMA_CATALOG.default WHERE key='duplicate_policy';
+$$
+LANGUAGE SQL STABLE PARALLEL SAFE;
+GRANT EXECUTE ON FUNCTION SCHEMA_CATALOG.get_default_duplicate_policy() TO prom_reader;
+
+CREATE OR REPLACE FUNCTION SCHEMA_CATALOG.get_metric_duplicate_policy(metric_name TEXT)
+RETURNS TEXT
+AS $$
+    SELECT COALESCE(m.duplicate_policy, SCHEMA_CATALOG.get_default_duplicate_policy())
+    FROM SCHEMA_CATALOG.metric m
+    WHERE m.table_schema = 'SCHEMA_DATA'
+    AND m.metric_name = get_metric_duplicate_policy.metric_name
+    UNION ALL
+    SELECT SCHEMA_CATALOG.get_default_duplicate_policy()
+    LIMIT 1
+$$
+LANGUAGE SQL STABLE PARALLEL SAFE;
+GRANT EXECUTE ON FUNCTION SCHEMA_CATALOG.get_metric_duplicate_policy(TEXT) TO prom_reader;
+
+CREATE OR REPLACE FUNCTION SCHEMA_PROM.set_default_duplicate_policy(policy TEXT)
+RETURNS BOOLEAN
+AS $func$
+BEGIN
+    IF policy IS NULL OR policy NOT IN ('reject', 'keep_first', 'keep_last', 'allow') THEN
+        RAISE EXCEPTION 'invalid duplicate policy %, expected one of reject, keep_first, keep_last or allow', policy;
+    END IF;
+
+    INSERT INTO SCHEMA_CATALOG.default(key, value) VALUES('duplicate_policy', policy)
+    ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value;
+    RETURN true;
+END
+$func$
+LANGUAGE PLPGSQL VOLATILE;
+COMMENT ON FUNCTION SCHEMA_PROM.set_default_duplicate_policy(TEXT)
+IS 'set the policy applied to duplicate and out-of-order samples of any metrics (existing and new) without an explicit override';
+GRANT EXECUTE ON FUNCTION SCHEMA_PROM.set_default_duplicate_policy(TEXT) TO prom_admin;
+
+CREATE OR REPLACE FUNCTION SCHEMA_PROM.set_metric_duplicate_policy(metric_name TEXT, policy TEXT)
+RETURNS BOOLEAN
+AS $func$
+BEGIN
+    IF policy IS NULL OR policy NOT IN ('reject', 'keep_first', 'keep_last', 'allow') THEN
+        RAISE EXCEPTION 'invalid duplicate policy %, expected one of reject, keep_first, keep_last or allow', policy;
+    END IF;
+
+    --use get_or_create_metric_table_name because we want to be able to set /before/ any data is ingested
+    --needs to run before update so row exists before update.
+    PERFORM SCHEMA_CATALOG.get_or_create_metric_table_name(set_metric_duplicate_policy.metric_name);
+
+    UPDATE SCHEMA_CATALOG.metric m SET duplicate_policy = policy
+    WHERE m.table_schema = 'SCHEMA_DATA'
+    AND m.metric_name = set_metric_duplicate_policy.metric_name;
+
+    RETURN true;
+END
+$func$
+LANGUAGE PLPGSQL VOLATILE;
+COMMENT ON FUNCTION SCHEMA_PROM.set_metric_duplicate_policy(TEXT, TEXT)
+IS 'set the policy applied to duplicate and out-of-order samples of a specific metric (this overrides the default)';
+GRANT EXECUTE ON FUNCTION SCHEMA_PROM.set_metric_duplicate_policy(TEXT, TEXT) TO prom_admin;
+
+CREATE OR REPLACE FUNCTION SCHEMA_PROM.reset_metric_duplicate_policy(metric_name TEXT)
+RETURNS BOOLEAN
+AS $func$
+    UPDATE SCHEMA_CATALOG.metric m SET duplicate_policy = NULL
+    WHERE m.table_schema = 'SCHEMA_DATA'
+    AND m.metric_name = reset_metric_duplicate_policy.metric_name;
+    SELECT true;
+$func$
+LANGUAGE SQL VOLATILE;
+COMMENT ON FUNCTION SCHEMA_PROM.reset_metric_duplicate_policy(TEXT)
+IS 'resets the duplicate policy for a specific metric to using the default';
+GRANT EXECUTE ON FUNCTION SCHEMA_PROM.reset_metric_duplicate_policy(TEXT) TO prom_admin;
+
 CREATE OR REPLACE FUNCTION SCHEMA_CATALOG.get_metric_compression_setting(metric_name TEXT)
 RETURNS BOOLEAN
 AS $$
@@ -1918,6 +2011,12 @@ BEGIN
         RAISE LOG 'promscale maintenance: data retention: metric %: starting', metric_name;
     END IF;
 
+    -- native histograms are dropped first: the metric table may have no chunks
+    -- to drop at all, when only native histograms are ingested for the metric.
+    IF metric_schema = 'SCHEMA_DATA' THEN
+        PERFORM SCHEMA_CATALOG.drop_histogram_chunk_data(metric_name, older_than);
+    END IF;
+
     -- transaction 1
         IF SCHEMA_CATALOG.is_timescaledb_installed() THEN
             --Get the time dimension id for the time dimension
@@ -2035,6 +2134,120 @@ $$
 LANGUAGE PLPGSQL STABLE;
 GRANT EXECUTE ON FUNCTION SCHEMA_CATALOG.get_storage_hypertable_info(text, text, boolean) TO prom_reader;
 
+--Returns true if the metric may have data between start_time and end_time,
+--according to the time ranges of the chunks storing it. Metrics which are not
+--stored in hypertables are assumed to have data in any range.
+CREATE OR REPLACE FUNCTION SCHEMA_CATALOG.metric_has_data_in_range(metric_schema_name text, metric_table_name text, is_view boolean, start_time TIMESTAMPTZ, end_time TIMESTAMPTZ)
+RETURNS BOOLEAN
+AS $$
+DECLARE
+    _hypertable_id int;
+    _time_dimension_id int;
+    _start_internal bigint;
+    _end_internal bigint;
+BEGIN
+    SELECT hi.id INTO _hypertable_id
+    FROM SCHEMA_CATALOG.get_storage_hypertable_info(metric_schema_name, metric_table_name, is_view) hi;
+    IF _hypertable_id IS NULL THEN
+        RETURN true;
+    END IF;
+
+    SELECT d.id INTO _time_dimension_id
+    FROM _timescaledb_catalog.dimension d
+    WHERE d.hypertable_id = _hypertable_id
+    ORDER BY d.id ASC
+    LIMIT 1;
+
+    IF start_time = timestamptz '-Infinity' THEN
+        _start_internal := -9223372036854775808;
+    ELSE
+        SELECT _timescaledb_internal.time_to_internal(start_time) INTO STRICT _start_internal;
+    END IF;
+    IF end_time = timestamptz 'Infinity' THEN
+        _end_internal := 9223372036854775807;
+    ELSE
+        SELECT _timescaledb_internal.time_to_internal(end_time) INTO STRICT _end_internal;
+    END IF;
+
+    RETURN EXISTS (
+        SELECT 1
+        FROM _timescaledb_catalog.dimension_slice ds
+        WHERE ds.dimension_id = _time_dimension_id
+        -- the range_ends are non-inclusive
+        AND ds.range_start <= _end_internal
+        AND ds.range_end > _start_internal
+        AND EXISTS (SELECT 1 FROM _timescaledb_catalog.chunk_constraint cc WHERE cc.dimension_slice_id = ds.id)
+    );
+END
+$$
+LANGUAGE PLPGSQL STABLE;
+GRANT EXECUTE ON FUNCTION SCHEMA_CATALOG.metric_has_data_in_range(text, text, boolean, TIMESTAMPTZ, TIMESTAMPTZ) TO prom_reader;
+
+--Evaluates the PromQL <kind>_over_time function (kind is one of avg, count, max, min
+--or sum) at every step from lowest_time + range to greatest_time, over the samples of
+--a series ordered by time. The result holds a value per step, NULL if there is no
+--sample in the range of the step. Staleness markers are ignored, like in PromQL.
+CREATE OR REPLACE FUNCTION SCHEMA_CATALOG.prom_over_time(kind TEXT, lowest_time TIMESTAMPTZ, greatest_time TIMESTAMPTZ, step_ms BIGINT, range_ms BIGINT, times TIMESTAMPTZ[], vals DOUBLE PRECISION[])
+RETURNS DOUBLE PRECISION[]
+AS $$
+DECLARE
+    _step INTERVAL := step_ms * interval '1 millisecond';
+    _range INTERVAL := range_ms * interval '1 millisecond';
+    _t TIMESTAMPTZ := lowest_time + _range;
+    _n INT := coalesce(array_length(times, 1), 0);
+    _first INT := 1;
+    _last INT := 0;
+    _count INT;
+    _acc DOUBLE PRECISION;
+    _v DOUBLE PRECISION;
+    _result DOUBLE PRECISION[] := array[]::DOUBLE PRECISION[];
+BEGIN
+    WHILE _t <= greatest_time LOOP
+        --the range of a step includes both of its ends
+        WHILE _last < _n AND times[_last + 1] <= _t LOOP
+            _last := _last + 1;
+        END LOOP;
+        WHILE _first <= _last AND times[_first] < _t - _range LOOP
+            _first := _first + 1;
+        END LOOP;
+
+        _count := 0;
+        _acc := NULL;
+        FOR _i IN _first.._last LOOP
+            _v := vals[_i];
+            CONTINUE WHEN float8send(_v) = '\x7ff0000000000002'::bytea;
+            _count := _count + 1;
+            IF kind IN ('avg', 'sum') THEN
+                _acc := coalesce(_acc, 0) + _v;
+            ELSIF kind = 'min' THEN
+                --NaN is greater than any number, so it is only kept if all the values are NaN
+                IF _acc IS NULL OR _v < _acc THEN
+                    _acc := _v;
+                END IF;
+            ELSIF kind = 'max' THEN
+                IF _acc IS NULL OR _acc = 'NaN' OR (_v > _acc AND _v <> 'NaN') THEN
+                    _acc := _v;
+                END IF;
+            END IF;
+        END LOOP;
+
+        IF _count = 0 THEN
+            _result := _result || NULL::DOUBLE PRECISION;
+        ELSIF kind = 'avg' THEN
+            _result := _result || (_acc / _count);
+        ELSIF kind = 'count' THEN
+            _result := _result || _count::DOUBLE PRECISION;
+        ELSE
+            _result := _result || _acc;
+        END IF;
+        _t := _t + _step;
+    END LOOP;
+    RETURN _result;
+END
+$$
+LANGUAGE PLPGSQL IMMUTABLE PARALLEL SAFE;
+GRANT EXECUTE ON FUNCTION SCHEMA_CATALOG.prom_over_time(TEXT, TIMESTAMPTZ, TIMESTAMPTZ, BIGINT, BIGINT, TIMESTAMPTZ[], DOUBLE PRECISION[]) TO prom_reader;
+
 
 --Get underlying metric view schema and name
 --we need to support up to two levels of views to support 2-step caggs
@@ -2205,6 +2418,432 @@ COMMENT ON PROCEDURE SCHEMA_CATALOG.execute_data_retention_policy(boolean)
 IS 'drops old data according to the data retention policy. This procedure should be run regularly in a cron job';
 GRANT EXECUTE ON PROCEDURE SCHEMA_CATALOG.execute_data_retention_policy(boolean) TO prom_maintenance;
 
+--Returns the name of the schema holding the metrics downsampled at the
+--resolution, e.g. ds_5m or ds_1h.
+CREATE OR REPLACE FUNCTION SCHEMA_CATALOG.get_downsample_schema_name(resolution INTERVAL)
+RETURNS NAME
+AS $func$
+DECLARE
+    _seconds BIGINT;
+BEGIN
+    _seconds := extract(epoch FROM resolution)::BIGINT;
+    IF _seconds % 86400 = 0 THEN
+        RETURN format('ds_%sd', _seconds / 86400);
+    ELSIF _seconds % 3600 = 0 THEN
+        RETURN format('ds_%sh', _seconds / 3600);
+    ELSIF _seconds % 60 = 0 THEN
+        RETURN format('ds_%sm', _seconds / 60);
+    END IF;
+    RETURN format('ds_%ss', _seconds);
+END
+$func$
+LANGUAGE PLPGSQL IMMUTABLE PARALLEL SAFE;
+GRANT EXECUTE ON FUNCTION SCHEMA_CATALOG.get_downsample_schema_name(INTERVAL) TO prom_reader;
+
+--Declares a new downsampling resolution along with its schema, and returns its id.
+CREATE OR REPLACE FUNCTION SCHEMA_CATALOG.create_downsample(new_resolution INTERVAL, new_retention_period INTERVAL, new_apply_to_all_metrics BOOLEAN)
+RETURNS INT
+AS $func$
+DECLARE
+    _id INT;
+    _schema_name NAME;
+BEGIN
+    IF new_resolution IS NULL OR new_resolution <= INTERVAL '0'
+        OR extract(year FROM new_resolution) != 0 OR extract(month FROM new_resolution) != 0
+        OR extract(epoch FROM new_resolution) != trunc(extract(epoch FROM new_resolution)) THEN
+        RAISE EXCEPTION 'invalid downsampling resolution %', new_resolution
+            USING HINT = 'The resolution must be a whole number of seconds, and cannot be expressed in months or years.';
+    END IF;
+    IF new_retention_period IS NULL OR new_retention_period <= new_resolution THEN
+        RAISE EXCEPTION 'invalid retention period % for the downsampling resolution %', new_retention_period, new_resolution
+            USING HINT = 'The retention period must be longer than the resolution.';
+    END IF;
+
+    _schema_name := SCHEMA_CATALOG.get_downsample_schema_name(new_resolution);
+    EXECUTE format('CREATE SCHEMA IF NOT EXISTS %I', _schema_name);
+    EXECUTE format('GRANT USAGE ON SCHEMA %I TO prom_reader', _schema_name);
+
+    INSERT INTO SCHEMA_CATALOG.downsample(schema_name, resolution, retention_period, apply_to_all_metrics)
+    VALUES (_schema_name, new_resolution, new_retention_period, new_apply_to_all_metrics)
+    RETURNING id INTO STRICT _id;
+    RETURN _id;
+END
+$func$
+LANGUAGE PLPGSQL VOLATILE
+SECURITY DEFINER
+--search path must be set for security definer
+SET search_path = pg_temp;
+--redundant given schema settings but extra caution for security definers
+REVOKE ALL ON FUNCTION SCHEMA_CATALOG.create_downsample(INTERVAL, INTERVAL, BOOLEAN) FROM PUBLIC;
+GRANT EXECUTE ON FUNCTION SCHEMA_CATALOG.create_downsample(INTERVAL, INTERVAL, BOOLEAN) TO prom_admin;
+
+CREATE OR REPLACE FUNCTION SCHEMA_PROM.set_downsampling(resolution INTERVAL, retention_period INTERVAL)
+RETURNS BOOLEAN
+AS $func$
+BEGIN
+    IF retention_period IS NULL OR retention_period <= resolution THEN
+        RAISE EXCEPTION 'invalid retention period % for the downsampling resolution %', retention_period, resolution
+            USING HINT = 'The retention period must be longer than the resolution.';
+    END IF;
+
+    UPDATE SCHEMA_CATALOG.downsample d
+    SET retention_period = set_downsampling.retention_period, apply_to_all_metrics = true
+    WHERE d.resolution = set_downsampling.resolution;
+
+    IF NOT FOUND THEN
+        PERFORM SCHEMA_CATALOG.create_downsample(resolution, retention_period, true);
+    END IF;
+    RETURN true;
+END
+$func$
+LANGUAGE PLPGSQL VOLATILE;
+COMMENT ON FUNCTION SCHEMA_PROM.set_downsampling(INTERVAL, INTERVAL)
+IS 'downsample all the metrics at the resolution, keeping the downsampled data for the retention period';
+GRANT EXECUTE ON FUNCTION SCHEMA_PROM.set_downsampling(INTERVAL, INTERVAL) TO prom_admin;
+
+CREATE OR REPLACE FUNCTION SCHEMA_PROM.remove_downsampling(resolution INTERVAL)
+RETURNS BOOLEAN
+AS $func$
+DECLARE
+    _id INT;
+    _schema_name NAME;
+    _table_name NAME;
+BEGIN
+    SELECT d.id, d.schema_name
+    INTO _id, _schema_name
+    FROM SCHEMA_CATALOG.downsample d
+    WHERE d.resolution = remove_downsampling.resolution;
+
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'no downsampling at the resolution %', resolution;
+    END IF;
+
+    FOR _table_name IN
+        SELECT m.table_name
+        FROM SCHEMA_CATALOG.metric m
+        WHERE m.table_schema = _schema_name
+    LOOP
+        EXECUTE format('DROP TABLE IF EXISTS %I.%I', _schema_name, _table_name);
+    END LOOP;
+    DELETE FROM SCHEMA_CATALOG.metric m WHERE m.table_schema = _schema_name;
+    DELETE FROM SCHEMA_CATALOG.downsample d WHERE d.id = _id;
+
+    --the schema is kept if objects were created in it by users
+    BEGIN
+        EXECUTE format('DROP SCHEMA IF EXISTS %I', _schema_name);
+    EXCEPTION WHEN dependent_objects_still_exist THEN
+        RAISE NOTICE 'schema % is not empty, keeping it', _schema_name;
+    END;
+    RETURN true;
+END
+$func$
+LANGUAGE PLPGSQL VOLATILE
+SECURITY DEFINER
+--search path must be set for security definer
+SET search_path = pg_temp;
+--redundant given schema settings but extra caution for security definers
+REVOKE ALL ON FUNCTION SCHEMA_PROM.remove_downsampling(INTERVAL) FROM PUBLIC;
+GRANT EXECUTE ON FUNCTION SCHEMA_PROM.remove_downsampling(INTERVAL) TO prom_admin;
+COMMENT ON FUNCTION SCHEMA_PROM.remove_downsampling(INTERVAL)
+IS 'stop downsampling metrics at the resolution, dropping all the data downsampled at it';
+
+CREATE OR REPLACE FUNCTION SCHEMA_PROM.set_metric_downsampling(metric_name TEXT, resolution INTERVAL, retention_period INTERVAL = NULL)
+RETURNS BOOLEAN
+AS $func$
+DECLARE
+    _metric_id INT;
+    _downsample_id INT;
+BEGIN
+    --use get_or_create_metric_table_name because we want to be able to set /before/ any data is ingested
+    SELECT m.id
+    INTO STRICT _metric_id
+    FROM SCHEMA_CATALOG.get_or_create_metric_table_name(set_metric_downsampling.metric_name) m;
+
+    SELECT d.id
+    INTO _downsample_id
+    FROM SCHEMA_CATALOG.downsample d
+    WHERE d.resolution = set_metric_downsampling.resolution;
+
+    IF NOT FOUND THEN
+        --the resolution is only applied to the metrics it is enabled for
+        _downsample_id := SCHEMA_CATALOG.create_downsample(resolution, retention_period, false);
+    ELSIF retention_period <= resolution THEN
+        RAISE EXCEPTION 'invalid retention period % for the downsampling resolution %', retention_period, resolution
+            USING HINT = 'The retention period must be longer than the resolution.';
+    END IF;
+
+    INSERT INTO SCHEMA_CATALOG.metric_downsample(metric_id, downsample_id, enabled, retention_period)
+    VALUES (_metric_id, _downsample_id, true, set_metric_downsampling.retention_period)
+    ON CONFLICT (metric_id, downsample_id) DO UPDATE
+    SET enabled = true, retention_period = EXCLUDED.retention_period;
+    RETURN true;
+END
+$func$
+LANGUAGE PLPGSQL VOLATILE;
+COMMENT ON FUNCTION SCHEMA_PROM.set_metric_downsampling(TEXT, INTERVAL, INTERVAL)
+IS 'downsample a specific metric at the resolution, optionally overriding the retention period of the resolution';
+GRANT EXECUTE ON FUNCTION SCHEMA_PROM.set_metric_downsampling(TEXT, INTERVAL, INTERVAL) TO prom_admin;
+
+CREATE OR REPLACE FUNCTION SCHEMA_PROM.disable_metric_downsampling(metric_name TEXT, resolution INTERVAL)
+RETURNS BOOLEAN
+AS $func$
+DECLARE
+    _metric_id INT;
+    _downsample_id INT;
+BEGIN
+    SELECT d.id
+    INTO _downsample_id
+    FROM SCHEMA_CATALOG.downsample d
+    WHERE d.resolution = disable_metric_downsampling.resolution;
+
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'no downsampling at the resolution %', resolution;
+    END IF;
+
+    SELECT m.id
+    INTO STRICT _metric_id
+    FROM SCHEMA_CATALOG.get_or_create_metric_table_name(disable_metric_downsampling.metric_name) m;
+
+    INSERT INTO SCHEMA_CATALOG.metric_downsample(metric_id, downsample_id, enabled)
+    VALUES (_metric_id, _downsample_id, false)
+    ON CONFLICT (metric_id, downsample_id) DO UPDATE
+    SET enabled = false, retention_period = NULL;
+    RETURN true;
+END
+$func$
+LANGUAGE PLPGSQL VOLATILE;
+COMMENT ON FUNCTION SCHEMA_PROM.disable_metric_downsampling(TEXT, INTERVAL)
+IS 'stop downsampling a specific metric at the resolution, the data already downsampled is kept until its retention period';
+GRANT EXECUTE ON FUNCTION SCHEMA_PROM.disable_metric_downsampling(TEXT, INTERVAL) TO prom_admin;
+
+CREATE OR REPLACE FUNCTION SCHEMA_PROM.reset_metric_downsampling(metric_name TEXT, resolution INTERVAL)
+RETURNS BOOLEAN
+AS $func$
+    DELETE FROM SCHEMA_CATALOG.metric_downsample md
+    USING SCHEMA_CATALOG.metric m, SCHEMA_CATALOG.downsample d
+    WHERE md.metric_id = m.id AND md.downsample_id = d.id
+    AND m.table_schema = 'SCHEMA_DATA' AND m.metric_name = $1 AND d.resolution = $2;
+    SELECT true;
+$func$
+LANGUAGE SQL VOLATILE;
+COMMENT ON FUNCTION SCHEMA_PROM.reset_metric_downsampling(TEXT, INTERVAL)
+IS 'resets the downsampling of a specific metric at the resolution to the default of the resolution';
+GRANT EXECUTE ON FUNCTION SCHEMA_PROM.reset_metric_downsampling(TEXT, INTERVAL) TO prom_admin;
+
+--Returns the raw metrics to downsample at each resolution, along with the
+--retention period of the downsampled data.
+CREATE OR REPLACE FUNCTION SCHEMA_CATALOG.get_metrics_that_need_downsampling()
+RETURNS TABLE (metric_id INT, metric_name TEXT, downsample_id INT, schema_name NAME, retention_period INTERVAL)
+AS $$
+    SELECT m.id, m.metric_name, d.id, d.schema_name, COALESCE(md.retention_period, d.retention_period)
+    FROM SCHEMA_CATALOG.metric m
+    CROSS JOIN SCHEMA_CATALOG.downsample d
+    LEFT JOIN SCHEMA_CATALOG.metric_downsample md
+        ON (md.metric_id = m.id AND md.downsample_id = d.id)
+    WHERE m.table_schema = 'SCHEMA_DATA'
+    AND NOT m.is_view
+    AND COALESCE(md.enabled, d.apply_to_all_metrics)
+    --random order also to prevent starvation
+    ORDER BY random();
+$$
+LANGUAGE SQL STABLE;
+GRANT EXECUTE ON FUNCTION SCHEMA_CATALOG.get_metrics_that_need_downsampling() TO prom_reader;
+
+--Creates the table of a metric downsampled at a resolution if it does not
+--exist yet, and returns the id of the downsampled metric. The table is
+--registered as a metric with the same name in the schema of the resolution,
+--sharing the series of the raw metric, so that it can be queried like views.
+CREATE OR REPLACE FUNCTION SCHEMA_CATALOG.get_or_create_downsampled_metric_table(raw_metric_id INT, downsample_id INT)
+RETURNS INT
+AS $func$
+DECLARE
+    _metric_name TEXT;
+    _table_name NAME;
+    _schema_name NAME;
+    _resolution INTERVAL;
+    _id INT;
+BEGIN
+    SELECT m.metric_name, m.table_name
+    INTO STRICT _metric_name, _table_name
+    FROM SCHEMA_CATALOG.metric m
+    WHERE m.id = raw_metric_id;
+
+    SELECT d.schema_name, d.resolution
+    INTO STRICT _schema_name, _resolution
+    FROM SCHEMA_CATALOG.downsample d
+    WHERE d.id = get_or_create_downsampled_metric_table.downsample_id;
+
+    SELECT m.id
+    INTO _id
+    FROM SCHEMA_CATALOG.metric m
+    WHERE m.table_schema = _schema_name AND m.metric_name = _metric_name;
+
+    IF FOUND THEN
+        RETURN _id;
+    END IF;
+
+    INSERT INTO SCHEMA_CATALOG.metric (metric_name, table_name, table_schema, series_table, is_view, creation_completed)
+    VALUES (_metric_name, _table_name, _schema_name, _table_name, false, true)
+    ON CONFLICT DO NOTHING
+    RETURNING id INTO _id;
+
+    IF NOT FOUND THEN
+        -- the table was created concurrently
+        SELECT m.id
+        INTO STRICT _id
+        FROM SCHEMA_CATALOG.metric m
+        WHERE m.table_schema = _schema_name AND m.metric_name = _metric_name;
+        RETURN _id;
+    END IF;
+
+    EXECUTE format('CREATE TABLE %I.%I(time TIMESTAMPTZ NOT NULL, series_id BIGINT NOT NULL, min DOUBLE PRECISION, max DOUBLE PRECISION, sum DOUBLE PRECISION, count DOUBLE PRECISION, last DOUBLE PRECISION)',
+                    _schema_name, _table_name);
+    EXECUTE format('GRANT SELECT ON TABLE %I.%I TO prom_reader', _schema_name, _table_name);
+    EXECUTE format('CREATE UNIQUE INDEX downsample_series_id_time_%s ON %I.%I (series_id, time)',
+                    _id, _schema_name, _table_name);
+
+    IF SCHEMA_CATALOG.is_timescaledb_installed() THEN
+        --chunks hold up to a thousand points per series
+        PERFORM SCHEMA_TIMESCALE.create_hypertable(format('%I.%I', _schema_name, _table_name), 'time',
+            chunk_time_interval=>_resolution * 1000,
+            create_default_indexes=>false);
+    END IF;
+    RETURN _id;
+END
+$func$
+LANGUAGE PLPGSQL VOLATILE
+SECURITY DEFINER
+--search path must be set for security definer
+SET search_path = pg_temp;
+--redundant given schema settings but extra caution for security definers
+REVOKE ALL ON FUNCTION SCHEMA_CATALOG.get_or_create_downsampled_metric_table(INT, INT) FROM PUBLIC;
+GRANT EXECUTE ON FUNCTION SCHEMA_CATALOG.get_or_create_downsampled_metric_table(INT, INT) TO prom_maintenance;
+
+--Aggregates the samples of a raw metric into the buckets of its downsampled
+--table, up to the last complete bucket. The last bucket already stored is
+--computed again, since samples may have been ingested for it afterwards.
+--Buckets are stamped with their end time, and stale markers are ignored.
+--At most a day of samples, or a bucket if longer, is aggregated per call, so
+--that the first run over the whole retention period is split into batches.
+--Returns the start of the next batch, to pass as from_time, or NULL once all
+--the complete buckets are aggregated.
+CREATE OR REPLACE FUNCTION SCHEMA_CATALOG.downsample_metric_data(raw_metric_id INT, downsample_id INT, retention_period INTERVAL, from_time TIMESTAMPTZ = NULL)
+RETURNS TIMESTAMPTZ
+AS $func$
+DECLARE
+    _metric_name TEXT;
+    _table_name NAME;
+    _schema_name NAME;
+    _resolution INTERVAL;
+    _bucket_seconds DOUBLE PRECISION;
+    _last TIMESTAMPTZ;
+    _start TIMESTAMPTZ;
+    _end TIMESTAMPTZ;
+    _batch_end TIMESTAMPTZ;
+BEGIN
+    SELECT m.metric_name, m.table_name
+    INTO STRICT _metric_name, _table_name
+    FROM SCHEMA_CATALOG.metric m
+    WHERE m.id = raw_metric_id;
+
+    SELECT d.schema_name, d.resolution
+    INTO STRICT _schema_name, _resolution
+    FROM SCHEMA_CATALOG.downsample d
+    WHERE d.id = downsample_metric_data.downsample_id;
+    _bucket_seconds := extract(epoch FROM _resolution);
+
+    --retention is enforced by the data retention policy, like for raw metrics
+    UPDATE SCHEMA_CATALOG.metric m
+    SET retention_period = downsample_metric_data.retention_period
+    WHERE m.table_schema = _schema_name AND m.metric_name = _metric_name
+    AND m.retention_period IS DISTINCT FROM downsample_metric_data.retention_period;
+
+    IF from_time IS NULL THEN
+        EXECUTE format('SELECT max(time) FROM %I.%I', _schema_name, _table_name)
+        INTO _last;
+        _start := COALESCE(_last - _resolution, now() - downsample_metric_data.retention_period);
+    ELSE
+        _start := from_time;
+    END IF;
+    _start := to_timestamp(floor(extract(epoch FROM _start) / _bucket_seconds) * _bucket_seconds);
+    _end := to_timestamp(floor(extract(epoch FROM now()) / _bucket_seconds) * _bucket_seconds);
+    IF _start >= _end THEN
+        RETURN NULL;
+    END IF;
+    _batch_end := least(_end, _start + make_interval(secs => greatest(floor(86400 / _bucket_seconds), 1) * _bucket_seconds));
+
+    EXECUTE format($query$
+        INSERT INTO %1$I.%2$I(time, series_id, min, max, sum, count, last)
+        SELECT
+            to_timestamp((floor(extract(epoch FROM time) / %3$L) + 1) * %3$L),
+            series_id,
+            min(value),
+            max(value),
+            sum(value),
+            count(value),
+            (array_agg(value ORDER BY time DESC))[1]
+        FROM SCHEMA_DATA.%2$I
+        WHERE time >= %4$L AND time < %5$L
+        AND NOT SCHEMA_PROM.is_stale_marker(value)
+        GROUP BY 1, 2
+        ON CONFLICT (series_id, time) DO UPDATE
+        SET min = EXCLUDED.min, max = EXCLUDED.max, sum = EXCLUDED.sum, count = EXCLUDED.count, last = EXCLUDED.last
+    $query$, _schema_name, _table_name, _bucket_seconds, _start, _batch_end);
+    IF _batch_end >= _end THEN
+        RETURN NULL;
+    END IF;
+    RETURN _batch_end;
+END
+$func$
+LANGUAGE PLPGSQL VOLATILE
+SECURITY DEFINER
+--search path must be set for security definer
+SET search_path = pg_temp;
+--redundant given schema settings but extra caution for security definers
+REVOKE ALL ON FUNCTION SCHEMA_CATALOG.downsample_metric_data(INT, INT, INTERVAL, TIMESTAMPTZ) FROM PUBLIC;
+GRANT EXECUTE ON FUNCTION SCHEMA_CATALOG.downsample_metric_data(INT, INT, INTERVAL, TIMESTAMPTZ) TO prom_maintenance;
+
+CREATE OR REPLACE PROCEDURE SCHEMA_CATALOG.execute_downsampling_policy(log_verbose boolean)
+AS $$
+DECLARE
+    r RECORD;
+    downsampled_metric_id INT;
+    batch_start TIMESTAMPTZ;
+    startT TIMESTAMPTZ;
+BEGIN
+    FOR r IN
+        SELECT *
+        FROM SCHEMA_CATALOG.get_metrics_that_need_downsampling()
+    LOOP
+        downsampled_metric_id := SCHEMA_CATALOG.get_or_create_downsampled_metric_table(r.metric_id, r.downsample_id);
+        COMMIT;
+
+        --a downsampled metric locked by another job is refreshed by the next run
+        IF NOT SCHEMA_CATALOG.lock_metric_for_maintenance(downsampled_metric_id, wait=>false) THEN
+            CONTINUE;
+        END IF;
+        startT := clock_timestamp();
+        PERFORM SCHEMA_CATALOG.set_app_name( format('promscale maintenance: downsampling: metric %s: %s', r.metric_name, r.schema_name));
+        --each batch is committed, the maintenance lock is held by the session
+        batch_start := NULL;
+        LOOP
+            batch_start := SCHEMA_CATALOG.downsample_metric_data(r.metric_id, r.downsample_id, r.retention_period, batch_start);
+            EXIT WHEN batch_start IS NULL;
+            COMMIT;
+        END LOOP;
+        PERFORM SCHEMA_CATALOG.unlock_metric_for_maintenance(downsampled_metric_id);
+        IF log_verbose THEN
+            RAISE LOG 'promscale maintenance: downsampling: metric %: %: finished in %', r.metric_name, r.schema_name, clock_timestamp()-startT;
+        END IF;
+
+        COMMIT;
+    END LOOP;
+END;
+$$ LANGUAGE PLPGSQL;
+COMMENT ON PROCEDURE SCHEMA_CATALOG.execute_downsampling_policy(boolean)
+IS 'downsamples the metrics according to the downsampling settings. This procedure should be run regularly in a cron job';
+GRANT EXECUTE ON PROCEDURE SCHEMA_CATALOG.execute_downsampling_policy(boolean) TO prom_maintenance;
+
 --public procedure to be called by cron
 --right now just does data retention but name is generic so that
 --we can add stuff later without needing people to change their cron scripts
@@ -2216,13 +2855,36 @@ DECLARE
    startT TIMESTAMPTZ;
 BEGIN
     startT := clock_timestamp();
+
+    --downsampling runs first, so that the raw samples are aggregated before
+    --they are dropped
+    IF EXISTS (SELECT FROM SCHEMA_CATALOG.downsample) THEN
+        IF log_verbose THEN
+            RAISE LOG 'promscale maintenance: downsampling: starting';
+        END IF;
+
+        PERFORM SCHEMA_CATALOG.set_app_name( format('promscale maintenance: downsampling'));
+        CALL SCHEMA_CATALOG.execute_downsampling_policy(log_verbose=>log_verbose);
+    END IF;
+
     IF log_verbose THEN
-        RAISE LOG 'promscale maintenance: data retention: starting';
+        RAISE LOG 'promscale maintenance: tracing dependencies: starting';
     END IF;
+    PERFORM SCHEMA_CATALOG.set_app_name( format('promscale maintenance: tracing dependencies'));
+    CALL SCHEMA_TRACING.refresh_service_dependencies(log_verbose=>log_verbose);
 
+    IF log_verbose THEN
+        RAISE LOG 'promscale maintenance: data retention: starting';
+    END IF;
     PERFORM SCHEMA_CATALOG.set_app_name( format('promscale maintenance: data retention'));
     CALL SCHEMA_CATALOG.execute_data_retention_policy(log_verbose=>log_verbose);
 
+    IF log_verbose THEN
+        RAISE LOG 'promscale maintenance: trace retention: starting';
+    END IF;
+    PERFORM SCHEMA_CATALOG.set_app_name( format('promscale maintenance: trace retention'));
+    CALL SCHEMA_TRACING.execute_trace_retention_policy(log_verbose=>log_verbose);
+
     IF NOT SCHEMA_CATALOG.is_timescaledb_oss() AND SCHEMA_CATALOG.get_timescale_major_version() >= 2 THEN
         IF log_verbose THEN
             RAISE LOG 'promscale maintenance: compression: starting';
@@ -2606,6 +3268,78 @@ SET search_path = pg_temp;
 REVOKE ALL ON FUNCTION SCHEMA_PROM.unregister_metric_view(name, name, boolean) FROM PUBLIC;
 GRANT EXECUTE ON FUNCTION SCHEMA_PROM.unregister_metric_view(name, name, boolean) TO prom_admin;
 
+--The aggregate is the one of the raw samples held by the column, which only
+--serves the PromQL functions giving the same results on the aggregated values:
+--min_over_time for min, max_over_time for max, sum_over_time for sum, and
+--instant selectors, last_over_time, rate, increase and delta for last.
+CREATE OR REPLACE FUNCTION SCHEMA_PROM.set_metric_view_resolution(schema_name name, view_name name, resolution INTERVAL, aggregate TEXT, value_column name = NULL)
+RETURNS BOOLEAN
+AS $func$
+DECLARE
+    _metric_id int;
+    _value_column name := COALESCE(value_column, aggregate::name);
+BEGIN
+    SELECT m.id INTO _metric_id
+    FROM SCHEMA_CATALOG.metric m
+    WHERE m.table_schema = set_metric_view_resolution.schema_name
+    AND m.table_name = set_metric_view_resolution.view_name
+    AND m.is_view;
+
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'metric view %.% is not registered', schema_name, view_name;
+    END IF;
+
+    IF resolution IS NULL OR resolution <= interval '0' THEN
+        RAISE EXCEPTION 'invalid resolution %, expected a positive interval', resolution;
+    END IF;
+
+    IF aggregate IS NULL OR aggregate NOT IN ('min', 'max', 'sum', 'last') THEN
+        RAISE EXCEPTION 'invalid aggregate %, expected min, max, sum or last', aggregate;
+    END IF;
+
+    PERFORM * FROM information_schema.columns c
+    WHERE c.table_schema = set_metric_view_resolution.schema_name
+    AND c.table_name = set_metric_view_resolution.view_name
+    AND c.column_name = _value_column
+    AND c.data_type = 'double precision';
+
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'metric view %.% has no column % with double precision data type', schema_name, view_name, _value_column;
+    END IF;
+
+    INSERT INTO SCHEMA_CATALOG.metric_view_resolution(metric_id, resolution, value_column, aggregate)
+    VALUES (_metric_id, set_metric_view_resolution.resolution, _value_column, set_metric_view_resolution.aggregate)
+    ON CONFLICT (metric_id, aggregate) DO UPDATE
+    SET value_column = EXCLUDED.value_column;
+
+    --all the columns of the view share its resolution
+    UPDATE SCHEMA_CATALOG.metric_view_resolution r
+    SET resolution = set_metric_view_resolution.resolution
+    WHERE r.metric_id = _metric_id;
+
+    RETURN true;
+END
+$func$
+LANGUAGE PLPGSQL VOLATILE;
+COMMENT ON FUNCTION SCHEMA_PROM.set_metric_view_resolution(name, name, INTERVAL, TEXT, name)
+IS 'set the interval between the points of a registered metric view, so that the queries on its raw metric with a step at least as coarse, using a function matching the aggregate (min, max, sum or last), are served by the column value_column of the view, named after the aggregate by default';
+GRANT EXECUTE ON FUNCTION SCHEMA_PROM.set_metric_view_resolution(name, name, INTERVAL, TEXT, name) TO prom_admin;
+
+CREATE OR REPLACE FUNCTION SCHEMA_PROM.reset_metric_view_resolution(schema_name name, view_name name)
+RETURNS BOOLEAN
+AS $func$
+    DELETE FROM SCHEMA_CATALOG.metric_view_resolution r
+    USING SCHEMA_CATALOG.metric m
+    WHERE r.metric_id = m.id
+    AND m.table_schema = reset_metric_view_resolution.schema_name
+    AND m.table_name = reset_metric_view_resolution.view_name;
+    SELECT true;
+$func$
+LANGUAGE SQL VOLATILE;
+COMMENT ON FUNCTION SCHEMA_PROM.reset_metric_view_resolution(name, name)
+IS 'stop serving the queries on the raw metric of a metric view from the view';
+GRANT EXECUTE ON FUNCTION SCHEMA_PROM.reset_metric_view_resolution(name, name) TO prom_admin;
+
 CREATE OR REPLACE FUNCTION SCHEMA_CATALOG.delete_series_from_metric(name text, series_ids bigint[])
 RETURNS BIGINT
 AS
@@ -2638,6 +3372,15 @@ BEGIN
         GET DIAGNOSTICS rows_affected = ROW_COUNT;
         num_rows_deleted = num_rows_deleted + rows_affected;
     END IF;
+    -- the downsampled data of the series is deleted as well, but not counted
+    FOR delete_stmt IN
+        SELECT FORMAT('DELETE FROM %1$I.%2$I WHERE series_id = ANY($1)', m.table_schema, m.table_name)
+        FROM SCHEMA_CATALOG.metric m
+        INNER JOIN SCHEMA_CATALOG.downsample d ON (d.schema_name = m.table_schema)
+        WHERE m.metric_name=name
+    LOOP
+        EXECUTE delete_stmt USING series_ids;
+    END LOOP;
     PERFORM SCHEMA_CATALOG.delete_series_catalog_row(metric_table, series_ids);
     RETURN num_rows_deleted;
 END;
@@ -3081,9 +3824,10 @@ AS $$
 DECLARE
     bgw_job_id int;
 BEGIN
+    --the downsampled metrics share the table name of their raw metric
     UPDATE SCHEMA_CATALOG.metric m
     SET delay_compression_until = new_start
-    WHERE table_name = ht_table;
+    WHERE table_schema = 'SCHEMA_DATA' AND table_name = ht_table;
 
     IF SCHEMA_CATALOG.get_timescale_major_version() < 2 THEN
         SELECT job_id INTO bgw_job_id
@@ -3318,10 +4062,10 @@ BEGIN
         SELECT m.*
         FROM SCHEMA_CATALOG.metric m
         WHERE
+          m.table_schema = 'SCHEMA_DATA' AND
           is_view = false AND
           SCHEMA_CATALOG.get_metric_compression_setting(m.metric_name) AND
-          delay_compression_until IS NULL OR delay_compression_until < now() AND
-          is_view = FALSE
+          (delay_compression_until IS NULL OR delay_compression_until < now())
         ORDER BY random();
 END
 $$
@@ -3413,24 +4157,84 @@ BEGIN
 END
 $func$ LANGUAGE PLPGSQL;
 
-CREATE OR REPLACE FUNCTION SCHEMA_CATALOG.insert_metric_row(
+--insert_metric_row_with_policy inserts the samples, applying the duplicate policy of the metric:
+--  * keep_first: samples with a timestamp already stored for their series are ignored.
+--  * keep_last: samples with a timestamp already stored for their series overwrite the value.
+--  * reject: samples which are not newer than the latest sample of their series, stored or earlier
+--    in the same batch, are rejected.
+--  * allow: same as keep_first, but the ignored samples are not reported as duplicates.
+--It returns the number of samples inserted (or updated) and rejected. The others are duplicates.
+CREATE OR REPLACE FUNCTION SCHEMA_CATALOG.insert_metric_row_with_policy(
     metric_table name,
     time_array timestamptz[],
     value_array DOUBLE PRECISION[],
-    series_id_array bigint[]
-) RETURNS BIGINT AS
+    series_id_array bigint[],
+    OUT inserted BIGINT,
+    OUT rejected BIGINT
+) AS
 $$
 DECLARE
-  num_rows BIGINT;
+    _policy TEXT;
 BEGIN
-    EXECUTE FORMAT(
-     'INSERT INTO  SCHEMA_DATA.%1$I (time, value, series_id)
-          SELECT * FROM unnest($1, $2, $3) a(t,v,s) ORDER BY s,t ON CONFLICT DO NOTHING',
-        metric_table
-    ) USING time_array, value_array, series_id_array;
-    GET DIAGNOSTICS num_rows = ROW_COUNT;
-    RETURN num_rows;
+    SELECT m.duplicate_policy
+    INTO _policy
+    FROM SCHEMA_CATALOG.metric m
+    WHERE m.table_schema = 'SCHEMA_DATA'
+    AND m.table_name = metric_table;
+
+    _policy := COALESCE(_policy, SCHEMA_CATALOG.get_default_duplicate_policy(), 'keep_first');
+    rejected := 0;
+    CASE _policy
+    WHEN 'keep_last' THEN
+        --a row cannot be updated twice by the same statement, so only the last
+        --sample of each timestamp is kept
+        EXECUTE FORMAT(
+         'INSERT INTO  SCHEMA_DATA.%1$I (time, value, series_id)
+              SELECT DISTINCT ON (s, t) t, v, s FROM unnest($1, $2, $3) WITH ORDINALITY a(t,v,s,i) ORDER BY s,t,i DESC
+              ON CONFLICT (series_id, time) DO UPDATE SET value = EXCLUDED.value',
+            metric_table
+        ) USING time_array, value_array, series_id_array;
+        GET DIAGNOSTICS inserted = ROW_COUNT;
+    WHEN 'reject' THEN
+        --each sample must be newer than both the latest sample stored for its
+        --series and the samples before it in the batch
+        EXECUTE FORMAT(
+         'INSERT INTO  SCHEMA_DATA.%1$I (time, value, series_id)
+              SELECT t, v, s FROM (
+                  SELECT t, v, s, max(t) OVER (PARTITION BY s ORDER BY i ROWS BETWEEN UNBOUNDED PRECEDING AND 1 PRECEDING) AS batch_max
+                  FROM unnest($1, $2, $3) WITH ORDINALITY a(t,v,s,i)
+              ) a
+              WHERE a.t > COALESCE(a.batch_max, ''-infinity'')
+              AND a.t > COALESCE((SELECT max(d.time) FROM SCHEMA_DATA.%1$I d WHERE d.series_id = a.s), ''-infinity'')
+              ORDER BY s,t ON CONFLICT DO NOTHING',
+            metric_table
+        ) USING time_array, value_array, series_id_array;
+        GET DIAGNOSTICS inserted = ROW_COUNT;
+        rejected := cardinality(time_array) - inserted;
+    ELSE
+        EXECUTE FORMAT(
+         'INSERT INTO  SCHEMA_DATA.%1$I (time, value, series_id)
+              SELECT * FROM unnest($1, $2, $3) a(t,v,s) ORDER BY s,t ON CONFLICT DO NOTHING',
+            metric_table
+        ) USING time_array, value_array, series_id_array;
+        GET DIAGNOSTICS inserted = ROW_COUNT;
+        IF _policy = 'allow' THEN
+            inserted := cardinality(time_array);
+        END IF;
+    END CASE;
 END;
 $$
 LANGUAGE PLPGSQL;
+GRANT EXECUTE ON FUNCTION SCHEMA_CATALOG.insert_metric_row_with_policy(NAME, TIMESTAMPTZ[], DOUBLE PRECISION[], BIGINT[]) TO prom_writer;
+
+CREATE OR REPLACE FUNCTION SCHEMA_CATALOG.insert_metric_row(
+    metric_table name,
+    time_array timestamptz[],
+    value_array DOUBLE PRECISION[],
+    series_id_array bigint[]
+) RETURNS BIGINT AS
+$$
+    SELECT inserted FROM SCHEMA_CATALOG.insert_metric_row_with_policy(metric_table, time_array, value_array, series_id_array);
+$$
+LANGUAGE SQL;
 GRANT EXECUTE ON FUNCTION SCHEMA_CATALOG.insert_metric_row(NAME, TIMESTAMPTZ[], DOUBLE PRECISION[], BIGINT[]) TO prom_writer;
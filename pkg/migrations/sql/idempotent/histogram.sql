@@ -0,0 +1,148 @@
+-- creates the native histogram table of a metric in prom_data_histogram schema if the table does not
+-- exists. Like create_exemplar_table_if_not_exists(), this function must be called after the metric
+-- is created in _prom_catalog.metric as it utilizes the table_name from the metric table. It returns
+-- true if the table was created.
+CREATE OR REPLACE FUNCTION SCHEMA_CATALOG.create_histogram_table_if_not_exists(metric_name TEXT)
+RETURNS BOOLEAN
+AS
+$$
+DECLARE
+    table_name_fetched TEXT;
+    metric_name_fetched TEXT;
+BEGIN
+    SELECT m.metric_name, m.table_name
+    INTO metric_name_fetched, table_name_fetched
+    FROM SCHEMA_CATALOG.metric m
+    WHERE m.metric_name=create_histogram_table_if_not_exists.metric_name AND table_schema = 'SCHEMA_DATA';
+
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'SCHEMA_CATALOG.metric does not contain the table entry for % metric', metric_name;
+    END IF;
+    -- check if table is already created.
+    IF (
+        SELECT count(h.table_name) > 0 FROM SCHEMA_CATALOG.histogram h WHERE h.metric_name=create_histogram_table_if_not_exists.metric_name
+    ) THEN
+        RETURN FALSE;
+    END IF;
+    -- bucket counts are stored dense, starting at the bucket index held in the offset column.
+    EXECUTE FORMAT('CREATE TABLE SCHEMA_DATA_HISTOGRAM.%I (
+            time TIMESTAMPTZ NOT NULL,
+            series_id BIGINT NOT NULL,
+            count DOUBLE PRECISION NOT NULL,
+            sum DOUBLE PRECISION NOT NULL,
+            schema INTEGER NOT NULL,
+            zero_threshold DOUBLE PRECISION NOT NULL,
+            zero_count DOUBLE PRECISION NOT NULL,
+            positive_offset INTEGER NOT NULL,
+            positive_counts DOUBLE PRECISION[] NOT NULL,
+            negative_offset INTEGER NOT NULL,
+            negative_counts DOUBLE PRECISION[] NOT NULL
+        ) WITH (autovacuum_vacuum_threshold = 50000, autovacuum_analyze_threshold = 50000)',
+        table_name_fetched);
+    EXECUTE format('GRANT SELECT ON TABLE SCHEMA_DATA_HISTOGRAM.%I TO prom_reader', table_name_fetched);
+    EXECUTE format('GRANT SELECT, INSERT ON TABLE SCHEMA_DATA_HISTOGRAM.%I TO prom_writer', table_name_fetched);
+    EXECUTE format('GRANT SELECT, INSERT, UPDATE, DELETE ON TABLE SCHEMA_DATA_HISTOGRAM.%I TO prom_modifier', table_name_fetched);
+    EXECUTE format('CREATE UNIQUE INDEX hi_%s ON SCHEMA_DATA_HISTOGRAM.%I (series_id, time)',
+                   table_name_fetched, table_name_fetched);
+
+    IF SCHEMA_CATALOG.is_timescaledb_installed() THEN
+        IF SCHEMA_CATALOG.is_multinode() THEN
+            PERFORM SCHEMA_TIMESCALE.create_distributed_hypertable(
+                format('SCHEMA_DATA_HISTOGRAM.%I', table_name_fetched),
+                'time',
+                chunk_time_interval=>SCHEMA_CATALOG.get_staggered_chunk_interval(SCHEMA_CATALOG.get_default_chunk_interval()),
+                create_default_indexes=>false
+            );
+        ELSE
+            PERFORM SCHEMA_TIMESCALE.create_hypertable(format('SCHEMA_DATA_HISTOGRAM.%I', table_name_fetched), 'time',
+                chunk_time_interval=>SCHEMA_CATALOG.get_staggered_chunk_interval(SCHEMA_CATALOG.get_default_chunk_interval()),
+                create_default_indexes=>false);
+        END IF;
+    END IF;
+
+    INSERT INTO SCHEMA_CATALOG.histogram (metric_name, table_name)
+        VALUES (metric_name_fetched, table_name_fetched);
+    RETURN TRUE;
+END;
+$$
+LANGUAGE PLPGSQL;
+GRANT EXECUTE ON FUNCTION SCHEMA_CATALOG.create_histogram_table_if_not_exists(TEXT) TO prom_writer;
+
+-- inserts native histograms into the histogram table of a metric. Bucket counts are passed as
+-- array literals since every histogram can have a different number of buckets.
+CREATE OR REPLACE FUNCTION SCHEMA_CATALOG.insert_histogram_row(
+    metric_table NAME,
+    time_array TIMESTAMPTZ[],
+    series_id_array BIGINT[],
+    count_array DOUBLE PRECISION[],
+    sum_array DOUBLE PRECISION[],
+    schema_array INTEGER[],
+    zero_threshold_array DOUBLE PRECISION[],
+    zero_count_array DOUBLE PRECISION[],
+    positive_offset_array INTEGER[],
+    positive_counts_array TEXT[],
+    negative_offset_array INTEGER[],
+    negative_counts_array TEXT[]
+) RETURNS BIGINT AS
+$$
+DECLARE
+    num_rows BIGINT;
+BEGIN
+    EXECUTE FORMAT(
+        'INSERT INTO SCHEMA_DATA_HISTOGRAM.%1$I (time, series_id, count, sum, schema, zero_threshold, zero_count,
+                positive_offset, positive_counts, negative_offset, negative_counts)
+             SELECT t, s, c, su, sc, zt, zc, po, pc::DOUBLE PRECISION[], no, nc::DOUBLE PRECISION[]
+             FROM unnest($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) a(t,s,c,su,sc,zt,zc,po,pc,no,nc)
+             ORDER BY s,t ON CONFLICT DO NOTHING',
+        metric_table
+    ) USING time_array, series_id_array, count_array, sum_array, schema_array, zero_threshold_array,
+        zero_count_array, positive_offset_array, positive_counts_array, negative_offset_array, negative_counts_array;
+    GET DIAGNOSTICS num_rows = ROW_COUNT;
+    RETURN num_rows;
+END;
+$$
+LANGUAGE PLPGSQL;
+GRANT EXECUTE ON FUNCTION SCHEMA_CATALOG.insert_histogram_row(NAME, TIMESTAMPTZ[], BIGINT[], DOUBLE PRECISION[], DOUBLE PRECISION[], INTEGER[], DOUBLE PRECISION[], DOUBLE PRECISION[], INTEGER[], TEXT[], INTEGER[], TEXT[]) TO prom_writer;
+
+--drop native histograms older than older_than of a metric. The retention period of the metric applies.
+CREATE OR REPLACE FUNCTION SCHEMA_CATALOG.drop_histogram_chunk_data(
+    metric_name TEXT, older_than TIMESTAMPTZ
+) RETURNS VOID AS $func$
+DECLARE
+    histogram_table NAME;
+BEGIN
+    SELECT h.table_name
+    INTO histogram_table
+    FROM SCHEMA_CATALOG.histogram h
+    WHERE h.metric_name = drop_histogram_chunk_data.metric_name;
+
+    IF NOT FOUND THEN
+        RETURN;
+    END IF;
+
+    IF SCHEMA_CATALOG.is_timescaledb_installed() THEN
+        IF SCHEMA_CATALOG.get_timescale_major_version() >= 2 THEN
+            PERFORM SCHEMA_TIMESCALE.drop_chunks(
+                relation=>format('%I.%I', 'SCHEMA_DATA_HISTOGRAM', histogram_table),
+                older_than=>older_than
+            );
+        ELSE
+            PERFORM SCHEMA_TIMESCALE.drop_chunks(
+                table_name=>histogram_table,
+                schema_name=>'SCHEMA_DATA_HISTOGRAM',
+                older_than=>older_than,
+                cascade_to_materializations=>FALSE
+            );
+        END IF;
+    ELSE
+        EXECUTE format($$ DELETE FROM SCHEMA_DATA_HISTOGRAM.%I WHERE time < %L $$, histogram_table, older_than);
+    END IF;
+END
+$func$
+LANGUAGE PLPGSQL VOLATILE
+SECURITY DEFINER
+--search path must be set for security definer
+SET search_path = pg_temp;
+--redundant given schema settings but extra caution for security definers
+REVOKE ALL ON FUNCTION SCHEMA_CATALOG.drop_histogram_chunk_data(text, timestamptz) FROM PUBLIC;
+GRANT EXECUTE ON FUNCTION SCHEMA_CATALOG.drop_histogram_chunk_data(text, timestamptz) TO prom_maintenance;
@@ -217,3 +217,305 @@ $func$
 LANGUAGE SQL STABLE PARALLEL SAFE;
 GRANT EXECUTE ON FUNCTION SCHEMA_TRACING.match_greater_than_or_equal(SCHEMA_TRACING_PUBLIC.tag_map, SCHEMA_TAG.tag_op_greater_than_or_equal) TO prom_reader;
 COMMENT ON FUNCTION SCHEMA_TRACING.match_greater_than_or_equal IS $$This function supports the #>= operator.$$;
+
+CREATE OR REPLACE FUNCTION SCHEMA_TRACING.service_dependency_edges(_start timestamptz, _end timestamptz)
+RETURNS TABLE (start_time timestamptz, parent_service text, child_service text)
+AS $func$
+    SELECT c.start_time, pt.value#>>'{}', ct.value#>>'{}'
+    FROM SCHEMA_TRACING.span c
+    INNER JOIN SCHEMA_TRACING.span p ON (p.trace_id = c.trace_id AND p.span_id = c.parent_span_id)
+    INNER JOIN SCHEMA_TRACING.operation co ON (co.id = c.operation_id)
+    INNER JOIN SCHEMA_TRACING.operation po ON (po.id = p.operation_id)
+    INNER JOIN SCHEMA_TRACING.tag ct ON (ct.key = 'service.name' AND ct.id = co.service_name_id) -- partition elimination
+    INNER JOIN SCHEMA_TRACING.tag pt ON (pt.key = 'service.name' AND pt.id = po.service_name_id)
+    WHERE c.start_time >= _start AND c.start_time < _end
+    AND co.service_name_id <> po.service_name_id
+$func$
+LANGUAGE SQL STABLE PARALLEL SAFE;
+GRANT EXECUTE ON FUNCTION SCHEMA_TRACING.service_dependency_edges(timestamptz, timestamptz) TO prom_reader;
+COMMENT ON FUNCTION SCHEMA_TRACING.service_dependency_edges IS
+$$Returns a row per span started in [_start, _end) whose parent span belongs to another service.$$;
+
+CREATE OR REPLACE PROCEDURE SCHEMA_TRACING.refresh_service_dependencies(log_verbose boolean = false)
+AS $$
+DECLARE
+    _start timestamptz;
+    _chunk_end timestamptz;
+    _end timestamptz;
+    _first boolean := true;
+    startT timestamptz;
+BEGIN
+    --the last few minutes are left to the query time, spans of recent traces
+    --are still being ingested
+    _end := date_trunc('hour', now() - INTERVAL '10 minutes');
+    LOOP
+        --concurrent maintenance jobs refresh the aggregate one at a time
+        LOCK TABLE SCHEMA_TRACING.service_dependency_watermark IN SHARE ROW EXCLUSIVE MODE;
+
+        SELECT w.refreshed_until INTO _start
+        FROM SCHEMA_TRACING.service_dependency_watermark w;
+
+        IF _start IS NULL THEN
+            --spans older than the retention period are about to be dropped
+            SELECT date_trunc('hour', greatest(min(s.start_time), now() - SCHEMA_TRACING_PUBLIC.get_trace_retention_period()))
+            INTO _start
+            FROM SCHEMA_TRACING.span s
+            HAVING min(s.start_time) IS NOT NULL;
+        ELSIF _first THEN
+            --the last bucket is recomputed to pick up spans ingested late
+            _start := _start - INTERVAL '1 hour';
+        END IF;
+        _first := false;
+
+        EXIT WHEN _start IS NULL OR _start >= _end;
+        _chunk_end := least(_start + INTERVAL '1 day', _end);
+        startT := clock_timestamp();
+
+        DELETE FROM SCHEMA_TRACING.service_dependency d
+        WHERE d.bucket >= _start AND d.bucket < _chunk_end;
+
+        INSERT INTO SCHEMA_TRACING.service_dependency (bucket, parent_service, child_service, call_count)
+        SELECT date_trunc('hour', e.start_time), e.parent_service, e.child_service, count(*)
+        FROM SCHEMA_TRACING.service_dependency_edges(_start, _chunk_end) e
+        GROUP BY 1, 2, 3;
+
+        INSERT INTO SCHEMA_TRACING.service_dependency_watermark (refreshed_until)
+        VALUES (_chunk_end)
+        ON CONFLICT (id) DO UPDATE SET refreshed_until = EXCLUDED.refreshed_until;
+
+        IF log_verbose THEN
+            RAISE LOG 'promscale maintenance: tracing dependencies: % to %: finished in %', _start, _chunk_end, clock_timestamp()-startT;
+        END IF;
+        COMMIT;
+    END LOOP;
+    COMMIT;
+END;
+$$ LANGUAGE PLPGSQL;
+COMMENT ON PROCEDURE SCHEMA_TRACING.refresh_service_dependencies(boolean)
+IS 'aggregates the calls between services by hour up to the last complete hour. This procedure is run by the maintenance jobs';
+GRANT EXECUTE ON PROCEDURE SCHEMA_TRACING.refresh_service_dependencies(boolean) TO prom_maintenance;
+
+--drop the chunks of the span, event and link tables, returns whether any data was dropped
+CREATE OR REPLACE FUNCTION SCHEMA_TRACING.drop_span_chunks(_older_than timestamptz)
+RETURNS boolean
+AS $func$
+DECLARE
+    _table name;
+    _dropped bigint := 0;
+    _count bigint;
+BEGIN
+    IF SCHEMA_CATALOG.is_timescaledb_installed() THEN
+        FOREACH _table IN ARRAY ARRAY['span', 'event', 'link']::name[]
+        LOOP
+            IF SCHEMA_CATALOG.get_timescale_major_version() >= 2 THEN
+                SELECT count(*) INTO _count
+                FROM SCHEMA_TIMESCALE.drop_chunks(
+                    relation=>format('%I.%I', 'SCHEMA_TRACING', _table),
+                    older_than=>_older_than
+                );
+            ELSE
+                SELECT count(*) INTO _count
+                FROM SCHEMA_TIMESCALE.drop_chunks(
+                    table_name=>_table,
+                    schema_name=>'SCHEMA_TRACING',
+                    older_than=>_older_than,
+                    cascade_to_materializations=>FALSE
+                );
+            END IF;
+            _dropped := _dropped + _count;
+        END LOOP;
+    ELSE
+        DELETE FROM SCHEMA_TRACING.span WHERE start_time < _older_than;
+        GET DIAGNOSTICS _count = ROW_COUNT;
+        _dropped := _dropped + _count;
+        DELETE FROM SCHEMA_TRACING.event WHERE time < _older_than;
+        GET DIAGNOSTICS _count = ROW_COUNT;
+        _dropped := _dropped + _count;
+        DELETE FROM SCHEMA_TRACING.link WHERE span_start_time < _older_than;
+        GET DIAGNOSTICS _count = ROW_COUNT;
+        _dropped := _dropped + _count;
+    END IF;
+    RETURN _dropped > 0;
+END
+$func$
+LANGUAGE PLPGSQL VOLATILE
+--security definer to drop the chunks as the owner of the tables
+SECURITY DEFINER
+--search path must be set for security definer
+SET search_path = pg_temp;
+--redundant given schema settings but extra caution for security definers
+REVOKE ALL ON FUNCTION SCHEMA_TRACING.drop_span_chunks(timestamptz) FROM PUBLIC;
+GRANT EXECUTE ON FUNCTION SCHEMA_TRACING.drop_span_chunks(timestamptz) TO prom_maintenance;
+
+--delete the operations and tags, up to the given ids, which are not referenced
+--anymore. The referenced ids are collected by scanning each table once, as the
+--GIN indexes of the tag maps cannot be used on compressed chunks. The ids
+--fetched again by put_tag and put_operation since they were marked are kept,
+--as their spans may not be written yet. The exclusive lock waits for the
+--ingests fetching ids, and makes them wait until the deletion is committed, so
+--that they either record the ids as kept or create them again
+CREATE OR REPLACE FUNCTION SCHEMA_TRACING.delete_orphaned_ids(_max_tag_id bigint, _max_operation_id bigint)
+RETURNS VOID
+AS $func$
+BEGIN
+    PERFORM pg_advisory_xact_lock(ADVISORY_LOCK_PREFIX_TRACE_ORPHANS, 0);
+
+    DELETE FROM SCHEMA_TRACING.operation o
+    WHERE o.id <= _max_operation_id
+    AND NOT EXISTS (
+        SELECT 1
+        FROM
+        (
+            SELECT s.operation_id AS id
+            FROM SCHEMA_TRACING.span s
+            WHERE s.operation_id <= _max_operation_id
+            UNION
+            SELECT k.id
+            FROM SCHEMA_TRACING.orphan_cleanup_kept_operation k
+        ) u
+        WHERE u.id = o.id
+    );
+
+    DELETE FROM SCHEMA_TRACING.tag t
+    WHERE t.id <= _max_tag_id
+    AND NOT EXISTS (
+        SELECT 1
+        FROM
+        (
+            SELECT o.service_name_id AS id
+            FROM SCHEMA_TRACING.operation o
+            UNION
+            SELECT m.id
+            FROM SCHEMA_TRACING.span s
+            CROSS JOIN LATERAL
+            (
+                SELECT x.value::bigint AS id FROM jsonb_each_text(s.span_tags) x
+                UNION ALL
+                SELECT x.value::bigint AS id FROM jsonb_each_text(s.resource_tags) x
+            ) m
+            WHERE m.id <= _max_tag_id
+            UNION
+            SELECT x.value::bigint
+            FROM SCHEMA_TRACING.event e
+            CROSS JOIN LATERAL jsonb_each_text(e.tags) x
+            WHERE x.value::bigint <= _max_tag_id
+            UNION
+            SELECT x.value::bigint
+            FROM SCHEMA_TRACING.link l
+            CROSS JOIN LATERAL jsonb_each_text(l.tags) x
+            WHERE x.value::bigint <= _max_tag_id
+            UNION
+            SELECT k.id
+            FROM SCHEMA_TRACING.orphan_cleanup_kept_tag k
+        ) u
+        WHERE u.id = t.id
+    );
+
+    DELETE FROM SCHEMA_TRACING.orphan_cleanup_kept_operation;
+    DELETE FROM SCHEMA_TRACING.orphan_cleanup_kept_tag;
+END
+$func$
+LANGUAGE PLPGSQL VOLATILE
+--security definer to delete the ids as the owner of the tables
+SECURITY DEFINER
+--search path must be set for security definer
+SET search_path = pg_temp;
+--redundant given schema settings but extra caution for security definers
+REVOKE ALL ON FUNCTION SCHEMA_TRACING.delete_orphaned_ids(bigint, bigint) FROM PUBLIC;
+GRANT EXECUTE ON FUNCTION SCHEMA_TRACING.delete_orphaned_ids(bigint, bigint) TO prom_maintenance;
+
+CREATE OR REPLACE PROCEDURE SCHEMA_TRACING.execute_trace_retention_policy(log_verbose boolean = false)
+AS $$
+DECLARE
+    _retention_period interval;
+    _older_than timestamptz;
+    _max_tag_id bigint;
+    _max_operation_id bigint;
+    startT timestamptz;
+BEGIN
+    startT := clock_timestamp();
+    _retention_period := SCHEMA_TRACING_PUBLIC.get_trace_retention_period();
+
+    --concurrent maintenance jobs drop the trace chunks one at a time
+    LOCK TABLE SCHEMA_TRACING.orphan_cleanup IN SHARE ROW EXCLUSIVE MODE;
+
+    --the traces are kept forever when no retention period is set
+    IF _retention_period IS NOT NULL THEN
+        _older_than := now() - _retention_period;
+        PERFORM SCHEMA_CATALOG.set_app_name('promscale maintenance: trace retention: drop chunks');
+        IF SCHEMA_TRACING.drop_span_chunks(_older_than) THEN
+            --the ids existing when the spans were dropped are checked by a
+            --later run, so that the ids fetched by concurrent ingests are not
+            --deleted before their spans are written. The ids fetched again
+            --after the mark are recorded as kept by put_tag and put_operation
+            INSERT INTO SCHEMA_TRACING.orphan_cleanup (max_tag_id, max_operation_id, marked_at)
+            SELECT
+                (SELECT coalesce(max(t.id), 0) FROM SCHEMA_TRACING.tag t),
+                (SELECT coalesce(max(o.id), 0) FROM SCHEMA_TRACING.operation o),
+                now()
+            ON CONFLICT (id) DO NOTHING;
+        END IF;
+        DELETE FROM SCHEMA_TRACING.service_dependency d WHERE d.bucket < _older_than;
+        IF log_verbose THEN
+            RAISE LOG 'promscale maintenance: trace retention: done dropping chunks in %', clock_timestamp()-startT;
+        END IF;
+    END IF;
+    COMMIT;
+
+    SELECT c.max_tag_id, c.max_operation_id INTO _max_tag_id, _max_operation_id
+    FROM SCHEMA_TRACING.orphan_cleanup c
+    WHERE c.marked_at <= now() - INTERVAL '1 hour';
+    IF FOUND THEN
+        startT := clock_timestamp();
+        PERFORM SCHEMA_CATALOG.set_app_name('promscale maintenance: trace retention: delete orphaned ids');
+        PERFORM SCHEMA_TRACING.delete_orphaned_ids(_max_tag_id, _max_operation_id);
+        DELETE FROM SCHEMA_TRACING.orphan_cleanup;
+        IF log_verbose THEN
+            RAISE LOG 'promscale maintenance: trace retention: done deleting orphaned ids in %', clock_timestamp()-startT;
+        END IF;
+    END IF;
+    COMMIT;
+END;
+$$ LANGUAGE PLPGSQL;
+COMMENT ON PROCEDURE SCHEMA_TRACING.execute_trace_retention_policy(boolean)
+IS 'drops old trace data according to the trace retention period, and the tags and operations they no longer use. This procedure is run by the maintenance jobs';
+GRANT EXECUTE ON PROCEDURE SCHEMA_TRACING.execute_trace_retention_policy(boolean) TO prom_maintenance;
+
+--replaces the TimescaleDB compression policies of the span, event and link
+--hypertables, so that their chunks are compressed after _compress_after
+CREATE OR REPLACE FUNCTION SCHEMA_TRACING.set_trace_compression_policies(_compress_after INTERVAL)
+RETURNS VOID
+AS $func$
+DECLARE
+    _table regclass;
+BEGIN
+    IF NOT SCHEMA_CATALOG.is_timescaledb_installed() OR SCHEMA_CATALOG.is_timescaledb_oss() THEN
+        RETURN;
+    END IF;
+
+    FOREACH _table IN ARRAY ARRAY['SCHEMA_TRACING.span', 'SCHEMA_TRACING.event', 'SCHEMA_TRACING.link']::regclass[]
+    LOOP
+        BEGIN
+            IF SCHEMA_CATALOG.get_timescale_major_version() >= 2 THEN
+                PERFORM SCHEMA_TIMESCALE.remove_compression_policy(_table, if_exists=>true);
+                PERFORM SCHEMA_TIMESCALE.add_compression_policy(_table, _compress_after);
+            ELSE
+                PERFORM SCHEMA_TIMESCALE.remove_compress_chunks_policy(_table, if_exists=>true);
+                PERFORM SCHEMA_TIMESCALE.add_compress_chunks_policy(_table, _compress_after);
+            END IF;
+        EXCEPTION
+            WHEN undefined_function THEN
+                RAISE NOTICE 'compression policies are not available';
+                RETURN;
+        END;
+    END LOOP;
+END
+$func$
+LANGUAGE PLPGSQL VOLATILE
+--security definer to change the policies as the owner of the tables
+SECURITY DEFINER
+--search path must be set for security definer
+SET search_path = pg_temp;
+--redundant given schema settings but extra caution for security definers
+REVOKE ALL ON FUNCTION SCHEMA_TRACING.set_trace_compression_policies(INTERVAL) FROM PUBLIC;
+GRANT EXECUTE ON FUNCTION SCHEMA_TRACING.set_trace_compression_policies(INTERVAL) TO prom_admin;
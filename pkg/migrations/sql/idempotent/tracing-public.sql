@@ -307,6 +307,9 @@ AS $func$
 DECLARE
     _tag SCHEMA_TRACING.tag;
 BEGIN
+    --the orphan cleanup waits for the ids being fetched, see SCHEMA_TRACING.delete_orphaned_ids
+    PERFORM pg_advisory_xact_lock_shared(ADVISORY_LOCK_PREFIX_TRACE_ORPHANS, 0);
+
     SELECT * INTO _tag
     FROM SCHEMA_TRACING.tag
     WHERE key = _key
@@ -337,6 +340,12 @@ BEGIN
         AND t.id = _tag.id;
     END IF;
 
+    --the ids marked for the orphan cleanup are kept once fetched again
+    IF _tag.id <= (SELECT c.max_tag_id FROM SCHEMA_TRACING.orphan_cleanup c) THEN
+        INSERT INTO SCHEMA_TRACING.orphan_cleanup_kept_tag (id) VALUES (_tag.id)
+        ON CONFLICT DO NOTHING;
+    END IF;
+
     RETURN _tag.id;
 END;
 $func$
@@ -385,6 +394,9 @@ DECLARE
     _service_name_id bigint;
     _operation_id bigint;
 BEGIN
+    --the orphan cleanup waits for the ids being fetched, see SCHEMA_TRACING.delete_orphaned_ids
+    PERFORM pg_advisory_xact_lock_shared(ADVISORY_LOCK_PREFIX_TRACE_ORPHANS, 0);
+
     SELECT id INTO _service_name_id
     FROM SCHEMA_TRACING.tag
     WHERE key = 'service.name'
@@ -439,6 +451,12 @@ BEGIN
         END IF;
     END IF;
 
+    --the ids marked for the orphan cleanup are kept once fetched again
+    IF _operation_id <= (SELECT c.max_operation_id FROM SCHEMA_TRACING.orphan_cleanup c) THEN
+        INSERT INTO SCHEMA_TRACING.orphan_cleanup_kept_operation (id) VALUES (_operation_id)
+        ON CONFLICT DO NOTHING;
+    END IF;
+
     RETURN _operation_id;
 END;
 $func$
@@ -604,3 +622,112 @@ LANGUAGE SQL STABLE PARALLEL SAFE STRICT;
 GRANT EXECUTE ON FUNCTION SCHEMA_TRACING_PUBLIC.get_tag_map(jsonb) TO prom_reader;
 
 
+
+CREATE OR REPLACE FUNCTION SCHEMA_TRACING_PUBLIC.service_dependencies(_start timestamptz, _end timestamptz)
+RETURNS TABLE (parent_service text, child_service text, call_count bigint)
+AS $func$
+DECLARE
+    _agg_start timestamptz;
+    _agg_end timestamptz;
+BEGIN
+    --whole hours up to the watermark are read from the aggregate, the rest of
+    --the range is computed from the spans
+    _agg_start := date_trunc('hour', _start);
+    IF _agg_start < _start THEN
+        _agg_start := _agg_start + INTERVAL '1 hour';
+    END IF;
+    SELECT least(date_trunc('hour', _end), w.refreshed_until) INTO _agg_end
+    FROM SCHEMA_TRACING.service_dependency_watermark w;
+    IF _agg_end IS NULL OR _agg_end <= _agg_start THEN
+        _agg_start := _end;
+        _agg_end := _end;
+    END IF;
+
+    RETURN QUERY
+    SELECT x.parent_service, x.child_service, sum(x.call_count)::bigint
+    FROM
+    (
+        SELECT d.parent_service, d.child_service, d.call_count
+        FROM SCHEMA_TRACING.service_dependency d
+        WHERE d.bucket >= _agg_start AND d.bucket < _agg_end
+        UNION ALL
+        SELECT e.parent_service, e.child_service, 1
+        FROM SCHEMA_TRACING.service_dependency_edges(_start, _agg_start) e
+        UNION ALL
+        SELECT e.parent_service, e.child_service, 1
+        FROM SCHEMA_TRACING.service_dependency_edges(_agg_end, _end) e
+    ) x
+    GROUP BY x.parent_service, x.child_service
+    ORDER BY x.parent_service, x.child_service;
+END;
+$func$
+LANGUAGE plpgsql STABLE STRICT;
+GRANT EXECUTE ON FUNCTION SCHEMA_TRACING_PUBLIC.service_dependencies(timestamptz, timestamptz) TO prom_reader;
+COMMENT ON FUNCTION SCHEMA_TRACING_PUBLIC.service_dependencies IS
+$$Returns the number of calls between services, from a parent span to a child span, for the spans started in [_start, _end).$$;
+
+CREATE OR REPLACE FUNCTION SCHEMA_TRACING_PUBLIC.get_trace_retention_period()
+RETURNS INTERVAL
+AS $func$
+    SELECT value::INTERVAL FROM SCHEMA_CATALOG.default WHERE key='trace_retention_period'
+$func$
+LANGUAGE SQL STABLE PARALLEL SAFE;
+GRANT EXECUTE ON FUNCTION SCHEMA_TRACING_PUBLIC.get_trace_retention_period() TO prom_reader;
+COMMENT ON FUNCTION SCHEMA_TRACING_PUBLIC.get_trace_retention_period()
+IS 'get the retention period of the spans, events and links, NULL if they are kept forever';
+
+CREATE OR REPLACE FUNCTION SCHEMA_TRACING_PUBLIC.set_trace_retention_period(_trace_retention_period INTERVAL)
+RETURNS BOOLEAN
+AS $func$
+BEGIN
+    IF _trace_retention_period <= INTERVAL '0' THEN
+        RAISE EXCEPTION 'trace retention period must be positive, got %', _trace_retention_period;
+    END IF;
+    INSERT INTO SCHEMA_CATALOG.default(key, value) VALUES ('trace_retention_period', _trace_retention_period::text)
+    ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value;
+    RETURN true;
+END
+$func$
+LANGUAGE PLPGSQL VOLATILE STRICT;
+GRANT EXECUTE ON FUNCTION SCHEMA_TRACING_PUBLIC.set_trace_retention_period(INTERVAL) TO prom_admin;
+COMMENT ON FUNCTION SCHEMA_TRACING_PUBLIC.set_trace_retention_period(INTERVAL)
+IS 'set the retention period of the spans, events and links. Older data is dropped by the maintenance jobs';
+
+CREATE OR REPLACE FUNCTION SCHEMA_TRACING_PUBLIC.reset_trace_retention_period()
+RETURNS BOOLEAN
+AS $func$
+    DELETE FROM SCHEMA_CATALOG.default WHERE key = 'trace_retention_period';
+    SELECT true;
+$func$
+LANGUAGE SQL VOLATILE;
+GRANT EXECUTE ON FUNCTION SCHEMA_TRACING_PUBLIC.reset_trace_retention_period() TO prom_admin;
+COMMENT ON FUNCTION SCHEMA_TRACING_PUBLIC.reset_trace_retention_period()
+IS 'unset the retention period of the spans, events and links, so that they are kept forever';
+
+CREATE OR REPLACE FUNCTION SCHEMA_TRACING_PUBLIC.get_trace_compress_after()
+RETURNS INTERVAL
+AS $func$
+    SELECT value::INTERVAL FROM SCHEMA_CATALOG.default WHERE key='trace_compress_after'
+$func$
+LANGUAGE SQL STABLE PARALLEL SAFE;
+GRANT EXECUTE ON FUNCTION SCHEMA_TRACING_PUBLIC.get_trace_compress_after() TO prom_reader;
+COMMENT ON FUNCTION SCHEMA_TRACING_PUBLIC.get_trace_compress_after()
+IS 'get the age after which the chunks of the spans, events and links are compressed';
+
+CREATE OR REPLACE FUNCTION SCHEMA_TRACING_PUBLIC.set_trace_compress_after(_compress_after INTERVAL)
+RETURNS BOOLEAN
+AS $func$
+BEGIN
+    IF _compress_after < INTERVAL '0' THEN
+        RAISE EXCEPTION 'trace compression age cannot be negative, got %', _compress_after;
+    END IF;
+    INSERT INTO SCHEMA_CATALOG.default(key, value) VALUES ('trace_compress_after', _compress_after::text)
+    ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value;
+    PERFORM SCHEMA_TRACING.set_trace_compression_policies(_compress_after);
+    RETURN true;
+END
+$func$
+LANGUAGE PLPGSQL VOLATILE STRICT;
+GRANT EXECUTE ON FUNCTION SCHEMA_TRACING_PUBLIC.set_trace_compress_after(INTERVAL) TO prom_admin;
+COMMENT ON FUNCTION SCHEMA_TRACING_PUBLIC.set_trace_compress_after(INTERVAL)
+IS 'set the age after which the chunks of the spans, events and links are compressed by the TimescaleDB compression policies';
@@ -28,6 +28,10 @@ CALL SCHEMA_CATALOG.execute_everywhere('create_schemas', $ee$ DO $$ BEGIN
     GRANT USAGE ON SCHEMA SCHEMA_DATA_EXEMPLAR TO prom_reader;
     GRANT ALL ON SCHEMA SCHEMA_DATA_EXEMPLAR TO prom_writer;
 
+    CREATE SCHEMA IF NOT EXISTS SCHEMA_DATA_HISTOGRAM;
+    GRANT USAGE ON SCHEMA SCHEMA_DATA_HISTOGRAM TO prom_reader;
+    GRANT ALL ON SCHEMA SCHEMA_DATA_HISTOGRAM TO prom_writer;
+
     CREATE SCHEMA IF NOT EXISTS SCHEMA_TAG;
     GRANT USAGE ON SCHEMA SCHEMA_TAG TO prom_reader;
 
@@ -24,6 +24,7 @@ INSERT INTO public.prom_installation_info(key, value) VALUES
     ('metric schema',           'SCHEMA_METRIC'),
     ('data schema',             'SCHEMA_DATA'),
     ('exemplar data schema',    'SCHEMA_DATA_EXEMPLAR'),
+    ('histogram data schema',   'SCHEMA_DATA_HISTOGRAM'),
     ('information schema',      'SCHEMA_INFO'),
     ('tracing schema',          'SCHEMA_TRACING_PUBLIC'),
     ('tracing schema private',  'SCHEMA_TRACING');
@@ -109,6 +110,7 @@ CREATE TABLE SCHEMA_CATALOG.metric (
     table_schema name NOT NULL DEFAULT 'SCHEMA_DATA',
     series_table name NOT NULL, -- series_table specifies the name of table where the series data is stored.
     is_view BOOLEAN NOT NULL DEFAULT false,
+    duplicate_policy TEXT DEFAULT NULL CHECK (duplicate_policy IN ('reject', 'keep_first', 'keep_last', 'allow')), --NULL to use the default duplicate_policy
     UNIQUE (metric_name, table_schema) INCLUDE (table_name),
     UNIQUE(table_schema, table_name)
 );
@@ -116,6 +118,37 @@ GRANT SELECT ON TABLE SCHEMA_CATALOG.metric TO prom_reader;
 GRANT SELECT, INSERT, UPDATE, DELETE ON TABLE SCHEMA_CATALOG.metric TO prom_writer;
 GRANT USAGE ON SEQUENCE SCHEMA_CATALOG.metric_id_seq TO prom_writer;
 
+CREATE TABLE SCHEMA_CATALOG.metric_view_resolution (
+    metric_id INT NOT NULL REFERENCES SCHEMA_CATALOG.metric(id) ON DELETE CASCADE, --the metric view
+    resolution INTERVAL NOT NULL CHECK (resolution > interval '0'), --interval between the points of the view
+    value_column name NOT NULL, --column holding the values replacing the raw samples
+    aggregate TEXT NOT NULL CHECK (aggregate IN ('min', 'max', 'sum', 'last')), --aggregate of the raw samples held by the column
+    PRIMARY KEY (metric_id, aggregate)
+);
+GRANT SELECT ON TABLE SCHEMA_CATALOG.metric_view_resolution TO prom_reader;
+GRANT SELECT, INSERT, UPDATE, DELETE ON TABLE SCHEMA_CATALOG.metric_view_resolution TO prom_admin;
+
+CREATE TABLE SCHEMA_CATALOG.downsample (
+    id SERIAL PRIMARY KEY,
+    schema_name name NOT NULL UNIQUE, --schema of the downsampled metric tables, named after the resolution
+    resolution INTERVAL NOT NULL UNIQUE CHECK (resolution > interval '0'),
+    retention_period INTERVAL NOT NULL CHECK (retention_period > interval '0'),
+    apply_to_all_metrics BOOLEAN NOT NULL DEFAULT true --false if only enabled for specific metrics
+);
+GRANT SELECT ON TABLE SCHEMA_CATALOG.downsample TO prom_reader;
+GRANT SELECT, INSERT, UPDATE, DELETE ON TABLE SCHEMA_CATALOG.downsample TO prom_admin;
+GRANT USAGE ON SEQUENCE SCHEMA_CATALOG.downsample_id_seq TO prom_admin;
+
+CREATE TABLE SCHEMA_CATALOG.metric_downsample (
+    metric_id INT NOT NULL REFERENCES SCHEMA_CATALOG.metric(id) ON DELETE CASCADE, --the raw metric
+    downsample_id INT NOT NULL REFERENCES SCHEMA_CATALOG.downsample(id) ON DELETE CASCADE,
+    enabled BOOLEAN NOT NULL,
+    retention_period INTERVAL DEFAULT NULL CHECK (retention_period > interval '0'), --NULL to use the retention period of the resolution
+    PRIMARY KEY (metric_id, downsample_id)
+);
+GRANT SELECT ON TABLE SCHEMA_CATALOG.metric_downsample TO prom_reader;
+GRANT SELECT, INSERT, UPDATE, DELETE ON TABLE SCHEMA_CATALOG.metric_downsample TO prom_admin;
+
 CREATE TABLE SCHEMA_CATALOG.default (
     key TEXT PRIMARY KEY,
     value TEXT
@@ -126,4 +159,5 @@ GRANT SELECT, INSERT, UPDATE, DELETE ON TABLE SCHEMA_CATALOG.default TO prom_adm
 INSERT INTO SCHEMA_CATALOG.default(key,value) VALUES
 ('chunk_interval', (INTERVAL '8 hours')::text),
 ('retention_period', (90 * INTERVAL '1 day')::text),
-('metric_compression', (exists(select * from pg_proc where proname = 'compress_chunk')::text));
+('metric_compression', (exists(select * from pg_proc where proname = 'compress_chunk')::text)),
+('duplicate_policy', 'keep_first');
@@ -37,6 +37,10 @@ CALL SCHEMA_CATALOG.execute_everywhere('create_schemas', $ee$ DO $$ BEGIN
     GRANT USAGE ON SCHEMA SCHEMA_DATA_EXEMPLAR TO prom_reader;
     GRANT ALL ON SCHEMA SCHEMA_DATA_EXEMPLAR TO prom_writer;
 
+    CREATE SCHEMA IF NOT EXISTS SCHEMA_DATA_HISTOGRAM;
+    GRANT USAGE ON SCHEMA SCHEMA_DATA_HISTOGRAM TO prom_reader;
+    GRANT ALL ON SCHEMA SCHEMA_DATA_HISTOGRAM TO prom_writer;
+
     CREATE SCHEMA IF NOT EXISTS SCHEMA_TAG;
     GRANT USAGE ON SCHEMA SCHEMA_TAG TO prom_reader;
 
@@ -237,6 +241,60 @@ CREATE INDEX ON SCHEMA_TRACING.link USING GIN (tags jsonb_path_ops);
 GRANT SELECT ON TABLE SCHEMA_TRACING.link TO prom_reader;
 GRANT SELECT, INSERT, UPDATE, DELETE ON TABLE SCHEMA_TRACING.link TO prom_writer;
 
+-- calls between services, aggregated by hour, see SCHEMA_TRACING.refresh_service_dependencies
+CREATE TABLE IF NOT EXISTS SCHEMA_TRACING.service_dependency
+(
+    bucket timestamptz NOT NULL,
+    parent_service text NOT NULL,
+    child_service text NOT NULL,
+    call_count bigint NOT NULL,
+    PRIMARY KEY (bucket, parent_service, child_service)
+);
+GRANT SELECT ON TABLE SCHEMA_TRACING.service_dependency TO prom_reader;
+GRANT SELECT, INSERT, UPDATE, DELETE ON TABLE SCHEMA_TRACING.service_dependency TO prom_maintenance;
+
+-- single row holding the end of the last aggregated bucket of SCHEMA_TRACING.service_dependency
+CREATE TABLE IF NOT EXISTS SCHEMA_TRACING.service_dependency_watermark
+(
+    id boolean PRIMARY KEY DEFAULT true CHECK (id),
+    refreshed_until timestamptz NOT NULL
+);
+GRANT SELECT ON TABLE SCHEMA_TRACING.service_dependency_watermark TO prom_reader;
+GRANT SELECT, INSERT, UPDATE, DELETE ON TABLE SCHEMA_TRACING.service_dependency_watermark TO prom_maintenance;
+
+INSERT INTO SCHEMA_CATALOG.default(key, value) VALUES
+('trace_retention_period', (30 * INTERVAL '1 day')::text),
+('trace_compress_after', (INTERVAL '1 hour')::text)
+ON CONFLICT (key) DO NOTHING;
+
+-- highest ids of the tag and operation tables when trace chunks were last
+-- dropped, see SCHEMA_TRACING.execute_trace_retention_policy
+CREATE TABLE IF NOT EXISTS SCHEMA_TRACING.orphan_cleanup
+(
+    id boolean PRIMARY KEY DEFAULT true CHECK (id),
+    max_tag_id bigint NOT NULL,
+    max_operation_id bigint NOT NULL,
+    marked_at timestamptz NOT NULL
+);
+GRANT SELECT ON TABLE SCHEMA_TRACING.orphan_cleanup TO prom_reader;
+GRANT SELECT, INSERT, UPDATE, DELETE ON TABLE SCHEMA_TRACING.orphan_cleanup TO prom_maintenance;
+
+-- ids fetched again by ingests after being marked for the orphan cleanup,
+-- see SCHEMA_TRACING.delete_orphaned_ids
+CREATE TABLE IF NOT EXISTS SCHEMA_TRACING.orphan_cleanup_kept_tag
+(
+    id bigint PRIMARY KEY
+);
+GRANT SELECT ON TABLE SCHEMA_TRACING.orphan_cleanup_kept_tag TO prom_reader;
+GRANT INSERT ON TABLE SCHEMA_TRACING.orphan_cleanup_kept_tag TO prom_writer;
+
+CREATE TABLE IF NOT EXISTS SCHEMA_TRACING.orphan_cleanup_kept_operation
+(
+    id bigint PRIMARY KEY
+);
+GRANT SELECT ON TABLE SCHEMA_TRACING.orphan_cleanup_kept_operation TO prom_reader;
+GRANT INSERT ON TABLE SCHEMA_TRACING.orphan_cleanup_kept_operation TO prom_writer;
+
 /*
     If "vanilla" postgres is installed, do nothing.
     If timescaledb is installed, turn on compression for tracing tables.
@@ -366,16 +424,21 @@ BEGIN
             ALTER TABLE SCHEMA_TRACING.event SET (timescaledb.compress, timescaledb.compress_segmentby='trace_id,span_id');
             ALTER TABLE SCHEMA_TRACING.link SET (timescaledb.compress, timescaledb.compress_segmentby='trace_id,span_id');
 
-            IF _timescaledb_major_version < 2 THEN
-                BEGIN
+            -- the policies follow ps_trace.set_trace_compress_after
+            BEGIN
+                IF _timescaledb_major_version >= 2 THEN
                     PERFORM SCHEMA_TIMESCALE.add_compression_policy('SCHEMA_TRACING.span', INTERVAL '1 hour');
                     PERFORM SCHEMA_TIMESCALE.add_compression_policy('SCHEMA_TRACING.event', INTERVAL '1 hour');
                     PERFORM SCHEMA_TIMESCALE.add_compression_policy('SCHEMA_TRACING.link', INTERVAL '1 hour');
-                EXCEPTION
-                    WHEN undefined_function THEN
-                        RAISE NOTICE 'add_compression_policy does not exist';
-                END;
-            END IF;
+                ELSE
+                    PERFORM SCHEMA_TIMESCALE.add_compress_chunks_policy('SCHEMA_TRACING.span', INTERVAL '1 hour');
+                    PERFORM SCHEMA_TIMESCALE.add_compress_chunks_policy('SCHEMA_TRACING.event', INTERVAL '1 hour');
+                    PERFORM SCHEMA_TIMESCALE.add_compress_chunks_policy('SCHEMA_TRACING.link', INTERVAL '1 hour');
+                END IF;
+            EXCEPTION
+                WHEN undefined_function THEN
+                    RAISE NOTICE 'compression policies are not available';
+            END;
         END IF;
     END IF;
 END;
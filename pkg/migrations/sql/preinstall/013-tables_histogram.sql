@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS SCHEMA_CATALOG.histogram (
+    id          SERIAL PRIMARY KEY,
+    metric_name TEXT NOT NULL,
+    table_name  TEXT NOT NULL,
+    UNIQUE (metric_name) INCLUDE (table_name, id)
+);
+GRANT SELECT ON TABLE SCHEMA_CATALOG.histogram TO prom_reader;
+GRANT SELECT, INSERT, UPDATE, DELETE ON TABLE SCHEMA_CATALOG.histogram TO prom_writer;
+
+GRANT USAGE, SELECT ON SEQUENCE SCHEMA_CATALOG.histogram_id_seq TO prom_writer;
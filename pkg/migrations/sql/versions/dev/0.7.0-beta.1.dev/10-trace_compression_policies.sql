@@ -0,0 +1,52 @@
+-- the trace chunks are compressed by the TimescaleDB compression policies,
+-- following ps_trace.set_trace_compress_after
+DROP PROCEDURE IF EXISTS SCHEMA_TRACING.execute_trace_compression_policy(boolean);
+DROP FUNCTION IF EXISTS SCHEMA_TRACING.compress_trace_chunk(name, name);
+
+-- ids fetched again by ingests after being marked for the orphan cleanup,
+-- see SCHEMA_TRACING.delete_orphaned_ids
+CREATE TABLE IF NOT EXISTS SCHEMA_TRACING.orphan_cleanup_kept_tag
+(
+    id bigint PRIMARY KEY
+);
+GRANT SELECT ON TABLE SCHEMA_TRACING.orphan_cleanup_kept_tag TO prom_reader;
+GRANT INSERT ON TABLE SCHEMA_TRACING.orphan_cleanup_kept_tag TO prom_writer;
+
+CREATE TABLE IF NOT EXISTS SCHEMA_TRACING.orphan_cleanup_kept_operation
+(
+    id bigint PRIMARY KEY
+);
+GRANT SELECT ON TABLE SCHEMA_TRACING.orphan_cleanup_kept_operation TO prom_reader;
+GRANT INSERT ON TABLE SCHEMA_TRACING.orphan_cleanup_kept_operation TO prom_writer;
+
+DO $block$
+DECLARE
+    _compress_after INTERVAL;
+    _table regclass;
+BEGIN
+    IF NOT SCHEMA_CATALOG.is_timescaledb_installed() OR SCHEMA_CATALOG.is_timescaledb_oss() THEN
+        RETURN;
+    END IF;
+
+    SELECT value::INTERVAL INTO _compress_after
+    FROM SCHEMA_CATALOG.default
+    WHERE key = 'trace_compress_after';
+
+    FOREACH _table IN ARRAY ARRAY['SCHEMA_TRACING.span', 'SCHEMA_TRACING.event', 'SCHEMA_TRACING.link']::regclass[]
+    LOOP
+        BEGIN
+            IF SCHEMA_CATALOG.get_timescale_major_version() >= 2 THEN
+                PERFORM SCHEMA_TIMESCALE.remove_compression_policy(_table, if_exists=>true);
+                PERFORM SCHEMA_TIMESCALE.add_compression_policy(_table, _compress_after);
+            ELSE
+                PERFORM SCHEMA_TIMESCALE.remove_compress_chunks_policy(_table, if_exists=>true);
+                PERFORM SCHEMA_TIMESCALE.add_compress_chunks_policy(_table, _compress_after);
+            END IF;
+        EXCEPTION
+            WHEN undefined_function THEN
+                RAISE NOTICE 'compression policies are not available';
+                RETURN;
+        END;
+    END LOOP;
+END;
+$block$;
@@ -0,0 +1,6 @@
+-- the trace retention period set by 7-trace_retention.sql would drop the
+-- traces older than 30 days on upgrade. The existing traces are kept until a
+-- retention period is set with ps_trace.set_trace_retention_period instead.
+DELETE FROM SCHEMA_CATALOG.default
+WHERE key = 'trace_retention_period'
+AND value = (30 * INTERVAL '1 day')::text;
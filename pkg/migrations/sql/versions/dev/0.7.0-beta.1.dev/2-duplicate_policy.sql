@@ -0,0 +1,5 @@
+ALTER TABLE SCHEMA_CATALOG.metric
+    ADD COLUMN duplicate_policy TEXT DEFAULT NULL CHECK (duplicate_policy IN ('reject', 'keep_first', 'keep_last', 'allow')); --NULL to use the default duplicate_policy
+
+INSERT INTO SCHEMA_CATALOG.default(key, value) VALUES ('duplicate_policy', 'keep_first')
+ON CONFLICT (key) DO NOTHING;
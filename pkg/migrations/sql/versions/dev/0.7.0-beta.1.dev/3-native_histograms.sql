@@ -0,0 +1,61 @@
+CREATE SCHEMA IF NOT EXISTS SCHEMA_DATA_HISTOGRAM;
+GRANT USAGE ON SCHEMA SCHEMA_DATA_HISTOGRAM TO prom_reader;
+GRANT ALL ON SCHEMA SCHEMA_DATA_HISTOGRAM TO prom_writer;
+
+CALL SCHEMA_CATALOG.execute_everywhere('create_schemas', $ee$ DO $$ BEGIN
+
+    CREATE SCHEMA IF NOT EXISTS SCHEMA_CATALOG; -- catalog tables + internal functions
+    GRANT USAGE ON SCHEMA SCHEMA_CATALOG TO prom_reader;
+
+    CREATE SCHEMA IF NOT EXISTS SCHEMA_PROM; -- public functions
+    GRANT USAGE ON SCHEMA SCHEMA_PROM TO prom_reader;
+
+    CREATE SCHEMA IF NOT EXISTS SCHEMA_EXT; -- optimized versions of functions created by the extension
+    GRANT USAGE ON SCHEMA SCHEMA_EXT TO prom_reader;
+
+    CREATE SCHEMA IF NOT EXISTS SCHEMA_SERIES; -- series views
+    GRANT USAGE ON SCHEMA SCHEMA_SERIES TO prom_reader;
+
+    CREATE SCHEMA IF NOT EXISTS SCHEMA_METRIC; -- metric views
+    GRANT USAGE ON SCHEMA SCHEMA_METRIC TO prom_reader;
+
+    CREATE SCHEMA IF NOT EXISTS SCHEMA_DATA;
+    GRANT USAGE ON SCHEMA SCHEMA_DATA TO prom_reader;
+
+    CREATE SCHEMA IF NOT EXISTS SCHEMA_DATA_SERIES;
+    GRANT USAGE ON SCHEMA SCHEMA_DATA_SERIES TO prom_reader;
+
+    CREATE SCHEMA IF NOT EXISTS SCHEMA_INFO;
+    GRANT USAGE ON SCHEMA SCHEMA_INFO TO prom_reader;
+
+    CREATE SCHEMA IF NOT EXISTS SCHEMA_DATA_EXEMPLAR;
+    GRANT USAGE ON SCHEMA SCHEMA_DATA_EXEMPLAR TO prom_reader;
+    GRANT ALL ON SCHEMA SCHEMA_DATA_EXEMPLAR TO prom_writer;
+
+    CREATE SCHEMA IF NOT EXISTS SCHEMA_DATA_HISTOGRAM;
+    GRANT USAGE ON SCHEMA SCHEMA_DATA_HISTOGRAM TO prom_reader;
+    GRANT ALL ON SCHEMA SCHEMA_DATA_HISTOGRAM TO prom_writer;
+
+    CREATE SCHEMA IF NOT EXISTS SCHEMA_TAG;
+    GRANT USAGE ON SCHEMA SCHEMA_TAG TO prom_reader;
+
+    CREATE SCHEMA IF NOT EXISTS SCHEMA_TRACING;
+    GRANT USAGE ON SCHEMA SCHEMA_TRACING TO prom_reader;
+
+    CREATE SCHEMA IF NOT EXISTS SCHEMA_TRACING_PUBLIC;
+    GRANT USAGE ON SCHEMA SCHEMA_TRACING_PUBLIC TO prom_reader;
+END $$ $ee$);
+
+CREATE TABLE IF NOT EXISTS SCHEMA_CATALOG.histogram (
+    id          SERIAL PRIMARY KEY,
+    metric_name TEXT NOT NULL,
+    table_name  TEXT NOT NULL,
+    UNIQUE (metric_name) INCLUDE (table_name, id)
+);
+GRANT SELECT ON TABLE SCHEMA_CATALOG.histogram TO prom_reader;
+GRANT SELECT, INSERT, UPDATE, DELETE ON TABLE SCHEMA_CATALOG.histogram TO prom_writer;
+
+GRANT USAGE, SELECT ON SEQUENCE SCHEMA_CATALOG.histogram_id_seq TO prom_writer;
+
+INSERT INTO public.prom_installation_info(key, value) VALUES
+    ('histogram data schema',  'SCHEMA_DATA_HISTOGRAM');
@@ -0,0 +1,7 @@
+CREATE TABLE SCHEMA_CATALOG.metric_view_resolution (
+    metric_id INT NOT NULL PRIMARY KEY REFERENCES SCHEMA_CATALOG.metric(id) ON DELETE CASCADE, --the metric view
+    resolution INTERVAL NOT NULL CHECK (resolution > interval '0'), --interval between the points of the view
+    value_column name NOT NULL --column holding the values replacing the raw samples
+);
+GRANT SELECT ON TABLE SCHEMA_CATALOG.metric_view_resolution TO prom_reader;
+GRANT SELECT, INSERT, UPDATE, DELETE ON TABLE SCHEMA_CATALOG.metric_view_resolution TO prom_admin;
@@ -0,0 +1,20 @@
+CREATE TABLE SCHEMA_CATALOG.downsample (
+    id SERIAL PRIMARY KEY,
+    schema_name name NOT NULL UNIQUE, --schema of the downsampled metric tables, named after the resolution
+    resolution INTERVAL NOT NULL UNIQUE CHECK (resolution > interval '0'),
+    retention_period INTERVAL NOT NULL CHECK (retention_period > interval '0'),
+    apply_to_all_metrics BOOLEAN NOT NULL DEFAULT true --false if only enabled for specific metrics
+);
+GRANT SELECT ON TABLE SCHEMA_CATALOG.downsample TO prom_reader;
+GRANT SELECT, INSERT, UPDATE, DELETE ON TABLE SCHEMA_CATALOG.downsample TO prom_admin;
+GRANT USAGE ON SEQUENCE SCHEMA_CATALOG.downsample_id_seq TO prom_admin;
+
+CREATE TABLE SCHEMA_CATALOG.metric_downsample (
+    metric_id INT NOT NULL REFERENCES SCHEMA_CATALOG.metric(id) ON DELETE CASCADE, --the raw metric
+    downsample_id INT NOT NULL REFERENCES SCHEMA_CATALOG.downsample(id) ON DELETE CASCADE,
+    enabled BOOLEAN NOT NULL,
+    retention_period INTERVAL DEFAULT NULL CHECK (retention_period > interval '0'), --NULL to use the retention period of the resolution
+    PRIMARY KEY (metric_id, downsample_id)
+);
+GRANT SELECT ON TABLE SCHEMA_CATALOG.metric_downsample TO prom_reader;
+GRANT SELECT, INSERT, UPDATE, DELETE ON TABLE SCHEMA_CATALOG.metric_downsample TO prom_admin;
@@ -0,0 +1,20 @@
+-- calls between services, aggregated by hour, see SCHEMA_TRACING.refresh_service_dependencies
+CREATE TABLE IF NOT EXISTS SCHEMA_TRACING.service_dependency
+(
+    bucket timestamptz NOT NULL,
+    parent_service text NOT NULL,
+    child_service text NOT NULL,
+    call_count bigint NOT NULL,
+    PRIMARY KEY (bucket, parent_service, child_service)
+);
+GRANT SELECT ON TABLE SCHEMA_TRACING.service_dependency TO prom_reader;
+GRANT SELECT, INSERT, UPDATE, DELETE ON TABLE SCHEMA_TRACING.service_dependency TO prom_maintenance;
+
+-- single row holding the end of the last aggregated bucket of SCHEMA_TRACING.service_dependency
+CREATE TABLE IF NOT EXISTS SCHEMA_TRACING.service_dependency_watermark
+(
+    id boolean PRIMARY KEY DEFAULT true CHECK (id),
+    refreshed_until timestamptz NOT NULL
+);
+GRANT SELECT ON TABLE SCHEMA_TRACING.service_dependency_watermark TO prom_reader;
+GRANT SELECT, INSERT, UPDATE, DELETE ON TABLE SCHEMA_TRACING.service_dependency_watermark TO prom_maintenance;
@@ -0,0 +1,16 @@
+INSERT INTO SCHEMA_CATALOG.default(key, value) VALUES
+('trace_retention_period', (30 * INTERVAL '1 day')::text),
+('trace_compress_after', (INTERVAL '1 hour')::text)
+ON CONFLICT (key) DO NOTHING;
+
+-- highest ids of the tag and operation tables when trace chunks were last
+-- dropped, see SCHEMA_TRACING.execute_trace_retention_policy
+CREATE TABLE IF NOT EXISTS SCHEMA_TRACING.orphan_cleanup
+(
+    id boolean PRIMARY KEY DEFAULT true CHECK (id),
+    max_tag_id bigint NOT NULL,
+    max_operation_id bigint NOT NULL,
+    marked_at timestamptz NOT NULL
+);
+GRANT SELECT ON TABLE SCHEMA_TRACING.orphan_cleanup TO prom_reader;
+GRANT SELECT, INSERT, UPDATE, DELETE ON TABLE SCHEMA_TRACING.orphan_cleanup TO prom_maintenance;
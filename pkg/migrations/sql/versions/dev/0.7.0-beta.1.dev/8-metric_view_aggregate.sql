@@ -0,0 +1,16 @@
+-- the columns of a metric view are registered along with the aggregate they
+-- hold, so that they only serve the functions giving the same results on the
+-- aggregated values as on the raw samples
+ALTER TABLE SCHEMA_CATALOG.metric_view_resolution ADD COLUMN aggregate TEXT;
+UPDATE SCHEMA_CATALOG.metric_view_resolution SET aggregate = value_column::TEXT
+WHERE value_column IN ('min', 'max', 'sum', 'last');
+-- the aggregate held by the other columns is unknown, their resolution must
+-- be set again
+DELETE FROM SCHEMA_CATALOG.metric_view_resolution WHERE aggregate IS NULL;
+ALTER TABLE SCHEMA_CATALOG.metric_view_resolution
+    ALTER COLUMN aggregate SET NOT NULL,
+    ADD CONSTRAINT metric_view_resolution_aggregate_check CHECK (aggregate IN ('min', 'max', 'sum', 'last')),
+    DROP CONSTRAINT metric_view_resolution_pkey,
+    ADD PRIMARY KEY (metric_id, aggregate);
+
+DROP FUNCTION IF EXISTS SCHEMA_PROM.set_metric_view_resolution(name, name, INTERVAL, name);
@@ -0,0 +1,3 @@
+-- downsample_metric_data aggregates a batch at a time, from the start of the
+-- batch it is passed
+DROP FUNCTION IF EXISTS SCHEMA_CATALOG.downsample_metric_data(INT, INT, INTERVAL);
@@ -11,6 +11,7 @@ import (
 
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/timescale/promscale/pkg/ha"
 	"github.com/timescale/promscale/pkg/log"
 	"github.com/timescale/promscale/pkg/pgmodel/cache"
@@ -135,8 +136,14 @@ func NewClientWithPool(cfg *Config, numCopiers int, connPool *pgxpool.Pool, mt t
 	labelsCache := cache.NewLabelsCache(cfg.CacheConfig)
 	seriesCache := cache.NewSeriesCache(cfg.CacheConfig, sigClose)
 	c := ingestor.Cfg{
+		AsyncAcks:              cfg.AsyncAcks,
 		NumCopiers:             numCopiers,
 		IgnoreCompressedChunks: cfg.IgnoreCompressedChunks,
+		WALDir:                 cfg.AsyncAcksWALDir,
+		WALMaxSize:             cfg.AsyncAcksWALMaxBytes,
+		SpanMetrics:            cfg.SpanMetrics,
+		TraceSampling:          cfg.TraceSampling,
+		TraceIDCache:           cfg.TraceIDCache,
 	}
 
 	var (
@@ -196,8 +203,8 @@ func (c *Client) Ingestor() *ingestor.DBIngestor {
 }
 
 // Ingest writes the timeseries object into the DB
-func (c *Client) Ingest(r *prompb.WriteRequest) (uint64, uint64, error) {
-	return c.ingestor.Ingest(r)
+func (c *Client) Ingest(ctx context.Context, r *prompb.WriteRequest) (uint64, uint64, error) {
+	return c.ingestor.Ingest(ctx, r)
 }
 
 // IngestTraces writes the traces object into the DB.
@@ -228,6 +235,18 @@ func (c *Client) Read(req *prompb.ReadRequest) (*prompb.ReadResponse, error) {
 	return &resp, nil
 }
 
+// ReadSeries implements the querier.Reader interface. The series are read
+// from the database one at a time, while the caller iterates over them.
+func (c *Client) ReadSeries(ctx context.Context, q *prompb.Query) (querier.SeriesSet, error) {
+	return c.querier.ReadSeries(ctx, q)
+}
+
+// SeriesFilter returns the filter restricting label lookups to the series
+// matching the matchers, and to the series of the authorized tenants.
+func (c *Client) SeriesFilter(mint, maxt int64, ms ...*labels.Matcher) (*lreader.SeriesFilter, error) {
+	return c.querier.SeriesFilter(mint, maxt, ms...)
+}
+
 func (c *Client) NumCachedMetricNames() int {
 	return c.metricCache.Len()
 }
@@ -13,6 +13,7 @@ import (
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/promql/parser"
 	"github.com/prometheus/prometheus/storage"
+	"github.com/timescale/promscale/pkg/pgmodel/lreader"
 	"github.com/timescale/promscale/pkg/pgmodel/querier"
 	"github.com/timescale/promscale/pkg/prompb"
 )
@@ -26,7 +27,7 @@ type mockQuerier struct {
 
 var _ querier.Querier = (*mockQuerier)(nil)
 
-func (q *mockQuerier) SamplesQuerier() querier.SamplesQuerier {
+func (q *mockQuerier) SamplesQuerier(context.Context) querier.SamplesQuerier {
 	return mockSamplesQuerier{}
 }
 
@@ -40,10 +41,18 @@ func (q *mockQuerier) Query(*prompb.Query) ([]*prompb.TimeSeries, error) {
 	return q.tts, q.err
 }
 
+func (q *mockQuerier) ReadSeries(context.Context, *prompb.Query) (querier.SeriesSet, error) {
+	return nil, q.err
+}
+
 func (q *mockQuerier) ExemplarsQuerier(_ context.Context) querier.ExemplarQuerier {
 	return nil
 }
 
+func (q *mockQuerier) SeriesFilter(int64, int64, ...*labels.Matcher) (*lreader.SeriesFilter, error) {
+	return nil, nil
+}
+
 func (q *mockQuerier) LabelNames() ([]string, error) {
 	return q.labelNames, q.labelNamesErr
 }
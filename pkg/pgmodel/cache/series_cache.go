@@ -210,6 +210,38 @@ func generateKey(labels []prompb.Label, builder *bytes.Buffer) (metricName strin
 	return metricName, nil
 }
 
+// SeriesLabelsFromKey decodes the labels of a series from its string
+// representation, as returned by model.Series.String().
+func SeriesLabelsFromKey(key string) ([]prompb.Label, error) {
+	var (
+		labels []prompb.Label
+		next   = func() (string, error) {
+			if len(key) < 2 {
+				return "", fmt.Errorf("invalid series key: truncated length")
+			}
+			n := int(binary.LittleEndian.Uint16([]byte(key[:2])))
+			if len(key) < 2+n {
+				return "", fmt.Errorf("invalid series key: truncated string")
+			}
+			s := key[2 : 2+n]
+			key = key[2+n:]
+			return s, nil
+		}
+	)
+	for len(key) > 0 {
+		name, err := next()
+		if err != nil {
+			return nil, err
+		}
+		value, err := next()
+		if err != nil {
+			return nil, err
+		}
+		labels = append(labels, prompb.Label{Name: name, Value: value})
+	}
+	return labels, nil
+}
+
 var keyPool = sync.Pool{
 	New: func() interface{} {
 		return new(bytes.Buffer)
@@ -10,6 +10,8 @@ import (
 	"testing"
 
 	promLabels "github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/prompb"
 )
 
 func TestBigLables(t *testing.T) {
@@ -34,3 +36,21 @@ func TestBigLables(t *testing.T) {
 		t.Errorf("expected error")
 	}
 }
+
+func TestSeriesLabelsFromKey(t *testing.T) {
+	cache := NewSeriesCache(DefaultConfig, nil)
+	labels := []prompb.Label{
+		{Name: "__name__", Value: "test"},
+		{Name: "empty", Value: ""},
+		{Name: "job", Value: "promscale"},
+	}
+	series, _, err := cache.GetSeriesFromProtos(labels)
+	require.NoError(t, err)
+
+	decoded, err := SeriesLabelsFromKey(series.String())
+	require.NoError(t, err)
+	require.Equal(t, labels, decoded)
+
+	_, err = SeriesLabelsFromKey(series.String()[:5])
+	require.Error(t, err)
+}
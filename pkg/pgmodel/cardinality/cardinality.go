@@ -0,0 +1,166 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package cardinality computes the cardinality statistics of the series stored
+// in the database, in the shape of the Prometheus TSDB status.
+package cardinality
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/timescale/promscale/pkg/pgmodel/common/schema"
+	"github.com/timescale/promscale/pkg/pgmodel/lreader"
+	"github.com/timescale/promscale/pkg/pgxconn"
+)
+
+const (
+	// The statistics are computed on the series selected by the where
+	// clause of a series filter, in which the series table is aliased as s.
+	headStatsSQLFormat = `SELECT
+	(SELECT count(*) FROM ` + schema.Catalog + `.series s WHERE %s),
+	(SELECT count(*) FROM ` + schema.Catalog + `.label)`
+
+	// filteredHeadStatsSQLFormat only counts the label pairs of the
+	// filtered series, instead of all the label pairs.
+	filteredHeadStatsSQLFormat = `SELECT count(DISTINCT s.id), count(DISTINCT label_id) FILTER (WHERE label_id > 0)
+FROM ` + schema.Catalog + `.series s, unnest(s.labels) AS label_id
+WHERE %s`
+
+	seriesCountByMetricNameSQLFormat = `SELECT m.metric_name, count(*)
+FROM ` + schema.Catalog + `.series s
+INNER JOIN ` + schema.Catalog + `.metric m ON (m.id = s.metric_id)
+WHERE %s
+GROUP BY m.metric_name
+ORDER BY count(*) DESC, m.metric_name COLLATE "C"
+LIMIT $%d`
+
+	// labelStatsSQLFormat computes all the statistics of the labels with a
+	// single scan of the series table. The kind column tells which
+	// statistic a row belongs to.
+	labelStatsSQLFormat = `WITH pairs AS (
+	SELECT l.key, l.value, p.series_count
+	FROM (
+		SELECT label_id, count(*) AS series_count
+		FROM ` + schema.Catalog + `.series s, unnest(s.labels) AS label_id
+		WHERE %[1]s AND label_id > 0
+		GROUP BY label_id
+	) p
+	INNER JOIN ` + schema.Catalog + `.label l ON (l.id = p.label_id)
+)
+(SELECT 'label_value_count', key, count(*) AS stat
+	FROM pairs GROUP BY key ORDER BY stat DESC, key COLLATE "C" LIMIT $%[2]d)
+UNION ALL
+(SELECT 'memory_in_bytes', key, sum(octet_length(value) * series_count)::BIGINT AS stat
+	FROM pairs GROUP BY key ORDER BY stat DESC, key COLLATE "C" LIMIT $%[2]d)
+UNION ALL
+(SELECT 'series_count', key || '=' || value, series_count
+	FROM pairs ORDER BY series_count DESC, (key || '=' || value) COLLATE "C" LIMIT $%[2]d)`
+)
+
+// Stat is the value of a statistic for a name.
+type Stat struct {
+	Name  string `json:"name"`
+	Value uint64 `json:"value"`
+}
+
+// HeadStats holds the totals of the series and label pairs. It is named after
+// its counterpart in the Prometheus API.
+type HeadStats struct {
+	NumSeries     uint64 `json:"numSeries"`
+	NumLabelPairs int    `json:"numLabelPairs"`
+}
+
+// TSDBStatus holds the cardinality statistics, in the format of the response
+// of the Prometheus /api/v1/status/tsdb endpoint.
+type TSDBStatus struct {
+	HeadStats                   HeadStats `json:"headStats"`
+	SeriesCountByMetricName     []Stat    `json:"seriesCountByMetricName"`
+	LabelValueCountByLabelName  []Stat    `json:"labelValueCountByLabelName"`
+	MemoryInBytesByLabelName    []Stat    `json:"memoryInBytesByLabelName"`
+	SeriesCountByLabelValuePair []Stat    `json:"seriesCountByLabelValuePair"`
+}
+
+// Status returns the cardinality statistics of the series selected by the
+// filter, keeping the top limit entries of each statistic. A nil filter
+// selects all the series which are not marked for deletion. Memory in bytes is
+// the size of the label values multiplied by the number of series using them,
+// like in Prometheus.
+func Status(ctx context.Context, conn pgxconn.PgxConn, limit int, filter *lreader.SeriesFilter) (*TSDBStatus, error) {
+	status := &TSDBStatus{
+		SeriesCountByMetricName:     []Stat{},
+		LabelValueCountByLabelName:  []Stat{},
+		MemoryInBytesByLabelName:    []Stat{},
+		SeriesCountByLabelValuePair: []Stat{},
+	}
+
+	headStatsSQL := headStatsSQLFormat
+	if filter == nil {
+		filter = &lreader.SeriesFilter{}
+	} else {
+		headStatsSQL = filteredHeadStatsSQLFormat
+	}
+	where, args := filter.Where()
+	limitArgs := append(args, limit)
+
+	var numSeries, numLabelPairs int64
+	if err := conn.QueryRow(ctx, fmt.Sprintf(headStatsSQL, where), args...).Scan(&numSeries, &numLabelPairs); err != nil {
+		return nil, fmt.Errorf("query head stats: %w", err)
+	}
+	status.HeadStats = HeadStats{NumSeries: uint64(numSeries), NumLabelPairs: int(numLabelPairs)}
+
+	var err error
+	sql := fmt.Sprintf(seriesCountByMetricNameSQLFormat, where, len(limitArgs))
+	if status.SeriesCountByMetricName, err = queryStats(ctx, conn, sql, limitArgs); err != nil {
+		return nil, fmt.Errorf("query series count by metric name: %w", err)
+	}
+
+	rows, err := conn.Query(ctx, fmt.Sprintf(labelStatsSQLFormat, where, len(limitArgs)), limitArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("query label stats: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			kind, name string
+			value      int64
+		)
+		if err := rows.Scan(&kind, &name, &value); err != nil {
+			return nil, fmt.Errorf("query label stats: %w", err)
+		}
+		stat := Stat{Name: name, Value: uint64(value)}
+		switch kind {
+		case "label_value_count":
+			status.LabelValueCountByLabelName = append(status.LabelValueCountByLabelName, stat)
+		case "memory_in_bytes":
+			status.MemoryInBytesByLabelName = append(status.MemoryInBytesByLabelName, stat)
+		case "series_count":
+			status.SeriesCountByLabelValuePair = append(status.SeriesCountByLabelValuePair, stat)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query label stats: %w", err)
+	}
+	return status, nil
+}
+
+func queryStats(ctx context.Context, conn pgxconn.PgxConn, sql string, args []interface{}) ([]Stat, error) {
+	rows, err := conn.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	stats := []Stat{}
+	for rows.Next() {
+		var (
+			name  string
+			value int64
+		)
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		stats = append(stats, Stat{Name: name, Value: uint64(value)})
+	}
+	return stats, rows.Err()
+}
@@ -0,0 +1,84 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package cardinality
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/pgmodel/lreader"
+	"github.com/timescale/promscale/pkg/pgmodel/model"
+)
+
+func TestStatus(t *testing.T) {
+	mock := model.NewSqlRecorder([]model.SqlQuery{
+		{
+			Sql:     fmt.Sprintf(headStatsSQLFormat, "s.delete_epoch IS NULL AND TRUE"),
+			Results: model.RowResults{{int64(4), int64(6)}},
+		},
+		{
+			Sql:     fmt.Sprintf(seriesCountByMetricNameSQLFormat, "s.delete_epoch IS NULL AND TRUE", 1),
+			Args:    []interface{}{5},
+			Results: model.RowResults{{"cpu", int64(3)}, {"mem", int64(1)}},
+		},
+		{
+			Sql:  fmt.Sprintf(labelStatsSQLFormat, "s.delete_epoch IS NULL AND TRUE", 1),
+			Args: []interface{}{5},
+			Results: model.RowResults{
+				{"label_value_count", "job", int64(2)},
+				{"label_value_count", "instance", int64(1)},
+				{"memory_in_bytes", "job", int64(4)},
+				{"series_count", "job=a", int64(3)},
+			},
+		},
+	}, t)
+
+	status, err := Status(context.Background(), mock, 5, nil)
+	require.NoError(t, err)
+	require.Equal(t, &TSDBStatus{
+		HeadStats:                   HeadStats{NumSeries: 4, NumLabelPairs: 6},
+		SeriesCountByMetricName:     []Stat{{Name: "cpu", Value: 3}, {Name: "mem", Value: 1}},
+		LabelValueCountByLabelName:  []Stat{{Name: "job", Value: 2}, {Name: "instance", Value: 1}},
+		MemoryInBytesByLabelName:    []Stat{{Name: "job", Value: 4}},
+		SeriesCountByLabelValuePair: []Stat{{Name: "job=a", Value: 3}},
+	}, status)
+}
+
+func TestStatusFiltered(t *testing.T) {
+	filter := &lreader.SeriesFilter{
+		Clauses: []string{"labels && (SELECT COALESCE(array_agg(l.id), array[]::int[]) FROM _prom_catalog.label l WHERE l.key = $1 and l.value = $2)"},
+		Args:    []interface{}{"__tenant__", "a"},
+	}
+	where := "s.delete_epoch IS NULL AND (" + filter.Clauses[0] + ")"
+	mock := model.NewSqlRecorder([]model.SqlQuery{
+		{
+			Sql:     fmt.Sprintf(filteredHeadStatsSQLFormat, where),
+			Args:    []interface{}{"__tenant__", "a"},
+			Results: model.RowResults{{int64(1), int64(2)}},
+		},
+		{
+			Sql:     fmt.Sprintf(seriesCountByMetricNameSQLFormat, where, 3),
+			Args:    []interface{}{"__tenant__", "a", 5},
+			Results: model.RowResults{{"cpu", int64(1)}},
+		},
+		{
+			Sql:     fmt.Sprintf(labelStatsSQLFormat, where, 3),
+			Args:    []interface{}{"__tenant__", "a", 5},
+			Results: model.RowResults{{"series_count", "__tenant__=a", int64(1)}},
+		},
+	}, t)
+
+	status, err := Status(context.Background(), mock, 5, filter)
+	require.NoError(t, err)
+	require.Equal(t, &TSDBStatus{
+		HeadStats:                   HeadStats{NumSeries: 1, NumLabelPairs: 2},
+		SeriesCountByMetricName:     []Stat{{Name: "cpu", Value: 1}},
+		LabelValueCountByLabelName:  []Stat{},
+		MemoryInBytesByLabelName:    []Stat{},
+		SeriesCountByLabelValuePair: []Stat{{Name: "__tenant__=a", Value: 1}},
+	}, status)
+}
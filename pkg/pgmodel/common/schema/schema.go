@@ -10,6 +10,7 @@ const (
 	Data      = "prom_data"
 	Info      = "prom_info"
 	Exemplar  = "prom_data_exemplar"
+	Histogram = "prom_data_histogram"
 	Ext       = "_prom_ext"
 	Catalog   = "_prom_catalog"
 	Timescale = "public"
@@ -19,6 +19,7 @@ const flushSize = 2000
 type insertDataTask struct {
 	finished *sync.WaitGroup
 	errChan  chan error
+	err      *error
 }
 
 // Report that this task is completed, along with any error that may have
@@ -26,6 +27,7 @@ type insertDataTask struct {
 // _must never block_: blocking here will cause deadlocks.
 func (idt *insertDataTask) reportResult(err error) {
 	if err != nil {
+		*idt.err = err
 		select {
 		case idt.errChan <- err:
 		default:
@@ -79,6 +81,6 @@ func (p *pendingBuffer) release() {
 }
 
 func (p *pendingBuffer) addReq(req *insertDataRequest) {
-	p.needsResponse = append(p.needsResponse, insertDataTask{finished: req.finished, errChan: req.errChan})
+	p.needsResponse = append(p.needsResponse, insertDataTask{finished: req.finished, errChan: req.errChan, err: req.err})
 	p.batch.AppendSlice(req.data)
 }
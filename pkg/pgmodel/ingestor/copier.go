@@ -262,20 +262,29 @@ func debugInsert() {
 }
 */
 
+// insertStmt is a statement queued by insertSeries to insert the samples,
+// exemplars or native histograms of a table.
+type insertStmt struct {
+	table   string
+	numRows int
+	samples bool
+}
+
 // insertSeries performs the insertion of time-series into the DB.
 func insertSeries(conn pgxconn.PgxConn, reqs ...copyRequest) (error, int64) {
 	batch := conn.NewBatch()
 
-	numRowsPerInsert := make([]int, 0, len(reqs))
+	inserts := make([]insertStmt, 0, len(reqs))
 	numRowsTotal := 0
 	totalSamples := 0
 	totalExemplars := 0
+	totalHistograms := 0
 	lowestEpoch := pgmodel.SeriesEpoch(math.MaxInt64)
 	lowestMinTime := int64(math.MaxInt64)
 	for r := range reqs {
 		req := &reqs[r]
-		numSamples, numExemplars := req.data.batch.Count()
-		NumRowsPerInsert.Observe(float64(numSamples + numExemplars))
+		numSamples, numExemplars, numHistograms := req.data.batch.Count()
+		NumRowsPerInsert.Observe(float64(numSamples + numExemplars + numHistograms))
 
 		// flatten the various series into arrays.
 		// there are four main bottlenecks for insertion:
@@ -303,6 +312,8 @@ func insertSeries(conn pgxconn.PgxConn, reqs ...copyRequest) (error, int64) {
 			seriesIdExemplars []int64
 
 			exemplarLbls [][]string
+
+			histograms *histogramRows
 		)
 
 		if numSamples > 0 {
@@ -316,6 +327,9 @@ func insertSeries(conn pgxconn.PgxConn, reqs ...copyRequest) (error, int64) {
 			seriesIdExemplars = make([]int64, 0, numExemplars)
 			exemplarLbls = make([][]string, 0, numExemplars)
 		}
+		if numHistograms > 0 {
+			histograms = newHistogramRows(numHistograms)
+		}
 
 		visitor := req.data.batch.Visitor()
 		err := visitor.Visit(
@@ -332,6 +346,7 @@ func insertSeries(conn pgxconn.PgxConn, reqs ...copyRequest) (error, int64) {
 				seriesIdExemplars = append(seriesIdExemplars, seriesId)
 				exemplarLbls = append(exemplarLbls, lvalues)
 			},
+			histograms.append,
 		)
 		if err != nil {
 			return err, lowestMinTime
@@ -345,12 +360,13 @@ func insertSeries(conn pgxconn.PgxConn, reqs ...copyRequest) (error, int64) {
 			lowestMinTime = minTime
 		}
 
-		numRowsTotal += numSamples + numExemplars
+		numRowsTotal += numSamples + numExemplars + numHistograms
 		totalSamples += numSamples
 		totalExemplars += numExemplars
+		totalHistograms += numHistograms
 		if hasSamples {
-			numRowsPerInsert = append(numRowsPerInsert, numSamples)
-			batch.Queue("SELECT "+schema.Catalog+".insert_metric_row($1, $2::TIMESTAMPTZ[], $3::DOUBLE PRECISION[], $4::BIGINT[])", req.table, timeSamples, valSamples, seriesIdSamples)
+			inserts = append(inserts, insertStmt{table: req.table, numRows: numSamples, samples: true})
+			batch.Queue("SELECT inserted, rejected FROM "+schema.Catalog+".insert_metric_row_with_policy($1, $2::TIMESTAMPTZ[], $3::DOUBLE PRECISION[], $4::BIGINT[])", req.table, timeSamples, valSamples, seriesIdSamples)
 		}
 		if hasExemplars {
 			// We cannot send 2-D [][]TEXT to postgres via the pgx.encoder. For this and easier querying reasons, we create a
@@ -360,9 +376,13 @@ func insertSeries(conn pgxconn.PgxConn, reqs ...copyRequest) (error, int64) {
 			if err := labelValues.Set(exemplarLbls); err != nil {
 				return fmt.Errorf("setting prom_api.label_value_array[] value: %w", err), lowestMinTime
 			}
-			numRowsPerInsert = append(numRowsPerInsert, numExemplars)
+			inserts = append(inserts, insertStmt{table: req.table, numRows: numExemplars})
 			batch.Queue("SELECT "+schema.Catalog+".insert_exemplar_row($1::NAME, $2::TIMESTAMPTZ[], $3::BIGINT[], $4::"+schema.Prom+".label_value_array[], $5::DOUBLE PRECISION[])", req.table, timeExemplars, seriesIdExemplars, labelValues, valExemplars)
 		}
+		if numHistograms > 0 {
+			inserts = append(inserts, insertStmt{table: req.table, numRows: numHistograms})
+			histograms.queue(batch, req.table)
+		}
 	}
 
 	//note the epoch increment takes an access exclusive on the table before incrementing.
@@ -382,13 +402,20 @@ func insertSeries(conn pgxconn.PgxConn, reqs ...copyRequest) (error, int64) {
 	defer results.Close()
 
 	var affectedMetrics uint64
-	for _, numRows := range numRowsPerInsert {
-		var insertedRows int64
-		err := results.QueryRow().Scan(&insertedRows)
+	for _, insert := range inserts {
+		var insertedRows, rejectedRows int64
+		if insert.samples {
+			err = results.QueryRow().Scan(&insertedRows, &rejectedRows)
+		} else {
+			err = results.QueryRow().Scan(&insertedRows)
+		}
 		if err != nil {
 			return err, lowestMinTime
 		}
-		numRowsExpected := int64(numRows)
+		if rejectedRows > 0 {
+			registerRejected(insert.table, rejectedRows)
+		}
+		numRowsExpected := int64(insert.numRows) - rejectedRows
 		if numRowsExpected != insertedRows {
 			affectedMetrics++
 			registerDuplicates(numRowsExpected - insertedRows)
@@ -396,6 +423,7 @@ func insertSeries(conn pgxconn.PgxConn, reqs ...copyRequest) (error, int64) {
 	}
 	numSamplesInserted.Add(float64(totalSamples))
 	numExemplarsInserted.Add(float64(totalExemplars))
+	numHistogramsInserted.Add(float64(totalHistograms))
 
 	var val []byte
 	row := results.QueryRow()
@@ -6,11 +6,16 @@ package ingestor
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/jackc/pgconn"
 	"github.com/timescale/promscale/pkg/log"
 	"github.com/timescale/promscale/pkg/pgmodel/cache"
 	"github.com/timescale/promscale/pkg/pgmodel/common/schema"
@@ -24,6 +29,12 @@ const (
 	MetricBatcherChannelCap = 1000
 	finalizeMetricCreation  = "CALL " + schema.Catalog + ".finalize_metric_creation()"
 	getEpochSQL             = "SELECT current_epoch FROM " + schema.Catalog + ".ids_epoch LIMIT 1"
+
+	walMinRetryBackoff = time.Second
+	walMaxRetryBackoff = time.Minute
+	// walMaxInsertAttempts caps the number of attempts to insert the data
+	// held in the write-ahead log, about a quarter of an hour of retries.
+	walMaxInsertAttempts = 20
 )
 
 // pgxDispatcher redirects incoming samples to the appropriate metricBatcher
@@ -41,6 +52,9 @@ type pgxDispatcher struct {
 	doneChannel            chan struct{}
 	doneWG                 sync.WaitGroup
 	labelArrayOID          uint32
+	wal                    *wal
+	closeMut               sync.RWMutex
+	closed                 bool
 }
 
 func newPgxDispatcher(conn pgxconn.PgxConn, cache cache.MetricCache, scache cache.SeriesCache, eCache cache.PositionCache, cfg *Cfg) (*pgxDispatcher, error) {
@@ -95,6 +109,15 @@ func newPgxDispatcher(conn pgxconn.PgxConn, cache cache.MetricCache, scache cach
 
 	go inserter.runCompleteMetricCreationWorker()
 
+	if cfg.AsyncAcks && cfg.WALDir != "" {
+		w, records, err := openWAL(cfg.WALDir, cfg.WALMaxSize, scache)
+		if err != nil {
+			return nil, fmt.Errorf("opening write-ahead log: %w", err)
+		}
+		inserter.wal = w
+		inserter.replayWAL(records)
+	}
+
 	if !cfg.DisableEpochSync {
 		inserter.doneWG.Add(1)
 		go func() {
@@ -168,6 +191,14 @@ func (p *pgxDispatcher) CompleteMetricCreation() error {
 }
 
 func (p *pgxDispatcher) Close() {
+	if p.wal != nil {
+		if err := p.wal.close(); err != nil {
+			log.Error("msg", "Error closing the write-ahead log", "err", err)
+		}
+	}
+	p.closeMut.Lock()
+	p.closed = true
+	p.closeMut.Unlock()
 	close(p.completeMetricCreation)
 	p.batchers.Range(func(key, value interface{}) bool {
 		close(value.(chan *insertDataRequest))
@@ -184,12 +215,45 @@ func (p *pgxDispatcher) Close() {
 // actually inserted) and any error.
 // Though we may insert data to multiple tables concurrently, if asyncAcks is
 // unset this function will wait until _all_ the insert attempts have completed.
-func (p *pgxDispatcher) InsertTs(dataTS model.Data) (uint64, error) {
+// ctx only stops the data from being handed to the metric batchers: the
+// inserts already handed over are waited for, so that an error is never
+// returned for data which is stored.
+func (p *pgxDispatcher) InsertTs(ctx context.Context, dataTS model.Data) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	var segment *walSegment
+	if p.wal != nil && len(dataTS.Rows) > 0 {
+		var err error
+		if segment, err = p.wal.append(dataTS); err != nil {
+			return 0, fmt.Errorf("writing to the write-ahead log: %w", err)
+		}
+	}
+
+	if !p.asyncAcks {
+		numRows, maxt, wait, _ := p.dispatch(ctx, dataTS)
+		err := wait()
+		postIngestTasks(maxt, numRows, 0)
+		return numRows, err
+	}
+	// The data is acknowledged once dispatched, so all of it is handed over.
+	numRows, maxt, wait, metricErrors := p.dispatch(context.Background(), dataTS)
+	go p.finishAsync(dataTS, numRows, maxt, wait, metricErrors, segment)
+	return numRows, nil
+}
+
+// dispatch sends the data to the metric batchers. The returned function waits
+// until all the insert attempts have completed and returns the first error.
+// The errors of each metric are returned by metricErrors once wait has
+// returned. The metrics not yet handed to their batcher when ctx is done are
+// not inserted, and fail with the error of ctx.
+func (p *pgxDispatcher) dispatch(ctx context.Context, dataTS model.Data) (numRows uint64, maxt int64, wait func() error, metricErrors func() map[string]error) {
 	var (
-		numRows      uint64
-		maxt         int64
 		rows         = dataTS.Rows
 		workFinished = new(sync.WaitGroup)
+		// metricErrs is written by the inserters before they are done
+		// with the work, so it can be read once the work is finished.
+		metricErrs = make(map[string]*error, len(rows))
 	)
 	workFinished.Add(len(rows))
 	// we only allocate enough space for a single error message here as we only
@@ -204,42 +268,145 @@ func (p *pgxDispatcher) InsertTs(dataTS model.Data) (uint64, error) {
 				maxt = ts
 			}
 		}
+		metricErr := new(error)
+		metricErrs[metricName] = metricErr
+		req := &insertDataRequest{metric: metricName, data: data, finished: workFinished, errChan: errChan, err: metricErr}
 		// the following is usually non-blocking, just a channel insert
-		p.getMetricBatcher(metricName) <- &insertDataRequest{metric: metricName, data: data, finished: workFinished, errChan: errChan}
+		select {
+		case p.getMetricBatcher(metricName) <- req:
+		case <-ctx.Done():
+			req.reportResult(ctx.Err())
+		}
 	}
 	reportIncomingBatch(numRows)
-	reportOutgoing := func() {
-		reportOutgoingBatch(numRows)
-		reportBatchProcessingTime(dataTS.ReceivedTime)
-	}
 
-	var err error
-	if !p.asyncAcks {
+	wait = func() error {
 		workFinished.Wait()
-		reportOutgoing()
+		reportOutgoingBatch(numRows)
+		reportBatchProcessingTime(dataTS.ReceivedTime)
+		var err error
 		select {
 		case err = <-errChan:
 		default:
 		}
-		postIngestTasks(maxt, numRows, 0)
 		close(errChan)
-	} else {
-		go func() {
-			workFinished.Wait()
-			reportOutgoing()
-			select {
-			case err = <-errChan:
-			default:
+		return err
+	}
+	metricErrors = func() map[string]error {
+		errs := make(map[string]error)
+		for metricName, err := range metricErrs {
+			if *err != nil {
+				errs[metricName] = *err
 			}
-			close(errChan)
-			if err != nil {
-				log.Error("msg", fmt.Sprintf("error on async send, dropping %d datapoints", numRows), "err", err)
+		}
+		return errs
+	}
+	return numRows, maxt, wait, metricErrors
+}
+
+// finishAsync waits for the insert of data which was acknowledged already.
+// If the data is in the write-ahead log, the insert of the metrics which
+// failed because the database is unavailable is retried, up to
+// walMaxInsertAttempts times. The data is then removed from the log, whether
+// it is in the database or was dropped.
+func (p *pgxDispatcher) finishAsync(dataTS model.Data, numRows uint64, maxt int64, wait func() error, metricErrors func() map[string]error, segment *walSegment) {
+	err := wait()
+	backoff := walMinRetryBackoff
+	for attempt := 1; err != nil && segment != nil; attempt++ {
+		errs := metricErrors()
+		if len(errs) == 0 {
+			break
+		}
+		retry := model.Data{Rows: make(map[string][]model.Insertable), ReceivedTime: dataTS.ReceivedTime}
+		for metricName, metricErr := range errs {
+			if isRetryableInsertError(metricErr) && attempt < walMaxInsertAttempts {
+				retry.Rows[metricName] = dataTS.Rows[metricName]
+				continue
 			}
-			postIngestTasks(maxt, numRows, 0)
-		}()
+			log.Error("msg", fmt.Sprintf("error on async send, dropping %d datapoints of metric %s", countRows(dataTS.Rows[metricName]), metricName), "err", metricErr, "attempts", attempt)
+		}
+		if len(retry.Rows) == 0 {
+			// The errors were logged for each metric.
+			err = nil
+			break
+		}
+		log.Warn("msg", fmt.Sprintf("error on async send, retrying %d metrics from the write-ahead log", len(retry.Rows)), "err", err, "backoff", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-p.doneChannel:
+			// The data stays in the log and is replayed on the next start.
+			return
+		}
+		if backoff *= 2; backoff > walMaxRetryBackoff {
+			backoff = walMaxRetryBackoff
+		}
+		walInsertRetries.Inc()
+
+		p.closeMut.RLock()
+		if p.closed {
+			p.closeMut.RUnlock()
+			return
+		}
+		_, _, wait, metricErrors = p.dispatch(context.Background(), retry)
+		p.closeMut.RUnlock()
+		dataTS = retry
+		err = wait()
 	}
+	if err != nil {
+		log.Error("msg", fmt.Sprintf("error on async send, dropping %d datapoints", numRows), "err", err)
+	}
+	postIngestTasks(maxt, numRows, 0)
+	if segment != nil {
+		p.wal.done(segment)
+	}
+}
+
+func countRows(data []model.Insertable) (n int) {
+	for _, insertable := range data {
+		n += insertable.Count()
+	}
+	return n
+}
 
-	return numRows, err
+// replayWAL inserts the data left in the write-ahead log by a previous run.
+func (p *pgxDispatcher) replayWAL(records []walRecord) {
+	var total uint64
+	for _, r := range records {
+		numRows, maxt, wait, metricErrors := p.dispatch(context.Background(), r.data)
+		total += numRows
+		go p.finishAsync(r.data, numRows, maxt, wait, metricErrors, r.segment)
+	}
+	walReplayedSamples.Add(float64(total))
+	if len(records) > 0 {
+		log.Info("msg", "Replaying the write-ahead log", "records", len(records), "datapoints", total)
+	}
+}
+
+// isRetryableInsertError returns true if the insert error is caused by the
+// connection to the database being lost or by a conflict with another
+// transaction, rather than by the data being rejected.
+func isRetryableInsertError(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case strings.HasPrefix(pgErr.Code, "08"): // connection exception
+			return true
+		}
+		switch pgErr.Code {
+		case "40001", // serialization_failure
+			"40P01", // deadlock_detected
+			"57P01", // admin_shutdown
+			"57P02", // crash_shutdown
+			"57P03": // cannot_connect_now, e.g. during a failover
+			return true
+		}
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		pgconn.SafeToRetry(err)
 }
 
 func (p *pgxDispatcher) InsertMetadata(metadata []model.Metadata) (uint64, error) {
@@ -293,10 +460,13 @@ type insertDataRequest struct {
 	finished *sync.WaitGroup
 	data     []model.Insertable
 	errChan  chan error
+	// err is set to the error of the insert, if any.
+	err *error
 }
 
 func (idr *insertDataRequest) reportResult(err error) {
 	if err != nil {
+		*idr.err = err
 		select {
 		case idr.errChan <- err:
 		default:
@@ -29,6 +29,11 @@ func registerDuplicates(duplicateSamples int64) {
 	metrics.DuplicateWrites.Inc()
 }
 
+func registerRejected(table string, rejectedSamples int64) {
+	metrics.RejectedSamples.Add(float64(rejectedSamples))
+	log.WarnRateLimited("msg", "samples rejected by the duplicate policy", "table", table, "rejected-samples", rejectedSamples)
+}
+
 func reportDuplicates(duplicateMetrics uint64) {
 	atomic.AddUint64(&duplicateMetricsTotal, duplicateMetrics)
 	metrics.DuplicateMetrics.Add(float64(duplicateMetrics))
@@ -0,0 +1,123 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package ingestor
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/timescale/promscale/pkg/pgmodel/common/schema"
+	pgmodel "github.com/timescale/promscale/pkg/pgmodel/model"
+	"github.com/timescale/promscale/pkg/pgxconn"
+)
+
+const (
+	createHistogramTable = "SELECT * FROM " + schema.Catalog + ".create_histogram_table_if_not_exists($1)"
+	insertHistogramRows  = "SELECT " + schema.Catalog + ".insert_histogram_row($1::NAME, $2::TIMESTAMPTZ[], $3::BIGINT[], " +
+		"$4::DOUBLE PRECISION[], $5::DOUBLE PRECISION[], $6::INTEGER[], $7::DOUBLE PRECISION[], $8::DOUBLE PRECISION[], " +
+		"$9::INTEGER[], $10::TEXT[], $11::INTEGER[], $12::TEXT[])"
+)
+
+// histogramRows holds the native histograms of a metric flattened into
+// arrays, one per column of the histogram table.
+//
+// Bucket counts are sent as array literals, since pgx cannot send arrays of
+// arrays with different lengths; they are cast back to DOUBLE PRECISION[] by
+// insert_histogram_row().
+type histogramRows struct {
+	times          []time.Time
+	seriesIds      []int64
+	counts         []float64
+	sums           []float64
+	schemas        []int32
+	zeroThresholds []float64
+	zeroCounts     []float64
+	posOffsets     []int32
+	posCounts      []string
+	negOffsets     []int32
+	negCounts      []string
+}
+
+func newHistogramRows(n int) *histogramRows {
+	return &histogramRows{
+		times:          make([]time.Time, 0, n),
+		seriesIds:      make([]int64, 0, n),
+		counts:         make([]float64, 0, n),
+		sums:           make([]float64, 0, n),
+		schemas:        make([]int32, 0, n),
+		zeroThresholds: make([]float64, 0, n),
+		zeroCounts:     make([]float64, 0, n),
+		posOffsets:     make([]int32, 0, n),
+		posCounts:      make([]string, 0, n),
+		negOffsets:     make([]int32, 0, n),
+		negCounts:      make([]string, 0, n),
+	}
+}
+
+func (r *histogramRows) append(t time.Time, h *pgmodel.NativeHistogram, seriesId int64) {
+	r.times = append(r.times, t)
+	r.seriesIds = append(r.seriesIds, seriesId)
+	r.counts = append(r.counts, h.Count)
+	r.sums = append(r.sums, h.Sum)
+	r.schemas = append(r.schemas, h.Schema)
+	r.zeroThresholds = append(r.zeroThresholds, h.ZeroThreshold)
+	r.zeroCounts = append(r.zeroCounts, h.ZeroCount)
+	r.posOffsets = append(r.posOffsets, h.PositiveOffset)
+	r.posCounts = append(r.posCounts, floatArrayLiteral(h.PositiveCounts))
+	r.negOffsets = append(r.negOffsets, h.NegativeOffset)
+	r.negCounts = append(r.negCounts, floatArrayLiteral(h.NegativeCounts))
+}
+
+func (r *histogramRows) queue(batch pgxconn.PgxBatch, table string) {
+	batch.Queue(insertHistogramRows, table, r.times, r.seriesIds, r.counts, r.sums, r.schemas,
+		r.zeroThresholds, r.zeroCounts, r.posOffsets, r.posCounts, r.negOffsets, r.negCounts)
+}
+
+// floatArrayLiteral formats the values as a Postgres array literal.
+func floatArrayLiteral(values []float64) string {
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		switch {
+		case math.IsInf(v, 1):
+			sb.WriteString("Infinity")
+		case math.IsInf(v, -1):
+			sb.WriteString("-Infinity")
+		default:
+			// NaN is formatted as Postgres expects it.
+			sb.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+		}
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+func containsHistograms(data []pgmodel.Insertable) bool {
+	for _, row := range data {
+		if row.IsOfType(pgmodel.Histogram) {
+			return true
+		}
+	}
+	return false
+}
+
+// initializeHistograms creates the table holding the native histograms of the
+// metric. Called lazily, the first time a native histogram of the metric is
+// seen.
+func initializeHistograms(conn pgxconn.PgxConn, metricName string) error {
+	var created bool
+	err := conn.QueryRow(context.Background(), createHistogramTable, metricName).Scan(&created)
+	if err != nil {
+		return fmt.Errorf("error initializing histogram table for %s: %w", metricName, err)
+	}
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/timescale/promscale/pkg/log"
 	"github.com/timescale/promscale/pkg/pgmodel/cache"
 	"github.com/timescale/promscale/pkg/pgmodel/common/errors"
 	"github.com/timescale/promscale/pkg/pgmodel/ingestor/trace"
@@ -24,6 +25,19 @@ type Cfg struct {
 	NumCopiers             int
 	DisableEpochSync       bool
 	IgnoreCompressedChunks bool
+	// WALDir is the directory of the write-ahead log keeping the data
+	// acknowledged with async acks until it is inserted. Empty disables it.
+	WALDir string
+	// WALMaxSize is the maximum size of the write-ahead log, in bytes.
+	WALMaxSize int64
+	// SpanMetrics configures the metrics derived from the ingested spans.
+	SpanMetrics trace.SpanMetricsConfig
+	// TraceSampling configures the tail-based sampling of the ingested
+	// traces.
+	TraceSampling trace.SamplingConfig
+	// TraceIDCache sets the sizes of the caches of the IDs of the trace
+	// tags, operations, schema URLs and instrumentation libraries.
+	TraceIDCache trace.IDCacheConfig
 }
 
 // DBIngestor ingest the TimeSeries data into Timescale database.
@@ -31,6 +45,11 @@ type DBIngestor struct {
 	sCache     cache.SeriesCache
 	dispatcher model.Dispatcher
 	tWriter    trace.Writer
+	// spanMetrics is nil when span metrics are disabled.
+	spanMetrics *trace.SpanMetrics
+	// sampler is nil when trace sampling is disabled. It is also tWriter
+	// otherwise.
+	sampler *trace.Sampler
 }
 
 // NewPgxIngestor returns a new Ingestor that uses connection pool and a metrics cache
@@ -40,18 +59,28 @@ func NewPgxIngestor(conn pgxconn.PgxConn, cache cache.MetricCache, sCache cache.
 	if err != nil {
 		return nil, err
 	}
-	return &DBIngestor{
+	ingestor := &DBIngestor{
 		sCache:     sCache,
 		dispatcher: dispatcher,
-		tWriter:    trace.NewWriter(conn),
-	}, nil
+		tWriter:    trace.NewWriter(conn, cfg.TraceIDCache),
+	}
+	if cfg.TraceSampling.DecisionWait > 0 {
+		ingestor.sampler = trace.NewSampler(cfg.TraceSampling, ingestor.tWriter)
+		ingestor.tWriter = ingestor.sampler
+	}
+	if cfg.SpanMetrics.Enabled {
+		if ingestor.spanMetrics, err = trace.NewSpanMetrics(cfg.SpanMetrics); err != nil {
+			return nil, err
+		}
+	}
+	return ingestor, nil
 }
 
 // NewPgxIngestorForTests returns a new Ingestor that write to PostgreSQL using PGX
 // with an empty config, a new default size metrics cache and a non-ha-aware data parser
 func NewPgxIngestorForTests(conn pgxconn.PgxConn, cfg *Cfg) (*DBIngestor, error) {
 	if cfg == nil {
-		cfg = &Cfg{}
+		cfg = &Cfg{TraceIDCache: trace.DefaultIDCacheConfig}
 	}
 	cacheConfig := cache.DefaultConfig
 	c := cache.NewMetricCache(cacheConfig)
@@ -72,16 +101,37 @@ type result struct {
 	err     error
 }
 
+// IngestTraces inserts the traces, and the metrics derived from their spans
+// when span metrics are enabled. With trace sampling, the traces are buffered
+// until they are sampled, and the span metrics are derived from all the
+// spans, sampled or not. The span metrics are not retried when they fail to
+// be ingested, since the client would retry the stored traces along.
 func (ingestor *DBIngestor) IngestTraces(ctx context.Context, traces pdata.Traces) error {
-	return ingestor.tWriter.InsertTraces(ctx, traces)
+	if err := ingestor.tWriter.InsertTraces(ctx, traces); err != nil {
+		return err
+	}
+	if ingestor.spanMetrics == nil {
+		return nil
+	}
+	timeseries := ingestor.spanMetrics.Record(traces, time.Now())
+	if len(timeseries) == 0 {
+		return nil
+	}
+	if _, err := ingestor.ingestTimeseries(ctx, timeseries, func() {}); err != nil {
+		trace.SpanMetricsFailures.Inc()
+		log.Warn("msg", "Error ingesting span metrics", "err", err)
+	}
+	return nil
 }
 
 // Ingest transforms and ingests the timeseries data into Timescale database.
 // input:
+//     ctx the context of the request. The ingest returns when it is done,
+//         even though the data may still be inserted.
 //     tts the []Timeseries to insert
 //     req the WriteRequest backing tts. It will be added to our WriteRequest
 //         pool when it is no longer needed.
-func (ingestor *DBIngestor) Ingest(r *prompb.WriteRequest) (numInsertablesIngested uint64, numMetadataIngested uint64, err error) {
+func (ingestor *DBIngestor) Ingest(ctx context.Context, r *prompb.WriteRequest) (numInsertablesIngested uint64, numMetadataIngested uint64, err error) {
 	activeWriteRequests.Inc()
 	defer activeWriteRequests.Dec() // Dec() is defered otherwise it will lead to loosing a decrement if some error occurs.
 	var (
@@ -92,7 +142,7 @@ func (ingestor *DBIngestor) Ingest(r *prompb.WriteRequest) (numInsertablesIngest
 	switch numTs, numMeta := len(timeseries), len(metadata); {
 	case numTs > 0 && numMeta == 0:
 		// Write request contains only time-series.
-		n, err := ingestor.ingestTimeseries(timeseries, release)
+		n, err := ingestor.ingestTimeseries(ctx, timeseries, release)
 		return n, 0, err
 	case numTs == 0 && numMeta == 0:
 		release()
@@ -113,7 +163,7 @@ func (ingestor *DBIngestor) Ingest(r *prompb.WriteRequest) (numInsertablesIngest
 	defer close(res)
 
 	go func() {
-		n, err := ingestor.ingestTimeseries(timeseries, release)
+		n, err := ingestor.ingestTimeseries(ctx, timeseries, release)
 		res <- result{series, n, err}
 	}()
 	go func() {
@@ -149,7 +199,7 @@ func (ingestor *DBIngestor) Ingest(r *prompb.WriteRequest) (numInsertablesIngest
 	return numInsertablesIngested, numMetadataIngested, err
 }
 
-func (ingestor *DBIngestor) ingestTimeseries(timeseries []prompb.TimeSeries, releaseMem func()) (uint64, error) {
+func (ingestor *DBIngestor) ingestTimeseries(ctx context.Context, timeseries []prompb.TimeSeries, releaseMem func()) (uint64, error) {
 	var (
 		totalRowsExpected uint64
 
@@ -193,14 +243,23 @@ func (ingestor *DBIngestor) ingestTimeseries(timeseries []prompb.TimeSeries, rel
 			totalRowsExpected += uint64(count)
 			insertables[metricName] = append(insertables[metricName], exemplars)
 		}
+		if len(ts.Histograms) > 0 {
+			histograms, count, err := ingestor.histograms(series, ts)
+			if err != nil {
+				return 0, fmt.Errorf("histograms: %w", err)
+			}
+			totalRowsExpected += uint64(count)
+			insertables[metricName] = append(insertables[metricName], histograms)
+		}
 		// we're going to free req after this, but we still need the samples,
 		// so nil the field
 		ts.Samples = nil
 		ts.Exemplars = nil
+		ts.Histograms = nil
 	}
 	releaseMem()
 
-	numInsertablesIngested, errSamples := ingestor.dispatcher.InsertTs(model.Data{Rows: insertables, ReceivedTime: time.Now()})
+	numInsertablesIngested, errSamples := ingestor.dispatcher.InsertTs(ctx, model.Data{Rows: insertables, ReceivedTime: time.Now()})
 	if errSamples == nil && numInsertablesIngested != totalRowsExpected {
 		return numInsertablesIngested, fmt.Errorf("failed to insert all the data! Expected: %d, Got: %d", totalRowsExpected, numInsertablesIngested)
 	}
@@ -215,6 +274,20 @@ func (ingestor *DBIngestor) exemplars(l *model.Series, ts *prompb.TimeSeries) (m
 	return model.NewPromExemplars(l, ts.Exemplars), len(ts.Exemplars), nil
 }
 
+// histograms decodes the native histograms of the time series, which are
+// copied out of the request so that it can be released.
+func (ingestor *DBIngestor) histograms(l *model.Series, ts *prompb.TimeSeries) (model.Insertable, int, error) {
+	histograms := make([]model.NativeHistogram, len(ts.Histograms))
+	for i := range ts.Histograms {
+		h, err := model.NewNativeHistogram(&ts.Histograms[i])
+		if err != nil {
+			return nil, 0, err
+		}
+		histograms[i] = h
+	}
+	return model.NewPromHistograms(l, histograms), len(histograms), nil
+}
+
 // ingestMetadata ingests metric metadata received from Prometheus. It runs as a secondary routine, independent from
 // the main dataflow (i.e., samples ingestion) since metadata ingestion is not as frequent as that of samples.
 func (ingestor *DBIngestor) ingestMetadata(metadata []prompb.MetricMetadata, releaseMem func()) (uint64, error) {
@@ -244,5 +317,8 @@ func (ingestor *DBIngestor) CompleteMetricCreation() error {
 
 // Close closes the ingestor
 func (ingestor *DBIngestor) Close() {
+	if ingestor.sampler != nil {
+		ingestor.sampler.Close()
+	}
 	ingestor.dispatcher.Close()
 }
@@ -16,6 +16,6 @@ import (
 type DBInserter interface {
 	// Ingest takes an array of TimeSeries and attepts to store it into the database.
 	// Returns the number of metrics ingested and any error encountered before finishing.
-	Ingest(*prompb.WriteRequest) (uint64, uint64, error)
+	Ingest(context.Context, *prompb.WriteRequest) (uint64, uint64, error)
 	IngestTraces(context.Context, pdata.Traces) error
 }
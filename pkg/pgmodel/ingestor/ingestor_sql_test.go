@@ -5,6 +5,7 @@
 package ingestor
 
 import (
+	"context"
 	"fmt"
 	"testing"
 	"time"
@@ -535,14 +536,48 @@ func TestPGXInserterInsertData(t *testing.T) {
 					Err:     error(nil),
 				},
 				{
-					Sql: "SELECT _prom_catalog.insert_metric_row($1, $2::TIMESTAMPTZ[], $3::DOUBLE PRECISION[], $4::BIGINT[])",
+					Sql: "SELECT inserted, rejected FROM _prom_catalog.insert_metric_row_with_policy($1, $2::TIMESTAMPTZ[], $3::DOUBLE PRECISION[], $4::BIGINT[])",
 					Args: []interface{}{
 						"metric_0",
 						[]time.Time{time.Unix(0, 0)},
 						[]float64{0},
 						[]int64{1},
 					},
-					Results: model.RowResults{{int64(1)}},
+					Results: model.RowResults{{int64(1), int64(0)}},
+					Err:     error(nil),
+				},
+				{
+					Sql:     "SELECT CASE current_epoch > $1::BIGINT + 1 WHEN true THEN _prom_catalog.epoch_abort($1) END FROM _prom_catalog.ids_epoch LIMIT 1",
+					Args:    []interface{}{int64(1)},
+					Results: model.RowResults{{[]byte{}}},
+					Err:     error(nil),
+				},
+			},
+		},
+		{
+			name: "Samples rejected by duplicate policy",
+			rows: map[string][]model.Insertable{
+				"metric_0": {model.NewPromSamples(makeLabel(), make([]prompb.Sample, 1))},
+			},
+			sqlQueries: []model.SqlQuery{
+				{Sql: "SELECT 'prom_api.label_array'::regtype::oid", Results: model.RowResults{{uint32(434)}}},
+				{Sql: "SELECT 'prom_api.label_value_array'::regtype::oid", Results: model.RowResults{{uint32(435)}}},
+				{Sql: "CALL _prom_catalog.finalize_metric_creation()"},
+				{
+					Sql:     "SELECT table_name, possibly_new FROM _prom_catalog.get_or_create_metric_table_name($1)",
+					Args:    []interface{}{"metric_0"},
+					Results: model.RowResults{{"metric_0", false}},
+					Err:     error(nil),
+				},
+				{
+					Sql: "SELECT inserted, rejected FROM _prom_catalog.insert_metric_row_with_policy($1, $2::TIMESTAMPTZ[], $3::DOUBLE PRECISION[], $4::BIGINT[])",
+					Args: []interface{}{
+						"metric_0",
+						[]time.Time{time.Unix(0, 0)},
+						[]float64{0},
+						[]int64{1},
+					},
+					Results: model.RowResults{{int64(0), int64(1)}},
 					Err:     error(nil),
 				},
 				{
@@ -573,14 +608,14 @@ func TestPGXInserterInsertData(t *testing.T) {
 				},
 
 				{
-					Sql: "SELECT _prom_catalog.insert_metric_row($1, $2::TIMESTAMPTZ[], $3::DOUBLE PRECISION[], $4::BIGINT[])",
+					Sql: "SELECT inserted, rejected FROM _prom_catalog.insert_metric_row_with_policy($1, $2::TIMESTAMPTZ[], $3::DOUBLE PRECISION[], $4::BIGINT[])",
 					Args: []interface{}{
 						"metric_0",
 						[]time.Time{time.Unix(0, 0), time.Unix(0, 0)},
 						[]float64{0, 0},
 						[]int64{1, 1},
 					},
-					Results: model.RowResults{{int64(1)}},
+					Results: model.RowResults{{int64(1), int64(0)}},
 					Err:     error(nil),
 				},
 				{
@@ -633,14 +668,14 @@ func TestPGXInserterInsertData(t *testing.T) {
 				},
 
 				{
-					Sql: "SELECT _prom_catalog.insert_metric_row($1, $2::TIMESTAMPTZ[], $3::DOUBLE PRECISION[], $4::BIGINT[])",
+					Sql: "SELECT inserted, rejected FROM _prom_catalog.insert_metric_row_with_policy($1, $2::TIMESTAMPTZ[], $3::DOUBLE PRECISION[], $4::BIGINT[])",
 					Args: []interface{}{
 						"metric_0",
 						[]time.Time{time.Unix(0, 0)},
 						[]float64{0},
 						[]int64{1},
 					},
-					Results: model.RowResults{{int64(1)}},
+					Results: model.RowResults{{int64(1), int64(0)}},
 					Err:     error(nil),
 				},
 				{
@@ -652,14 +687,14 @@ func TestPGXInserterInsertData(t *testing.T) {
 				},
 
 				{
-					Sql: "SELECT _prom_catalog.insert_metric_row($1, $2::TIMESTAMPTZ[], $3::DOUBLE PRECISION[], $4::BIGINT[])",
+					Sql: "SELECT inserted, rejected FROM _prom_catalog.insert_metric_row_with_policy($1, $2::TIMESTAMPTZ[], $3::DOUBLE PRECISION[], $4::BIGINT[])",
 					Args: []interface{}{
 						"metric_0",
 						[]time.Time{time.Unix(0, 0)},
 						[]float64{0},
 						[]int64{1},
 					},
-					Results: model.RowResults{{int64(1)}},
+					Results: model.RowResults{{int64(1), int64(0)}},
 					Err:     error(nil),
 				},
 				{
@@ -695,14 +730,14 @@ func TestPGXInserterInsertData(t *testing.T) {
 				},
 
 				{
-					Sql: "SELECT _prom_catalog.insert_metric_row($1, $2::TIMESTAMPTZ[], $3::DOUBLE PRECISION[], $4::BIGINT[])",
+					Sql: "SELECT inserted, rejected FROM _prom_catalog.insert_metric_row_with_policy($1, $2::TIMESTAMPTZ[], $3::DOUBLE PRECISION[], $4::BIGINT[])",
 					Args: []interface{}{
 						"metric_0",
 						[]time.Time{time.Unix(0, 0), time.Unix(0, 0), time.Unix(0, 0), time.Unix(0, 0), time.Unix(0, 0)},
 						make([]float64, 5),
 						[]int64{1, 1, 1, 1, 1},
 					},
-					Results: model.RowResults{{int64(1)}},
+					Results: model.RowResults{{int64(1), int64(0)}},
 					Err:     fmt.Errorf("some INSERT error"),
 				},
 				{
@@ -714,14 +749,14 @@ func TestPGXInserterInsertData(t *testing.T) {
 				},
 
 				{
-					Sql: "SELECT _prom_catalog.insert_metric_row($1, $2::TIMESTAMPTZ[], $3::DOUBLE PRECISION[], $4::BIGINT[])",
+					Sql: "SELECT inserted, rejected FROM _prom_catalog.insert_metric_row_with_policy($1, $2::TIMESTAMPTZ[], $3::DOUBLE PRECISION[], $4::BIGINT[])",
 					Args: []interface{}{
 						"metric_0",
 						[]time.Time{time.Unix(0, 0), time.Unix(0, 0), time.Unix(0, 0), time.Unix(0, 0), time.Unix(0, 0)},
 						make([]float64, 5),
 						[]int64{1, 1, 1, 1, 1},
 					},
-					Results: model.RowResults{{int64(1)}},
+					Results: model.RowResults{{int64(1), int64(0)}},
 					Err:     fmt.Errorf("some INSERT error"),
 				},
 				{
@@ -778,14 +813,14 @@ func TestPGXInserterInsertData(t *testing.T) {
 				},
 				{Sql: "CALL _prom_catalog.finalize_metric_creation()"},
 				{
-					Sql: "SELECT _prom_catalog.insert_metric_row($1, $2::TIMESTAMPTZ[], $3::DOUBLE PRECISION[], $4::BIGINT[])",
+					Sql: "SELECT inserted, rejected FROM _prom_catalog.insert_metric_row_with_policy($1, $2::TIMESTAMPTZ[], $3::DOUBLE PRECISION[], $4::BIGINT[])",
 					Args: []interface{}{
 						"metric_0",
 						[]time.Time{time.Unix(0, 0)},
 						[]float64{0},
 						[]int64{1},
 					},
-					Results: model.RowResults{{int64(1)}},
+					Results: model.RowResults{{int64(1), int64(0)}},
 					Err:     error(nil),
 				},
 				{
@@ -825,7 +860,7 @@ func TestPGXInserterInsertData(t *testing.T) {
 			}
 			defer inserter.Close()
 
-			_, err = inserter.InsertTs(model.Data{Rows: c.rows})
+			_, err = inserter.InsertTs(context.Background(), model.Data{Rows: c.rows})
 
 			var expErr error
 			switch {
@@ -860,3 +895,26 @@ func TestPGXInserterInsertData(t *testing.T) {
 		})
 	}
 }
+
+func TestPGXInserterInsertCanceled(t *testing.T) {
+	// No data is inserted, only the start-up queries are run.
+	mock := model.NewSqlRecorder([]model.SqlQuery{
+		{Sql: "SELECT 'prom_api.label_array'::regtype::oid", Results: model.RowResults{{uint32(434)}}},
+		{Sql: "SELECT 'prom_api.label_value_array'::regtype::oid", Results: model.RowResults{{uint32(435)}}},
+		{Sql: "CALL _prom_catalog.finalize_metric_creation()"},
+	}, t)
+	mockMetrics := &model.MockMetricCache{MetricCache: make(map[string]model.MetricInfo)}
+	inserter, err := newPgxDispatcher(mock, mockMetrics, cache.NewSeriesCache(cache.DefaultConfig, nil), nil, &Cfg{DisableEpochSync: true})
+	require.NoError(t, err)
+	defer inserter.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	series := &model.Series{}
+	series.SetSeriesID(1, 1)
+	rows := map[string][]model.Insertable{
+		"metric_0": {model.NewPromSamples(series, make([]prompb.Sample, 1))},
+	}
+	_, err = inserter.InsertTs(ctx, model.Data{Rows: rows})
+	require.True(t, errors.Is(err, context.Canceled), "unexpected error: %v", err)
+}
@@ -5,13 +5,18 @@
 package ingestor
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
 	"github.com/timescale/promscale/pkg/pgmodel/cache"
 	"github.com/timescale/promscale/pkg/pgmodel/common/errors"
+	"github.com/timescale/promscale/pkg/pgmodel/ingestor/trace"
 	"github.com/timescale/promscale/pkg/pgmodel/model"
 	"github.com/timescale/promscale/pkg/prompb"
+	"go.opentelemetry.io/collector/model/pdata"
 )
 
 func TestDBIngestorIngest(t *testing.T) {
@@ -266,7 +271,7 @@ func TestDBIngestorIngest(t *testing.T) {
 			wr := NewWriteRequest()
 			wr.Timeseries = c.metrics
 			wr.Metadata = c.metadata
-			countSamples, countMetadata, err := i.Ingest(wr)
+			countSamples, countMetadata, err := i.Ingest(context.Background(), wr)
 
 			if err != nil {
 				if c.insertSeriesErr != nil && err != c.insertSeriesErr {
@@ -310,3 +315,49 @@ func TestDBIngestorIngest(t *testing.T) {
 		})
 	}
 }
+
+type mockTraceWriter struct {
+	err error
+}
+
+func (m mockTraceWriter) InsertTraces(context.Context, pdata.Traces) error {
+	return m.err
+}
+
+func TestDBIngestorIngestTracesSpanMetrics(t *testing.T) {
+	traces := pdata.NewTraces()
+	spans := traces.ResourceSpans().AppendEmpty().InstrumentationLibrarySpans().AppendEmpty().Spans()
+	spans.AppendEmpty().SetName("operation")
+
+	newIngestor := func(writerErr, insertErr error) (*DBIngestor, *model.MockInserter) {
+		inserter := &model.MockInserter{InsertedSeries: make(map[string]model.SeriesID), InsertDataErr: insertErr}
+		spanMetrics, err := trace.NewSpanMetrics(trace.SpanMetricsConfig{Buckets: trace.SpanMetricsBuckets{1}, Instance: "promscale"})
+		require.NoError(t, err)
+		return &DBIngestor{
+			sCache:      cache.NewSeriesCache(cache.DefaultConfig, nil),
+			dispatcher:  inserter,
+			tWriter:     mockTraceWriter{err: writerErr},
+			spanMetrics: spanMetrics,
+		}, inserter
+	}
+
+	i, inserter := newIngestor(nil, nil)
+	require.NoError(t, i.IngestTraces(context.Background(), traces))
+	// calls, 2 buckets, sum and count
+	require.Len(t, inserter.InsertedSeries, 5)
+	require.Len(t, inserter.InsertedData, 1)
+	require.Contains(t, inserter.InsertedData[0], "trace_span_calls_total")
+
+	// No metrics are derived from traces that failed to be inserted.
+	writerErr := fmt.Errorf("insert failed")
+	i, inserter = newIngestor(writerErr, nil)
+	require.Equal(t, writerErr, i.IngestTraces(context.Background(), traces))
+	require.Empty(t, inserter.InsertedData)
+
+	// The traces are acknowledged when only the metrics fail, so that they
+	// are not retried.
+	i, _ = newIngestor(nil, fmt.Errorf("insert failed"))
+	failures := testutil.ToFloat64(trace.SpanMetricsFailures)
+	require.NoError(t, i.IngestTraces(context.Background(), traces))
+	require.Equal(t, failures+1, testutil.ToFloat64(trace.SpanMetricsFailures))
+}
@@ -132,10 +132,11 @@ func runMetricBatcher(conn pgxconn.PgxConn,
 	labelArrayOID uint32) {
 
 	var (
-		tableName            string
-		firstReq             *insertDataRequest
-		firstReqSet          = false
-		exemplarsInitialized = false
+		tableName             string
+		firstReq              *insertDataRequest
+		firstReqSet           = false
+		exemplarsInitialized  = false
+		histogramsInitialized = false
 	)
 
 	addReq := func(req *insertDataRequest, buf *pendingBuffer) {
@@ -147,6 +148,14 @@ func runMetricBatcher(conn pgxconn.PgxConn,
 			}
 			exemplarsInitialized = true
 		}
+		if !histogramsInitialized && containsHistograms(req.data) {
+			if err := initializeHistograms(conn, metricName); err != nil {
+				log.Error("msg", err)
+				req.reportResult(err)
+				return
+			}
+			histogramsInitialized = true
+		}
 		buf.addReq(req)
 	}
 	//This channel in synchronous (no buffering). This provides backpressure
@@ -54,6 +54,14 @@ var (
 		},
 	)
 
+	numHistogramsInserted = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Name:      "inserted_histograms_total",
+			Help:      "Total native histogram samples inserted by copiers into the database.",
+		},
+	)
+
 	NumInsertsPerBatch = prometheus.NewHistogram(
 		prometheus.HistogramOpts{
 			Namespace: util.PromNamespace,
@@ -124,6 +132,34 @@ var (
 			Help:      "Number of active ingestion occurring in Promscale at the moment.",
 		},
 	)
+	walSizeBytes = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Name:      "wal_size_bytes",
+			Help:      "Size of the write-ahead log segments holding data not yet inserted into the database.",
+		},
+	)
+	walReplayedSamples = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Name:      "wal_replayed_samples_total",
+			Help:      "Total samples and exemplars replayed from the write-ahead log on startup.",
+		},
+	)
+	walInsertRetries = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Name:      "wal_insert_retries_total",
+			Help:      "Total number of retried inserts of data held in the write-ahead log.",
+		},
+	)
+	walBackpressureWaits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Name:      "wal_backpressure_waits_total",
+			Help:      "Total number of writes which had to wait for the write-ahead log to be drained.",
+		},
+	)
 )
 
 func setCopierChannelToMonitor(toSamplesCopiers chan readRequest) {
@@ -141,6 +177,7 @@ func init() {
 		MetricBatcherFlushSeries,
 		numSamplesInserted,
 		numExemplarsInserted,
+		numHistogramsInserted,
 		NumInsertsPerBatch,
 		NumRowsPerBatch,
 		NumRowsPerInsert,
@@ -149,6 +186,10 @@ func init() {
 		SamplesCopierChCap,
 		SamplesCopierChLen,
 		activeWriteRequests,
+		walSizeBytes,
+		walReplayedSamples,
+		walInsertRetries,
+		walBackpressureWaits,
 	)
 
 	MetricBatcherChCap.Set(MetricBatcherChannelCap)
@@ -0,0 +1,241 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package trace
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/timescale/promscale/pkg/clockcache"
+	"github.com/timescale/promscale/pkg/pgmodel/common/schema"
+	"github.com/timescale/promscale/pkg/pgxconn"
+)
+
+const (
+	getOrphanCleanupSQL = "SELECT coalesce(max(max_tag_id), 0), coalesce(max(max_operation_id), 0), max(marked_at) FROM %s.orphan_cleanup"
+
+	// The trace retention maintenance job deletes the orphaned tags and
+	// operations at least an hour after marking them, except the ones
+	// fetched again since. The tags and operations caches are reset when
+	// the mark changes, or when it was last fetched more than half an hour
+	// ago, so that the marked IDs are fetched again before the deletion.
+	idCacheRefreshInterval = 10 * time.Minute
+	idCacheMaxRefreshAge   = 30 * time.Minute
+
+	tagsCacheType                       = "tag"
+	operationsCacheType                 = "operation"
+	schemaURLsCacheType                 = "schema_url"
+	instrumentationLibsCacheType        = "instrumentation_lib"
+	idCacheEntrySizeBytes               = 64
+	defaultTagsCacheSize                = 100000
+	defaultOperationsCacheSize          = 10000
+	defaultSchemaURLsCacheSize          = 1000
+	defaultInstrumentationLibsCacheSize = 1000
+)
+
+// IDCacheConfig sets the maximum number of IDs of each dimension table of the
+// traces cached across inserts. A zero size disables the cache of a table.
+type IDCacheConfig struct {
+	TagsSize                uint64
+	OperationsSize          uint64
+	SchemaURLsSize          uint64
+	InstrumentationLibsSize uint64
+}
+
+var DefaultIDCacheConfig = IDCacheConfig{
+	TagsSize:                defaultTagsCacheSize,
+	OperationsSize:          defaultOperationsCacheSize,
+	SchemaURLsSize:          defaultSchemaURLsCacheSize,
+	InstrumentationLibsSize: defaultInstrumentationLibsCacheSize,
+}
+
+// idCache caches the IDs of the tags, operations, schema URLs and
+// instrumentation libraries of the traces across inserts.
+type idCache struct {
+	tags                *clockcache.Cache
+	operations          *clockcache.Cache
+	schemaURLs          *clockcache.Cache
+	instrumentationLibs *clockcache.Cache
+
+	// generation is incremented each time the tags and operations caches are
+	// reset, so that the IDs fetched before are not added to the caches.
+	// resetMux is held for writing while resetting, and for reading while
+	// adding IDs.
+	resetMux   sync.RWMutex
+	generation uint64
+	// The tags and operations with IDs lower or equal to these may be
+	// deleted by the orphan cleanup, and are not taken from the caches.
+	maxTagID       int64
+	maxOperationID int64
+
+	mux           sync.Mutex
+	refreshedAt   time.Time
+	markedAt      pgtype.Timestamptz
+	markedTagID   int64
+	markedOpID    int64
+	refreshFailed bool
+}
+
+func newIDCache(cfg IDCacheConfig) *idCache {
+	newCache := func(size uint64) *clockcache.Cache {
+		if size == 0 {
+			return nil
+		}
+		return clockcache.WithMax(size)
+	}
+	return &idCache{
+		tags:                newCache(cfg.TagsSize),
+		operations:          newCache(cfg.OperationsSize),
+		schemaURLs:          newCache(cfg.SchemaURLsSize),
+		instrumentationLibs: newCache(cfg.InstrumentationLibsSize),
+	}
+}
+
+// idCacheView is the ID cache as of the start of an insert.
+type idCacheView struct {
+	cache      *idCache
+	generation uint64
+}
+
+// view refreshes the orphan cleanup mark if needed, and returns the view of
+// the cache to use for an insert.
+func (c *idCache) view(ctx context.Context, conn pgxconn.PgxConn) (idCacheView, error) {
+	if c.tags != nil || c.operations != nil {
+		if err := c.refresh(ctx, conn); err != nil {
+			return idCacheView{}, err
+		}
+	}
+	return idCacheView{cache: c, generation: atomic.LoadUint64(&c.generation)}, nil
+}
+
+func (c *idCache) refresh(ctx context.Context, conn pgxconn.PgxConn) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	now := time.Now()
+	sinceRefresh := now.Sub(c.refreshedAt)
+	if !c.refreshFailed && sinceRefresh < idCacheRefreshInterval {
+		return nil
+	}
+
+	var (
+		tagID, opID int64
+		markedAt    pgtype.Timestamptz
+	)
+	err := conn.QueryRow(ctx, fmt.Sprintf(getOrphanCleanupSQL, schema.Trace)).Scan(&tagID, &opID, &markedAt)
+	if err != nil {
+		c.refreshFailed = true
+		return fmt.Errorf("fetching the trace orphan cleanup mark: %w", err)
+	}
+	changed := tagID != c.markedTagID || opID != c.markedOpID ||
+		markedAt.Status != c.markedAt.Status || !markedAt.Time.Equal(c.markedAt.Time)
+	if changed || c.refreshFailed || sinceRefresh >= idCacheMaxRefreshAge {
+		c.resetMux.Lock()
+		atomic.AddUint64(&c.generation, 1)
+		if c.tags != nil {
+			c.tags.Reset()
+		}
+		if c.operations != nil {
+			c.operations.Reset()
+		}
+		c.resetMux.Unlock()
+	}
+	atomic.StoreInt64(&c.maxTagID, tagID)
+	atomic.StoreInt64(&c.maxOperationID, opID)
+	c.markedTagID, c.markedOpID, c.markedAt = tagID, opID, markedAt
+	c.refreshedAt = now
+	c.refreshFailed = false
+	return nil
+}
+
+// get returns the value of key in cache if it is valid.
+func (v idCacheView) get(cache *clockcache.Cache, typ string, key interface{}, valid func(interface{}) bool) (interface{}, bool) {
+	if cache == nil {
+		return nil, false
+	}
+	value, ok := cache.Get(key)
+	if ok && (valid == nil || valid(value)) {
+		idCacheHits.WithLabelValues(typ).Inc()
+		return value, true
+	}
+	idCacheMisses.WithLabelValues(typ).Inc()
+	return nil, false
+}
+
+func (v idCacheView) put(cache *clockcache.Cache, typ string, key, value interface{}) {
+	if cache == nil {
+		return
+	}
+	v.cache.resetMux.RLock()
+	defer v.cache.resetMux.RUnlock()
+	if atomic.LoadUint64(&v.cache.generation) != v.generation {
+		return
+	}
+	cache.Insert(key, value, idCacheEntrySizeBytes)
+	idCacheElements.WithLabelValues(typ).Set(float64(cache.Len()))
+}
+
+func (v idCacheView) getTag(t tag) (tagIDs, bool) {
+	ids, ok := v.get(v.cache.tags, tagsCacheType, t, func(ids interface{}) bool {
+		return ids.(tagIDs).valueID.Int > atomic.LoadInt64(&v.cache.maxTagID)
+	})
+	if !ok {
+		return tagIDs{}, false
+	}
+	return ids.(tagIDs), true
+}
+
+func (v idCacheView) putTag(t tag, ids tagIDs) {
+	if ids.keyID.Status == pgtype.Present && ids.valueID.Status == pgtype.Present {
+		v.put(v.cache.tags, tagsCacheType, t, ids)
+	}
+}
+
+func (v idCacheView) getOperation(op operation) (pgtype.Int8, bool) {
+	id, ok := v.get(v.cache.operations, operationsCacheType, op, func(id interface{}) bool {
+		return id.(pgtype.Int8).Int > atomic.LoadInt64(&v.cache.maxOperationID)
+	})
+	if !ok {
+		return pgtype.Int8{}, false
+	}
+	return id.(pgtype.Int8), true
+}
+
+func (v idCacheView) putOperation(op operation, id pgtype.Int8) {
+	if id.Status == pgtype.Present {
+		v.put(v.cache.operations, operationsCacheType, op, id)
+	}
+}
+
+func (v idCacheView) getSchemaURL(url schemaURL) (pgtype.Int8, bool) {
+	id, ok := v.get(v.cache.schemaURLs, schemaURLsCacheType, url, nil)
+	if !ok {
+		return pgtype.Int8{}, false
+	}
+	return id.(pgtype.Int8), true
+}
+
+func (v idCacheView) putSchemaURL(url schemaURL, id pgtype.Int8) {
+	if id.Status == pgtype.Present {
+		v.put(v.cache.schemaURLs, schemaURLsCacheType, url, id)
+	}
+}
+
+func (v idCacheView) getInstrumentationLib(lib instrumentationLibrary) (pgtype.Int8, bool) {
+	id, ok := v.get(v.cache.instrumentationLibs, instrumentationLibsCacheType, lib, nil)
+	if !ok {
+		return pgtype.Int8{}, false
+	}
+	return id.(pgtype.Int8), true
+}
+
+func (v idCacheView) putInstrumentationLib(lib instrumentationLibrary, id pgtype.Int8) {
+	if id.Status == pgtype.Present {
+		v.put(v.cache.instrumentationLibs, instrumentationLibsCacheType, lib, id)
+	}
+}
@@ -0,0 +1,83 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package trace
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/pgmodel/common/schema"
+	"github.com/timescale/promscale/pkg/pgmodel/model"
+)
+
+func TestIDCache(t *testing.T) {
+	orphanCleanup := func(maxTagID int64, markedAt interface{}) model.SqlQuery {
+		return model.SqlQuery{
+			Sql:     fmt.Sprintf(getOrphanCleanupSQL, schema.Trace),
+			Results: model.RowResults{{maxTagID, int64(0), markedAt}},
+		}
+	}
+	putTag := func(key string, keyID, valueID int64) []model.SqlQuery {
+		return []model.SqlQuery{
+			{
+				Sql:     fmt.Sprintf(insertTagKeySQL, schema.TracePublic, schema.TracePublic),
+				Args:    []interface{}{key, SpanTagType},
+				Results: model.RowResults{{keyID}},
+			},
+			{
+				Sql:     fmt.Sprintf(insertTagSQL, schema.TracePublic, schema.TracePublic),
+				Args:    []interface{}{key, `"value"`, SpanTagType},
+				Results: model.RowResults{{valueID}},
+			},
+		}
+	}
+	markedAt := time.Unix(1600000000, 0)
+
+	var queries []model.SqlQuery
+	queries = append(queries, orphanCleanup(0, nil))
+	queries = append(queries, putTag("a", 1, 10)...)
+	// the mark resets the cache, and the marked ids are not cached
+	queries = append(queries, orphanCleanup(10, markedAt))
+	queries = append(queries, putTag("a", 1, 10)...)
+	queries = append(queries, putTag("a", 1, 10)...)
+	queries = append(queries, putTag("b", 2, 11)...)
+	conn := model.NewSqlRecorder(queries, t)
+	cache := newIDCache(DefaultIDCacheConfig)
+
+	sendTag := func(key string) tagIDs {
+		view, err := cache.view(context.Background(), conn)
+		require.NoError(t, err)
+		batch := newTagBatch()
+		require.NoError(t, batch.Queue(map[string]interface{}{key: "value"}, SpanTagType))
+		require.NoError(t, batch.SendBatch(context.Background(), conn, view))
+		return batch[tag{key, `"value"`, SpanTagType}]
+	}
+
+	require.Equal(t, int64(10), sendTag("a").valueID.Int)
+	require.Equal(t, int64(10), sendTag("a").valueID.Int, "the tag ids are cached")
+
+	cache.refreshedAt = cache.refreshedAt.Add(-idCacheRefreshInterval)
+	require.Equal(t, int64(10), sendTag("a").valueID.Int)
+	require.Equal(t, int64(10), sendTag("a").valueID.Int)
+	require.Equal(t, int64(11), sendTag("b").valueID.Int)
+	require.Equal(t, int64(11), sendTag("b").valueID.Int, "the tag ids above the mark are cached")
+
+	// The ids fetched before a reset are not cached.
+	view, err := cache.view(context.Background(), conn)
+	require.NoError(t, err)
+	cache.refreshedAt = cache.refreshedAt.Add(-idCacheMaxRefreshAge)
+	conn = model.NewSqlRecorder([]model.SqlQuery{orphanCleanup(10, markedAt)}, t)
+	_, err = cache.view(context.Background(), conn)
+	require.NoError(t, err)
+	view.putTag(tag{"c", `"value"`, SpanTagType}, tagIDs{keyID: pgtype.Int8{Int: 3, Status: pgtype.Present}, valueID: pgtype.Int8{Int: 12, Status: pgtype.Present}})
+	_, ok := view.getTag(tag{"c", `"value"`, SpanTagType})
+	require.False(t, ok)
+	_, ok = view.getTag(tag{"b", `"value"`, SpanTagType})
+	require.False(t, ok, "the cache is reset when the mark was not fetched for too long")
+}
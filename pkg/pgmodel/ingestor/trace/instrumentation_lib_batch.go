@@ -24,7 +24,7 @@ type instrumentationLibrary struct {
 
 //instrumentationLibraryBatch queues up items to send to the DB but it sorts before sending
 //this avoids deadlocks in the DB. It also avoids sending the same instrumentation
-//libraries repeatedly.
+//libraries repeatedly, and the libraries whose ids are cached.
 type instrumentationLibraryBatch map[instrumentationLibrary]pgtype.Int8
 
 func newInstrumentationLibraryBatch() instrumentationLibraryBatch {
@@ -37,12 +37,17 @@ func (batch instrumentationLibraryBatch) Queue(name, version string, schemaUrlID
 	}
 }
 
-func (batch instrumentationLibraryBatch) SendBatch(ctx context.Context, conn pgxconn.PgxConn) error {
-	libs := make([]instrumentationLibrary, len(batch))
-	i := 0
+func (batch instrumentationLibraryBatch) SendBatch(ctx context.Context, conn pgxconn.PgxConn, cache idCacheView) error {
+	libs := make([]instrumentationLibrary, 0, len(batch))
 	for lib := range batch {
-		libs[i] = lib
-		i++
+		if id, ok := cache.getInstrumentationLib(lib); ok {
+			batch[lib] = id
+			continue
+		}
+		libs = append(libs, lib)
+	}
+	if len(libs) == 0 {
+		return nil
 	}
 	sort.Slice(libs, func(i, j int) bool {
 		if libs[i].name != libs[j].name {
@@ -72,6 +77,7 @@ func (batch instrumentationLibraryBatch) SendBatch(ctx context.Context, conn pgx
 			return err
 		}
 		batch[lib] = id
+		cache.putInstrumentationLib(lib, id)
 	}
 	if err = br.Close(); err != nil {
 		return err
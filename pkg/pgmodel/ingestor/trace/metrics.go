@@ -0,0 +1,142 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package trace
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+var (
+	sampledTraces = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "trace_sampling",
+			Name:      "traces_total",
+			Help:      "Total traces sampled, by decision and by the policy which decided it.",
+		},
+		[]string{"decision", "policy"},
+	)
+	sampledSpans = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "trace_sampling",
+			Name:      "spans_total",
+			Help:      "Total spans sampled, by decision.",
+		},
+		[]string{"decision"},
+	)
+	failedTraces = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "trace_sampling",
+			Name:      "failed_traces_total",
+			Help:      "Total kept traces lost as their insert failed after the buffered spans were acknowledged.",
+		},
+	)
+	failedSpans = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "trace_sampling",
+			Name:      "failed_spans_total",
+			Help:      "Total spans of the kept traces lost as their insert failed after the buffered spans were acknowledged.",
+		},
+	)
+	lateSpansTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "trace_sampling",
+			Name:      "late_spans_total",
+			Help:      "Total spans received after the decision on their trace.",
+		},
+	)
+	earlyDecisions = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "trace_sampling",
+			Name:      "early_decisions_total",
+			Help:      "Total traces decided before the end of the decision wait because the buffer was full.",
+		},
+	)
+	idCacheHits = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "trace",
+			Name:      "id_cache_hits_total",
+			Help:      "Total lookups of trace tag, operation, schema URL and instrumentation library IDs found in the cache.",
+		},
+		[]string{"type"},
+	)
+	idCacheMisses = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "trace",
+			Name:      "id_cache_misses_total",
+			Help:      "Total lookups of trace tag, operation, schema URL and instrumentation library IDs not found in the cache.",
+		},
+		[]string{"type"},
+	)
+	idCacheElements = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "trace",
+			Name:      "id_cache_elements_stored",
+			Help:      "Number of trace tag, operation, schema URL and instrumentation library IDs in the cache.",
+		},
+		[]string{"type"},
+	)
+	bufferedSpans = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "trace_sampling",
+			Name:      "buffered_spans",
+			Help:      "Number of spans buffered until the decision on their trace.",
+		},
+	)
+	spanMetricsSeriesCount = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "trace_span_metrics",
+			Name:      "series",
+			Help:      "Number of services, operations, span kinds and status codes counted by the span metrics.",
+		},
+	)
+	spanMetricsDroppedSpans = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "trace_span_metrics",
+			Name:      "dropped_spans_total",
+			Help:      "Number of spans not counted by the span metrics, as the maximum number of series was reached.",
+		},
+	)
+	// SpanMetricsFailures counts the span metrics which could not be
+	// ingested.
+	SpanMetricsFailures = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "trace_span_metrics",
+			Name:      "failures_total",
+			Help:      "Number of failed ingests of the span metrics. The spans are stored regardless.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		sampledTraces,
+		sampledSpans,
+		failedTraces,
+		failedSpans,
+		lateSpansTotal,
+		earlyDecisions,
+		bufferedSpans,
+		spanMetricsSeriesCount,
+		spanMetricsDroppedSpans,
+		SpanMetricsFailures,
+		idCacheHits,
+		idCacheMisses,
+		idCacheElements,
+	)
+}
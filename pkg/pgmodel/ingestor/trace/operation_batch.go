@@ -23,7 +23,7 @@ type operation struct {
 }
 
 //Operation batch queues up items to send to the db but it sorts before sending
-//this avoids deadlocks in the db
+//this avoids deadlocks in the db. The operations whose ids are cached are not sent.
 type operationBatch map[operation]pgtype.Int8
 
 func newOperationBatch() operationBatch {
@@ -34,12 +34,17 @@ func (o operationBatch) Queue(serviceName, spanName, spanKind string) {
 	o[operation{serviceName, spanName, spanKind}] = pgtype.Int8{}
 }
 
-func (batch operationBatch) SendBatch(ctx context.Context, conn pgxconn.PgxConn) error {
-	ops := make([]operation, len(batch))
-	i := 0
+func (batch operationBatch) SendBatch(ctx context.Context, conn pgxconn.PgxConn, cache idCacheView) error {
+	ops := make([]operation, 0, len(batch))
 	for op := range batch {
-		ops[i] = op
-		i++
+		if id, ok := cache.getOperation(op); ok {
+			batch[op] = id
+			continue
+		}
+		ops = append(ops, op)
+	}
+	if len(ops) == 0 {
+		return nil
 	}
 	sort.Slice(ops, func(i, j int) bool {
 		if ops[i].serviceName != ops[j].serviceName {
@@ -65,6 +70,7 @@ func (batch operationBatch) SendBatch(ctx context.Context, conn pgxconn.PgxConn)
 			return err
 		}
 		batch[op] = id
+		cache.putOperation(op, id)
 	}
 	if err = br.Close(); err != nil {
 		return err
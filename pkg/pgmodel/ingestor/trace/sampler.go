@@ -0,0 +1,446 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package trace
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/timescale/promscale/pkg/clockcache"
+	"github.com/timescale/promscale/pkg/log"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+const (
+	// samplingDecisionsCacheSize is the number of trace decisions remembered
+	// to sample the spans received after the decision on their trace.
+	samplingDecisionsCacheSize = 100000
+	minSamplingTickInterval    = 10 * time.Millisecond
+	// The kept traces failing to be inserted are retried up to
+	// samplingInsertAttempts times, waiting samplingRetryBackoff, doubled
+	// after each attempt, in between.
+	samplingInsertAttempts = 3
+	samplingRetryBackoff   = 500 * time.Millisecond
+
+	policyError     = "error"
+	policyLatency   = "latency"
+	policyAttribute = "attribute"
+	policyRate      = "rate"
+)
+
+// SamplingConfig configures the tail-based sampling of the ingested traces.
+// A trace is kept if any of its spans has an error status, if it lasts at
+// least LatencyThreshold, or if any of its spans or their resources has one
+// of KeepAttributes. Otherwise it is kept with the sampling rate of the
+// service of its root span.
+type SamplingConfig struct {
+	// DecisionWait is how long the spans of a trace are buffered, from its
+	// first span, before deciding whether to keep the trace. Zero disables
+	// sampling.
+	DecisionWait time.Duration
+	// MaxSpans is the maximum number of buffered spans. The oldest traces
+	// are decided early when it is reached.
+	MaxSpans         int
+	KeepErrors       bool
+	LatencyThreshold time.Duration
+	KeepAttributes   SamplingAttributes
+	// Rate is the fraction of the traces kept for the services without a
+	// rate in ServiceRates.
+	Rate         float64
+	ServiceRates SamplingRates
+}
+
+// Validate checks the sampling configuration.
+func (cfg *SamplingConfig) Validate() error {
+	if cfg.DecisionWait < 0 {
+		return fmt.Errorf("invalid decision wait %v, must not be negative", cfg.DecisionWait)
+	}
+	if cfg.DecisionWait > 0 && cfg.MaxSpans <= 0 {
+		return fmt.Errorf("invalid max spans %d, must be positive", cfg.MaxSpans)
+	}
+	if cfg.Rate < 0 || cfg.Rate > 1 {
+		return fmt.Errorf("invalid rate %v, must be between 0 and 1", cfg.Rate)
+	}
+	return nil
+}
+
+// SamplingRates are the sampling rates of services. It is set from a comma
+// separated list of service=rate.
+type SamplingRates map[string]float64
+
+func (r *SamplingRates) String() string {
+	rates := make([]string, 0, len(*r))
+	for service, rate := range *r {
+		rates = append(rates, service+"="+strconv.FormatFloat(rate, 'f', -1, 64))
+	}
+	sort.Strings(rates)
+	return strings.Join(rates, ",")
+}
+
+func (r *SamplingRates) Set(s string) error {
+	rates := make(SamplingRates)
+	if s == "" {
+		*r = rates
+		return nil
+	}
+	for _, field := range strings.Split(s, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return fmt.Errorf("invalid service rate %q, must be service=rate", field)
+		}
+		rate, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil {
+			return fmt.Errorf("invalid service rate %q: %w", field, err)
+		}
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("invalid service rate %q, must be between 0 and 1", field)
+		}
+		rates[kv[0]] = rate
+	}
+	*r = rates
+	return nil
+}
+
+// SamplingAttribute is an attribute of spans or resources whose traces are
+// kept.
+type SamplingAttribute struct {
+	Key   string
+	Value string
+}
+
+// SamplingAttributes is set from a comma separated list of key=value.
+type SamplingAttributes []SamplingAttribute
+
+func (a *SamplingAttributes) String() string {
+	attributes := make([]string, len(*a))
+	for i, attribute := range *a {
+		attributes[i] = attribute.Key + "=" + attribute.Value
+	}
+	return strings.Join(attributes, ",")
+}
+
+func (a *SamplingAttributes) Set(s string) error {
+	var attributes SamplingAttributes
+	if s != "" {
+		for _, field := range strings.Split(s, ",") {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				return fmt.Errorf("invalid attribute %q, must be key=value", field)
+			}
+			attributes = append(attributes, SamplingAttribute{Key: kv[0], Value: kv[1]})
+		}
+	}
+	*a = attributes
+	return nil
+}
+
+func (a SamplingAttributes) match(attributes pdata.AttributeMap) bool {
+	for _, attribute := range a {
+		if v, ok := attributes.Get(attribute.Key); ok && v.AsString() == attribute.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// bufferedTrace holds the spans of a trace until the decision on it.
+type bufferedTrace struct {
+	id       [16]byte
+	received time.Time
+	spans    pdata.Traces
+	numSpans int
+
+	serviceName    string
+	hasRoot        bool
+	hasError       bool
+	matchAttribute bool
+	start, end     pdata.Timestamp
+}
+
+// keptTraces are the spans of the kept traces, along with the policies which
+// kept them. They are counted as kept once inserted.
+type keptTraces struct {
+	spans     pdata.Traces
+	numSpans  int
+	numTraces map[string]int
+}
+
+func newKeptTraces() *keptTraces {
+	return &keptTraces{spans: pdata.NewTraces(), numTraces: make(map[string]int)}
+}
+
+// Sampler is a Writer buffering the spans of each trace for the decision
+// wait, then inserting or dropping whole traces according to the sampling
+// policies. The buffered spans are acknowledged before they are inserted,
+// so they are delivered at most once: they are lost if Promscale crashes,
+// or if their insert still fails after a few attempts.
+type Sampler struct {
+	cfg    SamplingConfig
+	writer Writer
+	// ctx is the context of the inserts of the buffered traces, which do not
+	// belong to any request. It is cancelled once the sampler is closed.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mux sync.Mutex
+	// queue holds the buffered traces in the order they were received,
+	// which is the order of their decision.
+	queue     []*bufferedTrace
+	traces    map[[16]byte]*bufferedTrace
+	numSpans  int
+	decisions *clockcache.Cache
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewSampler returns a Sampler inserting the kept traces with writer.
+func NewSampler(cfg SamplingConfig, writer Writer) *Sampler {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Sampler{
+		cfg:       cfg,
+		writer:    writer,
+		ctx:       ctx,
+		cancel:    cancel,
+		traces:    make(map[[16]byte]*bufferedTrace),
+		decisions: clockcache.WithMax(samplingDecisionsCacheSize),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *Sampler) run() {
+	defer close(s.done)
+	interval := s.cfg.DecisionWait / 10
+	if interval < minSamplingTickInterval {
+		interval = minSamplingTickInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			s.decide(func(*bufferedTrace) bool { return true })
+			return
+		case now := <-ticker.C:
+			s.decide(func(t *bufferedTrace) bool { return !now.Before(t.received.Add(s.cfg.DecisionWait)) })
+		}
+	}
+}
+
+// Close decides on all the buffered traces and stops the sampler.
+func (s *Sampler) Close() {
+	close(s.stop)
+	<-s.done
+	s.cancel()
+}
+
+// decide decides on the buffered traces, from the oldest one, as long as
+// expired returns true, and inserts the kept ones.
+func (s *Sampler) decide(expired func(*bufferedTrace) bool) {
+	kept := newKeptTraces()
+	s.mux.Lock()
+	for len(s.queue) > 0 && expired(s.queue[0]) {
+		s.decideOldest(kept)
+	}
+	s.mux.Unlock()
+
+	s.insertKept(kept)
+}
+
+// insertKept inserts the kept traces with the context of the sampler, as the
+// clients which sent them were already answered. The insert is retried a few
+// times, after which the traces are counted as failed and lost.
+func (s *Sampler) insertKept(kept *keptTraces) {
+	if kept.numSpans == 0 {
+		return
+	}
+	var err error
+	backoff := samplingRetryBackoff
+	for attempt := 1; attempt <= samplingInsertAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-s.ctx.Done():
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		if err = s.writer.InsertTraces(s.ctx, kept.spans); err == nil {
+			for policy, n := range kept.numTraces {
+				sampledTraces.WithLabelValues("kept", policy).Add(float64(n))
+			}
+			sampledSpans.WithLabelValues("kept").Add(float64(kept.numSpans))
+			return
+		}
+	}
+	numTraces := 0
+	for _, n := range kept.numTraces {
+		numTraces += n
+	}
+	failedTraces.Add(float64(numTraces))
+	failedSpans.Add(float64(kept.numSpans))
+	log.Error("msg", "failed to insert sampled traces, dropping them", "traces", numTraces, "spans", kept.numSpans, "err", err)
+}
+
+// InsertTraces buffers the spans of traces until the decision on their
+// trace. The spans of the traces already decided on are inserted right away
+// if their trace was kept, and their insert error is returned. The traces
+// decided early to make room in the buffer are inserted as well, like the
+// ones decided after the decision wait.
+func (s *Sampler) InsertTraces(ctx context.Context, traces pdata.Traces) error {
+	late := pdata.NewTraces()
+	lateSpans := 0
+	early := newKeptTraces()
+	received := time.Now()
+
+	s.mux.Lock()
+	rSpans := traces.ResourceSpans()
+	for i := 0; i < rSpans.Len(); i++ {
+		rSpan := rSpans.At(i)
+		serviceName := getServiceName(rSpan)
+		matchResource := s.cfg.KeepAttributes.match(rSpan.Resource().Attributes())
+		instLibSpans := rSpan.InstrumentationLibrarySpans()
+		for j := 0; j < instLibSpans.Len(); j++ {
+			instLibSpan := instLibSpans.At(j)
+			// the spans of instLibSpan are copied to a single library spans
+			// of each destination
+			dests := make(map[pdata.Traces]pdata.SpanSlice)
+			destSpans := func(dest pdata.Traces) pdata.SpanSlice {
+				spans, ok := dests[dest]
+				if !ok {
+					rSpanDest := dest.ResourceSpans().AppendEmpty()
+					rSpan.Resource().CopyTo(rSpanDest.Resource())
+					rSpanDest.SetSchemaUrl(rSpan.SchemaUrl())
+					instLibSpanDest := rSpanDest.InstrumentationLibrarySpans().AppendEmpty()
+					instLibSpan.InstrumentationLibrary().CopyTo(instLibSpanDest.InstrumentationLibrary())
+					instLibSpanDest.SetSchemaUrl(instLibSpan.SchemaUrl())
+					spans = instLibSpanDest.Spans()
+					dests[dest] = spans
+				}
+				return spans
+			}
+
+			spans := instLibSpan.Spans()
+			for k := 0; k < spans.Len(); k++ {
+				span := spans.At(k)
+				id := span.TraceID().Bytes()
+				if keep, ok := s.decisions.Get(id); ok {
+					lateSpansTotal.Inc()
+					if keep.(bool) {
+						span.CopyTo(destSpans(late).AppendEmpty())
+						lateSpans++
+					} else {
+						sampledSpans.WithLabelValues("dropped").Inc()
+					}
+					continue
+				}
+
+				t, ok := s.traces[id]
+				if !ok {
+					t = &bufferedTrace{
+						id:          id,
+						received:    received,
+						spans:       pdata.NewTraces(),
+						serviceName: serviceName,
+						start:       span.StartTimestamp(),
+						end:         span.EndTimestamp(),
+					}
+					s.traces[id] = t
+					s.queue = append(s.queue, t)
+				}
+				span.CopyTo(destSpans(t.spans).AppendEmpty())
+				t.numSpans++
+				s.numSpans++
+
+				if !t.hasRoot && span.ParentSpanID().IsEmpty() {
+					t.hasRoot = true
+					t.serviceName = serviceName
+				}
+				if span.Status().Code() == pdata.StatusCodeError {
+					t.hasError = true
+				}
+				if matchResource || s.cfg.KeepAttributes.match(span.Attributes()) {
+					t.matchAttribute = true
+				}
+				if span.StartTimestamp() < t.start {
+					t.start = span.StartTimestamp()
+				}
+				if span.EndTimestamp() > t.end {
+					t.end = span.EndTimestamp()
+				}
+			}
+		}
+	}
+	for s.numSpans > s.cfg.MaxSpans && len(s.queue) > 0 {
+		earlyDecisions.Inc()
+		s.decideOldest(early)
+	}
+	bufferedSpans.Set(float64(s.numSpans))
+	s.mux.Unlock()
+
+	s.insertKept(early)
+	if lateSpans == 0 {
+		return nil
+	}
+	if err := s.writer.InsertTraces(ctx, late); err != nil {
+		return err
+	}
+	sampledSpans.WithLabelValues("kept").Add(float64(lateSpans))
+	return nil
+}
+
+// decideOldest decides on the oldest buffered trace, moving its spans to
+// kept if it is kept. It must be called with the lock held.
+func (s *Sampler) decideOldest(kept *keptTraces) {
+	t := s.queue[0]
+	s.queue[0] = nil
+	s.queue = s.queue[1:]
+	delete(s.traces, t.id)
+	s.numSpans -= t.numSpans
+	bufferedSpans.Set(float64(s.numSpans))
+
+	keep, policy := s.policy(t)
+	s.decisions.Insert(t.id, keep, uint64(len(t.id))+1)
+	if keep {
+		t.spans.ResourceSpans().MoveAndAppendTo(kept.spans.ResourceSpans())
+		kept.numSpans += t.numSpans
+		kept.numTraces[policy]++
+	} else {
+		sampledTraces.WithLabelValues("dropped", policy).Inc()
+		sampledSpans.WithLabelValues("dropped").Add(float64(t.numSpans))
+	}
+}
+
+// policy returns whether the trace is kept, and the policy deciding it.
+func (s *Sampler) policy(t *bufferedTrace) (bool, string) {
+	switch {
+	case s.cfg.KeepErrors && t.hasError:
+		return true, policyError
+	case s.cfg.LatencyThreshold > 0 && t.end.AsTime().Sub(t.start.AsTime()) >= s.cfg.LatencyThreshold:
+		return true, policyLatency
+	case t.matchAttribute:
+		return true, policyAttribute
+	}
+	rate, ok := s.cfg.ServiceRates[t.serviceName]
+	if !ok {
+		rate = s.cfg.Rate
+	}
+	return sampleTraceID(t.id, rate), policyRate
+}
+
+// sampleTraceID returns whether a trace is kept with the given rate. The
+// decision only depends on the random part of the trace ID, so that all
+// Promscale instances make the same decision on a trace.
+func sampleTraceID(id [16]byte, rate float64) bool {
+	return float64(binary.BigEndian.Uint64(id[8:])>>11)/(1<<53) < rate
+}
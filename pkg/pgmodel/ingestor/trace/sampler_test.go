@@ -0,0 +1,221 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package trace
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+type mockWriter struct {
+	mux   sync.Mutex
+	spans map[[16]byte]int
+	// failures is the number of the next inserts which fail.
+	failures int
+	// ctxErrs are the errors of the contexts of the inserts.
+	ctxErrs []error
+}
+
+func (w *mockWriter) InsertTraces(ctx context.Context, traces pdata.Traces) error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	w.ctxErrs = append(w.ctxErrs, ctx.Err())
+	if w.failures > 0 {
+		w.failures--
+		return fmt.Errorf("insert failed")
+	}
+	rSpans := traces.ResourceSpans()
+	for i := 0; i < rSpans.Len(); i++ {
+		instLibSpans := rSpans.At(i).InstrumentationLibrarySpans()
+		for j := 0; j < instLibSpans.Len(); j++ {
+			spans := instLibSpans.At(j).Spans()
+			for k := 0; k < spans.Len(); k++ {
+				w.spans[spans.At(k).TraceID().Bytes()]++
+			}
+		}
+	}
+	return nil
+}
+
+func (w *mockWriter) inserted(id [16]byte) int {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	return w.spans[id]
+}
+
+type testSpan struct {
+	traceID  [16]byte
+	service  string
+	root     bool
+	err      bool
+	duration time.Duration
+	attr     string
+}
+
+func newTestTraces(spans ...testSpan) pdata.Traces {
+	traces := pdata.NewTraces()
+	start := pdata.NewTimestampFromTime(time.Unix(1600000000, 0))
+	for _, s := range spans {
+		rSpan := traces.ResourceSpans().AppendEmpty()
+		rSpan.Resource().Attributes().InsertString("service.name", s.service)
+		span := rSpan.InstrumentationLibrarySpans().AppendEmpty().Spans().AppendEmpty()
+		span.SetTraceID(pdata.NewTraceID(s.traceID))
+		span.SetSpanID(pdata.NewSpanID([8]byte{1}))
+		if !s.root {
+			span.SetParentSpanID(pdata.NewSpanID([8]byte{2}))
+		}
+		if s.err {
+			span.Status().SetCode(pdata.StatusCodeError)
+		}
+		if s.attr != "" {
+			span.Attributes().InsertString("sampling.priority", s.attr)
+		}
+		span.SetStartTimestamp(start)
+		span.SetEndTimestamp(pdata.NewTimestampFromTime(start.AsTime().Add(s.duration)))
+	}
+	return traces
+}
+
+// traceID returns a trace ID kept by the sampling rates above n/256.
+func traceID(n byte) [16]byte {
+	return [16]byte{0: n, 8: n}
+}
+
+func TestSamplerPolicies(t *testing.T) {
+	w := &mockWriter{spans: make(map[[16]byte]int)}
+	s := NewSampler(SamplingConfig{
+		DecisionWait:     time.Hour,
+		MaxSpans:         100,
+		KeepErrors:       true,
+		LatencyThreshold: time.Second,
+		KeepAttributes:   SamplingAttributes{{Key: "sampling.priority", Value: "1"}},
+		Rate:             0.5,
+		ServiceRates:     SamplingRates{"busy": 0},
+	}, w)
+	defer s.Close()
+
+	var (
+		errorTrace     = traceID(200)
+		slowTrace      = traceID(201)
+		attributeTrace = traceID(202)
+		keptByRate     = traceID(10)
+		droppedByRate  = traceID(203)
+		busyTrace      = traceID(11)
+	)
+	err := s.InsertTraces(context.Background(), newTestTraces(
+		testSpan{traceID: errorTrace, service: "a", root: true},
+		testSpan{traceID: errorTrace, service: "b", err: true},
+		testSpan{traceID: slowTrace, service: "a", root: true, duration: 2 * time.Second},
+		testSpan{traceID: attributeTrace, service: "a", attr: "1"},
+		testSpan{traceID: keptByRate, service: "a", root: true},
+		testSpan{traceID: droppedByRate, service: "a", root: true},
+		// the rate is the one of the service of the root span
+		testSpan{traceID: busyTrace, service: "a"},
+		testSpan{traceID: busyTrace, service: "busy", root: true},
+	))
+	require.NoError(t, err)
+	require.Equal(t, 0, w.inserted(errorTrace), "the traces are buffered for the decision wait")
+
+	s.decide(func(*bufferedTrace) bool { return true })
+	require.Equal(t, 2, w.inserted(errorTrace))
+	require.Equal(t, 1, w.inserted(slowTrace))
+	require.Equal(t, 1, w.inserted(attributeTrace))
+	require.Equal(t, 1, w.inserted(keptByRate))
+	require.Equal(t, 0, w.inserted(droppedByRate))
+	require.Equal(t, 0, w.inserted(busyTrace))
+
+	// The spans received after the decision on their trace follow it.
+	err = s.InsertTraces(context.Background(), newTestTraces(
+		testSpan{traceID: keptByRate, service: "b"},
+		testSpan{traceID: droppedByRate, service: "b", err: true},
+	))
+	require.NoError(t, err)
+	require.Equal(t, 2, w.inserted(keptByRate))
+	require.Equal(t, 0, w.inserted(droppedByRate))
+}
+
+func TestSamplerMaxSpans(t *testing.T) {
+	w := &mockWriter{spans: make(map[[16]byte]int)}
+	s := NewSampler(SamplingConfig{DecisionWait: time.Hour, MaxSpans: 2, Rate: 1}, w)
+
+	for i := byte(0); i < 3; i++ {
+		err := s.InsertTraces(context.Background(), newTestTraces(testSpan{traceID: traceID(i), root: true}))
+		require.NoError(t, err)
+	}
+	// The oldest trace is decided early to keep 2 spans in the buffer.
+	require.Equal(t, 1, w.inserted(traceID(0)))
+	require.Equal(t, 0, w.inserted(traceID(1)))
+	require.Equal(t, 2, s.numSpans)
+
+	// The buffered traces are decided on when the sampler is closed.
+	s.Close()
+	require.Equal(t, 1, w.inserted(traceID(1)))
+	require.Equal(t, 1, w.inserted(traceID(2)))
+}
+
+func TestSamplerInsertFailures(t *testing.T) {
+	w := &mockWriter{spans: make(map[[16]byte]int)}
+	s := NewSampler(SamplingConfig{DecisionWait: time.Hour, MaxSpans: 1, Rate: 1}, w)
+	defer s.Close()
+
+	// The traces decided early are inserted with the context of the
+	// sampler, as they were acknowledged to other requests.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, s.InsertTraces(ctx, newTestTraces(testSpan{traceID: traceID(1), root: true})))
+	require.NoError(t, s.InsertTraces(ctx, newTestTraces(testSpan{traceID: traceID(2), root: true})))
+	require.Equal(t, 1, w.inserted(traceID(1)))
+	require.Equal(t, []error{nil}, w.ctxErrs)
+
+	// The kept traces are inserted again when their insert fails.
+	w.failures = 1
+	require.NoError(t, s.InsertTraces(context.Background(), newTestTraces(testSpan{traceID: traceID(3), root: true})))
+	require.Equal(t, 1, w.inserted(traceID(2)))
+
+	// They are lost once the attempts are exhausted, or the sampler is
+	// closed.
+	failed := testutil.ToFloat64(failedTraces)
+	w.failures = samplingInsertAttempts
+	s.cancel()
+	require.NoError(t, s.InsertTraces(context.Background(), newTestTraces(testSpan{traceID: traceID(4), root: true})))
+	require.Equal(t, 0, w.inserted(traceID(3)))
+	require.Equal(t, failed+1, testutil.ToFloat64(failedTraces))
+}
+
+func TestSamplerDecisionWait(t *testing.T) {
+	w := &mockWriter{spans: make(map[[16]byte]int)}
+	s := NewSampler(SamplingConfig{DecisionWait: 50 * time.Millisecond, MaxSpans: 10, Rate: 1}, w)
+	defer s.Close()
+
+	require.NoError(t, s.InsertTraces(context.Background(), newTestTraces(testSpan{traceID: traceID(1), root: true})))
+	require.Eventually(t, func() bool { return w.inserted(traceID(1)) == 1 }, 10*time.Second, 10*time.Millisecond)
+}
+
+func TestSamplingFlags(t *testing.T) {
+	var rates SamplingRates
+	require.NoError(t, rates.Set("b=0.5,a=0"))
+	require.Equal(t, SamplingRates{"a": 0, "b": 0.5}, rates)
+	require.Equal(t, "a=0,b=0.5", rates.String())
+	for _, invalid := range []string{"a", "=1", "a=x", "a=2", "a=-1"} {
+		require.Error(t, rates.Set(invalid), invalid)
+	}
+
+	var attributes SamplingAttributes
+	require.NoError(t, attributes.Set("http.status_code=500,debug=true"))
+	require.Equal(t, SamplingAttributes{{Key: "http.status_code", Value: "500"}, {Key: "debug", Value: "true"}}, attributes)
+	require.Equal(t, "http.status_code=500,debug=true", attributes.String())
+	require.Error(t, attributes.Set("debug"))
+
+	require.NoError(t, (&SamplingConfig{}).Validate())
+	require.Error(t, (&SamplingConfig{DecisionWait: time.Second}).Validate())
+	require.Error(t, (&SamplingConfig{Rate: 1.5}).Validate())
+}
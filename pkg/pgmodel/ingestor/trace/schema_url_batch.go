@@ -19,7 +19,8 @@ const insertSchemaURLSQL = `SELECT %s.put_schema_url($1)`
 type schemaURL string
 
 //schemaURLBatch queues up items to send to the DB but it sorts before sending
-//this avoids deadlocks in the DB. It also avoids sending the same URLs repeatedly.
+//this avoids deadlocks in the DB. It also avoids sending the same URLs repeatedly,
+//and the URLs whose ids are cached.
 type schemaURLBatch map[schemaURL]pgtype.Int8
 
 func newSchemaUrlBatch() schemaURLBatch {
@@ -32,12 +33,17 @@ func (batch schemaURLBatch) Queue(url string) {
 	}
 }
 
-func (batch schemaURLBatch) SendBatch(ctx context.Context, conn pgxconn.PgxConn) error {
-	urls := make([]schemaURL, len(batch))
-	i := 0
+func (batch schemaURLBatch) SendBatch(ctx context.Context, conn pgxconn.PgxConn, cache idCacheView) error {
+	urls := make([]schemaURL, 0, len(batch))
 	for url := range batch {
-		urls[i] = url
-		i++
+		if id, ok := cache.getSchemaURL(url); ok {
+			batch[url] = id
+			continue
+		}
+		urls = append(urls, url)
+	}
+	if len(urls) == 0 {
+		return nil
 	}
 	sort.Slice(urls, func(i, j int) bool {
 		return urls[i] < urls[j]
@@ -58,6 +64,7 @@ func (batch schemaURLBatch) SendBatch(ctx context.Context, conn pgxconn.PgxConn)
 			return err
 		}
 		batch[sURL] = id
+		cache.putSchemaURL(sURL, id)
 	}
 	if err = br.Close(); err != nil {
 		return err
@@ -0,0 +1,261 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package trace
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/timescale/promscale/pkg/prompb"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+const (
+	spanCallsMetric      = "trace_span_calls_total"
+	spanDurationMetric   = "trace_span_duration_seconds"
+	traceIDExemplarLabel = "trace_id"
+	instanceLabel        = "instance"
+
+	// The series not updated for spanMetricsSeriesTTL are forgotten, and
+	// start again from zero if their spans are seen again, like a counter
+	// reset. They are looked for every spanMetricsExpiryInterval.
+	spanMetricsSeriesTTL      = 30 * time.Minute
+	spanMetricsExpiryInterval = time.Minute
+
+	DefaultSpanMetricsMaxSeries = 10000
+)
+
+// DefaultSpanMetricsBuckets are the upper bounds of the buckets of the span
+// duration histogram, in seconds.
+var DefaultSpanMetricsBuckets = SpanMetricsBuckets{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// SpanMetricsConfig configures the metrics derived from the ingested spans.
+type SpanMetricsConfig struct {
+	Enabled bool
+	Buckets SpanMetricsBuckets
+	// Instance is the value of the instance label of the metrics, which
+	// keeps apart the counters of each Promscale. It defaults to the host
+	// name.
+	Instance string
+	// MaxSeries is the maximum number of services, operations, span kinds
+	// and status codes counted at once. The spans of new ones are not
+	// counted once it is reached.
+	MaxSeries int
+}
+
+// SpanMetricsBuckets are the upper bounds of the buckets of the span duration
+// histogram, in seconds. It is set from a comma separated list.
+type SpanMetricsBuckets []float64
+
+func (b *SpanMetricsBuckets) String() string {
+	bounds := make([]string, len(*b))
+	for i, bound := range *b {
+		bounds[i] = strconv.FormatFloat(bound, 'f', -1, 64)
+	}
+	return strings.Join(bounds, ",")
+}
+
+func (b *SpanMetricsBuckets) Set(s string) error {
+	var buckets SpanMetricsBuckets
+	for _, field := range strings.Split(s, ",") {
+		bound, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return fmt.Errorf("invalid bucket %q: %w", field, err)
+		}
+		if bound <= 0 || math.IsInf(bound, 0) || math.IsNaN(bound) {
+			return fmt.Errorf("invalid bucket %q: must be positive and finite", field)
+		}
+		if len(buckets) > 0 && bound <= buckets[len(buckets)-1] {
+			return fmt.Errorf("invalid bucket %q: buckets must be in increasing order", field)
+		}
+		buckets = append(buckets, bound)
+	}
+	*b = buckets
+	return nil
+}
+
+type spanMetricsKey struct {
+	serviceName string
+	operation   string
+	spanKind    string
+	statusCode  string
+}
+
+type spanMetricsSeries struct {
+	updatedAt time.Time
+	calls     uint64
+	sum       float64
+	// counts are the number of spans of each bucket, the last one being the
+	// +Inf bucket.
+	counts []uint64
+	// exemplars are the last span of each bucket since the series were
+	// last returned.
+	exemplars []*prompb.Exemplar
+}
+
+// SpanMetrics derives request rate, error rate and duration metrics of every
+// service, operation, span kind and status code from the ingested spans. The
+// metrics are cumulative since the start of Promscale, like the metrics of a
+// Prometheus client, and are labeled with the instance deriving them.
+type SpanMetrics struct {
+	buckets   SpanMetricsBuckets
+	instance  string
+	maxSeries int
+
+	mux       sync.Mutex
+	series    map[spanMetricsKey]*spanMetricsSeries
+	expiredAt time.Time
+}
+
+func NewSpanMetrics(cfg SpanMetricsConfig) (*SpanMetrics, error) {
+	instance := cfg.Instance
+	if instance == "" {
+		var err error
+		if instance, err = os.Hostname(); err != nil {
+			return nil, fmt.Errorf("span metrics instance: %w", err)
+		}
+	}
+	maxSeries := cfg.MaxSeries
+	if maxSeries <= 0 {
+		maxSeries = DefaultSpanMetricsMaxSeries
+	}
+	return &SpanMetrics{
+		buckets:   cfg.Buckets,
+		instance:  instance,
+		maxSeries: maxSeries,
+		series:    make(map[spanMetricsKey]*spanMetricsSeries),
+	}, nil
+}
+
+// Record adds the spans of traces to the metrics, and returns the series of
+// the metrics updated by the spans, with their value at time now. The
+// duration buckets have the trace ID of their last span as exemplar.
+func (m *SpanMetrics) Record(traces pdata.Traces, now time.Time) []prompb.TimeSeries {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	updated := make(map[spanMetricsKey]*spanMetricsSeries)
+	rSpans := traces.ResourceSpans()
+	for i := 0; i < rSpans.Len(); i++ {
+		rSpan := rSpans.At(i)
+		serviceName := getServiceName(rSpan)
+		instLibSpans := rSpan.InstrumentationLibrarySpans()
+		for j := 0; j < instLibSpans.Len(); j++ {
+			spans := instLibSpans.At(j).Spans()
+			for k := 0; k < spans.Len(); k++ {
+				span := spans.At(k)
+				key := spanMetricsKey{
+					serviceName: serviceName,
+					operation:   span.Name(),
+					spanKind:    span.Kind().String(),
+					statusCode:  span.Status().Code().String(),
+				}
+				s, ok := m.series[key]
+				if !ok {
+					if len(m.series) >= m.maxSeries {
+						spanMetricsDroppedSpans.Inc()
+						continue
+					}
+					s = &spanMetricsSeries{
+						counts:    make([]uint64, len(m.buckets)+1),
+						exemplars: make([]*prompb.Exemplar, len(m.buckets)+1),
+					}
+					m.series[key] = s
+				}
+
+				duration := span.EndTimestamp().AsTime().Sub(span.StartTimestamp().AsTime()).Seconds()
+				bucket := sort.SearchFloat64s(m.buckets, duration)
+				s.updatedAt = now
+				s.calls++
+				s.sum += duration
+				s.counts[bucket]++
+				s.exemplars[bucket] = &prompb.Exemplar{
+					Labels:    []prompb.Label{{Name: traceIDExemplarLabel, Value: span.TraceID().HexString()}},
+					Value:     duration,
+					Timestamp: timestamp.FromTime(span.EndTimestamp().AsTime()),
+				}
+				updated[key] = s
+			}
+		}
+	}
+
+	m.expire(now)
+	spanMetricsSeriesCount.Set(float64(len(m.series)))
+
+	ts := timestamp.FromTime(now)
+	timeseries := make([]prompb.TimeSeries, 0, len(updated)*(len(m.buckets)+4))
+	for key, s := range updated {
+		timeseries = m.appendTimeseries(timeseries, key, s, ts)
+		for i := range s.exemplars {
+			s.exemplars[i] = nil
+		}
+	}
+	return timeseries
+}
+
+// expire forgets the series not updated for spanMetricsSeriesTTL.
+func (m *SpanMetrics) expire(now time.Time) {
+	if now.Sub(m.expiredAt) < spanMetricsExpiryInterval {
+		return
+	}
+	m.expiredAt = now
+	for key, s := range m.series {
+		if now.Sub(s.updatedAt) >= spanMetricsSeriesTTL {
+			delete(m.series, key)
+		}
+	}
+}
+
+func (m *SpanMetrics) appendTimeseries(timeseries []prompb.TimeSeries, key spanMetricsKey, s *spanMetricsSeries, ts int64) []prompb.TimeSeries {
+	// the labels are sorted by name
+	newSeries := func(name string, value float64, le string) prompb.TimeSeries {
+		labels := make([]prompb.Label, 0, 7)
+		labels = append(labels,
+			prompb.Label{Name: model.MetricNameLabel, Value: name},
+			prompb.Label{Name: instanceLabel, Value: m.instance},
+		)
+		if le != "" {
+			labels = append(labels, prompb.Label{Name: model.BucketLabel, Value: le})
+		}
+		labels = append(labels,
+			prompb.Label{Name: "operation", Value: key.operation},
+			prompb.Label{Name: "service_name", Value: key.serviceName},
+			prompb.Label{Name: "span_kind", Value: key.spanKind},
+			prompb.Label{Name: "status_code", Value: key.statusCode},
+		)
+		return prompb.TimeSeries{
+			Labels:  labels,
+			Samples: []prompb.Sample{{Timestamp: ts, Value: value}},
+		}
+	}
+
+	timeseries = append(timeseries, newSeries(spanCallsMetric, float64(s.calls), ""))
+	var cumulative uint64
+	for i, count := range s.counts {
+		cumulative += count
+		le := "+Inf"
+		if i < len(m.buckets) {
+			le = strconv.FormatFloat(m.buckets[i], 'f', -1, 64)
+		}
+		bucket := newSeries(spanDurationMetric+"_bucket", float64(cumulative), le)
+		if s.exemplars[i] != nil {
+			bucket.Exemplars = []prompb.Exemplar{*s.exemplars[i]}
+		}
+		timeseries = append(timeseries, bucket)
+	}
+	timeseries = append(timeseries,
+		newSeries(spanDurationMetric+"_sum", s.sum, ""),
+		newSeries(spanDurationMetric+"_count", float64(s.calls), ""),
+	)
+	return timeseries
+}
@@ -0,0 +1,125 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package trace
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/prompb"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+func TestSpanMetricsBuckets(t *testing.T) {
+	var b SpanMetricsBuckets
+	require.NoError(t, b.Set("0.1, 1,10"))
+	require.Equal(t, SpanMetricsBuckets{0.1, 1, 10}, b)
+	require.Equal(t, "0.1,1,10", b.String())
+
+	for _, invalid := range []string{"", "a", "0", "-1", "1,+Inf", "1,1", "2,1"} {
+		require.Error(t, b.Set(invalid), invalid)
+	}
+	require.Equal(t, SpanMetricsBuckets{0.1, 1, 10}, b, "invalid buckets must not be set")
+}
+
+func TestSpanMetricsRecord(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	traceID := [16]byte{1}
+	newTraces := func(durations ...time.Duration) pdata.Traces {
+		return newSpanMetricsTraces(traceID, "operation", start, durations...)
+	}
+	now := start.Add(time.Minute)
+	m, err := NewSpanMetrics(SpanMetricsConfig{Buckets: SpanMetricsBuckets{0.1, 1}, Instance: "promscale-0"})
+	require.NoError(t, err)
+
+	byName := func(timeseries []prompb.TimeSeries) map[string]prompb.TimeSeries {
+		series := make(map[string]prompb.TimeSeries)
+		for _, ts := range timeseries {
+			name := ""
+			for _, l := range ts.Labels {
+				switch l.Name {
+				case model.MetricNameLabel:
+					name = l.Value + name
+				case model.BucketLabel:
+					name += "{le=" + l.Value + "}"
+				}
+			}
+			require.True(t, sort.SliceIsSorted(ts.Labels, func(i, j int) bool { return ts.Labels[i].Name < ts.Labels[j].Name }))
+			require.Equal(t, ts.Labels[1], prompb.Label{Name: "instance", Value: "promscale-0"})
+			require.Equal(t, ts.Labels[len(ts.Labels)-1], prompb.Label{Name: "status_code", Value: "STATUS_CODE_UNSET"})
+			require.Len(t, ts.Samples, 1)
+			require.Equal(t, now.UnixNano()/int64(time.Millisecond), ts.Samples[0].Timestamp)
+			series[name] = ts
+		}
+		return series
+	}
+
+	series := byName(m.Record(newTraces(50*time.Millisecond, 500*time.Millisecond), now))
+	require.Len(t, series, 6)
+	require.Equal(t, 2.0, series["trace_span_calls_total"].Samples[0].Value)
+	require.Equal(t, 1.0, series["trace_span_duration_seconds_bucket{le=0.1}"].Samples[0].Value)
+	require.Equal(t, 2.0, series["trace_span_duration_seconds_bucket{le=1}"].Samples[0].Value)
+	require.Equal(t, 2.0, series["trace_span_duration_seconds_bucket{le=+Inf}"].Samples[0].Value)
+	require.InDelta(t, 0.55, series["trace_span_duration_seconds_sum"].Samples[0].Value, 1e-9)
+	require.Equal(t, 2.0, series["trace_span_duration_seconds_count"].Samples[0].Value)
+	require.Equal(t, []prompb.Exemplar{{
+		Labels:    []prompb.Label{{Name: "trace_id", Value: pdata.NewTraceID(traceID).HexString()}},
+		Value:     0.05,
+		Timestamp: start.Add(50*time.Millisecond).UnixNano() / int64(time.Millisecond),
+	}}, series["trace_span_duration_seconds_bucket{le=0.1}"].Exemplars)
+	require.Empty(t, series["trace_span_duration_seconds_bucket{le=+Inf}"].Exemplars)
+
+	// The metrics are cumulative, and the exemplars are only returned once.
+	series = byName(m.Record(newTraces(2*time.Second), now))
+	require.Equal(t, 3.0, series["trace_span_calls_total"].Samples[0].Value)
+	require.Equal(t, 1.0, series["trace_span_duration_seconds_bucket{le=0.1}"].Samples[0].Value)
+	require.Empty(t, series["trace_span_duration_seconds_bucket{le=0.1}"].Exemplars)
+	require.Equal(t, 3.0, series["trace_span_duration_seconds_bucket{le=+Inf}"].Samples[0].Value)
+	require.Len(t, series["trace_span_duration_seconds_bucket{le=+Inf}"].Exemplars, 1)
+
+	require.Empty(t, m.Record(pdata.NewTraces(), now))
+}
+
+func newSpanMetricsTraces(traceID [16]byte, operation string, start time.Time, durations ...time.Duration) pdata.Traces {
+	traces := pdata.NewTraces()
+	rSpans := traces.ResourceSpans().AppendEmpty()
+	rSpans.Resource().Attributes().InsertString("service.name", "service")
+	spans := rSpans.InstrumentationLibrarySpans().AppendEmpty().Spans()
+	for _, d := range durations {
+		span := spans.AppendEmpty()
+		span.SetTraceID(pdata.NewTraceID(traceID))
+		span.SetName(operation)
+		span.SetKind(pdata.SpanKindServer)
+		span.SetStartTimestamp(pdata.NewTimestampFromTime(start))
+		span.SetEndTimestamp(pdata.NewTimestampFromTime(start.Add(d)))
+	}
+	return traces
+}
+
+func TestSpanMetricsBounds(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	m, err := NewSpanMetrics(SpanMetricsConfig{Buckets: SpanMetricsBuckets{1}, Instance: "promscale-0", MaxSeries: 2})
+	require.NoError(t, err)
+	record := func(operation string, now time.Time) int {
+		return len(m.Record(newSpanMetricsTraces([16]byte{1}, operation, start, time.Millisecond), now))
+	}
+
+	// calls, 2 buckets, sum and count
+	require.Equal(t, 5, record("a", start))
+	require.Equal(t, 5, record("b", start))
+	dropped := testutil.ToFloat64(spanMetricsDroppedSpans)
+	require.Equal(t, 0, record("c", start), "the maximum number of series is reached")
+	require.Equal(t, dropped+1, testutil.ToFloat64(spanMetricsDroppedSpans))
+
+	// The series not updated for spanMetricsSeriesTTL are forgotten.
+	require.Equal(t, 5, record("a", start.Add(spanMetricsSeriesTTL/2)))
+	require.Equal(t, 5, record("a", start.Add(spanMetricsSeriesTTL)))
+	require.Len(t, m.series, 1)
+	require.Equal(t, 5, record("c", start.Add(spanMetricsSeriesTTL)))
+}
@@ -32,7 +32,8 @@ type tagIDs struct {
 }
 
 //tagBatch queues up items to send to the db but it sorts before sending
-//this avoids deadlocks in the db. It also avoids sending the same tags repeatedly.
+//this avoids deadlocks in the db. It also avoids sending the same tags repeatedly,
+//and the tags whose ids are cached.
 type tagBatch map[tag]tagIDs
 
 func newTagBatch() tagBatch {
@@ -50,12 +51,17 @@ func (batch tagBatch) Queue(tags map[string]interface{}, typ TagType) error {
 	return nil
 }
 
-func (batch tagBatch) SendBatch(ctx context.Context, conn pgxconn.PgxConn) error {
-	tags := make([]tag, len(batch))
-	i := 0
-	for op := range batch {
-		tags[i] = op
-		i++
+func (batch tagBatch) SendBatch(ctx context.Context, conn pgxconn.PgxConn, cache idCacheView) error {
+	tags := make([]tag, 0, len(batch))
+	for t := range batch {
+		if ids, ok := cache.getTag(t); ok {
+			batch[t] = ids
+			continue
+		}
+		tags = append(tags, t)
+	}
+	if len(tags) == 0 {
+		return nil
 	}
 	sort.Slice(tags, func(i, j int) bool {
 		if tags[i].key != tags[j].key {
@@ -91,6 +97,7 @@ func (batch tagBatch) SendBatch(ctx context.Context, conn pgxconn.PgxConn) error
 			return err
 		}
 		batch[tag] = tagIDs{keyID: keyID, valueID: valueID}
+		cache.putTag(tag, batch[tag])
 	}
 	if err = br.Close(); err != nil {
 		return err
@@ -45,12 +45,14 @@ type Writer interface {
 }
 
 type traceWriterImpl struct {
-	conn pgxconn.PgxConn
+	conn    pgxconn.PgxConn
+	idCache *idCache
 }
 
-func NewWriter(conn pgxconn.PgxConn) *traceWriterImpl {
+func NewWriter(conn pgxconn.PgxConn, cacheCfg IDCacheConfig) *traceWriterImpl {
 	return &traceWriterImpl{
-		conn: conn,
+		conn:    conn,
+		idCache: newIDCache(cacheCfg),
 	}
 }
 
@@ -112,6 +114,10 @@ func getServiceName(rSpan pdata.ResourceSpans) string {
 }
 
 func (t *traceWriterImpl) InsertTraces(ctx context.Context, traces pdata.Traces) error {
+	cache, err := t.idCache.view(ctx, t.conn)
+	if err != nil {
+		return err
+	}
 	rSpans := traces.ResourceSpans()
 
 	sURLBatch := newSchemaUrlBatch()
@@ -127,7 +133,7 @@ func (t *traceWriterImpl) InsertTraces(ctx context.Context, traces pdata.Traces)
 			sURLBatch.Queue(url)
 		}
 	}
-	if err := sURLBatch.SendBatch(ctx, t.conn); err != nil {
+	if err := sURLBatch.SendBatch(ctx, t.conn, cache); err != nil {
 		return err
 	}
 
@@ -185,13 +191,13 @@ func (t *traceWriterImpl) InsertTraces(ctx context.Context, traces pdata.Traces)
 			}
 		}
 	}
-	if err := instrLibBatch.SendBatch(ctx, t.conn); err != nil {
+	if err := instrLibBatch.SendBatch(ctx, t.conn, cache); err != nil {
 		return err
 	}
-	if err := operationBatch.SendBatch(ctx, t.conn); err != nil {
+	if err := operationBatch.SendBatch(ctx, t.conn, cache); err != nil {
 		return err
 	}
-	if err := tagsBatch.SendBatch(ctx, t.conn); err != nil {
+	if err := tagsBatch.SendBatch(ctx, t.conn, cache); err != nil {
 		return err
 	}
 
@@ -0,0 +1,548 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package ingestor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgmodel/cache"
+	"github.com/timescale/promscale/pkg/pgmodel/model"
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+const (
+	walSegmentSuffix = ".wal"
+	// walRecordHeaderSize is the size of the header of each record: the length
+	// of the payload and its CRC32 checksum, as little-endian uint32s.
+	walRecordHeaderSize = 8
+	// maxWALSegmentSize is the size after which a new segment is started.
+	// Segments are removed once all their records are in the database.
+	maxWALSegmentSize = 64 << 20
+)
+
+var (
+	walCastagnoli = crc32.MakeTable(crc32.Castagnoli)
+	errWALClosed  = fmt.Errorf("write-ahead log is closed")
+)
+
+// walSegment is a file of the WAL, along with the number of its records which
+// are not in the database yet.
+type walSegment struct {
+	index   int
+	size    int64
+	pending int
+	// sealed is set once no more records are appended to the segment.
+	sealed bool
+}
+
+// walRecord is a record read back from the WAL when it is opened.
+type walRecord struct {
+	data    model.Data
+	segment *walSegment
+}
+
+// wal is a write-ahead log keeping the data acknowledged with async acks on
+// disk until it is inserted into the database. Records are appended to
+// segment files, which are removed once all their records are inserted.
+// Appends block while the WAL is over its maximum size, pushing back on the
+// senders when the database is not keeping up or unavailable.
+type wal struct {
+	dir         string
+	maxSize     int64
+	segmentSize int64
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	file    *os.File
+	current *walSegment
+	size    int64
+	closed  bool
+}
+
+// openWAL opens the WAL in the given directory, creating it if needed. It
+// returns the records left over by the previous run, which have to be
+// inserted and reported as done like newly appended ones.
+func openWAL(dir string, maxSize int64, scache cache.SeriesCache) (*wal, []walRecord, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, nil, fmt.Errorf("creating WAL directory: %w", err)
+	}
+	segmentSize := int64(maxWALSegmentSize)
+	if maxSize/4 < segmentSize {
+		segmentSize = maxSize / 4
+	}
+	w := &wal{
+		dir:         dir,
+		maxSize:     maxSize,
+		segmentSize: segmentSize,
+	}
+	w.cond = sync.NewCond(&w.mu)
+
+	indexes, err := w.segmentIndexes()
+	if err != nil {
+		return nil, nil, err
+	}
+	var (
+		records   []walRecord
+		nextIndex int
+	)
+	for _, index := range indexes {
+		nextIndex = index + 1
+		segment := &walSegment{index: index, sealed: true}
+		segmentRecords, err := w.readSegment(segment, scache)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(segmentRecords) == 0 {
+			if err := os.Remove(w.segmentPath(index)); err != nil {
+				return nil, nil, fmt.Errorf("removing WAL segment: %w", err)
+			}
+			continue
+		}
+		segment.pending = len(segmentRecords)
+		w.size += segment.size
+		records = append(records, segmentRecords...)
+	}
+
+	if err := w.openSegment(nextIndex); err != nil {
+		return nil, nil, err
+	}
+	walSizeBytes.Set(float64(w.size))
+	return w, records, nil
+}
+
+func (w *wal) segmentPath(index int) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%08d%s", index, walSegmentSuffix))
+}
+
+// segmentIndexes returns the sorted indexes of the segments in the WAL directory.
+func (w *wal) segmentIndexes() ([]int, error) {
+	files, err := ioutil.ReadDir(w.dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading WAL directory: %w", err)
+	}
+	var indexes []int
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasSuffix(name, walSegmentSuffix) {
+			continue
+		}
+		index, err := strconv.Atoi(strings.TrimSuffix(name, walSegmentSuffix))
+		if err != nil {
+			continue
+		}
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	return indexes, nil
+}
+
+// readSegment reads all the records of a segment. A torn or corrupted record,
+// e.g. because of a crash during a write, ends the segment.
+func (w *wal) readSegment(segment *walSegment, scache cache.SeriesCache) ([]walRecord, error) {
+	path := w.segmentPath(segment.index)
+	b, err := ioutil.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("reading WAL segment: %w", err)
+	}
+	segment.size = int64(len(b))
+
+	var records []walRecord
+	for offset := 0; offset < len(b); {
+		payload, err := readWALRecord(b[offset:])
+		if err == nil {
+			var data model.Data
+			data, err = decodeWALRecord(payload, scache)
+			if err == nil {
+				records = append(records, walRecord{data: data, segment: segment})
+				offset += walRecordHeaderSize + len(payload)
+				continue
+			}
+		}
+		log.Warn("msg", "Skipping the rest of a corrupted WAL segment", "segment", path, "offset", offset, "err", err)
+		break
+	}
+	return records, nil
+}
+
+func (w *wal) openSegment(index int) error {
+	f, err := os.OpenFile(w.segmentPath(index), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("creating WAL segment: %w", err)
+	}
+	w.file = f
+	w.current = &walSegment{index: index}
+	return nil
+}
+
+// rotate seals the current segment and starts a new one.
+func (w *wal) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("closing WAL segment: %w", err)
+	}
+	w.current.sealed = true
+	if w.current.pending == 0 {
+		w.remove(w.current)
+	}
+	return w.openSegment(w.current.index + 1)
+}
+
+func (w *wal) remove(segment *walSegment) {
+	if err := os.Remove(w.segmentPath(segment.index)); err != nil {
+		log.Error("msg", "Error removing WAL segment", "segment", segment.index, "err", err)
+	}
+	w.size -= segment.size
+	walSizeBytes.Set(float64(w.size))
+}
+
+// append writes the data to the WAL and syncs it to disk. It blocks while
+// the WAL is full. The returned segment has to be passed to done once the
+// data is in the database.
+func (w *wal) append(data model.Data) (*walSegment, error) {
+	record, err := encodeWALRecord(data)
+	if err != nil {
+		return nil, err
+	}
+	n := int64(len(record))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for !w.closed && w.size > 0 && w.size+n > w.maxSize {
+		// The current segment only goes away once it is sealed.
+		if w.current.size > 0 && w.current.pending == 0 {
+			if err := w.rotate(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		walBackpressureWaits.Inc()
+		w.cond.Wait()
+	}
+	if w.closed {
+		return nil, errWALClosed
+	}
+	if w.current.size > 0 && w.current.size+n > w.segmentSize {
+		if err := w.rotate(); err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = w.file.Write(record)
+	if err == nil {
+		err = w.file.Sync()
+	}
+	if err != nil {
+		// Drop the partial record, so that it does not hide the records
+		// appended after it.
+		_ = w.file.Truncate(w.current.size)
+		return nil, fmt.Errorf("writing WAL record: %w", err)
+	}
+	w.current.size += n
+	w.current.pending++
+	w.size += n
+	walSizeBytes.Set(float64(w.size))
+	return w.current, nil
+}
+
+// done reports that one of the records of the segment is in the database.
+func (w *wal) done(segment *walSegment) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	segment.pending--
+	if segment.pending == 0 && segment.sealed {
+		w.remove(segment)
+	}
+	w.cond.Broadcast()
+}
+
+// close closes the WAL, failing the pending and future appends. The records
+// which are not done yet stay on disk and are replayed on the next start.
+func (w *wal) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	w.cond.Broadcast()
+	return w.file.Close()
+}
+
+// readWALRecord returns the payload of the record at the beginning of b.
+func readWALRecord(b []byte) ([]byte, error) {
+	if len(b) < walRecordHeaderSize {
+		return nil, fmt.Errorf("truncated record header")
+	}
+	size := binary.LittleEndian.Uint32(b)
+	if uint64(size) > uint64(len(b)-walRecordHeaderSize) {
+		return nil, fmt.Errorf("truncated record")
+	}
+	payload := b[walRecordHeaderSize : walRecordHeaderSize+int(size)]
+	if crc32.Checksum(payload, walCastagnoli) != binary.LittleEndian.Uint32(b[4:]) {
+		return nil, fmt.Errorf("record checksum mismatch")
+	}
+	return payload, nil
+}
+
+// encodeWALRecord encodes the data as a WAL record, header included. Series
+// are stored using their string representation, which holds all their labels.
+func encodeWALRecord(data model.Data) ([]byte, error) {
+	e := walEncoder{buf: make([]byte, walRecordHeaderSize, 4096)}
+	e.varint(data.ReceivedTime.UnixNano())
+	e.uvarint(uint64(len(data.Rows)))
+	for metric, insertables := range data.Rows {
+		e.string(metric)
+		e.uvarint(uint64(len(insertables)))
+		for _, insertable := range insertables {
+			e.buf = append(e.buf, byte(insertable.Type()))
+			e.string(insertable.Series().String())
+			e.uvarint(uint64(insertable.Count()))
+			switch it := insertable.Iterator().(type) {
+			case model.SamplesIterator:
+				for it.HasNext() {
+					ts, v := it.Value()
+					e.varint(ts)
+					e.float(v)
+				}
+			case model.ExemplarsIterator:
+				for it.HasNext() {
+					labels, ts, v := it.Value()
+					e.uvarint(uint64(len(labels)))
+					for _, l := range labels {
+						e.string(l.Name)
+						e.string(l.Value)
+					}
+					e.varint(ts)
+					e.float(v)
+				}
+			case model.HistogramsIterator:
+				for it.HasNext() {
+					h := it.Value()
+					e.varint(h.Timestamp)
+					e.float(h.Count)
+					e.float(h.Sum)
+					e.varint(int64(h.Schema))
+					e.float(h.ZeroThreshold)
+					e.float(h.ZeroCount)
+					e.varint(int64(h.PositiveOffset))
+					e.floats(h.PositiveCounts)
+					e.varint(int64(h.NegativeOffset))
+					e.floats(h.NegativeCounts)
+				}
+			default:
+				return nil, fmt.Errorf("unsupported insertable type %d", insertable.Type())
+			}
+		}
+	}
+	payload := e.buf[walRecordHeaderSize:]
+	binary.LittleEndian.PutUint32(e.buf, uint32(len(payload)))
+	binary.LittleEndian.PutUint32(e.buf[4:], crc32.Checksum(payload, walCastagnoli))
+	return e.buf, nil
+}
+
+// decodeWALRecord decodes the payload of a WAL record, getting the series
+// from the series cache.
+func decodeWALRecord(payload []byte, scache cache.SeriesCache) (model.Data, error) {
+	d := walDecoder{buf: payload}
+	data := model.Data{ReceivedTime: time.Unix(0, d.varint())}
+	numMetrics := d.count()
+	data.Rows = make(map[string][]model.Insertable, numMetrics)
+	for i := 0; i < numMetrics && d.err == nil; i++ {
+		var (
+			metric      = d.string()
+			n           = d.count()
+			insertables = make([]model.Insertable, 0, n)
+		)
+		for j := 0; j < n && d.err == nil; j++ {
+			var (
+				typ   = model.InsertableType(d.byte())
+				key   = d.string()
+				count = d.count()
+			)
+			if d.err != nil {
+				break
+			}
+			labels, err := cache.SeriesLabelsFromKey(key)
+			if err != nil {
+				return data, err
+			}
+			series, _, err := scache.GetSeriesFromProtos(labels)
+			if err != nil {
+				return data, err
+			}
+			switch typ {
+			case model.Sample:
+				samples := make([]prompb.Sample, count)
+				for k := range samples {
+					samples[k].Timestamp = d.varint()
+					samples[k].Value = d.float()
+				}
+				insertables = append(insertables, model.NewPromSamples(series, samples))
+			case model.Exemplar:
+				exemplars := make([]prompb.Exemplar, count)
+				for k := range exemplars {
+					exemplars[k].Labels = make([]prompb.Label, d.count())
+					for l := range exemplars[k].Labels {
+						exemplars[k].Labels[l].Name = d.string()
+						exemplars[k].Labels[l].Value = d.string()
+					}
+					exemplars[k].Timestamp = d.varint()
+					exemplars[k].Value = d.float()
+				}
+				insertables = append(insertables, model.NewPromExemplars(series, exemplars))
+			case model.Histogram:
+				histograms := make([]model.NativeHistogram, count)
+				for k := range histograms {
+					h := &histograms[k]
+					h.Timestamp = d.varint()
+					h.Count = d.float()
+					h.Sum = d.float()
+					h.Schema = int32(d.varint())
+					h.ZeroThreshold = d.float()
+					h.ZeroCount = d.float()
+					h.PositiveOffset = int32(d.varint())
+					h.PositiveCounts = d.floats()
+					h.NegativeOffset = int32(d.varint())
+					h.NegativeCounts = d.floats()
+				}
+				insertables = append(insertables, model.NewPromHistograms(series, histograms))
+			default:
+				return data, fmt.Errorf("unsupported insertable type %d", typ)
+			}
+		}
+		data.Rows[metric] = insertables
+	}
+	if d.err == nil && len(d.buf) > 0 {
+		d.err = fmt.Errorf("%d trailing bytes", len(d.buf))
+	}
+	return data, d.err
+}
+
+type walEncoder struct {
+	buf []byte
+	tmp [binary.MaxVarintLen64]byte
+}
+
+func (e *walEncoder) uvarint(v uint64) {
+	n := binary.PutUvarint(e.tmp[:], v)
+	e.buf = append(e.buf, e.tmp[:n]...)
+}
+
+func (e *walEncoder) varint(v int64) {
+	n := binary.PutVarint(e.tmp[:], v)
+	e.buf = append(e.buf, e.tmp[:n]...)
+}
+
+func (e *walEncoder) float(v float64) {
+	binary.LittleEndian.PutUint64(e.tmp[:], math.Float64bits(v))
+	e.buf = append(e.buf, e.tmp[:8]...)
+}
+
+func (e *walEncoder) floats(v []float64) {
+	e.uvarint(uint64(len(v)))
+	for _, f := range v {
+		e.float(f)
+	}
+}
+
+func (e *walEncoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// walDecoder decodes the fields of a WAL record. The first error is kept and
+// all the subsequent reads return zero values.
+type walDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *walDecoder) fail(what string) {
+	if d.err == nil {
+		d.err = fmt.Errorf("invalid WAL record: truncated %s", what)
+	}
+	d.buf = nil
+}
+
+func (d *walDecoder) byte() byte {
+	if len(d.buf) < 1 {
+		d.fail("byte")
+		return 0
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b
+}
+
+func (d *walDecoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.fail("uvarint")
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *walDecoder) varint() int64 {
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.fail("varint")
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+// count reads a number of elements, each of them taking at least a byte, so
+// that a corrupted count cannot cause huge allocations.
+func (d *walDecoder) count() int {
+	v := d.uvarint()
+	if v > uint64(len(d.buf)) {
+		d.fail("count")
+		return 0
+	}
+	return int(v)
+}
+
+func (d *walDecoder) float() float64 {
+	if len(d.buf) < 8 {
+		d.fail("float")
+		return 0
+	}
+	v := math.Float64frombits(binary.LittleEndian.Uint64(d.buf))
+	d.buf = d.buf[8:]
+	return v
+}
+
+func (d *walDecoder) floats() []float64 {
+	n := d.count()
+	if n == 0 {
+		return nil
+	}
+	v := make([]float64, n)
+	for i := range v {
+		v[i] = d.float()
+	}
+	return v
+}
+
+func (d *walDecoder) string() string {
+	n := d.count()
+	s := string(d.buf[:n])
+	d.buf = d.buf[n:]
+	return s
+}
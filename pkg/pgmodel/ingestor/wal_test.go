@@ -0,0 +1,213 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package ingestor
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/pgmodel/cache"
+	"github.com/timescale/promscale/pkg/pgmodel/model"
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+func walTestData(t *testing.T, scache cache.SeriesCache, metric string, numSamples int) model.Data {
+	series, _, err := scache.GetSeriesFromProtos([]prompb.Label{
+		{Name: model.MetricNameLabelName, Value: metric},
+		{Name: "job", Value: "test"},
+	})
+	require.NoError(t, err)
+	samples := make([]prompb.Sample, numSamples)
+	for i := range samples {
+		samples[i] = prompb.Sample{Timestamp: int64(i), Value: float64(i) / 2}
+	}
+	exemplars := []prompb.Exemplar{
+		{Labels: []prompb.Label{{Name: "trace_id", Value: "abc"}}, Timestamp: 5, Value: 1.5},
+	}
+	histograms := []model.NativeHistogram{
+		{Timestamp: 7, Count: 4, Sum: 2.5, Schema: 1, ZeroThreshold: 0.001, ZeroCount: 1,
+			PositiveOffset: -1, PositiveCounts: []float64{1, 0, 2}, NegativeOffset: 2, NegativeCounts: nil},
+	}
+	return model.Data{
+		Rows: map[string][]model.Insertable{
+			metric: {
+				model.NewPromSamples(series, samples),
+				model.NewPromExemplars(series, exemplars),
+				model.NewPromHistograms(series, histograms),
+			},
+		},
+		ReceivedTime: time.Unix(0, 1234),
+	}
+}
+
+func TestWALRecordRoundTrip(t *testing.T) {
+	scache := cache.NewSeriesCache(cache.DefaultConfig, nil)
+	data := walTestData(t, scache, "metric_a", 3)
+
+	record, err := encodeWALRecord(data)
+	require.NoError(t, err)
+	payload, err := readWALRecord(record)
+	require.NoError(t, err)
+
+	// Decode into a fresh cache, as on startup.
+	decoded, err := decodeWALRecord(payload, cache.NewSeriesCache(cache.DefaultConfig, nil))
+	require.NoError(t, err)
+	require.Equal(t, data.ReceivedTime, decoded.ReceivedTime)
+	require.Len(t, decoded.Rows["metric_a"], 3)
+	for i, insertable := range decoded.Rows["metric_a"] {
+		expected := data.Rows["metric_a"][i]
+		require.Equal(t, expected.Type(), insertable.Type())
+		require.Equal(t, expected.Series().String(), insertable.Series().String())
+		require.Equal(t, expected.Count(), insertable.Count())
+		require.Equal(t, expected.MaxTs(), insertable.MaxTs())
+	}
+	it := decoded.Rows["metric_a"][0].Iterator().(model.SamplesIterator)
+	for i := 0; it.HasNext(); i++ {
+		ts, v := it.Value()
+		require.Equal(t, int64(i), ts)
+		require.Equal(t, float64(i)/2, v)
+	}
+	labels, ts, v := decoded.Rows["metric_a"][1].Iterator().(model.ExemplarsIterator).Value()
+	require.Equal(t, []prompb.Label{{Name: "trace_id", Value: "abc"}}, labels)
+	require.Equal(t, int64(5), ts)
+	require.Equal(t, 1.5, v)
+	h := decoded.Rows["metric_a"][2].Iterator().(model.HistogramsIterator).Value()
+	require.Equal(t, data.Rows["metric_a"][2].Iterator().(model.HistogramsIterator).Value(), h)
+
+	record[len(record)-1] ^= 0xff
+	_, err = readWALRecord(record)
+	require.Error(t, err)
+	_, err = readWALRecord(record[:len(record)-1])
+	require.Error(t, err)
+}
+
+func TestWALReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "promscale-wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	scache := cache.NewSeriesCache(cache.DefaultConfig, nil)
+
+	w, records, err := openWAL(dir, 1<<20, scache)
+	require.NoError(t, err)
+	require.Empty(t, records)
+
+	first, err := w.append(walTestData(t, scache, "metric_a", 1))
+	require.NoError(t, err)
+	_, err = w.append(walTestData(t, scache, "metric_b", 2))
+	require.NoError(t, err)
+	w.done(first)
+	require.NoError(t, w.close())
+	_, err = w.append(walTestData(t, scache, "metric_c", 1))
+	require.Equal(t, errWALClosed, err)
+
+	// Simulate a crash in the middle of a write.
+	f, err := os.OpenFile(w.segmentPath(0), os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{42, 0, 0})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// Records are only removed with their segment, so both are replayed.
+	w, records, err = openWAL(dir, 1<<20, cache.NewSeriesCache(cache.DefaultConfig, nil))
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Contains(t, records[0].data.Rows, "metric_a")
+	require.Contains(t, records[1].data.Rows, "metric_b")
+	require.Equal(t, 1, w.current.index)
+
+	for _, r := range records {
+		w.done(r.segment)
+	}
+	_, err = os.Stat(w.segmentPath(0))
+	require.True(t, os.IsNotExist(err))
+	require.Equal(t, int64(0), w.size)
+	require.NoError(t, w.close())
+
+	w, records, err = openWAL(dir, 1<<20, scache)
+	require.NoError(t, err)
+	require.Empty(t, records)
+	require.NoError(t, w.close())
+	files, err := filepath.Glob(filepath.Join(dir, "*"+walSegmentSuffix))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+}
+
+func TestWALBackpressure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "promscale-wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	scache := cache.NewSeriesCache(cache.DefaultConfig, nil)
+
+	data := walTestData(t, scache, "metric_a", 10)
+	record, err := encodeWALRecord(data)
+	require.NoError(t, err)
+
+	// Room for two records only.
+	w, _, err := openWAL(dir, int64(len(record))*5/2, scache)
+	require.NoError(t, err)
+	defer w.close()
+
+	segments := make([]*walSegment, 0, 3)
+	for i := 0; i < 2; i++ {
+		s, err := w.append(data)
+		require.NoError(t, err)
+		segments = append(segments, s)
+	}
+
+	appended := make(chan *walSegment)
+	go func() {
+		s, _ := w.append(data)
+		appended <- s
+	}()
+	select {
+	case <-appended:
+		t.Fatal("append should wait for the WAL to be drained")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	w.done(segments[0])
+	select {
+	case s := <-appended:
+		require.NotNil(t, s)
+		segments = append(segments, s)
+	case <-time.After(5 * time.Second):
+		t.Fatal("append should resume once a record is done")
+	}
+	for _, s := range segments[1:] {
+		w.done(s)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	require.LessOrEqual(t, w.size, w.maxSize)
+}
+
+func TestIsRetryableInsertError(t *testing.T) {
+	testCases := []struct {
+		err       error
+		retryable bool
+	}{
+		{err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, retryable: true},
+		{err: fmt.Errorf("copier: %w", io.ErrUnexpectedEOF), retryable: true},
+		{err: &pgconn.PgError{Code: "57P01"}, retryable: true},
+		{err: fmt.Errorf("copier: %w", &pgconn.PgError{Code: "08006"}), retryable: true},
+		{err: &pgconn.PgError{Code: "40001"}, retryable: true},
+		{err: &pgconn.PgError{Code: "53100"}, retryable: false},
+		{err: &pgconn.PgError{Code: "22003"}, retryable: false},
+		{err: fmt.Errorf("copier: %w", &pgconn.PgError{Code: "23505"}), retryable: false},
+		{err: fmt.Errorf("some other error"), retryable: false},
+	}
+	for _, c := range testCases {
+		require.Equal(t, c.retryable, isRetryableInsertError(c.err), c.err.Error())
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"context"
 	"fmt"
 	"sort"
+	"strings"
 	"unsafe"
 
 	"github.com/prometheus/prometheus/pkg/labels"
@@ -22,14 +23,60 @@ const (
 	getLabelNamesSQL  = "SELECT distinct key from " + schema.Catalog + ".label"
 	getLabelValuesSQL = "SELECT value from " + schema.Catalog + ".label WHERE key = $1"
 	getLabelsSQL      = "SELECT (" + schema.Prom + ".labels_info($1::int[])).*"
+
+	// filteredSeriesSQL selects the series of the filtered label lookups,
+	// in which the series table is aliased as s.
+	filteredSeriesSQL = "s.delete_epoch IS NULL AND %s"
+	// metricsInRangeSQL restricts the series of the filtered label lookups
+	// to the metrics with data in the time range of the filter.
+	metricsInRangeSQL = " AND s.metric_id IN (SELECT m.id FROM " + schema.Catalog + ".metric m WHERE " +
+		schema.Catalog + ".metric_has_data_in_range(m.table_schema, m.table_name, m.is_view, $%d::timestamptz, $%d::timestamptz))"
+	getFilteredLabelNamesSQL = "SELECT distinct l.key from " + schema.Catalog + ".label l WHERE l.id IN " +
+		"(SELECT unnest(s.labels) FROM " + schema.Catalog + ".series s WHERE %s)"
+	getFilteredLabelValuesSQL = "SELECT l.value from " + schema.Catalog + ".label l WHERE l.key = $%d AND EXISTS " +
+		"(SELECT 1 FROM " + schema.Catalog + ".series s WHERE s.labels @> array[l.id] AND %s)"
 )
 
+// SeriesFilter restricts label lookups to the labels of some series.
+type SeriesFilter struct {
+	// Clauses are SQL conditions on the labels column of the series
+	// table, using the positional parameters in Args.
+	Clauses []string
+	Args    []interface{}
+	// Start and End restrict the series to the metrics with data in the
+	// time range, if set. They are parsed as timestamptz.
+	Start, End string
+}
+
+// Where returns the conditions selecting the series of the filter, on the
+// series table aliased as s, with the arguments of its positional parameters.
+// The parameters of the caller follow them.
+func (f *SeriesFilter) Where() (string, []interface{}) {
+	clauses := "TRUE"
+	if len(f.Clauses) > 0 {
+		clauses = "(" + strings.Join(f.Clauses, ") AND (") + ")"
+	}
+	where := fmt.Sprintf(filteredSeriesSQL, clauses)
+	args := append([]interface{}{}, f.Args...)
+	if f.Start != "" && f.End != "" {
+		where += fmt.Sprintf(metricsInRangeSQL, len(args)+1, len(args)+2)
+		args = append(args, f.Start, f.End)
+	}
+	return where, args
+}
+
 // LabelsReader defines the methods for accessing labels data
 type LabelsReader interface {
 	// LabelNames returns all the distinct label names in the system.
 	LabelNames() ([]string, error)
 	// LabelValues returns all the distinct values for a given label name.
 	LabelValues(labelName string) ([]string, error)
+	// FilteredLabelNames returns the distinct label names of the series
+	// selected by the filter.
+	FilteredLabelNames(filter *SeriesFilter) ([]string, error)
+	// FilteredLabelValues returns the distinct values for a given label name
+	// in the series selected by the filter.
+	FilteredLabelValues(labelName string, filter *SeriesFilter) ([]string, error)
 	// LabelsForIdMap fills in the label.Label values in a map of label id => labels.Label.
 	LabelsForIdMap(idMap map[int64]labels.Label) (err error)
 }
@@ -46,51 +93,53 @@ type labelsReader struct {
 // LabelValues implements the LabelsReader interface. It returns all distinct values
 // for a specified label name.
 func (lr *labelsReader) LabelValues(labelName string) ([]string, error) {
-	rows, err := lr.conn.Query(context.Background(), getLabelValuesSQL, labelName)
-	if err != nil {
-		return nil, err
-	}
-
-	defer rows.Close()
-
-	labelValues := make([]string, 0)
-
-	for rows.Next() {
-		var value string
-		if err := rows.Scan(&value); err != nil {
-			return nil, err
-		}
-
-		labelValues = append(labelValues, value)
-	}
+	return lr.queryStrings(getLabelValuesSQL, labelName)
+}
 
-	sort.Strings(labelValues)
-	return labelValues, nil
+// FilteredLabelValues implements the LabelsReader interface. It returns the
+// distinct values for a specified label name in the series selected by the
+// filter.
+func (lr *labelsReader) FilteredLabelValues(labelName string, filter *SeriesFilter) ([]string, error) {
+	where, args := filter.Where()
+	sql := fmt.Sprintf(getFilteredLabelValuesSQL, len(args)+1, where)
+	return lr.queryStrings(sql, append(args, labelName)...)
 }
 
 // LabelNames implements the LabelReader interface. It returns all distinct
 // label names available in the database.
 func (lr *labelsReader) LabelNames() ([]string, error) {
-	rows, err := lr.conn.Query(context.Background(), getLabelNamesSQL)
+	return lr.queryStrings(getLabelNamesSQL)
+}
+
+// FilteredLabelNames implements the LabelReader interface. It returns the
+// distinct label names of the series selected by the filter.
+func (lr *labelsReader) FilteredLabelNames(filter *SeriesFilter) ([]string, error) {
+	where, args := filter.Where()
+	return lr.queryStrings(fmt.Sprintf(getFilteredLabelNamesSQL, where), args...)
+}
+
+// queryStrings returns the sorted strings returned by the query.
+func (lr *labelsReader) queryStrings(sql string, args ...interface{}) ([]string, error) {
+	rows, err := lr.conn.Query(context.Background(), sql, args...)
 	if err != nil {
 		return nil, err
 	}
 
 	defer rows.Close()
 
-	labelNames := make([]string, 0)
+	res := make([]string, 0)
 
 	for rows.Next() {
-		var labelName string
-		if err := rows.Scan(&labelName); err != nil {
+		var value string
+		if err := rows.Scan(&value); err != nil {
 			return nil, err
 		}
 
-		labelNames = append(labelNames, labelName)
+		res = append(res, value)
 	}
 
-	sort.Strings(labelNames)
-	return labelNames, nil
+	sort.Strings(res)
+	return res, nil
 }
 
 // LabelsForIdMap fills in the label.Label values in a map of label id => labels.Label.
@@ -186,3 +186,83 @@ func TestLabelsReaderLabelsValues(t *testing.T) {
 		})
 	}
 }
+
+func TestLabelsReaderFilteredLabels(t *testing.T) {
+	filter := &SeriesFilter{
+		Clauses: []string{"labels && $1", "NOT labels && $2"},
+		Args:    []interface{}{[]int32{1}, []int32{2}},
+	}
+	rangeFilter := &SeriesFilter{
+		Clauses: filter.Clauses,
+		Args:    filter.Args,
+		Start:   "2021-01-01T00:00:00Z",
+		End:     "Infinity",
+	}
+	const inRange = "s.metric_id IN (SELECT m.id FROM _prom_catalog.metric m WHERE " +
+		"_prom_catalog.metric_has_data_in_range(m.table_schema, m.table_name, m.is_view, $3::timestamptz, $4::timestamptz))"
+
+	testCases := []struct {
+		name     string
+		lookup   func(lr labelsReader) ([]string, error)
+		sqlQuery model.SqlQuery
+	}{
+		{
+			name:   "Names without time range",
+			lookup: func(lr labelsReader) ([]string, error) { return lr.FilteredLabelNames(filter) },
+			sqlQuery: model.SqlQuery{
+				Sql: "SELECT distinct l.key from _prom_catalog.label l WHERE l.id IN (SELECT unnest(s.labels) FROM _prom_catalog.series s " +
+					"WHERE s.delete_epoch IS NULL AND (labels && $1) AND (NOT labels && $2))",
+				Args: []interface{}{[]int32{1}, []int32{2}},
+			},
+		}, {
+			name: "Names with time range only",
+			lookup: func(lr labelsReader) ([]string, error) {
+				return lr.FilteredLabelNames(&SeriesFilter{Start: "-Infinity", End: "Infinity"})
+			},
+			sqlQuery: model.SqlQuery{
+				Sql: "SELECT distinct l.key from _prom_catalog.label l WHERE l.id IN (SELECT unnest(s.labels) FROM _prom_catalog.series s " +
+					"WHERE s.delete_epoch IS NULL AND TRUE AND s.metric_id IN (SELECT m.id FROM _prom_catalog.metric m WHERE " +
+					"_prom_catalog.metric_has_data_in_range(m.table_schema, m.table_name, m.is_view, $1::timestamptz, $2::timestamptz)))",
+				Args: []interface{}{"-Infinity", "Infinity"},
+			},
+		}, {
+			name:   "Names with time range",
+			lookup: func(lr labelsReader) ([]string, error) { return lr.FilteredLabelNames(rangeFilter) },
+			sqlQuery: model.SqlQuery{
+				Sql: "SELECT distinct l.key from _prom_catalog.label l WHERE l.id IN (SELECT unnest(s.labels) FROM _prom_catalog.series s " +
+					"WHERE s.delete_epoch IS NULL AND (labels && $1) AND (NOT labels && $2) AND " + inRange + ")",
+				Args: []interface{}{[]int32{1}, []int32{2}, "2021-01-01T00:00:00Z", "Infinity"},
+			},
+		}, {
+			name:   "Values without time range",
+			lookup: func(lr labelsReader) ([]string, error) { return lr.FilteredLabelValues("job", filter) },
+			sqlQuery: model.SqlQuery{
+				Sql: "SELECT l.value from _prom_catalog.label l WHERE l.key = $3 AND EXISTS (SELECT 1 FROM _prom_catalog.series s " +
+					"WHERE s.labels @> array[l.id] AND s.delete_epoch IS NULL AND (labels && $1) AND (NOT labels && $2))",
+				Args: []interface{}{[]int32{1}, []int32{2}, "job"},
+			},
+		}, {
+			name:   "Values with time range",
+			lookup: func(lr labelsReader) ([]string, error) { return lr.FilteredLabelValues("job", rangeFilter) },
+			sqlQuery: model.SqlQuery{
+				Sql: "SELECT l.value from _prom_catalog.label l WHERE l.key = $5 AND EXISTS (SELECT 1 FROM _prom_catalog.series s " +
+					"WHERE s.labels @> array[l.id] AND s.delete_epoch IS NULL AND (labels && $1) AND (NOT labels && $2) AND " + inRange + ")",
+				Args: []interface{}{[]int32{1}, []int32{2}, "2021-01-01T00:00:00Z", "Infinity", "job"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.sqlQuery.Results = model.RowResults{{"b"}, {"a"}}
+			mock := model.NewSqlRecorder([]model.SqlQuery{tc.sqlQuery}, t)
+			res, err := tc.lookup(labelsReader{conn: mock})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual([]string{"a", "b"}, res) {
+				t.Errorf("expected: %v, got: %v", []string{"a", "b"}, res)
+			}
+		})
+	}
+}
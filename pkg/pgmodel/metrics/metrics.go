@@ -24,6 +24,13 @@ var (
 			Help:      "Total number of writes that contained duplicates",
 		},
 	)
+	RejectedSamples = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Name:      "rejected_samples_total",
+			Help:      "Total number of samples rejected by the reject duplicate policy, because they were not newer than the latest sample of their series.",
+		},
+	)
 	DuplicateMetrics = prometheus.NewCounter(
 		prometheus.CounterOpts{
 			Namespace: util.PromNamespace,
@@ -74,6 +81,7 @@ func init() {
 		DecompressCalls,
 		DecompressEarliest,
 		DuplicateMetrics,
+		RejectedSamples,
 		HAClusterLeaderDetails,
 		NumOfHAClusterLeaderChanges,
 		MaxSentTimestamp,
@@ -48,6 +48,7 @@ var (
 			"ha.sql",
 			"metric-metadata.sql",
 			"exemplar.sql",
+			"histogram.sql",
 			"tracing-private.sql",
 			"tracing-public.sql",
 			"tracing-public-views.sql",
@@ -391,7 +392,8 @@ func replaceSchemaNames(r io.ReadCloser) (string, error) {
 	s = strings.ReplaceAll(s, "SCHEMA_TIMESCALE", schema.Timescale)
 	s = strings.ReplaceAll(s, "SCHEMA_SERIES", schema.SeriesView)
 	s = strings.ReplaceAll(s, "SCHEMA_METRIC", schema.MetricView)
-	s = strings.ReplaceAll(s, "SCHEMA_DATA_EXEMPLAR", schema.Exemplar) // Keep this above SCHEMA_DATA to avoid conflicts.
+	s = strings.ReplaceAll(s, "SCHEMA_DATA_EXEMPLAR", schema.Exemplar)   // Keep this above SCHEMA_DATA to avoid conflicts.
+	s = strings.ReplaceAll(s, "SCHEMA_DATA_HISTOGRAM", schema.Histogram) // Keep this above SCHEMA_DATA to avoid conflicts.
 	s = strings.ReplaceAll(s, "SCHEMA_DATA_SERIES", schema.DataSeries)
 	s = strings.ReplaceAll(s, "SCHEMA_DATA", schema.Data)
 	s = strings.ReplaceAll(s, "SCHEMA_INFO", schema.Info)
@@ -399,6 +401,7 @@ func replaceSchemaNames(r io.ReadCloser) (string, error) {
 	s = strings.ReplaceAll(s, "SCHEMA_TRACING", schema.Trace)
 	s = strings.ReplaceAll(s, "ADVISORY_LOCK_PREFIX_JOB", "12377")
 	s = strings.ReplaceAll(s, "ADVISORY_LOCK_PREFIX_MAINTENACE", "12378")
+	s = strings.ReplaceAll(s, "ADVISORY_LOCK_PREFIX_TRACE_ORPHANS", "12379")
 	return s, err
 }
 
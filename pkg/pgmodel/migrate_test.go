@@ -0,0 +1,49 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package pgmodel
+
+import (
+	"os"
+	"testing"
+
+	"github.com/blang/semver/v4"
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/migrations"
+	"github.com/timescale/promscale/pkg/version"
+)
+
+// TestVersionIncludesMigrationFiles checks that the version of the app was
+// bumped along with the migration files, since only the migration files up to
+// the version of the app are applied.
+func TestVersionIncludesMigrationFiles(t *testing.T) {
+	appVersion, err := semver.Make(version.Promscale)
+	require.NoError(t, err)
+
+	mig := NewMigrator(nil, migrations.MigrationFiles, tableOfContents)
+	versionDirs, err := readDir(mig, versionScripts)
+	require.NoError(t, err)
+	for _, versionDir := range versionDirs {
+		if !versionDir.IsDir() {
+			continue
+		}
+		files, err := readDir(mig, versionScripts+"/"+versionDir.Name())
+		require.NoError(t, err)
+		for _, f := range files {
+			fileVersion, err := mig.getMigrationFileVersion(versionDir.Name(), f.Name())
+			require.NoError(t, err)
+			require.True(t, appVersion.GTE(*fileVersion),
+				"version %s does not include the migration file %s/%s, bump it to %s", appVersion, versionDir.Name(), f.Name(), fileVersion)
+		}
+	}
+}
+
+func readDir(mig *Migrator, name string) ([]os.FileInfo, error) {
+	dir, err := mig.sqlFiles.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	return dir.Readdir(-1)
+}
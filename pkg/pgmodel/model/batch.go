@@ -44,14 +44,16 @@ func (t *Batch) Data() []Insertable {
 	return t.data
 }
 
-func (t *Batch) Count() (numSamples, numExemplars int) {
+func (t *Batch) Count() (numSamples, numExemplars, numHistograms int) {
 	for _, d := range t.data {
 		if d.IsOfType(Sample) {
 			numSamples += d.Count()
 		} else if d.IsOfType(Exemplar) {
 			numExemplars += d.Count()
+		} else if d.IsOfType(Histogram) {
+			numHistograms += d.Count()
 		} else {
-			panic(fmt.Sprintf("invalid type %T. Valid options: ['Sample', 'Exemplar']", d))
+			panic(fmt.Sprintf("invalid type %T. Valid options: ['Sample', 'Exemplar', 'Histogram']", d))
 		}
 	}
 	return
@@ -32,6 +32,7 @@ func (vtr *batchVisitor) MinTime() int64 {
 func (vtr *batchVisitor) Visit(
 	visitSamples func(t time.Time, v float64, seriesId int64),
 	visitExemplars func(t time.Time, v float64, seriesId int64, lvalues []string),
+	visitHistograms func(t time.Time, h *NativeHistogram, seriesId int64),
 ) error {
 	var (
 		seriesId    SeriesID
@@ -71,6 +72,13 @@ func (vtr *batchVisitor) Visit(
 				updateMinTs(t)
 				visitExemplars(model.Time(t).Time(), v, int64(seriesId), labelsToStringSlice(l))
 			}
+		case Histogram:
+			itr := insertable.Iterator().(HistogramsIterator)
+			for itr.HasNext() {
+				h := itr.Value()
+				updateMinTs(h.Timestamp)
+				visitHistograms(model.Time(h.Timestamp).Time(), h, int64(seriesId))
+			}
 		}
 	}
 	return nil
@@ -0,0 +1,242 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package model
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+// MaxNativeHistogramBuckets is the maximum number of buckets, including the
+// empty ones between spans, stored for each side of a native histogram.
+const MaxNativeHistogramBuckets = 1 << 14
+
+// NativeHistogram is a native (sparse) histogram sample, in the layout used to
+// store it in the database. Buckets are exponential: with a given schema, the
+// positive bucket with index i has an upper bound of 2^(i*2^-schema). Buckets
+// of each side are stored as dense absolute counts, starting at the bucket
+// with index Offset.
+type NativeHistogram struct {
+	Timestamp      int64
+	Count          float64
+	Sum            float64
+	Schema         int32
+	ZeroThreshold  float64
+	ZeroCount      float64
+	PositiveOffset int32
+	PositiveCounts []float64
+	NegativeOffset int32
+	NegativeCounts []float64
+}
+
+// NewNativeHistogram decodes a native histogram received through remote-write
+// into its storage layout.
+func NewNativeHistogram(h *prompb.Histogram) (NativeHistogram, error) {
+	var err error
+	nh := NativeHistogram{
+		Timestamp:     h.Timestamp,
+		Sum:           h.Sum,
+		Schema:        h.Schema,
+		ZeroThreshold: h.ZeroThreshold,
+	}
+	if h.Schema < -4 || h.Schema > 8 {
+		return nh, fmt.Errorf("invalid native histogram schema %d", h.Schema)
+	}
+	if _, ok := h.Count.(*prompb.Histogram_CountFloat); ok {
+		nh.Count = h.GetCountFloat()
+		nh.ZeroCount = h.GetZeroCountFloat()
+		nh.PositiveOffset, nh.PositiveCounts, err = denseBuckets(h.PositiveSpans, nil, h.PositiveCounts)
+		if err != nil {
+			return nh, fmt.Errorf("positive buckets: %w", err)
+		}
+		nh.NegativeOffset, nh.NegativeCounts, err = denseBuckets(h.NegativeSpans, nil, h.NegativeCounts)
+		if err != nil {
+			return nh, fmt.Errorf("negative buckets: %w", err)
+		}
+		return nh, nil
+	}
+	nh.Count = float64(h.GetCountInt())
+	nh.ZeroCount = float64(h.GetZeroCountInt())
+	nh.PositiveOffset, nh.PositiveCounts, err = denseBuckets(h.PositiveSpans, h.PositiveDeltas, nil)
+	if err != nil {
+		return nh, fmt.Errorf("positive buckets: %w", err)
+	}
+	nh.NegativeOffset, nh.NegativeCounts, err = denseBuckets(h.NegativeSpans, h.NegativeDeltas, nil)
+	if err != nil {
+		return nh, fmt.Errorf("negative buckets: %w", err)
+	}
+	return nh, nil
+}
+
+// denseBuckets expands the spans of a histogram side into absolute counts of
+// consecutive buckets, either from the deltas of integer histograms or from
+// the counts of float histograms.
+func denseBuckets(spans []prompb.BucketSpan, deltas []int64, counts []float64) (int32, []float64, error) {
+	numBuckets := len(deltas) + len(counts)
+	expected, width := 0, 0
+	for i, s := range spans {
+		if i > 0 && s.Offset < 0 {
+			return 0, nil, fmt.Errorf("span %d has a negative offset", i)
+		}
+		expected += int(s.Length)
+		if i > 0 {
+			width += int(s.Offset)
+		}
+		width += int(s.Length)
+	}
+	if expected != numBuckets {
+		return 0, nil, fmt.Errorf("spans contain %d buckets but %d were sent", expected, numBuckets)
+	}
+	if numBuckets == 0 {
+		return 0, nil, nil
+	}
+	if width > MaxNativeHistogramBuckets {
+		return 0, nil, fmt.Errorf("too many buckets: %d, max %d", width, MaxNativeHistogramBuckets)
+	}
+
+	var (
+		dense = make([]float64, 0, width)
+		b     = 0
+		abs   int64
+	)
+	for i, s := range spans {
+		if i > 0 {
+			for j := int32(0); j < s.Offset; j++ {
+				dense = append(dense, 0)
+			}
+		}
+		for j := uint32(0); j < s.Length; j++ {
+			if deltas != nil {
+				abs += deltas[b]
+				if abs < 0 {
+					return 0, nil, fmt.Errorf("bucket %d has a negative count", b)
+				}
+				dense = append(dense, float64(abs))
+			} else {
+				dense = append(dense, counts[b])
+			}
+			b++
+		}
+	}
+	return spans[0].Offset, dense, nil
+}
+
+// HistogramBucket is a cumulative histogram bucket, as used by classic
+// Prometheus histograms.
+type HistogramBucket struct {
+	UpperBound float64
+	Count      float64
+}
+
+// bucketUpperBound returns the upper bound of the positive bucket with the
+// given index in the given schema.
+func bucketUpperBound(schema, index int32) float64 {
+	if schema >= 0 {
+		return math.Exp2(float64(index) / float64(int64(1)<<uint(schema)))
+	}
+	return math.Exp2(float64(index) * float64(int64(1)<<uint(-schema)))
+}
+
+// CumulativeBuckets returns the native histogram as cumulative buckets sorted
+// by upper bound. The last bucket always has an upper bound of +Inf and holds
+// the total count of observations.
+func (h *NativeHistogram) CumulativeBuckets() []HistogramBucket {
+	var (
+		buckets    = make([]HistogramBucket, 0, len(h.NegativeCounts)+len(h.PositiveCounts)+2)
+		cumulative float64
+	)
+	// A negative bucket with index i holds the observations between
+	// -upperBound(i) and -upperBound(i-1), so the highest index comes first.
+	for i := len(h.NegativeCounts) - 1; i >= 0; i-- {
+		cumulative += h.NegativeCounts[i]
+		idx := h.NegativeOffset + int32(i)
+		buckets = append(buckets, HistogramBucket{UpperBound: -bucketUpperBound(h.Schema, idx-1), Count: cumulative})
+	}
+	if h.ZeroCount > 0 || h.ZeroThreshold > 0 {
+		cumulative += h.ZeroCount
+		buckets = append(buckets, HistogramBucket{UpperBound: h.ZeroThreshold, Count: cumulative})
+	}
+	for i, c := range h.PositiveCounts {
+		cumulative += c
+		idx := h.PositiveOffset + int32(i)
+		buckets = append(buckets, HistogramBucket{UpperBound: bucketUpperBound(h.Schema, idx), Count: cumulative})
+	}
+	return append(buckets, HistogramBucket{UpperBound: math.Inf(1), Count: h.Count})
+}
+
+// CumulativeCounts returns the cumulative count of the histogram for each of
+// the given upper bounds, which must be sorted. Upper bounds which are not
+// bucket boundaries of the histogram get the count of the closest lower
+// boundary.
+func (h *NativeHistogram) CumulativeCounts(upperBounds []float64) []float64 {
+	buckets := h.CumulativeBuckets()
+	counts := make([]float64, len(upperBounds))
+	for i, ub := range upperBounds {
+		j := sort.Search(len(buckets), func(j int) bool { return buckets[j].UpperBound > ub })
+		if j > 0 {
+			counts[i] = buckets[j-1].Count
+		}
+	}
+	return counts
+}
+
+type promHistograms struct {
+	series     *Series
+	histograms []NativeHistogram
+}
+
+// NewPromHistograms returns an insertable holding native histograms.
+func NewPromHistograms(series *Series, histograms []NativeHistogram) Insertable {
+	return &promHistograms{series, histograms}
+}
+
+func (t *promHistograms) Series() *Series {
+	return t.series
+}
+
+func (t *promHistograms) Count() int {
+	return len(t.histograms)
+}
+
+func (t *promHistograms) MaxTs() int64 {
+	numSamples := len(t.histograms)
+	if numSamples == 0 {
+		// If no samples exist, return a -ve int, so that the stats
+		// caller does not capture this value.
+		return -1
+	}
+	return t.histograms[numSamples-1].Timestamp
+}
+
+type histogramsIterator struct {
+	curr  int
+	total int
+	data  []NativeHistogram
+}
+
+func (i *histogramsIterator) HasNext() bool {
+	return i.curr < i.total
+}
+
+func (i *histogramsIterator) Value() *NativeHistogram {
+	h := &i.data[i.curr]
+	i.curr++
+	return h
+}
+
+func (t *promHistograms) Iterator() Iterator {
+	return &histogramsIterator{data: t.histograms, total: len(t.histograms)}
+}
+
+func (t *promHistograms) Type() InsertableType {
+	return Histogram
+}
+
+func (t *promHistograms) IsOfType(typ InsertableType) bool {
+	return Histogram == typ
+}
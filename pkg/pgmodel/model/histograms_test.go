@@ -0,0 +1,136 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package model
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+func TestNewNativeHistogram(t *testing.T) {
+	testCases := []struct {
+		name     string
+		in       prompb.Histogram
+		expected NativeHistogram
+		err      bool
+	}{
+		{
+			name: "integer histogram",
+			in: prompb.Histogram{
+				Count:          &prompb.Histogram_CountInt{CountInt: 7},
+				Sum:            10,
+				Schema:         0,
+				ZeroThreshold:  0.001,
+				ZeroCount:      &prompb.Histogram_ZeroCountInt{ZeroCountInt: 1},
+				PositiveSpans:  []prompb.BucketSpan{{Offset: 0, Length: 2}, {Offset: 1, Length: 1}},
+				PositiveDeltas: []int64{1, 1, -1},
+				NegativeSpans:  []prompb.BucketSpan{{Offset: 0, Length: 1}},
+				NegativeDeltas: []int64{2},
+				Timestamp:      1000,
+			},
+			expected: NativeHistogram{
+				Timestamp:      1000,
+				Count:          7,
+				Sum:            10,
+				ZeroThreshold:  0.001,
+				ZeroCount:      1,
+				PositiveCounts: []float64{1, 2, 0, 1},
+				NegativeCounts: []float64{2},
+			},
+		},
+		{
+			name: "float histogram",
+			in: prompb.Histogram{
+				Count:          &prompb.Histogram_CountFloat{CountFloat: 3.5},
+				Sum:            4,
+				Schema:         -1,
+				PositiveSpans:  []prompb.BucketSpan{{Offset: 1, Length: 1}},
+				PositiveCounts: []float64{3.5},
+				Timestamp:      2000,
+			},
+			expected: NativeHistogram{
+				Timestamp:      2000,
+				Count:          3.5,
+				Sum:            4,
+				Schema:         -1,
+				PositiveOffset: 1,
+				PositiveCounts: []float64{3.5},
+			},
+		},
+		{
+			name: "invalid schema",
+			in:   prompb.Histogram{Schema: 9},
+			err:  true,
+		},
+		{
+			name: "spans do not match deltas",
+			in: prompb.Histogram{
+				PositiveSpans:  []prompb.BucketSpan{{Offset: 0, Length: 2}},
+				PositiveDeltas: []int64{1},
+			},
+			err: true,
+		},
+		{
+			name: "negative offset after first span",
+			in: prompb.Histogram{
+				PositiveSpans:  []prompb.BucketSpan{{Offset: 0, Length: 1}, {Offset: -1, Length: 1}},
+				PositiveDeltas: []int64{1, 1},
+			},
+			err: true,
+		},
+		{
+			name: "negative bucket count",
+			in: prompb.Histogram{
+				PositiveSpans:  []prompb.BucketSpan{{Offset: 0, Length: 2}},
+				PositiveDeltas: []int64{1, -2},
+			},
+			err: true,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			h, err := NewNativeHistogram(&c.in)
+			if c.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expected, h)
+		})
+	}
+}
+
+func TestNativeHistogramCumulativeBuckets(t *testing.T) {
+	h := NativeHistogram{
+		Count:          7,
+		ZeroThreshold:  0.001,
+		ZeroCount:      1,
+		PositiveCounts: []float64{1, 2, 0, 1},
+		NegativeCounts: []float64{2},
+	}
+	require.Equal(t, []HistogramBucket{
+		{UpperBound: -0.5, Count: 2},
+		{UpperBound: 0.001, Count: 3},
+		{UpperBound: 1, Count: 4},
+		{UpperBound: 2, Count: 6},
+		{UpperBound: 4, Count: 6},
+		{UpperBound: 8, Count: 7},
+		{UpperBound: math.Inf(1), Count: 7},
+	}, h.CumulativeBuckets())
+	require.Equal(t, []float64{0, 3, 4, 7, 7}, h.CumulativeCounts([]float64{-1, 0.001, 1.5, 8, math.Inf(1)}))
+
+	h = NativeHistogram{Count: 3.5, Schema: -1, PositiveOffset: 1, PositiveCounts: []float64{3.5}}
+	require.Equal(t, []HistogramBucket{
+		{UpperBound: 4, Count: 3.5},
+		{UpperBound: math.Inf(1), Count: 3.5},
+	}, h.CumulativeBuckets())
+
+	h = NativeHistogram{Count: 1, Schema: 2, PositiveOffset: 4, PositiveCounts: []float64{1}}
+	require.Equal(t, 2.0, h.CumulativeBuckets()[0].UpperBound)
+}
@@ -275,6 +275,10 @@ func (m *MockRows) Scan(dest ...interface{}) error {
 	}
 
 	for i := range dest {
+		if dest[i] == nil {
+			// nil skips the value entirely.
+			continue
+		}
 		switch s := m.results[m.idx][i].(type) {
 		case []time.Time:
 			if d, ok := dest[i].(*[]time.Time); ok {
@@ -348,9 +352,13 @@ func (m *MockRows) Scan(dest ...interface{}) error {
 		case time.Time:
 			if d, ok := dest[i].(*time.Time); ok {
 				*d = s
+			} else if d, ok := dest[i].(pgtype.Value); ok {
+				if err := d.Set(s); err != nil {
+					return err
+				}
 			}
 		case float64:
-			if _, ok := dest[i].(float64); !ok {
+			if _, ok := dest[i].(*float64); !ok {
 				return fmt.Errorf("wrong value type float64")
 			}
 			dv := reflect.ValueOf(dest[i])
@@ -399,6 +407,12 @@ func (m *MockRows) Scan(dest ...interface{}) error {
 			dvp := reflect.Indirect(dv)
 			dvp.SetUint(m.results[m.idx][i].(uint64))
 		case int64:
+			if d, ok := dest[i].(pgtype.Value); ok {
+				if err := d.Set(s); err != nil {
+					return err
+				}
+				continue
+			}
 			_, ok1 := dest[i].(*int64)
 			_, ok2 := dest[i].(*SeriesID)
 			_, ok3 := dest[i].(*SeriesEpoch)
@@ -424,6 +438,14 @@ func (m *MockRows) Scan(dest ...interface{}) error {
 				continue
 			}
 			return fmt.Errorf("wrong value type: neither 'string' or 'pgutf8str'")
+		case nil:
+			d, ok := dest[i].(pgtype.Value)
+			if !ok {
+				return fmt.Errorf("wrong value type nil for scan of %T", dest[i])
+			}
+			if err := d.Set(nil); err != nil {
+				return err
+			}
 		default:
 			panic(fmt.Sprintf("unhandled %T", m.results[m.idx][i]))
 		}
@@ -489,14 +511,14 @@ type MockInserter struct {
 func (m *MockInserter) Close() {}
 
 func (m *MockInserter) InsertNewData(data Data) (uint64, error) {
-	return m.InsertTs(data)
+	return m.InsertTs(context.Background(), data)
 }
 
 func (m *MockInserter) CompleteMetricCreation() error {
 	return nil
 }
 
-func (m *MockInserter) InsertTs(data Data) (uint64, error) {
+func (m *MockInserter) InsertTs(_ context.Context, data Data) (uint64, error) {
 	rows := data.Rows
 	for _, v := range rows {
 		for i, si := range v {
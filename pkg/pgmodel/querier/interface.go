@@ -7,6 +7,7 @@ import (
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/promql/parser"
 	"github.com/prometheus/prometheus/storage"
+	"github.com/timescale/promscale/pkg/pgmodel/lreader"
 	"github.com/timescale/promscale/pkg/pgmodel/model"
 	"github.com/timescale/promscale/pkg/prompb"
 )
@@ -14,6 +15,10 @@ import (
 // Reader reads the data based on the provided read request.
 type Reader interface {
 	Read(*prompb.ReadRequest) (*prompb.ReadResponse, error)
+	// ReadSeries returns the series matching a remote read query, sorted
+	// by labels, for the streamed responses. The series are read from the
+	// database while iterating over the set, which must be closed.
+	ReadSeries(context.Context, *prompb.Query) (SeriesSet, error)
 }
 
 // SeriesSet adds a Close method to storage.SeriesSet to provide a way to free memory/
@@ -27,10 +32,19 @@ type SeriesSet interface {
 type Querier interface {
 	// Query returns resulting timeseries for a query.
 	Query(*prompb.Query) ([]*prompb.TimeSeries, error)
-	// SamplesQuerier returns a sample querier.
-	SamplesQuerier() SamplesQuerier
+	// ReadSeries returns the series matching a query sorted by labels,
+	// reading them from the database one at a time while iterating over
+	// the set. The set must be closed.
+	ReadSeries(context.Context, *prompb.Query) (SeriesSet, error)
+	// SamplesQuerier returns a sample querier. The statistics of the
+	// selected samples are collected if the context holds a QueryStats.
+	SamplesQuerier(ctx context.Context) SamplesQuerier
 	// ExemplarsQuerier returns an exemplar querier.
 	ExemplarsQuerier(ctx context.Context) ExemplarQuerier
+	// SeriesFilter returns the filter restricting label lookups to the
+	// series matching the matchers, of the metrics with data between mint
+	// and maxt. A nil filter is returned if all series are selected.
+	SeriesFilter(mint, maxt int64, ms ...*labels.Matcher) (*lreader.SeriesFilter, error)
 }
 
 // SamplesQuerier queries data using the provided query data and returns the
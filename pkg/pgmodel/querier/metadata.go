@@ -40,6 +40,10 @@ type evalMetadata struct {
 	clauses         []string
 	values          []interface{}
 	*promqlMetadata
+
+	// downsampled is set if the metric is read from one of its views with
+	// a resolution. The series keep the labels of the raw metric.
+	downsampled bool
 }
 
 func GetMetadata(clauses []string, values []interface{}) *evalMetadata {
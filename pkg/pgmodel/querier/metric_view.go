@@ -0,0 +1,146 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package querier
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/timescale/promscale/pkg/clockcache"
+	"github.com/timescale/promscale/pkg/pgmodel/common/schema"
+	"github.com/timescale/promscale/pkg/pgxconn"
+)
+
+const (
+	/* The tables of the downsampling policies are not listed: they are only
+	* refreshed by the maintenance jobs, so they lag behind the raw metric by
+	* up to a resolution plus the interval of the jobs, and their retention is
+	* independent of the raw metric. Serving the queries on the raw metric
+	* from them would drop the recent points of the queries, or the oldest
+	* ones. They are queried with the __schema__ matcher instead. */
+	getMetricViewsSQL = `SELECT v.table_schema, v.table_name, r.value_column, r.aggregate, (extract(epoch FROM r.resolution) * 1000)::BIGINT
+	FROM ` + schema.Catalog + `.metric m
+	INNER JOIN ` + schema.Catalog + `.metric v ON (v.series_table = m.table_name AND v.is_view)
+	INNER JOIN ` + schema.Catalog + `.metric_view_resolution r ON (r.metric_id = v.id)
+	WHERE m.metric_name = $1 AND m.table_schema = '` + schema.Data + `' AND NOT m.is_view`
+
+	// metricViewsTTL is how long the views of a metric are cached, which
+	// is how long it takes for a change of the registered resolutions to
+	// be taken into account.
+	metricViewsTTL = time.Minute
+
+	metricViewsCacheSize = 10000
+)
+
+// viewAggregates are the aggregates of the raw samples held by a view column
+// which give the same results as the raw samples to the range functions. The
+// last values of the buckets also serve instant selectors. The rate of
+// counters computed from them only misses the counter resets within buckets.
+var viewAggregates = map[string]string{
+	"min_over_time":  "min",
+	"max_over_time":  "max",
+	"sum_over_time":  "sum",
+	"last_over_time": "last",
+	"rate":           "last",
+	"increase":       "last",
+	"delta":          "last",
+}
+
+// metricView is a metric view column registered with a resolution, which
+// replaces its raw metric in the queries whose step is coarse enough and
+// whose function matches the aggregate of the column.
+type metricView struct {
+	schema    string
+	table     string
+	column    string
+	aggregate string
+	// resolution is the time interval between the points of the view, in
+	// milliseconds.
+	resolution int64
+}
+
+type metricViewsEntry struct {
+	views     []metricView
+	fetchedAt time.Time
+}
+
+// metricViewCache caches the views with a resolution of the raw metrics.
+type metricViewCache struct {
+	cache *clockcache.Cache
+}
+
+func newMetricViewCache() *metricViewCache {
+	return &metricViewCache{cache: clockcache.WithMax(metricViewsCacheSize)}
+}
+
+// get returns the views of the raw metric, from the cache if they were
+// fetched less than metricViewsTTL ago.
+func (c *metricViewCache) get(conn pgxconn.PgxConn, metric string) ([]metricView, error) {
+	if entry, ok := c.cache.Get(metric); ok && time.Since(entry.(metricViewsEntry).fetchedAt) < metricViewsTTL {
+		return entry.(metricViewsEntry).views, nil
+	}
+
+	rows, err := conn.Query(context.Background(), getMetricViewsSQL, metric)
+	if err != nil {
+		return nil, fmt.Errorf("fetching metric views: %w", err)
+	}
+	defer rows.Close()
+	var (
+		views []metricView
+		size  = uint64(len(metric))
+	)
+	for rows.Next() {
+		var v metricView
+		if err := rows.Scan(&v.schema, &v.table, &v.column, &v.aggregate, &v.resolution); err != nil {
+			return nil, fmt.Errorf("fetching metric views: %w", err)
+		}
+		views = append(views, v)
+		size += uint64(len(v.schema) + len(v.table) + len(v.column) + len(v.aggregate) + 8)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("fetching metric views: %w", err)
+	}
+	c.cache.Update(metric, metricViewsEntry{views: views, fetchedAt: time.Now()}, size)
+	return views, nil
+}
+
+// chooseMetricView returns the view with the coarsest resolution which can
+// replace the raw metric for the evaluation of a selector, or nil if there is
+// none. A view can be used if its aggregate matches the function applied to
+// the selector, there is at least a point per step of the query, and the
+// selector looks far enough back to find points: the lookback delta for
+// instant selectors, and at least two points for range selectors.
+func chooseMetricView(views []metricView, hints *storage.SelectHints, qh *QueryHints, path []parser.Node) *metricView {
+	if len(views) == 0 || hints == nil || qh == nil || hints.Step <= 0 || hasSubquery(path) {
+		return nil
+	}
+	aggregate := "last"
+	if hints.Range != 0 {
+		var ok bool
+		if aggregate, ok = viewAggregates[hints.Func]; !ok {
+			return nil
+		}
+	}
+	var chosen *metricView
+	for i := range views {
+		v := &views[i]
+		if v.aggregate != aggregate || v.resolution > hints.Step {
+			continue
+		}
+		if hints.Range == 0 && v.resolution > qh.Lookback.Milliseconds() {
+			continue
+		}
+		if hints.Range != 0 && hints.Range < 2*v.resolution {
+			continue
+		}
+		if chosen == nil || v.resolution > chosen.resolution {
+			chosen = v
+		}
+	}
+	return chosen
+}
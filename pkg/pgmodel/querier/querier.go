@@ -11,6 +11,7 @@ import (
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/timescale/promscale/pkg/pgmodel/cache"
+	"github.com/timescale/promscale/pkg/pgmodel/common/errors"
 	"github.com/timescale/promscale/pkg/pgmodel/lreader"
 	"github.com/timescale/promscale/pkg/pgxconn"
 	"github.com/timescale/promscale/pkg/prompb"
@@ -39,13 +40,15 @@ func NewQuerier(
 			metricTableNames: metricCache,
 			exemplarPosCache: exemplarCache,
 			rAuth:            rAuth,
+			metricViews:      newMetricViewCache(),
+			histogramMetrics: newHistogramMetricCache(),
 		},
 	}
 	return querier
 }
 
-func (q *pgxQuerier) SamplesQuerier() SamplesQuerier {
-	return newQuerySamples(q)
+func (q *pgxQuerier) SamplesQuerier(ctx context.Context) SamplesQuerier {
+	return newQuerySamples(ctx, q)
 }
 
 func (q *pgxQuerier) ExemplarsQuerier(ctx context.Context) ExemplarQuerier {
@@ -59,13 +62,13 @@ func (q *pgxQuerier) Query(query *prompb.Query) ([]*prompb.TimeSeries, error) {
 		return []*prompb.TimeSeries{}, nil
 	}
 
-	matchers, err := fromLabelMatchers(query.Matchers)
+	matchers, err := FromLabelMatchers(query.Matchers)
 	if err != nil {
 		return nil, err
 	}
 
-	qrySamples := newQuerySamples(q)
-	sampleRows, _, err := qrySamples.fetchSamplesRows(query.StartTimestampMs, query.EndTimestampMs, nil, nil, nil, matchers)
+	qrySamples := newQuerySamples(context.Background(), q)
+	sampleRows, _, err := qrySamples.fetchSamplesRows(query.StartTimestampMs, query.EndTimestampMs, nil, nil, nil, matchers, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -76,6 +79,42 @@ func (q *pgxQuerier) Query(query *prompb.Query) ([]*prompb.TimeSeries, error) {
 	return results, nil
 }
 
+// SeriesFilter implements the Querier interface. The tenant matcher is added
+// to the matchers, so that only the labels of the authorized tenants are
+// returned.
+func (q *pgxQuerier) SeriesFilter(mint, maxt int64, ms ...*labels.Matcher) (*lreader.SeriesFilter, error) {
+	if q.tools.rAuth != nil {
+		ms = q.tools.rAuth.AppendTenantMatcher(ms)
+	}
+	if mint < minTime {
+		mint = minTime
+	}
+	if maxt > maxTime {
+		maxt = maxTime
+	}
+	unbounded := mint == minTime && maxt == maxTime
+	if len(ms) == 0 && unbounded {
+		return nil, nil
+	}
+
+	filter := &lreader.SeriesFilter{}
+	if len(ms) > 0 {
+		builder, err := BuildSubQueries(ms)
+		if err != nil {
+			return nil, fmt.Errorf("build subQueries: %w", err)
+		}
+		filter.Clauses, filter.Args, err = builder.Build(true)
+		// Matchers on __schema__ and __column__ only do not restrict the series.
+		if err != nil && err != errors.ErrNoClausesGen {
+			return nil, fmt.Errorf("building series clauses: %w", err)
+		}
+	}
+	if !unbounded {
+		filter.Start, filter.End = toRFC3339Nano(mint), toRFC3339Nano(maxt)
+	}
+	return filter, nil
+}
+
 // errorSeriesSet represents an error result in a form of a series set.
 // This behavior is inherited from Prometheus codebase.
 type errorSeriesSet struct {
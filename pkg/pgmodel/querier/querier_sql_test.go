@@ -5,6 +5,7 @@
 package querier
 
 import (
+	"context"
 	"fmt"
 	"github.com/prometheus/prometheus/pkg/timestamp"
 	"reflect"
@@ -199,6 +200,12 @@ func TestPGXQuerierQuery(t *testing.T) {
 					Results: model.RowResults(nil),
 					Err:     error(nil),
 				},
+				{
+					Sql:     "SELECT metric_name, table_name FROM _prom_catalog.histogram ORDER BY metric_name",
+					Args:    []interface{}(nil),
+					Results: model.RowResults(nil),
+					Err:     error(nil),
+				},
 			},
 		},
 		{
@@ -267,6 +274,12 @@ func TestPGXQuerierQuery(t *testing.T) {
 					Results: model.RowResults{{[]int64{1}, []time.Time{time.Unix(0, 0)}, []float64{1}}},
 					Err:     error(nil),
 				},
+				{
+					Sql:     "SELECT metric_name, table_name FROM _prom_catalog.histogram ORDER BY metric_name",
+					Args:    []interface{}(nil),
+					Results: model.RowResults(nil),
+					Err:     error(nil),
+				},
 				{
 					Sql:     "SELECT (prom_api.labels_info($1::int[])).*",
 					Args:    []interface{}{[]int64{1}},
@@ -484,6 +497,12 @@ func TestPGXQuerierQuery(t *testing.T) {
 					Results: model.RowResults{{[]int64{4}, []time.Time{time.Unix(0, 0)}, []float64{1}}},
 					Err:     error(nil),
 				},
+				{
+					Sql:     "SELECT metric_name, table_name FROM _prom_catalog.histogram ORDER BY metric_name",
+					Args:    []interface{}(nil),
+					Results: model.RowResults(nil),
+					Err:     error(nil),
+				},
 				{
 					Sql:           "SELECT (prom_api.labels_info($1::int[])).*",
 					Args:          []interface{}{[]int64{3, 4}},
@@ -569,6 +588,12 @@ func TestPGXQuerierQuery(t *testing.T) {
 					Results: model.RowResults{{[]int64{7}, []time.Time{time.Unix(0, 0)}, []float64{1}}},
 					Err:     error(nil),
 				},
+				{
+					Sql:     "SELECT metric_name, table_name FROM _prom_catalog.histogram ORDER BY metric_name",
+					Args:    []interface{}(nil),
+					Results: model.RowResults(nil),
+					Err:     error(nil),
+				},
 				{
 					Sql:     "SELECT (prom_api.labels_info($1::int[])).*",
 					Args:    []interface{}{[]int64{7}},
@@ -630,6 +655,12 @@ func TestPGXQuerierQuery(t *testing.T) {
 					Results: model.RowResults{{[]int64{8, 9}, []time.Time{time.Unix(0, 0)}, []float64{1}}},
 					Err:     error(nil),
 				},
+				{
+					Sql:     "SELECT metric_name, table_name FROM _prom_catalog.histogram ORDER BY metric_name",
+					Args:    []interface{}(nil),
+					Results: model.RowResults(nil),
+					Err:     error(nil),
+				},
 				{
 					Sql:           "SELECT (prom_api.labels_info($1::int[])).*",
 					Args:          []interface{}{[]int64{9, 8}},
@@ -691,6 +722,12 @@ func TestPGXQuerierQuery(t *testing.T) {
 					Results: model.RowResults{{[]int64{10}, []time.Time{time.Unix(0, 0)}, []float64{1}}},
 					Err:     error(nil),
 				},
+				{
+					Sql:     "SELECT metric_name, table_name FROM _prom_catalog.histogram ORDER BY metric_name",
+					Args:    []interface{}(nil),
+					Results: model.RowResults(nil),
+					Err:     error(nil),
+				},
 				{
 					Sql:     "SELECT (prom_api.labels_info($1::int[])).*",
 					Args:    []interface{}{[]int64{10}},
@@ -699,6 +736,68 @@ func TestPGXQuerierQuery(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "Regex metric name matcher, native histogram",
+			query: &prompb.Query{
+				StartTimestampMs: 1000,
+				EndTimestampMs:   2000,
+				Matchers: []*prompb.LabelMatcher{
+					{Type: prompb.LabelMatcher_RE, Name: model.MetricNameLabelName, Value: "foo_c.*"},
+				},
+			},
+			result: []*prompb.TimeSeries{
+				{
+					Labels:  []prompb.Label{{Name: model.MetricNameLabelName, Value: "foo_count"}},
+					Samples: []prompb.Sample{{Timestamp: timestamp.FromTime(time.Unix(0, 0)), Value: 3}},
+				},
+			},
+			sqlQueries: []model.SqlQuery{
+				{
+					Sql: "SELECT m.table_schema, m.metric_name, array_agg(s.id)\n\t" +
+						"FROM _prom_catalog.series s\n\t" +
+						"INNER JOIN _prom_catalog.metric m\n\t" +
+						"ON (m.id = s.metric_id)\n\t" +
+						"WHERE labels && (SELECT COALESCE(array_agg(l.id), array[]::int[]) FROM _prom_catalog.label l WHERE l.key = $1 and l.value ~ $2)\n\t" +
+						"GROUP BY m.metric_name, m.table_schema\n\t" +
+						"ORDER BY m.metric_name, m.table_schema",
+					Args:    []interface{}{"__name__", "^(?:foo_c.*)$"},
+					Results: model.RowResults(nil),
+					Err:     error(nil),
+				},
+				{
+					Sql:     "SELECT metric_name, table_name FROM _prom_catalog.histogram ORDER BY metric_name",
+					Args:    []interface{}(nil),
+					Results: model.RowResults{{"foo", "foo_hist"}},
+					Err:     error(nil),
+				},
+				{
+					Sql:     "SELECT table_schema, table_name, series_table FROM _prom_catalog.get_metric_table_name_if_exists($1, $2)",
+					Args:    []interface{}{"prom_data", "foo"},
+					Results: model.RowResults{{"prom_data", "foo", "foo"}},
+					Err:     error(nil),
+				},
+				{
+					Sql: "SELECT series.id, series.labels, h.time, h.count, h.sum, h.schema, h.zero_threshold, h.zero_count,\n\t\t" +
+						"h.positive_offset, h.positive_counts, h.negative_offset, h.negative_counts\n\t" +
+						"FROM \"prom_data_histogram\".\"foo_hist\" h\n\t" +
+						"INNER JOIN \"prom_data_series\".\"foo\" series\n\t" +
+						"ON h.series_id = series.id\n\t" +
+						"WHERE TRUE\n\t" +
+						"AND h.time >= '1970-01-01T00:00:01Z'\n\t" +
+						"AND h.time <= '1970-01-01T00:00:02Z'\n\t" +
+						"ORDER BY h.series_id, h.time",
+					Args:    []interface{}(nil),
+					Results: model.RowResults{{int64(1), []int64{5}, time.Unix(0, 0), float64(3), float64(6), int32(0), float64(0), float64(0), int32(0), []float64{1, 2}, int32(0), []float64(nil)}},
+					Err:     error(nil),
+				},
+				{
+					Sql:     "SELECT (prom_api.labels_info($1::int[])).*",
+					Args:    []interface{}{[]int64{5}},
+					Results: model.RowResults{{[]int64{5}, []string{"__name__"}, []string{"foo"}}},
+					Err:     error(nil),
+				},
+			},
+		},
 	}
 
 	for _, c := range testCases {
@@ -750,3 +849,82 @@ func TestPGXQuerierQuery(t *testing.T) {
 		})
 	}
 }
+
+func TestPGXQuerierReadSeries(t *testing.T) {
+	mock := model.NewSqlRecorder([]model.SqlQuery{
+		{
+			Sql:     "SELECT table_schema, table_name, series_table FROM _prom_catalog.get_metric_table_name_if_exists($1, $2)",
+			Args:    []interface{}{"", "bar"},
+			Results: model.RowResults{{"prom_data", "bar", "bar"}},
+			Err:     error(nil),
+		},
+		{
+			Sql: `SELECT lbl.keys, lbl.vals, result.time_array, result.value_array, lbl.sort_key
+			FROM "prom_data_series"."bar" series
+			CROSS JOIN LATERAL (
+				SELECT array_agg(l.key ORDER BY l.key COLLATE "C") AS keys,
+					array_agg(l.value ORDER BY l.key COLLATE "C") AS vals,
+					string_agg(l.key || chr(1) || l.value, chr(1) ORDER BY l.key COLLATE "C") COLLATE "C" AS sort_key
+				FROM _prom_catalog.label l
+				WHERE l.id = ANY(series.labels)
+			) AS lbl
+			INNER JOIN LATERAL (
+				SELECT array_agg(time ORDER BY time) AS time_array, array_agg("value" ORDER BY time) AS value_array
+				FROM "prom_data"."bar" metric
+				WHERE metric.series_id = series.id
+				AND time >= '1970-01-01T00:00:01Z'
+				AND time <= '1970-01-01T00:00:02Z'
+			) AS result ON (result.time_array IS NOT NULL)
+			WHERE TRUE
+			ORDER BY sort_key`,
+			Args: nil,
+			Results: model.RowResults{
+				{[]string{"__name__", "job"}, []string{"bar", "a"}, []time.Time{time.Unix(1, 0)}, []float64{1}, "__name__\x01bar\x01job\x01a"},
+				{[]string{"__name__", "job"}, []string{"bar", "b"}, []time.Time{time.Unix(1, 0), time.Unix(2, 0)}, []float64{2, 3}, "__name__\x01bar\x01job\x01b"},
+			},
+			Err: error(nil),
+		},
+	}, t)
+	querier := pgxQuerier{&queryTools{
+		conn:             mock,
+		metricTableNames: &model.MockMetricCache{MetricCache: make(map[string]model.MetricInfo)},
+		labelsReader:     lreader.NewLabelsReader(mock, clockcache.WithMax(0)),
+	}}
+
+	ss, err := querier.ReadSeries(context.Background(), &prompb.Query{
+		StartTimestampMs: 1000,
+		EndTimestampMs:   2000,
+		Matchers: []*prompb.LabelMatcher{
+			{Type: prompb.LabelMatcher_EQ, Name: model.MetricNameLabelName, Value: "bar"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer ss.Close()
+
+	type series struct {
+		labels  string
+		samples []prompb.Sample
+	}
+	var result []series
+	for ss.Next() {
+		s := series{labels: ss.At().Labels().String()}
+		it := ss.At().Iterator()
+		for it.Next() {
+			ts, v := it.At()
+			s.samples = append(s.samples, prompb.Sample{Timestamp: ts, Value: v})
+		}
+		result = append(result, s)
+	}
+	if err := ss.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []series{
+		{`{__name__="bar", job="a"}`, []prompb.Sample{{Timestamp: 1000, Value: 1}}},
+		{`{__name__="bar", job="b"}`, []prompb.Sample{{Timestamp: 1000, Value: 2}, {Timestamp: 2000, Value: 3}}},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("unexpected result:\ngot\n%+v\nwanted\n%+v", result, expected)
+	}
+}
@@ -0,0 +1,293 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package querier
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/pgmodel/lreader"
+	pgmodel "github.com/timescale/promscale/pkg/pgmodel/model"
+	"github.com/timescale/promscale/pkg/tenancy"
+)
+
+func TestSeriesFilter(t *testing.T) {
+	const eqClause = "labels && (SELECT COALESCE(array_agg(l.id), array[]::int[]) FROM _prom_catalog.label l WHERE l.key = $%d and l.value = $%d)"
+	q := &pgxQuerier{tools: &queryTools{}}
+
+	filter, err := q.SeriesFilter(math.MinInt64, math.MaxInt64)
+	require.NoError(t, err)
+	require.Nil(t, filter, "unbounded lookups without matchers must not be filtered")
+
+	filter, err = q.SeriesFilter(1000, math.MaxInt64)
+	require.NoError(t, err)
+	require.Equal(t, &lreader.SeriesFilter{Start: "1970-01-01T00:00:01Z", End: "Infinity"}, filter)
+
+	filter, err = q.SeriesFilter(math.MinInt64, math.MaxInt64,
+		labels.MustNewMatcher(labels.MatchEqual, "job", "api"),
+		labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "up"),
+	)
+	require.NoError(t, err)
+	require.Equal(t, &lreader.SeriesFilter{
+		Clauses: []string{fmt.Sprintf(eqClause, 1, 2), fmt.Sprintf(eqClause, 3, 4)},
+		Args:    []interface{}{"job", "api", labels.MetricName, "up"},
+	}, filter)
+
+	filter, err = q.SeriesFilter(math.MinInt64, math.MaxInt64, labels.MustNewMatcher(labels.MatchEqual, "__schema__", "prom_data"))
+	require.NoError(t, err)
+	require.Equal(t, &lreader.SeriesFilter{}, filter)
+
+	rAuth, err := tenancy.NewReadAuthorizer(tenancy.NewSelectiveTenancyConfig([]string{"tenant-a"}, false))
+	require.NoError(t, err)
+	q = &pgxQuerier{tools: &queryTools{rAuth: rAuth}}
+	filter, err = q.SeriesFilter(math.MinInt64, math.MaxInt64)
+	require.NoError(t, err)
+	require.NotNil(t, filter, "lookups must be restricted to the authorized tenants")
+	require.Len(t, filter.Clauses, 1)
+}
+
+func TestGetAggregatorsPushdown(t *testing.T) {
+	const (
+		start = int64(3600000)
+		end   = int64(7200000)
+		step  = int64(60000)
+	)
+	defaultFilter := timeFilter{metric: "m", schema: "prom_data", column: "value", seriesTable: "m"}
+	testCases := []struct {
+		name        string
+		query       string
+		filter      *timeFilter
+		topNode     string
+		kind        string
+		grouping    *grouping
+		shouldError bool
+	}{
+		{
+			name:    "over time function",
+			query:   "avg_over_time(m[5m])",
+			topNode: "avg_over_time(m[5m])",
+			kind:    "avg",
+		},
+		{
+			name:    "sum by over time function",
+			query:   "sum by (job, __name__) (max_over_time(m[5m]))",
+			topNode: "sum by(job, __name__) (max_over_time(m[5m]))",
+			kind:    "max",
+			grouping: &grouping{
+				aggregation: groupingAggregations[parser.SUM],
+				keys:        []string{"job"},
+			},
+		},
+		{
+			name:    "count without over time function",
+			query:   "count without (instance) (count_over_time(m[5m]))",
+			topNode: "count without(instance) (count_over_time(m[5m]))",
+			kind:    "count",
+			grouping: &grouping{
+				aggregation: groupingAggregations[parser.COUNT],
+				keys:        []string{"instance", "__name__"},
+				without:     true,
+			},
+		},
+		{
+			name:    "unsupported aggregation",
+			query:   "quantile(0.9, sum_over_time(m[5m]))",
+			topNode: "sum_over_time(m[5m])",
+			kind:    "sum",
+		},
+		{
+			name:    "aggregation of a non default column",
+			query:   "sum(min_over_time(m[5m]))",
+			filter:  &timeFilter{metric: "m", schema: "prom_data", column: "other", seriesTable: "m"},
+			topNode: "min_over_time(m[5m])",
+			kind:    "min",
+		},
+		{
+			name:  "over time function with offset",
+			query: "sum(avg_over_time(m[5m] offset 1m))",
+		},
+		{
+			name:  "last over time function keeps the metric name",
+			query: "last_over_time(m[5m])",
+		},
+		{
+			name:  "function not pushed down",
+			query: "sum(irate(m[5m]))",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			expr, err := parser.ParseExpr(tc.query)
+			require.NoError(t, err)
+
+			var md *promqlMetadata
+			parser.Inspect(expr, func(node parser.Node, path []parser.Node) error {
+				vs, ok := node.(*parser.VectorSelector)
+				if !ok {
+					return nil
+				}
+				rng := int64(0)
+				if ms, ok := path[len(path)-1].(*parser.MatrixSelector); ok {
+					rng = ms.Range.Milliseconds()
+				}
+				md = GetPromQLMetadata(vs.LabelMatchers,
+					&storage.SelectHints{Start: start - rng, End: end, Step: step, Range: rng},
+					&QueryHints{StartTime: timestamp.Time(start), EndTime: timestamp.Time(end), CurrentNode: vs, Lookback: 5 * time.Minute},
+					append([]parser.Node{}, path...))
+				return nil
+			})
+			filter := defaultFilter
+			if tc.filter != nil {
+				filter = *tc.filter
+			}
+
+			agg, node, err := getAggregators(&evalMetadata{isSingleMetric: true, timeFilter: filter, promqlMetadata: md})
+			require.NoError(t, err)
+			if tc.topNode == "" {
+				require.Nil(t, node)
+				require.Equal(t, getDefaultAggregators(), agg)
+				return
+			}
+			require.Equal(t, tc.topNode, node.String())
+			require.Equal(t, tc.kind, agg.valueParams[0])
+			require.Contains(t, agg.valueClause, "prom_over_time")
+			require.Equal(t, int((end-start)/step)+1, agg.tsSeries.Len())
+			require.Equal(t, tc.grouping, agg.grouping)
+		})
+	}
+}
+
+func TestBuildGroupedSamplesQuery(t *testing.T) {
+	expr, err := parser.ParseExpr("sum by (job) (sum_over_time(m[5m]))")
+	require.NoError(t, err)
+	aggNode := expr.(*parser.AggregateExpr)
+	call := aggNode.Expr.(*parser.Call)
+	ms := call.Args[0].(*parser.MatrixSelector)
+	vs := ms.VectorSelector.(*parser.VectorSelector)
+
+	md := GetPromQLMetadata(vs.LabelMatchers,
+		&storage.SelectHints{Start: 0, End: 600000, Step: 60000, Range: 300000},
+		&QueryHints{StartTime: timestamp.Time(300000), EndTime: timestamp.Time(600000), CurrentNode: vs},
+		[]parser.Node{aggNode, call, ms})
+	metadata := &evalMetadata{
+		isSingleMetric: true,
+		timeFilter:     timeFilter{metric: "m", schema: "prom_data", column: "value", seriesTable: "m", start: "1970-01-01T00:00:00Z", end: "1970-01-01T00:10:00Z"},
+		clauses:        []string{"TRUE"},
+		promqlMetadata: md,
+	}
+
+	sql, values, node, tsSeries, err := buildSingleMetricSamplesQuery(metadata)
+	require.NoError(t, err)
+	require.Equal(t, aggNode, node)
+	require.Equal(t, 6, tsSeries.Len())
+	require.Contains(t, sql, "WITH series_result AS MATERIALIZED (\n\t\tSELECT COALESCE((SELECT array_agg(l.id) FROM _prom_catalog.label l WHERE l.id = ANY(series.labels) AND l.key = ANY($6)), array[]::int[]) AS labels,  result.value_array")
+	require.Contains(t, sql, "_prom_catalog.prom_over_time($1, $2, $3, $4, $5, array_agg(time), array_agg(value))")
+	require.Contains(t, sql, "SELECT r.labels, u.idx, sum(u.value) AS value")
+	require.Equal(t, []interface{}{"sum", model.Time(0).Time(), model.Time(600000).Time(), int64(60000), int64(300000), []string{"job"}}, values)
+}
+
+func TestBuildLatestSampleQuery(t *testing.T) {
+	hints := &storage.SelectHints{Start: 0, End: 300000, Func: LatestSampleFunc}
+	metadata := &evalMetadata{
+		isSingleMetric: true,
+		timeFilter:     timeFilter{metric: "m", schema: "prom_data", column: "value", seriesTable: "m", start: "1970-01-01T00:00:00Z", end: "1970-01-01T00:05:00Z"},
+		clauses:        []string{"TRUE"},
+		promqlMetadata: GetPromQLMetadata(nil, hints, nil, nil),
+	}
+
+	sql, _, node, tsSeries, err := buildSingleMetricSamplesQuery(metadata)
+	require.NoError(t, err)
+	require.Nil(t, node)
+	require.Nil(t, tsSeries)
+	require.Contains(t, sql, "(array_agg(time ORDER BY time DESC))[1:1] as time_array, (array_agg(value ORDER BY time DESC))[1:1] as value_array")
+
+	sql, err = buildMultipleMetricSamplesQuery(metadata.timeFilter, []pgmodel.SeriesID{1, 2}, true)
+	require.NoError(t, err)
+	require.Contains(t, sql, "SELECT s.labels, (array_agg(m.time ORDER BY time DESC))[1:1], (array_agg(m.value ORDER BY time DESC))[1:1]")
+	require.Contains(t, sql, "WHERE m.series_id IN (1,2)")
+}
+
+func TestChooseMetricView(t *testing.T) {
+	views := []metricView{
+		{schema: "cagg", table: "m_5m", column: "value", aggregate: "last", resolution: 300000},
+		{schema: "cagg", table: "m_1h", column: "last", aggregate: "last", resolution: 3600000},
+		{schema: "cagg", table: "m_1h", column: "max", aggregate: "max", resolution: 3600000},
+	}
+	testCases := []struct {
+		name     string
+		hints    *storage.SelectHints
+		path     []parser.Node
+		expected string
+		column   string
+	}{
+		{
+			name:  "instant query",
+			hints: &storage.SelectHints{Step: 0},
+		},
+		{
+			name:  "fine step",
+			hints: &storage.SelectHints{Step: 60000},
+		},
+		{
+			name:     "instant selector within the lookback delta",
+			hints:    &storage.SelectHints{Step: 7200000, Func: "sum"},
+			expected: "m_5m",
+			column:   "value",
+		},
+		{
+			name:     "range selector",
+			hints:    &storage.SelectHints{Step: 3600000, Range: 7200000, Func: "rate"},
+			expected: "m_1h",
+			column:   "last",
+		},
+		{
+			name:     "range selector too short for the coarsest view",
+			hints:    &storage.SelectHints{Step: 3600000, Range: 3600000, Func: "rate"},
+			expected: "m_5m",
+			column:   "value",
+		},
+		{
+			name:     "column matching the function",
+			hints:    &storage.SelectHints{Step: 3600000, Range: 7200000, Func: "max_over_time"},
+			expected: "m_1h",
+			column:   "max",
+		},
+		{
+			name:  "no column matching the function",
+			hints: &storage.SelectHints{Step: 3600000, Range: 7200000, Func: "min_over_time"},
+		},
+		{
+			name:  "function not served by views",
+			hints: &storage.SelectHints{Step: 3600000, Range: 7200000, Func: "avg_over_time"},
+		},
+		{
+			name:  "subquery",
+			hints: &storage.SelectHints{Step: 3600000, Range: 7200000, Func: "rate"},
+			path:  []parser.Node{&parser.SubqueryExpr{}},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			qh := &QueryHints{Lookback: 5 * time.Minute}
+			v := chooseMetricView(views, tc.hints, qh, tc.path)
+			if tc.expected == "" {
+				require.Nil(t, v)
+				return
+			}
+			require.NotNil(t, v)
+			require.Equal(t, tc.expected, v.table)
+			require.Equal(t, tc.column, v.column)
+		})
+	}
+	require.Nil(t, chooseMetricView(nil, &storage.SelectHints{Step: 3600000}, &QueryHints{Lookback: time.Hour}, nil))
+}
@@ -18,6 +18,7 @@ import (
 	"github.com/prometheus/prometheus/storage"
 	"github.com/timescale/promscale/pkg/pgmodel/common/errors"
 	"github.com/timescale/promscale/pkg/pgmodel/common/extension"
+	"github.com/timescale/promscale/pkg/pgmodel/common/schema"
 	"github.com/timescale/promscale/pkg/pgmodel/lreader"
 	pgmodel "github.com/timescale/promscale/pkg/pgmodel/model"
 	"github.com/timescale/promscale/pkg/prompb"
@@ -202,11 +203,37 @@ func calledByTimestamp(path []parser.Node) bool {
 	return false
 }
 
+// LatestSampleFunc is the function of the select hints asking for the latest
+// sample of every series only, e.g. for federation.
+const LatestSampleFunc = "promscale_latest_sample"
+
 var (
 	vectorSelectorExtensionRange = semver.MustParseRange(">= 0.2.0")
 	rateIncreaseExtensionRange   = semver.MustParseRange(">= 0.2.0")
 )
 
+// overTimeFunctions are the range-vector functions evaluated by the
+// prom_over_time SQL function, by PromQL function name.
+var overTimeFunctions = map[string]string{
+	"avg_over_time":   "avg",
+	"count_over_time": "count",
+	"max_over_time":   "max",
+	"min_over_time":   "min",
+	"sum_over_time":   "sum",
+}
+
+// groupingAggregations are the SQL expressions computing the PromQL
+// aggregation operators over the values of the series of a group at a step.
+// NULL values are missing points. max keeps NaN values only if all the values
+// are NaN, like in PromQL.
+var groupingAggregations = map[parser.ItemType]string{
+	parser.SUM:   "sum(u.value)",
+	parser.AVG:   "avg(u.value)",
+	parser.MIN:   "min(u.value)",
+	parser.MAX:   "COALESCE(max(u.value) FILTER (WHERE u.value <> 'NaN'), max(u.value))",
+	parser.COUNT: "NULLIF(count(u.value), 0)::DOUBLE PRECISION",
+}
+
 type aggregators struct {
 	timeClause  string
 	timeParams  []interface{}
@@ -214,15 +241,30 @@ type aggregators struct {
 	valueParams []interface{}
 	unOrdered   bool
 	tsSeries    TimestampSeries //can be NULL and only present if timeClause == ""
+	grouping    *grouping       //can be NULL and only present if tsSeries != nil
+}
+
+// grouping is an aggregation of the series resulting from a pushed-down
+// function, evaluated in the database as well.
+type grouping struct {
+	aggregation string
+	// keys are the names of the labels kept in the groups, or dropped if
+	// without is set. The metric name is always dropped.
+	keys    []string
+	without bool
 }
 
 /* The path is the list of ancestors (direct parent last) returned node is the most-ancestral node processed by the pushdown */
 // todo: investigate if query hints can have only node and lookback
-func getAggregators(md *promqlMetadata) (*aggregators, parser.Node, error) {
+func getAggregators(metadata *evalMetadata) (*aggregators, parser.Node, error) {
+	md := metadata.promqlMetadata
 	path := md.path // PromQL AST.
 	qh := md.queryHints
 	hints := md.selectHints
-	if !extension.ExtensionIsInstalled || qh == nil || hasSubquery(path) || hints == nil {
+	if hints != nil && hints.Func == LatestSampleFunc {
+		return getLatestSampleAggregators(), nil, nil
+	}
+	if qh == nil || hasSubquery(path) || hints == nil {
 		return getDefaultAggregators(), nil, nil
 	}
 
@@ -235,15 +277,21 @@ func getAggregators(md *promqlMetadata) (*aggregators, parser.Node, error) {
 			node := path[len(path)-2]
 			callNode, isCall := node.(*parser.Call)
 			if isCall {
-				switch callNode.Func.Name {
-				case "delta":
-					agg, err := callAggregator(hints, callNode.Func.Name)
-					return agg, node, err
-				case "rate", "increase":
-					if rateIncreaseExtensionRange(extension.PromscaleExtensionVersion) {
-						agg, err := callAggregator(hints, callNode.Func.Name)
-						return agg, node, err
+				agg, err := getCallAggregators(vs, hints, callNode.Func.Name)
+				if err != nil {
+					return nil, nil, err
+				}
+				if agg != nil {
+					/* An aggregation of the results of the function can be evaluated in the database too */
+					if len(path) >= 3 && canGroupSeries(metadata, vs) {
+						if aggNode, ok := path[len(path)-3].(*parser.AggregateExpr); ok && aggNode.Expr == node {
+							if g := getGrouping(aggNode); g != nil {
+								agg.grouping = g
+								return agg, aggNode, nil
+							}
+						}
 					}
+					return agg, node, nil
 				}
 			}
 		}
@@ -253,7 +301,8 @@ func getAggregators(md *promqlMetadata) (*aggregators, parser.Node, error) {
 		* in a vector selector window(step) this decreases the amount of samples transferred from the DB to Promscale
 		* by orders of magnitude. A vector selector aggregate also does not require ordered inputs which saves
 		* a sort and allows for parallel evaluation. */
-		if hints.Step > 0 &&
+		if extension.ExtensionIsInstalled &&
+			hints.Step > 0 &&
 			hints.Range == 0 && /* So this is not an aggregate. That's optimized above */
 			!calledByTimestamp(path) &&
 			vs.OriginalOffset == time.Duration(0) &&
@@ -272,6 +321,63 @@ func getAggregators(md *promqlMetadata) (*aggregators, parser.Node, error) {
 	return getDefaultAggregators(), nil, nil
 }
 
+// getCallAggregators returns the aggregators evaluating the function in the
+// database, or nil if the function cannot be pushed down.
+func getCallAggregators(vs *parser.VectorSelector, hints *storage.SelectHints, funcName string) (*aggregators, error) {
+	switch funcName {
+	case "delta":
+		if extension.ExtensionIsInstalled {
+			return callAggregator(hints, funcName)
+		}
+	case "rate", "increase":
+		if extension.ExtensionIsInstalled && rateIncreaseExtensionRange(extension.PromscaleExtensionVersion) {
+			return callAggregator(hints, funcName)
+		}
+	default:
+		/* The steps of the result are computed from the hints, which do not account for modifiers */
+		kind, ok := overTimeFunctions[funcName]
+		if ok && isUnmodifiedSelector(vs) {
+			return overTimeAggregator(hints, kind)
+		}
+	}
+	return nil, nil
+}
+
+func isUnmodifiedSelector(vs *parser.VectorSelector) bool {
+	return vs.OriginalOffset == 0 && vs.Offset == 0 && vs.Timestamp == nil && vs.StartOrEnd == 0
+}
+
+// canGroupSeries returns true if the labels of the series are only made of
+// their label IDs. Otherwise, labels added to the series set, like the schema
+// and column labels, would be missing from the groups.
+func canGroupSeries(metadata *evalMetadata, vs *parser.VectorSelector) bool {
+	filter := metadata.timeFilter
+	return isUnmodifiedSelector(vs) &&
+		(metadata.downsampled ||
+			(filter.schema == "" || filter.schema == schema.Data) &&
+				(filter.column == "" || filter.column == defaultColumnName) &&
+				filter.metric == filter.seriesTable)
+}
+
+// getGrouping returns the grouping evaluating the aggregation in the
+// database, or nil if the aggregation operator is not supported.
+func getGrouping(aggNode *parser.AggregateExpr) *grouping {
+	aggregation, ok := groupingAggregations[aggNode.Op]
+	if !ok || aggNode.Param != nil {
+		return nil
+	}
+	keys := make([]string, 0, len(aggNode.Grouping)+1)
+	for _, key := range aggNode.Grouping {
+		if key != pgmodel.MetricNameLabelName {
+			keys = append(keys, key)
+		}
+	}
+	if aggNode.Without {
+		keys = append(keys, pgmodel.MetricNameLabelName)
+	}
+	return &grouping{aggregation: aggregation, keys: keys, without: aggNode.Without}
+}
+
 func getDefaultAggregators() *aggregators {
 	return &aggregators{
 		timeClause:  "array_agg(time)",
@@ -280,6 +386,16 @@ func getDefaultAggregators() *aggregators {
 	}
 }
 
+// getLatestSampleAggregators returns the aggregators selecting only the
+// latest sample of each series.
+func getLatestSampleAggregators() *aggregators {
+	return &aggregators{
+		timeClause:  "(array_agg(time ORDER BY time DESC))[1:1]",
+		valueClause: "(array_agg(value ORDER BY time DESC))[1:1]",
+		unOrdered:   true,
+	}
+}
+
 func callAggregator(hints *storage.SelectHints, funcName string) (*aggregators, error) {
 	queryStart := hints.Start + hints.Range
 	queryEnd := hints.End
@@ -302,6 +418,19 @@ func callAggregator(hints *storage.SelectHints, funcName string) (*aggregators,
 	return &qf, nil
 }
 
+// overTimeAggregator returns the aggregators evaluating a *_over_time
+// function with the prom_over_time SQL function, which takes the same time
+// parameters as the extension functions.
+func overTimeAggregator(hints *storage.SelectHints, kind string) (*aggregators, error) {
+	qf, err := callAggregator(hints, "over_time")
+	if err != nil {
+		return nil, err
+	}
+	qf.valueClause = schema.Catalog + ".prom_over_time($%d, $%d, $%d, $%d, $%d, array_agg(time), array_agg(value))"
+	qf.valueParams = append([]interface{}{kind}, qf.valueParams...)
+	return qf, nil
+}
+
 // anchorValue adds anchors to values in regexps since PromQL docs
 // states that "Regex-matches are fully anchored."
 func anchorValue(str string) string {
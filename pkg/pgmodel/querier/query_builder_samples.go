@@ -20,6 +20,15 @@ const (
 	AND time <= '%[5]s'
 	GROUP BY s.id`
 
+	latestSampleBySeriesIDsSQLFormat = `SELECT s.labels, (array_agg(m.time ORDER BY time DESC))[1:1], (array_agg(m.value ORDER BY time DESC))[1:1]
+	FROM %[1]s m
+	INNER JOIN %[2]s s
+	ON m.series_id = s.id
+	WHERE m.series_id IN (%[3]s)
+	AND time >= '%[4]s'
+	AND time <= '%[5]s'
+	GROUP BY s.id`
+
 	/* SINGLE METRIC PATH (common, performance critical case) */
 	/* The simpler query (which isn't used):
 			SELECT s.labels, array_agg(m.time ORDER BY time) as time_array, array_agg(m.value ORDER BY time)
@@ -63,7 +72,7 @@ const (
 			WHERE
 				labels && (SELECT COALESCE(array_agg(l.id), array[]::int[]) FROM _prom_catalog.label l WHERE l.key = 'job' and l.value = 'demo');
 	*/
-	timeseriesByMetricSQLFormat = `SELECT series.labels,  %[7]s
+	timeseriesByMetricSQLFormat = `SELECT %[10]s,  %[7]s
 	FROM %[2]s series
 	INNER JOIN LATERAL (
 		SELECT %[6]s
@@ -83,7 +92,7 @@ const (
 	/* optimized for no clauses besides __name__
 	   uses a inner join without a lateral to allow for better parallel execution
 	*/
-	timeseriesByMetricSQLFormatNoClauses = `SELECT series.labels,  %[7]s
+	timeseriesByMetricSQLFormatNoClauses = `SELECT %[10]s,  %[7]s
 	FROM %[2]s series
 	INNER JOIN (
 		SELECT series_id, %[6]s
@@ -99,14 +108,36 @@ const (
 		GROUP BY series_id
 	) as result ON (result.value_array is not null AND result.series_id = series.id)`
 
+	/* GROUPED SINGLE METRIC PATH
+	   used when an aggregation of the results of a pushed-down function is evaluated in the database.
+	   The inner query returns the labels of the group of each series instead of its labels, and the value
+	   arrays of the series, which hold a value per step. The values of a group are aggregated by step
+	   and returned as a single row.
+	*/
+	groupedTimeseriesSQLFormat = `WITH series_result AS MATERIALIZED (
+		%[1]s
+	)
+	SELECT grouped.labels, array_agg(grouped.value ORDER BY grouped.idx)
+	FROM (
+		SELECT r.labels, u.idx, %[2]s AS value
+		FROM series_result r
+		CROSS JOIN LATERAL unnest(r.value_array) WITH ORDINALITY AS u(value, idx)
+		GROUP BY r.labels, u.idx
+	) AS grouped
+	GROUP BY grouped.labels`
+
+	groupLabelsBySQLFormat      = "COALESCE((SELECT array_agg(l.id) FROM _prom_catalog.label l WHERE l.id = ANY(series.labels) AND l.key = ANY($%d)), array[]::int[]) AS labels"
+	groupLabelsWithoutSQLFormat = "COALESCE((SELECT array_agg(l.id) FROM _prom_catalog.label l WHERE l.id = ANY(series.labels) AND l.key <> ALL($%d)), array[]::int[]) AS labels"
+
 	defaultColumnName = "value"
+	defaultLabels     = "series.labels"
 )
 
 func buildSingleMetricSamplesQuery(metadata *evalMetadata) (string, []interface{}, parser.Node, TimestampSeries, error) {
 	// Aggregators are not in exemplar queries. In sample query, we have aggregations since they are
 	// to serve promql evaluations. But, exemplar queries are fetch-only queries. Their responses are not meant to be
 	// served by any PromQL function.
-	qf, node, err := getAggregators(metadata.promqlMetadata)
+	qf, node, err := getAggregators(metadata)
 	if err != nil {
 		return "", nil, nil, nil, err
 	}
@@ -142,6 +173,17 @@ func buildSingleMetricSamplesQuery(metadata *evalMetadata) (string, []interface{
 			orderByClause = "ORDER BY series_id, time"
 		}
 	}
+	labelsClause := defaultLabels
+	if qf.grouping != nil {
+		labelsFormat := groupLabelsBySQLFormat
+		if qf.grouping.without {
+			labelsFormat = groupLabelsWithoutSQLFormat
+		}
+		labelsClause, values, err = setParameterNumbers(labelsFormat, values, qf.grouping.keys)
+		if err != nil {
+			return "", nil, nil, nil, err
+		}
+	}
 	filter := metadata.timeFilter
 	finalSQL := fmt.Sprintf(template,
 		pgx.Identifier{filter.schema, filter.metric}.Sanitize(),
@@ -153,18 +195,26 @@ func buildSingleMetricSamplesQuery(metadata *evalMetadata) (string, []interface{
 		strings.Join(selectors, ", "),
 		orderByClause,
 		pgx.Identifier{filter.column}.Sanitize(),
+		labelsClause,
 	)
+	if qf.grouping != nil {
+		finalSQL = fmt.Sprintf(groupedTimeseriesSQLFormat, finalSQL, qf.grouping.aggregation)
+	}
 
 	return finalSQL, values, node, qf.tsSeries, nil
 }
 
-func buildMultipleMetricSamplesQuery(filter timeFilter, series []pgmodel.SeriesID) (string, error) {
+func buildMultipleMetricSamplesQuery(filter timeFilter, series []pgmodel.SeriesID, latestOnly bool) (string, error) {
 	s := make([]string, len(series))
 	for i, sID := range series {
 		s[i] = fmt.Sprintf("%d", sID)
 	}
+	template := timeseriesBySeriesIDsSQLFormat
+	if latestOnly {
+		template = latestSampleBySeriesIDsSQLFormat
+	}
 	return fmt.Sprintf(
-		timeseriesBySeriesIDsSQLFormat,
+		template,
 		pgx.Identifier{filter.schema, filter.metric}.Sanitize(),
 		pgx.Identifier{schema.DataSeries, filter.seriesTable}.Sanitize(),
 		strings.Join(s, ","),
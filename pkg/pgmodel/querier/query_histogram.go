@@ -0,0 +1,357 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package querier
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/timescale/promscale/pkg/pgmodel/common/errors"
+	"github.com/timescale/promscale/pkg/pgmodel/common/schema"
+	"github.com/timescale/promscale/pkg/pgmodel/model"
+	"github.com/timescale/promscale/pkg/pgxconn"
+)
+
+const (
+	getHistogramMetricsSQL = "SELECT metric_name, table_name FROM " + schema.Catalog + ".histogram ORDER BY metric_name"
+
+	// histogramMetricsTTL is how long the native histogram metrics are
+	// cached, which is how long it takes for the first native histograms of
+	// a metric to be queryable.
+	histogramMetricsTTL = time.Minute
+
+	histogramsByMetricSQLFormat = `SELECT series.id, series.labels, h.time, h.count, h.sum, h.schema, h.zero_threshold, h.zero_count,
+		h.positive_offset, h.positive_counts, h.negative_offset, h.negative_counts
+	FROM %[1]s h
+	INNER JOIN %[2]s series
+	ON h.series_id = series.id
+	WHERE %[3]s
+	AND h.time >= '%[4]s'
+	AND h.time <= '%[5]s'
+	ORDER BY h.series_id, h.time`
+
+	bucketLabelName = "le"
+)
+
+// nativeHistogramSuffixes are the suffixes of the classic histogram series
+// native histograms are exposed as.
+var nativeHistogramSuffixes = []string{"_bucket", "_count", "_sum"}
+
+// histogramMetric is a metric backed by native histograms.
+type histogramMetric struct {
+	name  string
+	table string
+}
+
+// histogramMetricCache caches the metrics backed by native histograms, so that
+// the queries do not look them up when there is none.
+type histogramMetricCache struct {
+	mux       sync.Mutex
+	metrics   []histogramMetric
+	fetchedAt time.Time
+}
+
+func newHistogramMetricCache() *histogramMetricCache {
+	return &histogramMetricCache{}
+}
+
+// get returns the metrics backed by native histograms sorted by name, from
+// the cache if they were fetched less than histogramMetricsTTL ago. A nil
+// cache always fetches them.
+func (c *histogramMetricCache) get(conn pgxconn.PgxConn) ([]histogramMetric, error) {
+	if c != nil {
+		c.mux.Lock()
+		defer c.mux.Unlock()
+		if !c.fetchedAt.IsZero() && time.Since(c.fetchedAt) < histogramMetricsTTL {
+			return c.metrics, nil
+		}
+	}
+
+	rows, err := conn.Query(context.Background(), getHistogramMetricsSQL)
+	if err != nil {
+		return nil, fmt.Errorf("get histogram metrics: %w", err)
+	}
+	defer rows.Close()
+	var metrics []histogramMetric
+	for rows.Next() {
+		var m histogramMetric
+		if err = rows.Scan(&m.name, &m.table); err != nil {
+			return nil, fmt.Errorf("scanning histogram metric: %w", err)
+		}
+		metrics = append(metrics, m)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("get histogram metrics: %w", err)
+	}
+	// The collation of the database may not sort the names bytewise.
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name < metrics[j].name })
+	if c != nil {
+		c.metrics, c.fetchedAt = metrics, time.Now()
+	}
+	return metrics, nil
+}
+
+// nativeHistogramSeries holds the native histograms of a single series.
+type nativeHistogramSeries struct {
+	labelIds   []int64
+	times      []time.Time
+	histograms []model.NativeHistogram
+}
+
+// fetchNativeHistogramSamples returns the native histograms of a metric as the
+// series of a classic histogram: metric_bucket{le="..."}, metric_count and
+// metric_sum. The PromQL engine has no native histogram support, so this is
+// how histogram_quantile() and friends are evaluated on native histograms.
+// The le labels of a series are the bounds of the buckets of its histograms
+// within the queried range. It returns no rows if the queried metric is not
+// backed by native histograms.
+func fetchNativeHistogramSamples(tools *queryTools, filter timeFilter, ms []*labels.Matcher) ([]sampleRow, error) {
+	if !isRawDataFilter(filter) {
+		return nil, nil
+	}
+	var baseName, suffix string
+	for _, s := range nativeHistogramSuffixes {
+		if strings.HasSuffix(filter.metric, s) {
+			baseName, suffix = strings.TrimSuffix(filter.metric, s), s
+			break
+		}
+	}
+	if baseName == "" {
+		return nil, nil
+	}
+
+	metrics, err := tools.histogramMetrics.get(tools.conn)
+	if err != nil {
+		return nil, err
+	}
+	i := sort.Search(len(metrics), func(i int) bool { return metrics[i].name >= baseName })
+	if i == len(metrics) || metrics[i].name != baseName {
+		return nil, nil
+	}
+	return fetchHistogramTableSamples(tools, filter, ms, baseName, suffix, metrics[i].table)
+}
+
+// fetchMultipleNativeHistogramSamples returns the classic histogram series of
+// all the native histograms whose exposed series names match the metric name
+// matchers. It is the counterpart of fetchNativeHistogramSamples for the
+// selectors which do not match on a single metric name.
+func fetchMultipleNativeHistogramSamples(tools *queryTools, filter timeFilter, ms []*labels.Matcher) ([]sampleRow, error) {
+	if !isRawDataFilter(filter) {
+		return nil, nil
+	}
+	metrics, err := tools.histogramMetrics.get(tools.conn)
+	if err != nil || len(metrics) == 0 {
+		return nil, err
+	}
+	nameMatchers := make([]*labels.Matcher, 0, 1)
+	for _, m := range ms {
+		if m.Name == model.MetricNameLabelName {
+			nameMatchers = append(nameMatchers, m)
+		}
+	}
+
+	var results []sampleRow
+	for _, h := range metrics {
+		for _, suffix := range nativeHistogramSuffixes {
+			if !matchesAll(nameMatchers, h.name+suffix) {
+				continue
+			}
+			f := filter
+			f.metric = h.name + suffix
+			sampleRows, err := fetchHistogramTableSamples(tools, f, ms, h.name, suffix, h.table)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, sampleRows...)
+		}
+	}
+	return results, nil
+}
+
+// isRawDataFilter returns true if the filter reads the value column of the
+// metrics in the default data schema, the only ones native histograms are
+// exposed as.
+func isRawDataFilter(filter timeFilter) bool {
+	return (filter.schema == "" || filter.schema == schema.Data) && (filter.column == "" || filter.column == defaultColumnName)
+}
+
+// fetchHistogramTableSamples returns the rows of the classic histogram series
+// with the given suffix, decoded from the native histogram table of baseName.
+func fetchHistogramTableSamples(tools *queryTools, filter timeFilter, ms []*labels.Matcher, baseName, suffix, histogramTable string) ([]sampleRow, error) {
+	mInfo, err := tools.getMetricTableName(schema.Data, baseName, false)
+	if err != nil {
+		if err == errors.ErrMissingTableName {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("get metric table name: %w", err)
+	}
+
+	// The series are stored under the base name and do not have an le label,
+	// so the le matchers are applied on the decoded buckets.
+	matchers := make([]*labels.Matcher, 0, len(ms))
+	leMatchers := make([]*labels.Matcher, 0, 1)
+	for _, m := range ms {
+		switch m.Name {
+		case model.MetricNameLabelName:
+			matchers = append(matchers, labels.MustNewMatcher(labels.MatchEqual, model.MetricNameLabelName, baseName))
+		case bucketLabelName:
+			leMatchers = append(leMatchers, m)
+		default:
+			matchers = append(matchers, m)
+		}
+	}
+	if tools.rAuth != nil {
+		matchers = tools.rAuth.AppendTenantMatcher(matchers)
+	}
+	builder, err := BuildSubQueries(matchers)
+	if err != nil {
+		return nil, fmt.Errorf("build subQueries: %w", err)
+	}
+	clauses, values, err := builder.Build(false)
+	if err != nil {
+		return nil, fmt.Errorf("building native histogram clauses: %w", err)
+	}
+
+	sqlQuery := fmt.Sprintf(histogramsByMetricSQLFormat,
+		pgx.Identifier{schema.Histogram, histogramTable}.Sanitize(),
+		pgx.Identifier{schema.DataSeries, mInfo.SeriesTable}.Sanitize(),
+		strings.Join(clauses, " AND "),
+		filter.start,
+		filter.end,
+	)
+	rows, err := tools.conn.Query(context.Background(), sqlQuery, values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		series   []*nativeHistogramSeries
+		current  *nativeHistogramSeries
+		lastID   int64
+		seriesID int64
+	)
+	for rows.Next() {
+		var (
+			h        model.NativeHistogram
+			t        time.Time
+			labelIds []int64
+		)
+		err = rows.Scan(&seriesID, &labelIds, &t, &h.Count, &h.Sum, &h.Schema, &h.ZeroThreshold, &h.ZeroCount,
+			&h.PositiveOffset, &h.PositiveCounts, &h.NegativeOffset, &h.NegativeCounts)
+		if err != nil {
+			return nil, fmt.Errorf("scanning native histogram: %w", err)
+		}
+		if current == nil || seriesID != lastID {
+			current = &nativeHistogramSeries{labelIds: labelIds}
+			series = append(series, current)
+			lastID = seriesID
+		}
+		current.times = append(current.times, t)
+		current.histograms = append(current.histograms, h)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	results := make([]sampleRow, 0, len(series))
+	for _, s := range series {
+		results = append(results, s.sampleRows(filter.metric, suffix, leMatchers)...)
+	}
+	return results, nil
+}
+
+// sampleRows expands the native histograms of the series into the rows of the
+// classic histogram series with the given suffix.
+func (s *nativeHistogramSeries) sampleRows(metric, suffix string, leMatchers []*labels.Matcher) []sampleRow {
+	switch suffix {
+	case "_count", "_sum":
+		if !matchesAll(leMatchers, "") {
+			return nil
+		}
+		values := make([]float64, len(s.histograms))
+		for i := range s.histograms {
+			if suffix == "_count" {
+				values[i] = s.histograms[i].Count
+			} else {
+				values[i] = s.histograms[i].Sum
+			}
+		}
+		return []sampleRow{s.newSampleRow(metric, values, nil)}
+	}
+
+	// Histograms of a series can have different buckets, so every series
+	// gets the union of all the upper bounds.
+	boundSet := make(map[float64]struct{})
+	for i := range s.histograms {
+		for _, b := range s.histograms[i].CumulativeBuckets() {
+			boundSet[b.UpperBound] = struct{}{}
+		}
+	}
+	bounds := make([]float64, 0, len(boundSet))
+	for b := range boundSet {
+		bounds = append(bounds, b)
+	}
+	sort.Float64s(bounds)
+
+	counts := make([][]float64, len(s.histograms))
+	for i := range s.histograms {
+		counts[i] = s.histograms[i].CumulativeCounts(bounds)
+	}
+	rows := make([]sampleRow, 0, len(bounds))
+	for j, b := range bounds {
+		le := formatBucketBound(b)
+		if !matchesAll(leMatchers, le) {
+			continue
+		}
+		values := make([]float64, len(s.histograms))
+		for i := range counts {
+			values[i] = counts[i][j]
+		}
+		rows = append(rows, s.newSampleRow(metric, values, labels.Labels{{Name: bucketLabelName, Value: le}}))
+	}
+	return rows
+}
+
+func (s *nativeHistogramSeries) newSampleRow(metric string, values []float64, extraLabels labels.Labels) sampleRow {
+	times := &pgtype.TimestamptzArray{}
+	_ = times.Set(s.times)
+	valuesArray := &pgtype.Float8Array{}
+	_ = valuesArray.Set(values)
+	return sampleRow{
+		labelIds:       s.labelIds,
+		times:          newRowTimestampSeries(times),
+		values:         valuesArray,
+		metricOverride: metric,
+		extraLabels:    extraLabels,
+	}
+}
+
+func matchesAll(ms []*labels.Matcher, value string) bool {
+	for _, m := range ms {
+		if !m.Matches(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// formatBucketBound formats a bucket upper bound the way Prometheus client
+// libraries do for the le label.
+func formatBucketBound(b float64) string {
+	if math.IsInf(b, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(b, 'g', -1, 64)
+}
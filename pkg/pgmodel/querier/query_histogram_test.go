@@ -0,0 +1,100 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package querier
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/pgmodel/model"
+)
+
+func TestNativeHistogramSampleRows(t *testing.T) {
+	s := &nativeHistogramSeries{
+		labelIds: []int64{1, 2},
+		times:    []time.Time{time.Unix(1, 0), time.Unix(2, 0)},
+		histograms: []model.NativeHistogram{
+			{Count: 3, Sum: 4, PositiveCounts: []float64{1, 2}},
+			// The second histogram has an additional bucket.
+			{Count: 6, Sum: 9, PositiveCounts: []float64{2, 2, 2}},
+		},
+	}
+
+	values := func(r sampleRow) []float64 {
+		res := make([]float64, 0, len(r.values.Elements))
+		for _, e := range r.values.Elements {
+			res = append(res, e.Float)
+		}
+		return res
+	}
+
+	rows := s.sampleRows("metric_bucket", "_bucket", nil)
+	require.Len(t, rows, 4)
+	expected := []struct {
+		le     string
+		values []float64
+	}{
+		{"1", []float64{1, 2}},
+		{"2", []float64{3, 4}},
+		{"4", []float64{3, 6}},
+		{"+Inf", []float64{3, 6}},
+	}
+	for i, e := range expected {
+		require.Equal(t, "metric_bucket", rows[i].metricOverride)
+		require.Equal(t, labels.Labels{{Name: "le", Value: e.le}}, rows[i].GetAdditionalLabels())
+		require.Equal(t, e.values, values(rows[i]))
+		require.Equal(t, 2, rows[i].times.Len())
+		ts, ok := rows[i].times.At(1)
+		require.True(t, ok)
+		require.Equal(t, int64(2000), ts)
+	}
+
+	rows = s.sampleRows("metric_bucket", "_bucket", []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "le", "+Inf")})
+	require.Len(t, rows, 1)
+	require.Equal(t, []float64{3, 6}, values(rows[0]))
+
+	rows = s.sampleRows("metric_count", "_count", nil)
+	require.Len(t, rows, 1)
+	require.Empty(t, rows[0].GetAdditionalLabels())
+	require.Equal(t, []float64{3, 6}, values(rows[0]))
+
+	rows = s.sampleRows("metric_sum", "_sum", nil)
+	require.Equal(t, []float64{4, 9}, values(rows[0]))
+
+	rows = s.sampleRows("metric_sum", "_sum", []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "le", "1")})
+	require.Empty(t, rows)
+}
+
+func TestHistogramMetricCache(t *testing.T) {
+	// The native histogram metrics are only looked up once within the TTL.
+	mock := model.NewSqlRecorder([]model.SqlQuery{
+		{
+			Sql:     "SELECT metric_name, table_name FROM _prom_catalog.histogram ORDER BY metric_name",
+			Results: model.RowResults{},
+		},
+		{
+			Sql: "SELECT metric_name, table_name FROM _prom_catalog.histogram ORDER BY metric_name",
+			Err: fmt.Errorf("fetched again"),
+		},
+	}, t)
+	tools := &queryTools{conn: mock, histogramMetrics: newHistogramMetricCache()}
+	filter := timeFilter{metric: "metric_bucket", start: "1970-01-01T00:00:01Z", end: "1970-01-01T00:00:02Z"}
+	ms := []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, model.MetricNameLabelName, "metric.*")}
+	for i := 0; i < 2; i++ {
+		rows, err := fetchNativeHistogramSamples(tools, filter, ms)
+		require.NoError(t, err)
+		require.Empty(t, rows)
+		rows, err = fetchMultipleNativeHistogramSamples(tools, filter, ms)
+		require.NoError(t, err)
+		require.Empty(t, rows)
+	}
+
+	tools.histogramMetrics.fetchedAt = time.Now().Add(-histogramMetricsTTL)
+	_, err := fetchMultipleNativeHistogramSamples(tools, filter, ms)
+	require.EqualError(t, err, "get histogram metrics: fetched again", "the metrics are fetched again once expired")
+}
@@ -0,0 +1,233 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package querier
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/timescale/promscale/pkg/pgmodel/common/errors"
+	"github.com/timescale/promscale/pkg/pgmodel/common/schema"
+	"github.com/timescale/promscale/pkg/pgmodel/model"
+	"github.com/timescale/promscale/pkg/pgxconn"
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+const (
+	/* The series are returned with their label keys and values, sorted like
+	* Prometheus label sets. The sort key joins the keys and values with a
+	* separator lower than any other character, so that comparing the keys
+	* bytewise (COLLATE "C") compares the label sets label by label. */
+	readSeriesSQLFormat = `SELECT lbl.keys, lbl.vals, result.time_array, result.value_array, lbl.sort_key
+	FROM %[2]s series
+	CROSS JOIN LATERAL (
+		SELECT array_agg(l.key ORDER BY l.key COLLATE "C") AS keys,
+			array_agg(l.value ORDER BY l.key COLLATE "C") AS vals,
+			string_agg(l.key || chr(1) || l.value, chr(1) ORDER BY l.key COLLATE "C") COLLATE "C" AS sort_key
+		FROM _prom_catalog.label l
+		WHERE l.id = ANY(series.labels)
+	) AS lbl
+	INNER JOIN LATERAL (
+		SELECT array_agg(time ORDER BY time) AS time_array, array_agg(%[6]s ORDER BY time) AS value_array
+		FROM %[1]s metric
+		WHERE metric.series_id = series.id
+		AND time >= '%[4]s'
+		AND time <= '%[5]s'
+	) AS result ON (result.time_array IS NOT NULL)
+	WHERE %[3]s`
+
+	readSeriesUnion   = "\nUNION ALL\n"
+	readSeriesOrderBy = "\nORDER BY sort_key"
+)
+
+// ReadSeries implements the Querier interface. The series are read from a
+// single query sorted by labels, and decoded one at a time while the caller
+// iterates over them, so that only the series being sent is held in memory.
+// The series set must be closed to release the database connection.
+func (q *pgxQuerier) ReadSeries(ctx context.Context, query *prompb.Query) (SeriesSet, error) {
+	matchers, err := FromLabelMatchers(query.Matchers)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := getEvaluationMetadata(q.tools, query.StartTimestampMs, query.EndTimestampMs, GetPromQLMetadata(matchers, nil, nil, nil))
+	if err != nil {
+		return nil, fmt.Errorf("get evaluation metadata: %w", err)
+	}
+
+	var (
+		subQueries    []string
+		values        []interface{}
+		histogramRows []sampleRow
+		set           = &readSeriesSet{}
+	)
+	filter := metadata.timeFilter
+	if metadata.isSingleMetric {
+		mInfo, err := q.tools.getMetricTableName(filter.schema, filter.metric, false)
+		switch {
+		case err == errors.ErrMissingTableName:
+			if histogramRows, err = fetchNativeHistogramSamples(q.tools, filter, matchers); err != nil {
+				return nil, err
+			}
+		case err != nil:
+			return nil, fmt.Errorf("get metric table name: %w", err)
+		default:
+			column := filter.column
+			if column == "" {
+				column = defaultColumnName
+			}
+			subQueries = append(subQueries, buildReadSeriesQuery(mInfo, filter, column, metadata.clauses))
+			values = metadata.values
+			// Same labels as the series returned by fetchSingleMetricSamples.
+			if mInfo.TableName != mInfo.SeriesTable {
+				set.metricOverride = mInfo.TableName
+			}
+			set.extraLabels = (&sampleRow{schema: mInfo.TableSchema, column: column}).GetAdditionalLabels()
+		}
+	} else {
+		metrics, schemas, series, err := GetMetricNameSeriesIds(q.tools.conn, metadata)
+		if err != nil {
+			return nil, err
+		}
+		for i := range metrics {
+			mInfo, err := q.tools.getMetricTableName(schemas[i], metrics[i], false)
+			if err != nil {
+				if err == errors.ErrMissingTableName {
+					continue
+				}
+				return nil, err
+			}
+			if mInfo.TableSchema != schema.Data {
+				return nil, fmt.Errorf("found unsupported metric schema in multi-metric matching query")
+			}
+			ids := make([]string, len(series[i]))
+			for j, id := range series[i] {
+				ids[j] = fmt.Sprintf("%d", id)
+			}
+			clause := fmt.Sprintf("series.id IN (%s)", strings.Join(ids, ","))
+			subQueries = append(subQueries, buildReadSeriesQuery(mInfo, filter, defaultColumnName, []string{clause}))
+		}
+		if histogramRows, err = fetchMultipleNativeHistogramSamples(q.tools, filter, matchers); err != nil {
+			return nil, err
+		}
+	}
+
+	var sets []storage.SeriesSet
+	if len(subQueries) > 0 {
+		sql := strings.Join(subQueries, readSeriesUnion) + readSeriesOrderBy
+		if set.rows, err = q.tools.conn.Query(ctx, sql, values...); err != nil {
+			return nil, err
+		}
+		sets = append(sets, set)
+	}
+	if len(histogramRows) > 0 {
+		sets = append(sets, newSortedSeriesSet(buildSeriesSet(histogramRows, q.tools.labelsReader)))
+	}
+	switch len(sets) {
+	case 0:
+		// Without an error, the error series set is empty.
+		return errorSeriesSet{}, nil
+	case 1:
+		return sets[0].(SeriesSet), nil
+	}
+	// The histogram series are merged with the streamed ones in label order.
+	return &mergedSeriesSet{
+		SeriesSet: storage.NewMergeSeriesSet(sets, storage.ChainedSeriesMerge),
+		sets:      []SeriesSet{sets[0].(SeriesSet), sets[1].(SeriesSet)},
+	}, nil
+}
+
+func buildReadSeriesQuery(mInfo model.MetricInfo, filter timeFilter, column string, clauses []string) string {
+	return fmt.Sprintf(readSeriesSQLFormat,
+		pgx.Identifier{mInfo.TableSchema, mInfo.TableName}.Sanitize(),
+		pgx.Identifier{schema.DataSeries, mInfo.SeriesTable}.Sanitize(),
+		strings.Join(clauses, " AND "),
+		filter.start,
+		filter.end,
+		pgx.Identifier{column}.Sanitize(),
+	)
+}
+
+// readSeriesSet decodes the series of a ReadSeries query one row at a time.
+type readSeriesSet struct {
+	rows pgxconn.PgxRows
+	cur  *pgxSeries
+	err  error
+
+	// metricOverride and extraLabels are applied to the labels of all the
+	// series, like for the rows of a single metric query.
+	metricOverride string
+	extraLabels    labels.Labels
+}
+
+func (s *readSeriesSet) Next() bool {
+	s.cur = nil
+	if s.err != nil || !s.rows.Next() {
+		return false
+	}
+	var (
+		keys, vals []string
+		times      = &pgtype.TimestamptzArray{}
+		values     = &pgtype.Float8Array{}
+	)
+	if s.err = s.rows.Scan(&keys, &vals, times, values, nil); s.err != nil {
+		return false
+	}
+	if len(keys) != len(vals) || len(times.Elements) != len(values.Elements) {
+		s.err = errors.ErrInvalidRowData
+		return false
+	}
+	lls := make(labels.Labels, 0, len(keys)+len(s.extraLabels))
+	for i := range keys {
+		value := vals[i]
+		if keys[i] == model.MetricNameLabelName && s.metricOverride != "" {
+			value = s.metricOverride
+		}
+		lls = append(lls, labels.Label{Name: keys[i], Value: value})
+	}
+	if len(s.extraLabels) > 0 {
+		lls = append(lls, s.extraLabels...)
+		sort.Sort(lls)
+	}
+	s.cur = &pgxSeries{labels: lls, times: newRowTimestampSeries(times), values: values}
+	return true
+}
+
+func (s *readSeriesSet) At() storage.Series {
+	if s.cur == nil {
+		return nil
+	}
+	return s.cur
+}
+
+func (s *readSeriesSet) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.rows.Err()
+}
+
+func (s *readSeriesSet) Warnings() storage.Warnings { return nil }
+
+func (s *readSeriesSet) Close() {
+	s.rows.Close()
+}
+
+// mergedSeriesSet closes the merged series sets.
+type mergedSeriesSet struct {
+	storage.SeriesSet
+	sets []SeriesSet
+}
+
+func (m *mergedSeriesSet) Close() {
+	for _, s := range m.sets {
+		s.Close()
+	}
+}
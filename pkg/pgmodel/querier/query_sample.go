@@ -15,24 +15,30 @@ import (
 
 type querySamples struct {
 	*pgxQuerier
+	ctx context.Context
 }
 
-func newQuerySamples(qr *pgxQuerier) *querySamples {
-	return &querySamples{qr}
+func newQuerySamples(ctx context.Context, qr *pgxQuerier) *querySamples {
+	return &querySamples{qr, ctx}
 }
 
 // Select implements the Querier interface. It is the entry point for our
 // own version of the Prometheus engine.
-func (q *querySamples) Select(mint, maxt int64, _ bool, hints *storage.SelectHints, qh *QueryHints, path []parser.Node, ms ...*labels.Matcher) (seriesSet SeriesSet, node parser.Node) {
-	sampleRows, topNode, err := q.fetchSamplesRows(mint, maxt, hints, qh, path, ms)
+func (q *querySamples) Select(mint, maxt int64, sortSeries bool, hints *storage.SelectHints, qh *QueryHints, path []parser.Node, ms ...*labels.Matcher) (seriesSet SeriesSet, node parser.Node) {
+	stats := newSelectorStatsCollector(queryStatsFromContext(q.ctx), ms)
+	sampleRows, topNode, err := q.fetchSamplesRows(mint, maxt, hints, qh, path, ms, stats)
 	if err != nil {
 		return errorSeriesSet{err: err}, nil
 	}
+	stats.finish(sampleRows, topNode)
 	responseSeriesSet := buildSeriesSet(sampleRows, q.tools.labelsReader)
+	if sortSeries {
+		responseSeriesSet = newSortedSeriesSet(responseSeriesSet)
+	}
 	return responseSeriesSet, topNode
 }
 
-func (q *querySamples) fetchSamplesRows(mint, maxt int64, hints *storage.SelectHints, qh *QueryHints, path []parser.Node, ms []*labels.Matcher) ([]sampleRow, parser.Node, error) {
+func (q *querySamples) fetchSamplesRows(mint, maxt int64, hints *storage.SelectHints, qh *QueryHints, path []parser.Node, ms []*labels.Matcher, stats *selectorStatsCollector) ([]sampleRow, parser.Node, error) {
 	metadata, err := getEvaluationMetadata(q.tools, mint, maxt, GetPromQLMetadata(ms, hints, qh, path))
 	if err != nil {
 		return nil, nil, fmt.Errorf("get evaluation metadata: %w", err)
@@ -44,7 +50,10 @@ func (q *querySamples) fetchSamplesRows(mint, maxt int64, hints *storage.SelectH
 		mInfo, err := q.tools.getMetricTableName(filter.schema, filter.metric, false)
 		if err != nil {
 			if err == errors.ErrMissingTableName {
-				return nil, nil, nil
+				// The metric may be one of the classic histogram series
+				// exposing native histograms.
+				sampleRows, err := fetchNativeHistogramSamples(q.tools, filter, ms)
+				return sampleRows, nil, err
 			}
 			return nil, nil, fmt.Errorf("get metric table name: %w", err)
 		}
@@ -52,7 +61,24 @@ func (q *querySamples) fetchSamplesRows(mint, maxt int64, hints *storage.SelectH
 		metadata.timeFilter.schema = mInfo.TableSchema
 		metadata.timeFilter.seriesTable = mInfo.SeriesTable
 
-		sampleRows, topNode, err := fetchSingleMetricSamples(q.tools, metadata)
+		// Queries on raw metrics which do not pick a column can be served by
+		// a view with a coarse enough resolution.
+		if filter.schema == "" && filter.column == defaultColumnName && q.tools.metricViews != nil {
+			views, err := q.tools.metricViews.get(q.tools.conn, metadata.metric)
+			if err != nil {
+				return nil, nil, err
+			}
+			if v := chooseMetricView(views, hints, qh, path); v != nil {
+				metadata.timeFilter.metric = v.table
+				metadata.timeFilter.schema = v.schema
+				metadata.timeFilter.column = v.column
+				metadata.downsampled = true
+				stats.setDownsampled()
+			}
+		}
+		stats.addTable(metadata.timeFilter.schema, metadata.timeFilter.metric)
+
+		sampleRows, topNode, err := fetchSingleMetricSamples(q.tools, metadata, stats)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -60,7 +86,7 @@ func (q *querySamples) fetchSamplesRows(mint, maxt int64, hints *storage.SelectH
 		return sampleRows, topNode, nil
 	}
 	// Multiple vector selector case.
-	sampleRows, err := fetchMultipleMetricsSamples(q.tools, metadata)
+	sampleRows, err := fetchMultipleMetricsSamples(q.tools, metadata, stats)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -70,11 +96,14 @@ func (q *querySamples) fetchSamplesRows(mint, maxt int64, hints *storage.SelectH
 // fetchSingleMetricSamples returns all the result rows for a single metric using the
 // query metadata and the tools. It uses the hints and node path to try to push
 // down query functions where possible.
-func fetchSingleMetricSamples(tools *queryTools, metadata *evalMetadata) ([]sampleRow, parser.Node, error) {
+func fetchSingleMetricSamples(tools *queryTools, metadata *evalMetadata, stats *selectorStatsCollector) ([]sampleRow, parser.Node, error) {
 	sqlQuery, values, topNode, tsSeries, err := buildSingleMetricSamplesQuery(metadata)
 	if err != nil {
 		return nil, nil, err
 	}
+	if err = stats.addQuery(tools, sqlQuery, values); err != nil {
+		return nil, nil, err
+	}
 
 	rows, err := tools.conn.Query(context.Background(), sqlQuery, values...)
 	if err != nil {
@@ -103,7 +132,13 @@ func fetchSingleMetricSamples(tools *queryTools, metadata *evalMetadata) ([]samp
 	}
 
 	filter := metadata.timeFilter
-	samplesRows, err := appendSampleRows(make([]sampleRow, 0, 1), rows, tsSeries, updatedMetricName, filter.schema, filter.column)
+	labelsSchema, labelsColumn := filter.schema, filter.column
+	if metadata.downsampled {
+		// The view was not picked by the query, so its series look like the
+		// series of the raw metric.
+		updatedMetricName, labelsSchema, labelsColumn = "", "", ""
+	}
+	samplesRows, err := appendSampleRows(make([]sampleRow, 0, 1), rows, tsSeries, updatedMetricName, labelsSchema, labelsColumn)
 	if err != nil {
 		return nil, topNode, fmt.Errorf("appending sample rows: %w", err)
 	}
@@ -112,12 +147,18 @@ func fetchSingleMetricSamples(tools *queryTools, metadata *evalMetadata) ([]samp
 
 // queryMultipleMetrics returns all the result rows for across multiple metrics
 // using the supplied query parameters.
-func fetchMultipleMetricsSamples(tools *queryTools, metadata *evalMetadata) ([]sampleRow, error) {
+func fetchMultipleMetricsSamples(tools *queryTools, metadata *evalMetadata, stats *selectorStatsCollector) ([]sampleRow, error) {
 	// First fetch series IDs per metric.
 	metrics, schemas, series, err := GetMetricNameSeriesIds(tools.conn, metadata)
 	if err != nil {
 		return nil, err
 	}
+	if err = stats.addQuery(tools, buildMetricNameSeriesIDQuery(metadata.clauses), metadata.values); err != nil {
+		return nil, err
+	}
+
+	hints := metadata.selectHints
+	latestOnly := hints != nil && hints.Func == LatestSampleFunc
 
 	// TODO this assume on average on row per-metric. Is this right?
 	results := make([]sampleRow, 0, len(metrics))
@@ -150,10 +191,14 @@ func fetchMultipleMetricsSamples(tools *queryTools, metadata *evalMetadata) ([]s
 			start:       metadata.timeFilter.start,
 			end:         metadata.timeFilter.end,
 		}
-		sqlQuery, err := buildMultipleMetricSamplesQuery(filter, series[i])
+		sqlQuery, err := buildMultipleMetricSamplesQuery(filter, series[i], latestOnly)
 		if err != nil {
 			return nil, fmt.Errorf("build timeseries by series-id: %w", err)
 		}
+		if err = stats.addQuery(tools, sqlQuery, nil); err != nil {
+			return nil, err
+		}
+		stats.addTable(filter.schema, filter.metric)
 		batch.Queue(sqlQuery)
 		numQueries += 1
 	}
@@ -179,5 +224,11 @@ func fetchMultipleMetricsSamples(tools *queryTools, metadata *evalMetadata) ([]s
 		}
 	}
 
-	return results, nil
+	// The classic histogram series exposing native histograms are not in the
+	// series catalog under their own names, so they are matched separately.
+	histogramRows, err := fetchMultipleNativeHistogramSamples(tools, metadata.timeFilter, metadata.matchers)
+	if err != nil {
+		return nil, err
+	}
+	return append(results, histogramRows...), nil
 }
@@ -0,0 +1,182 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package querier
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// SelectorStats describes how the samples of a vector selector were fetched
+// from the database.
+type SelectorStats struct {
+	// Selector is the vector selector, as written in PromQL.
+	Selector string `json:"selector"`
+	// Tables are the tables the samples were read from.
+	Tables []string `json:"tables,omitempty"`
+	// Queries are the SQL queries executed to fetch the samples, in order.
+	Queries []QueryStatement `json:"queries,omitempty"`
+	// Pushdown is the PromQL expression evaluated in the database, empty if
+	// the raw samples were fetched.
+	Pushdown string `json:"pushdown,omitempty"`
+	// Downsampled is set if the samples were read from a metric view with a
+	// resolution instead of the raw metric.
+	Downsampled bool `json:"downsampled,omitempty"`
+	// Series and Samples are the number of series rows and of points
+	// returned by the database.
+	Series  int `json:"series"`
+	Samples int `json:"samples"`
+	// FetchTime is the time spent fetching the samples, in seconds.
+	FetchTime float64 `json:"fetchTime"`
+}
+
+// QueryStatement is an SQL query executed for a selector, along with its plan
+// if the query is explained.
+type QueryStatement struct {
+	SQL  string        `json:"sql"`
+	Args []interface{} `json:"args,omitempty"`
+	Plan []string      `json:"plan,omitempty"`
+}
+
+// QueryStats collects the statistics of the selectors of a PromQL query. It is
+// passed to the querier through the context of the query.
+type QueryStats struct {
+	explain bool
+
+	mux       sync.Mutex
+	selectors []SelectorStats
+}
+
+// NewQueryStats returns a collector of the statistics of a query. If explain is
+// set, the plan of every SQL query is fetched with EXPLAIN as well.
+func NewQueryStats(explain bool) *QueryStats {
+	return &QueryStats{explain: explain}
+}
+
+// Selectors returns the statistics of the selectors evaluated so far.
+func (s *QueryStats) Selectors() []SelectorStats {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	selectors := make([]SelectorStats, len(s.selectors))
+	copy(selectors, s.selectors)
+	return selectors
+}
+
+func (s *QueryStats) add(selector SelectorStats) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.selectors = append(s.selectors, selector)
+}
+
+type queryStatsKey struct{}
+
+// WithQueryStats returns a context collecting the statistics of the queries
+// executed with it in s.
+func WithQueryStats(ctx context.Context, s *QueryStats) context.Context {
+	return context.WithValue(ctx, queryStatsKey{}, s)
+}
+
+func queryStatsFromContext(ctx context.Context) *QueryStats {
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(queryStatsKey{}).(*QueryStats)
+	return s
+}
+
+// selectorStatsCollector records the statistics of a single selector while it
+// is being fetched. A nil collector records nothing.
+type selectorStatsCollector struct {
+	stats   *QueryStats
+	current SelectorStats
+	begin   time.Time
+}
+
+func newSelectorStatsCollector(s *QueryStats, ms []*labels.Matcher) *selectorStatsCollector {
+	if s == nil {
+		return nil
+	}
+	return &selectorStatsCollector{
+		stats:   s,
+		current: SelectorStats{Selector: (&parser.VectorSelector{LabelMatchers: ms}).String()},
+		begin:   time.Now(),
+	}
+}
+
+// addQuery records an executed SQL query, and its plan if the query is
+// explained.
+func (c *selectorStatsCollector) addQuery(tools *queryTools, sql string, args []interface{}) error {
+	if c == nil {
+		return nil
+	}
+	stmt := QueryStatement{SQL: sql, Args: args}
+	if c.stats.explain {
+		explainBegin := time.Now()
+		plan, err := explainQuery(tools, sql, args)
+		if err != nil {
+			return err
+		}
+		stmt.Plan = plan
+		// The time spent explaining is not part of the fetch time.
+		c.begin = c.begin.Add(time.Since(explainBegin))
+	}
+	c.current.Queries = append(c.current.Queries, stmt)
+	return nil
+}
+
+func (c *selectorStatsCollector) addTable(schemaName, table string) {
+	if c == nil {
+		return
+	}
+	c.current.Tables = append(c.current.Tables, schemaName+"."+table)
+}
+
+func (c *selectorStatsCollector) setDownsampled() {
+	if c == nil {
+		return
+	}
+	c.current.Downsampled = true
+}
+
+// finish records the results of the selector in the query statistics.
+func (c *selectorStatsCollector) finish(rows []sampleRow, topNode parser.Node) {
+	if c == nil {
+		return
+	}
+	c.current.FetchTime = time.Since(c.begin).Seconds()
+	if topNode != nil {
+		c.current.Pushdown = topNode.String()
+	}
+	c.current.Series = len(rows)
+	for i := range rows {
+		if rows[i].values != nil {
+			c.current.Samples += len(rows[i].values.Elements)
+		}
+	}
+	c.stats.add(c.current)
+}
+
+// explainQuery returns the plan of an SQL query, one line per element.
+func explainQuery(tools *queryTools, sql string, args []interface{}) ([]string, error) {
+	rows, err := tools.conn.Query(context.Background(), "EXPLAIN "+sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("explain query: %w", err)
+	}
+	defer rows.Close()
+	var plan []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return nil, fmt.Errorf("explain query: %w", err)
+		}
+		plan = append(plan, line)
+	}
+	return plan, rows.Err()
+}
@@ -20,6 +20,8 @@ type queryTools struct {
 	exemplarPosCache cache.PositionCache
 	labelsReader     lreader.LabelsReader
 	rAuth            tenancy.ReadAuthorizer
+	metricViews      *metricViewCache
+	histogramMetrics *histogramMetricCache
 }
 
 // getMetricTableName gets the table name for a specific metric from internal
@@ -140,6 +140,9 @@ type sampleRow struct {
 	metricOverride string
 	schema         string
 	column         string
+	// extraLabels are added to the labels of the series, e.g. the le
+	// label of buckets decoded from native histograms.
+	extraLabels labels.Labels
 
 	//only used to hold ownership for releasing to pool
 	timeArrayOwnership *pgtype.TimestamptzArray
@@ -159,7 +162,7 @@ func (r *sampleRow) GetAdditionalLabels() (ll labels.Labels) {
 	if r.column != "" && r.column != defaultColumnName {
 		ll = append(ll, labels.Label{Name: model.ColumnNameLabelName, Value: r.column})
 	}
-	return ll
+	return append(ll, r.extraLabels...)
 }
 
 // appendTsRows adds new results rows to already existing result rows and
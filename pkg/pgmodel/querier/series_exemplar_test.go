@@ -12,6 +12,7 @@ import (
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/stretchr/testify/require"
 	"github.com/timescale/promscale/pkg/pgmodel/cache"
+	"github.com/timescale/promscale/pkg/pgmodel/lreader"
 	"github.com/timescale/promscale/pkg/pgmodel/model"
 )
 
@@ -87,6 +88,14 @@ func (m mockLabelsReader) LabelValues(_ string) ([]string, error) {
 	return nil, nil
 }
 
+func (m mockLabelsReader) FilteredLabelNames(_ *lreader.SeriesFilter) ([]string, error) {
+	return nil, nil
+}
+
+func (m mockLabelsReader) FilteredLabelValues(_ string, _ *lreader.SeriesFilter) ([]string, error) {
+	return nil, nil
+}
+
 func (m mockLabelsReader) LabelsForIdMap(index map[int64]labels.Label) error {
 	for seriesId := range index {
 		if lbls, present := m.items[seriesId]; present {
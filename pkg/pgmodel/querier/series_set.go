@@ -148,6 +148,44 @@ func (p *pgxSamplesSeriesSet) Close() {
 	}
 }
 
+// sortedSeriesSet returns the series of a SeriesSet sorted by labels. The
+// rows are already held in memory, so only the series are buffered.
+type sortedSeriesSet struct {
+	SeriesSet
+	series []storage.Series
+	idx    int
+}
+
+func newSortedSeriesSet(set SeriesSet) SeriesSet {
+	s := &sortedSeriesSet{SeriesSet: set, idx: -1}
+	for set.Next() {
+		if series := set.At(); series != nil {
+			s.series = append(s.series, series)
+		}
+	}
+	sort.Slice(s.series, func(i, j int) bool {
+		return labels.Compare(s.series[i].Labels(), s.series[j].Labels()) < 0
+	})
+	return s
+}
+
+// Next forwards the internal cursor to next storage.Series
+func (s *sortedSeriesSet) Next() bool {
+	if s.SeriesSet.Err() != nil || s.idx >= len(s.series) {
+		return false
+	}
+	s.idx++
+	return s.idx < len(s.series)
+}
+
+// At returns the current storage.Series.
+func (s *sortedSeriesSet) At() storage.Series {
+	if s.idx < 0 || s.idx >= len(s.series) {
+		return nil
+	}
+	return s.series[s.idx]
+}
+
 // pgxSeries implements storage.Series.
 type pgxSeries struct {
 	labels labels.Labels
@@ -532,3 +532,42 @@ func genSeries(labels []int64, ts []pgtype.Timestamptz, vs []pgtype.Float8, sche
 		column:     column,
 	}
 }
+
+func TestSortedSeriesSet(t *testing.T) {
+	mapping := map[int64]struct {
+		k string
+		v string
+	}{
+		1: {"__name__", "up"},
+		2: {"job", "b"},
+		3: {"job", "a"},
+		4: {"instance", "1"},
+	}
+	point := []pgtype.Timestamptz{{Time: time.Unix(1, 0)}}
+	value := []pgtype.Float8{{Float: 1}}
+	input := [][]seriesSetRow{{
+		genSeries([]int64{1, 2}, point, value, "", defaultColumnName),
+		genSeries([]int64{1, 3}, point, value, "", defaultColumnName),
+		genSeries([]int64{1, 4, 3}, point, value, "", defaultColumnName),
+	}}
+
+	p := newSortedSeriesSet(buildSeriesSet(genPgxRows(input, nil), mapQuerier{mapping}))
+	var got []string
+	for p.Next() {
+		got = append(got, p.At().Labels().String())
+	}
+	if p.Err() != nil {
+		t.Fatal(p.Err())
+	}
+	expected := []string{
+		`{__name__="up", instance="1", job="a"}`,
+		`{__name__="up", job="a"}`,
+		`{__name__="up", job="b"}`,
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Fatalf("unexpected order of series: got %v, wanted %v", got, expected)
+	}
+	if p.Next() {
+		t.Fatal("unexpected series after the end of the set")
+	}
+}
@@ -60,7 +60,9 @@ type Queryable interface {
 type SamplesQuerier interface {
 	// LabelValues returns all potential values for a label name.
 	// It is not safe to use the strings beyond the lifefime of the querier.
-	LabelValues(name string) ([]string, storage.Warnings, error)
+	// If matchers are specified the returned result set is reduced
+	// to label values of metrics matching the matchers.
+	LabelValues(name string, matchers ...*labels.Matcher) ([]string, storage.Warnings, error)
 
 	// LabelNames returns all the unique label names present in the block in sorted order.
 	LabelNames(...*labels.Matcher) ([]string, storage.Warnings, error)
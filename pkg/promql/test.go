@@ -115,8 +115,8 @@ func (t *QuerierWrapper) Select(b bool, sh *storage.SelectHints, _ *querier.Quer
 	return ss, nil
 }
 
-func (t *QuerierWrapper) LabelValues(n string) ([]string, storage.Warnings, error) {
-	return nil, nil, nil
+func (t *QuerierWrapper) LabelValues(n string, m ...*labels.Matcher) ([]string, storage.Warnings, error) {
+	return t.Querier.LabelValues(n, m...)
 }
 
 // Test is a sequence of read and write commands that are run
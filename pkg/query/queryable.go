@@ -48,14 +48,31 @@ func (q queryable) newSamplesQuerier(ctx context.Context, mint, maxt int64) *sam
 	}
 }
 
-func (q samplesQuerier) LabelValues(name string) ([]string, storage.Warnings, error) {
-	lVals, err := q.labelsReader.LabelValues(name)
+func (q samplesQuerier) LabelValues(name string, matchers ...*labels.Matcher) ([]string, storage.Warnings, error) {
+	filter, err := q.metricsReader.SeriesFilter(q.mint, q.maxt, matchers...)
+	if err != nil {
+		return nil, nil, err
+	}
+	var lVals []string
+	if filter == nil {
+		lVals, err = q.labelsReader.LabelValues(name)
+	} else {
+		lVals, err = q.labelsReader.FilteredLabelValues(name, filter)
+	}
 	return lVals, nil, err
 }
 
-func (q samplesQuerier) LabelNames(_ ...*labels.Matcher) ([]string, storage.Warnings, error) {
-	// todo: implement labels matcher
-	lNames, err := q.labelsReader.LabelNames()
+func (q samplesQuerier) LabelNames(matchers ...*labels.Matcher) ([]string, storage.Warnings, error) {
+	filter, err := q.metricsReader.SeriesFilter(q.mint, q.maxt, matchers...)
+	if err != nil {
+		return nil, nil, err
+	}
+	var lNames []string
+	if filter == nil {
+		lNames, err = q.labelsReader.LabelNames()
+	} else {
+		lNames, err = q.labelsReader.FilteredLabelNames(filter)
+	}
 	return lNames, nil, err
 }
 
@@ -67,7 +84,7 @@ func (q *samplesQuerier) Close() error {
 }
 
 func (q *samplesQuerier) Select(sortSeries bool, hints *storage.SelectHints, qh *pgQuerier.QueryHints, path []parser.Node, matchers ...*labels.Matcher) (storage.SeriesSet, parser.Node) {
-	qry := q.metricsReader.SamplesQuerier()
+	qry := q.metricsReader.SamplesQuerier(q.ctx)
 	ss, n := qry.Select(q.mint, q.maxt, sortSeries, hints, qh, path, matchers...)
 	q.seriesSets = append(q.seriesSets, ss)
 	return ss, n
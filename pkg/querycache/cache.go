@@ -0,0 +1,316 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package querycache caches the results of range queries. Queries are split
+// into time intervals aligned on multiples of the split interval, and the
+// results of the intervals old enough not to receive new samples are cached,
+// so that only the recent tail of a query is evaluated again on dashboard
+// refreshes.
+package querycache
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgmodel/common/schema"
+	"github.com/timescale/promscale/pkg/pgxconn"
+	"github.com/timescale/promscale/pkg/promql"
+)
+
+const (
+	getEpochSQL = "SELECT current_epoch FROM " + schema.Catalog + ".ids_epoch LIMIT 1"
+
+	// epochCheckInterval is the minimum time between the checks of the
+	// series epoch.
+	epochCheckInterval = time.Minute
+)
+
+// Cache caches the results of range queries by time interval. The cached
+// results are dropped when series are deleted through this connector, or
+// when the series epoch changes, which happens once deleted series are
+// dropped from the database. Changes which do not go through either are not
+// seen by the cached results: deletions through other connectors until the
+// series are dropped, samples removed by the retention policies, and samples
+// ingested after the max freshness of their interval. That is why the cache
+// is opt-in.
+type Cache struct {
+	store         store
+	splitInterval int64
+	maxFreshness  time.Duration
+	conn          pgxconn.PgxConn
+	now           func() time.Time
+
+	// mtx is held for writing while the store is reset, so that results
+	// evaluated before an invalidation are not stored after it.
+	mtx        sync.RWMutex
+	generation uint64
+
+	epochMtx       sync.Mutex
+	epoch          int64
+	epochCheckedAt time.Time
+}
+
+// New returns a cache using the backend of the configuration. The series
+// epoch is read through conn.
+func New(cfg *Config, conn pgxconn.PgxConn) (*Cache, error) {
+	var (
+		s   store
+		err error
+	)
+	switch cfg.Backend {
+	case memoryBackend:
+		s = newMemoryStore(cfg.MaxEntries)
+	case diskBackend:
+		if s, err = newDiskStore(cfg.Directory, cfg.MaxEntries); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("invalid query cache backend %q", cfg.Backend)
+	}
+	return &Cache{
+		store:         s,
+		splitInterval: cfg.SplitInterval.Milliseconds(),
+		maxFreshness:  cfg.MaxFreshness,
+		conn:          conn,
+		now:           time.Now,
+	}, nil
+}
+
+// Invalidate drops all the cached results. It must be called after data is
+// deleted.
+func (c *Cache) Invalidate() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.generation++
+	c.store.reset()
+	invalidations.Inc()
+}
+
+// Exec returns the result of a range query created by the engine. The
+// results of the past intervals of the query are read from the cache or
+// evaluated and cached, while the recent tail is evaluated every time.
+func (c *Cache) Exec(ctx context.Context, engine *promql.Engine, queryable promql.Queryable, qry promql.Query) *promql.Result {
+	stmt, ok := qry.Statement().(*parser.EvalStmt)
+	if !ok || !cacheable(qry.String()) {
+		return qry.Exec(ctx)
+	}
+	var (
+		qs    = qry.String()
+		start = timestamp.FromTime(stmt.Start)
+		end   = timestamp.FromTime(stmt.End)
+		step  = stmt.Interval.Milliseconds()
+	)
+	// Only the intervals ending before the cutoff are cached.
+	cutoff := timestamp.FromTime(c.now().Add(-c.maxFreshness))
+	cutoff -= mod(cutoff, c.splitInterval)
+	if start >= cutoff || step <= 0 {
+		return qry.Exec(ctx)
+	}
+
+	c.mtx.RLock()
+	generation := c.generation
+	c.mtx.RUnlock()
+	epoch := c.checkEpoch()
+
+	offset := mod(start, step)
+	var segments []segment
+	for from := start - mod(start, c.splitInterval); from < cutoff && from <= end; from += c.splitInterval {
+		first := alignUp(from, step, offset)
+		last := alignUp(from+c.splitInterval, step, offset) - step
+		if first > last {
+			// No step falls in the interval.
+			continue
+		}
+		seg := segment{
+			first: first,
+			last:  last,
+			key:   fmt.Sprintf("%d/%d/%d/%d/%d/%s", epoch, c.splitInterval, step, offset, from, qs),
+		}
+		if seg.matrix, seg.cached = c.store.get(seg.key); seg.cached {
+			hits.Inc()
+		} else {
+			misses.Inc()
+		}
+		segments = append(segments, seg)
+	}
+	if tailStart := alignUp(cutoff, step, offset); tailStart <= end {
+		// The tail is evaluated every time and never cached.
+		segments = append(segments, segment{first: tailStart, last: end})
+	}
+
+	var (
+		matrices []promql.Matrix
+		warnings storage.Warnings
+	)
+	for i := 0; i < len(segments); {
+		if segments[i].cached {
+			matrices = append(matrices, segments[i].matrix)
+			i++
+			continue
+		}
+		// The consecutive segments missing from the cache are evaluated by
+		// a single range query, which is then split to cache each interval.
+		j := i
+		for j+1 < len(segments) && !segments[j+1].cached {
+			j++
+		}
+		res := evaluate(ctx, engine, queryable, qs, segments[i].first, segments[j].last, step)
+		if res.Err != nil {
+			return res
+		}
+		m, err := res.Matrix()
+		if err != nil {
+			return &promql.Result{Err: err}
+		}
+		matrices = append(matrices, m)
+		warnings = append(warnings, res.Warnings...)
+		// Warnings may tell about incomplete results, which must not be reused.
+		if len(res.Warnings) == 0 {
+			c.storeSegments(generation, segments[i:j+1], m)
+		}
+		i = j + 1
+	}
+	return &promql.Result{Value: merge(matrices, start, end), Warnings: warnings}
+}
+
+// segment is a part of a range query, from its first to its last step. The
+// segments of the past intervals are cached under their key, while the
+// segment of the recent tail has no key.
+type segment struct {
+	first, last int64
+	key         string
+	matrix      promql.Matrix
+	cached      bool
+}
+
+// storeSegments caches the points of m falling in each segment, unless the
+// cache was invalidated since generation.
+func (c *Cache) storeSegments(generation uint64, segments []segment, m promql.Matrix) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	if c.generation != generation {
+		return
+	}
+	for _, seg := range segments {
+		if seg.key == "" {
+			continue
+		}
+		cached := promql.Matrix{}
+		for _, s := range m {
+			points := trimPoints(s.Points, seg.first, seg.last)
+			if len(points) == 0 {
+				continue
+			}
+			// The points are copied, so that the cached interval does not
+			// hold the points of the whole query.
+			cached = append(cached, promql.Series{Metric: s.Metric, Points: append([]promql.Point(nil), points...)})
+		}
+		c.store.set(seg.key, cached)
+	}
+}
+
+// checkEpoch returns the current series epoch, reading it from the database
+// at most every epochCheckInterval. The cache is invalidated if the epoch
+// changed.
+func (c *Cache) checkEpoch() int64 {
+	c.epochMtx.Lock()
+	defer c.epochMtx.Unlock()
+	if c.conn == nil || c.now().Sub(c.epochCheckedAt) < epochCheckInterval {
+		return c.epoch
+	}
+	var epoch int64
+	if err := c.conn.QueryRow(context.Background(), getEpochSQL).Scan(&epoch); err != nil {
+		log.Warn("msg", "Reading the series epoch for the query cache failed", "err", err)
+		return c.epoch
+	}
+	if !c.epochCheckedAt.IsZero() && epoch != c.epoch {
+		c.Invalidate()
+	}
+	c.epoch = epoch
+	c.epochCheckedAt = c.now()
+	return epoch
+}
+
+func evaluate(ctx context.Context, engine *promql.Engine, queryable promql.Queryable, qs string, start, end, step int64) *promql.Result {
+	qry, err := engine.NewRangeQuery(queryable, qs, timestamp.Time(start), timestamp.Time(end), time.Duration(step)*time.Millisecond)
+	if err != nil {
+		return &promql.Result{Err: err}
+	}
+	return qry.Exec(ctx)
+}
+
+// cacheable returns false for the queries whose results at a step depend on
+// the range of the query, which is the case of the start() and end() @
+// modifiers.
+func cacheable(qs string) bool {
+	expr, err := parser.ParseExpr(qs)
+	if err != nil {
+		return false
+	}
+	ok := true
+	parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+		switch n := node.(type) {
+		case *parser.VectorSelector:
+			ok = ok && n.StartOrEnd == 0
+		case *parser.SubqueryExpr:
+			ok = ok && n.StartOrEnd == 0
+		}
+		return nil
+	})
+	return ok
+}
+
+// merge joins the series of the matrices, which hold consecutive time ranges,
+// keeping the points between start and end. The points are copied, since the
+// matrices may be cached.
+func merge(matrices []promql.Matrix, start, end int64) promql.Matrix {
+	var (
+		result = promql.Matrix{}
+		index  = make(map[string]int)
+	)
+	for _, m := range matrices {
+		for _, s := range m {
+			points := trimPoints(s.Points, start, end)
+			if len(points) == 0 {
+				continue
+			}
+			key := s.Metric.String()
+			i, ok := index[key]
+			if !ok {
+				i = len(result)
+				index[key] = i
+				result = append(result, promql.Series{Metric: s.Metric})
+			}
+			result[i].Points = append(result[i].Points, points...)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return labels.Compare(result[i].Metric, result[j].Metric) < 0
+	})
+	return result
+}
+
+func trimPoints(points []promql.Point, start, end int64) []promql.Point {
+	from := sort.Search(len(points), func(i int) bool { return points[i].T >= start })
+	to := sort.Search(len(points), func(i int) bool { return points[i].T > end })
+	return points[from:to]
+}
+
+// alignUp returns the first time from t which is offset after a multiple of
+// step.
+func alignUp(t, step, offset int64) int64 {
+	return t + mod(offset-t, step)
+}
+
+func mod(a, b int64) int64 {
+	return (a%b + b) % b
+}
@@ -0,0 +1,246 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package querycache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+	pgquerier "github.com/timescale/promscale/pkg/pgmodel/querier"
+	"github.com/timescale/promscale/pkg/promql"
+)
+
+func TestCacheExec(t *testing.T) {
+	storage := promql.NewTestStorage(t)
+	defer storage.Close()
+
+	app := storage.Appender(context.Background())
+	for ts := int64(0); ts <= 5*time.Hour.Milliseconds(); ts += 15000 {
+		_, err := app.Append(0, labels.FromStrings("__name__", "m", "job", "a"), ts, float64(ts/1000))
+		require.NoError(t, err)
+		if ts > 2*time.Hour.Milliseconds() {
+			// A series appearing in the middle of the queries.
+			_, err = app.Append(0, labels.FromStrings("__name__", "m", "job", "b"), ts, 1)
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, app.Commit())
+
+	engine := promql.NewEngine(promql.EngineOpts{
+		MaxSamples:       1000000,
+		Timeout:          10 * time.Second,
+		LookbackDelta:    5 * time.Minute,
+		EnableAtModifier: true,
+	})
+	cache, err := New(&Config{
+		Backend:       memoryBackend,
+		MaxEntries:    100,
+		SplitInterval: time.Hour,
+		MaxFreshness:  10 * time.Minute,
+	}, nil)
+	require.NoError(t, err)
+	cache.now = func() time.Time { return timestamp.Time(5 * time.Hour.Milliseconds()) }
+
+	rangeQuery := func(qs string, start, end, step time.Duration) promql.Query {
+		qry, err := engine.NewRangeQuery(storage, qs, timestamp.Time(start.Milliseconds()), timestamp.Time(end.Milliseconds()), step)
+		require.NoError(t, err)
+		return qry
+	}
+
+	testCases := []struct {
+		name   string
+		qs     string
+		start  time.Duration
+		end    time.Duration
+		step   time.Duration
+		misses float64
+	}{
+		{
+			name:   "aligned",
+			qs:     "m",
+			start:  0,
+			end:    5 * time.Hour,
+			step:   time.Minute,
+			misses: 4,
+		},
+		{
+			name:   "unaligned start",
+			qs:     "rate(m[5m])",
+			start:  37 * time.Minute,
+			end:    4*time.Hour + 55*time.Minute,
+			step:   7 * time.Minute,
+			misses: 4,
+		},
+		{
+			name:   "step longer than the split interval",
+			qs:     "sum(m)",
+			start:  10 * time.Minute,
+			end:    5 * time.Hour,
+			step:   90 * time.Minute,
+			misses: 3,
+		},
+		{
+			name:   "end before the cutoff",
+			qs:     "sum by (job) (m)",
+			start:  30 * time.Minute,
+			end:    2*time.Hour + 30*time.Minute,
+			step:   15 * time.Second,
+			misses: 3,
+		},
+		{
+			name:  "start after the cutoff",
+			qs:    "m",
+			start: 4*time.Hour + 30*time.Minute,
+			end:   5 * time.Hour,
+			step:  time.Minute,
+		},
+		{
+			name:  "@ modifier",
+			qs:    "m @ end()",
+			start: 0,
+			end:   5 * time.Hour,
+			step:  time.Minute,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			expected := rangeQuery(tc.qs, tc.start, tc.end, tc.step).Exec(context.Background())
+			require.NoError(t, expected.Err)
+
+			hitsBefore, missesBefore := testutil.ToFloat64(hits), testutil.ToFloat64(misses)
+			res := cache.Exec(context.Background(), engine, storage, rangeQuery(tc.qs, tc.start, tc.end, tc.step))
+			require.NoError(t, res.Err)
+			require.Equal(t, expected.Value, res.Value)
+			require.Equal(t, missesBefore+tc.misses, testutil.ToFloat64(misses))
+			require.Equal(t, hitsBefore, testutil.ToFloat64(hits))
+
+			res = cache.Exec(context.Background(), engine, storage, rangeQuery(tc.qs, tc.start, tc.end, tc.step))
+			require.NoError(t, res.Err)
+			require.Equal(t, expected.Value, res.Value)
+			require.Equal(t, missesBefore+tc.misses, testutil.ToFloat64(misses))
+			require.Equal(t, hitsBefore+tc.misses, testutil.ToFloat64(hits))
+		})
+	}
+
+	missesBefore := testutil.ToFloat64(misses)
+	cache.Invalidate()
+	res := cache.Exec(context.Background(), engine, storage, rangeQuery("m", 0, 5*time.Hour, time.Minute))
+	require.NoError(t, res.Err)
+	require.Equal(t, missesBefore+4, testutil.ToFloat64(misses))
+}
+
+func TestCacheExecEvaluations(t *testing.T) {
+	storage := promql.NewTestStorage(t)
+	defer storage.Close()
+
+	app := storage.Appender(context.Background())
+	for ts := int64(0); ts <= 5*time.Hour.Milliseconds(); ts += 15000 {
+		_, err := app.Append(0, labels.FromStrings("__name__", "m", "job", "a"), ts, float64(ts/1000))
+		require.NoError(t, err)
+	}
+	require.NoError(t, app.Commit())
+
+	engine := promql.NewEngine(promql.EngineOpts{
+		MaxSamples:    1000000,
+		Timeout:       10 * time.Second,
+		LookbackDelta: 5 * time.Minute,
+	})
+	cache, err := New(&Config{
+		Backend:       memoryBackend,
+		MaxEntries:    100,
+		SplitInterval: time.Hour,
+		MaxFreshness:  10 * time.Minute,
+	}, nil)
+	require.NoError(t, err)
+	cache.now = func() time.Time { return timestamp.Time(5 * time.Hour.Milliseconds()) }
+
+	exec := func(queryable *countingQueryable, start, end time.Duration) *promql.Result {
+		qry, err := engine.NewRangeQuery(queryable, "m", timestamp.Time(start.Milliseconds()), timestamp.Time(end.Milliseconds()), time.Minute)
+		require.NoError(t, err)
+		res := cache.Exec(context.Background(), engine, queryable, qry)
+		require.NoError(t, res.Err)
+		return res
+	}
+
+	// The intervals missing from the cache are evaluated with the tail in a
+	// single range query.
+	queryable := &countingQueryable{Queryable: storage}
+	exec(queryable, 2*time.Hour, 5*time.Hour)
+	require.Equal(t, 1, queryable.queries)
+
+	// The cached intervals split the missing ones.
+	queryable = &countingQueryable{Queryable: storage}
+	exec(queryable, 0, 5*time.Hour)
+	require.Equal(t, 2, queryable.queries)
+
+	// Results with warnings are not cached, and all the warnings are returned.
+	cache.Invalidate()
+	queryable = &countingQueryable{Queryable: storage, warn: true}
+	res := exec(queryable, 2*time.Hour, 4*time.Hour+50*time.Minute)
+	require.Equal(t, 1, queryable.queries)
+	require.Len(t, res.Warnings, 1)
+	queryable = &countingQueryable{Queryable: storage}
+	exec(queryable, 0, 5*time.Hour)
+	require.Equal(t, 1, queryable.queries)
+}
+
+// countingQueryable counts the queries evaluated on a queryable, and adds a
+// warning to their results if warn is set.
+type countingQueryable struct {
+	promql.Queryable
+	queries int
+	warn    bool
+}
+
+func (q *countingQueryable) SamplesQuerier(ctx context.Context, mint, maxt int64) (promql.SamplesQuerier, error) {
+	q.queries++
+	querier, err := q.Queryable.SamplesQuerier(ctx, mint, maxt)
+	return &warningQuerier{SamplesQuerier: querier, warn: q.warn}, err
+}
+
+type warningQuerier struct {
+	promql.SamplesQuerier
+	warn bool
+}
+
+func (q *warningQuerier) Select(sortSeries bool, hints *storage.SelectHints, qh *pgquerier.QueryHints, nodes []parser.Node, matchers ...*labels.Matcher) (storage.SeriesSet, parser.Node) {
+	ss, node := q.SamplesQuerier.Select(sortSeries, hints, qh, nodes, matchers...)
+	if !q.warn {
+		return ss, node
+	}
+	return warningSeriesSet{ss}, node
+}
+
+type warningSeriesSet struct {
+	storage.SeriesSet
+}
+
+func (warningSeriesSet) Warnings() storage.Warnings {
+	return storage.Warnings{fmt.Errorf("incomplete results")}
+}
+
+func TestAlignUp(t *testing.T) {
+	testCases := []struct {
+		t, step, offset, expected int64
+	}{
+		{t: 0, step: 10, offset: 0, expected: 0},
+		{t: 1, step: 10, offset: 0, expected: 10},
+		{t: 10, step: 10, offset: 3, expected: 13},
+		{t: 14, step: 10, offset: 3, expected: 23},
+		{t: -5, step: 10, offset: 3, expected: 3},
+		{t: -8, step: 10, offset: 3, expected: -7},
+	}
+	for _, tc := range testCases {
+		require.Equal(t, tc.expected, alignUp(tc.t, tc.step, tc.offset), "alignUp(%d, %d, %d)", tc.t, tc.step, tc.offset)
+	}
+}
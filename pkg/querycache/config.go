@@ -0,0 +1,67 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package querycache
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+const (
+	memoryBackend = "memory"
+	diskBackend   = "disk"
+)
+
+// Config holds the configuration of the query results cache.
+type Config struct {
+	// Backend is where the results are cached, memory or disk. The cache
+	// is disabled if it is empty.
+	Backend    string
+	Directory  string
+	MaxEntries uint64
+	// SplitInterval is the length of the time intervals that range
+	// queries are split into. Each interval is cached separately.
+	SplitInterval time.Duration
+	// MaxFreshness is the age under which results are never cached, since
+	// samples may still be ingested for that time.
+	MaxFreshness time.Duration
+}
+
+// Enabled returns true if a cache backend is configured.
+func (cfg *Config) Enabled() bool {
+	return cfg.Backend != ""
+}
+
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.StringVar(&cfg.Backend, "query-cache-backend", "", "Where to cache the results of range queries: 'memory' or 'disk'. The cache is disabled by default. Cached results do not see samples dropped by retention, deleted through other connectors or backfilled.")
+	fs.StringVar(&cfg.Directory, "query-cache-dir", "", "Directory of the cached results, when using the 'disk' backend.")
+	fs.Uint64Var(&cfg.MaxEntries, "query-cache-max-entries", 10000, "Maximum number of cached results. Each result holds a split interval of a range query.")
+	fs.DurationVar(&cfg.SplitInterval, "query-cache-split-interval", time.Hour, "Range queries are split into intervals of this length, aligned on multiples of it, which are cached separately.")
+	fs.DurationVar(&cfg.MaxFreshness, "query-cache-max-freshness", 10*time.Minute, "Results more recent than this are never cached, since samples may still be ingested for them.")
+	return cfg
+}
+
+func Validate(cfg *Config) error {
+	switch cfg.Backend {
+	case "", memoryBackend:
+	case diskBackend:
+		if cfg.Directory == "" {
+			return fmt.Errorf("query cache directory must be set with the disk backend")
+		}
+	default:
+		return fmt.Errorf("invalid query cache backend %q: must be memory or disk", cfg.Backend)
+	}
+	if cfg.MaxEntries == 0 {
+		return fmt.Errorf("query cache max entries must be positive")
+	}
+	if cfg.SplitInterval < time.Millisecond {
+		return fmt.Errorf("query cache split interval must be at least 1ms")
+	}
+	if cfg.MaxFreshness < 0 {
+		return fmt.Errorf("query cache max freshness must not be negative")
+	}
+	return nil
+}
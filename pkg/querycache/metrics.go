@@ -0,0 +1,45 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package querycache
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+var (
+	hits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "query_cache",
+			Name:      "hits_total",
+			Help:      "Total number of range query intervals served from the cache.",
+		},
+	)
+	misses = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "query_cache",
+			Name:      "misses_total",
+			Help:      "Total number of cacheable range query intervals which were evaluated.",
+		},
+	)
+	invalidations = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "query_cache",
+			Name:      "invalidations_total",
+			Help:      "Total number of times the cached results were dropped, after deletions or series epoch changes.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		hits,
+		misses,
+		invalidations,
+	)
+}
@@ -0,0 +1,206 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package querycache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/timescale/promscale/pkg/clockcache"
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/promql"
+)
+
+// store holds the cached results by key. The stored matrices must not be
+// modified.
+type store interface {
+	get(key string) (promql.Matrix, bool)
+	set(key string, m promql.Matrix)
+	reset()
+}
+
+// memoryStore keeps the results in a clockcache.
+type memoryStore struct {
+	cache *clockcache.Cache
+}
+
+func newMemoryStore(maxEntries uint64) *memoryStore {
+	return &memoryStore{cache: clockcache.WithMax(maxEntries)}
+}
+
+func (s *memoryStore) get(key string) (promql.Matrix, bool) {
+	m, ok := s.cache.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return m.(promql.Matrix), true
+}
+
+func (s *memoryStore) set(key string, m promql.Matrix) {
+	s.cache.Insert(key, m, uint64(len(key))+matrixSize(m))
+}
+
+func (s *memoryStore) reset() {
+	s.cache.Reset()
+}
+
+func matrixSize(m promql.Matrix) uint64 {
+	var size uint64
+	for _, s := range m {
+		for _, l := range s.Metric {
+			size += uint64(len(l.Name) + len(l.Value))
+		}
+		size += uint64(len(s.Points)) * 16
+	}
+	return size
+}
+
+const (
+	diskEntrySuffix    = ".result"
+	diskTmpEntryPrefix = "tmp-"
+)
+
+// diskEntry is the content of a file of the diskStore. The key is kept to
+// rule out hash collisions.
+type diskEntry struct {
+	Key    string
+	Matrix promql.Matrix
+}
+
+// diskStore keeps the results in files named after the hash of their key,
+// removing the oldest files beyond maxEntries. The results of a previous run
+// are kept.
+type diskStore struct {
+	dir        string
+	maxEntries int
+
+	mtx sync.Mutex
+	// files are the names of the entries, oldest first.
+	files []string
+	index map[string]struct{}
+}
+
+func newDiskStore(dir string, maxEntries uint64) (*diskStore, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("creating query cache directory: %w", err)
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading query cache directory: %w", err)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().Before(infos[j].ModTime())
+	})
+
+	s := &diskStore{dir: dir, maxEntries: int(maxEntries), index: make(map[string]struct{})}
+	for _, info := range infos {
+		switch name := info.Name(); {
+		case strings.HasPrefix(name, diskTmpEntryPrefix):
+			// Left over by a crash while writing an entry.
+			_ = os.Remove(filepath.Join(dir, name))
+		case strings.HasSuffix(name, diskEntrySuffix):
+			s.files = append(s.files, name)
+			s.index[name] = struct{}{}
+		}
+	}
+	s.prune()
+	return s, nil
+}
+
+func diskEntryName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:]) + diskEntrySuffix
+}
+
+func (s *diskStore) get(key string) (promql.Matrix, bool) {
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, diskEntryName(key)))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn("msg", "Reading a cached query result failed", "err", err)
+		}
+		return nil, false
+	}
+	var entry diskEntry
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entry); err != nil {
+		log.Warn("msg", "Decoding a cached query result failed", "err", err)
+		return nil, false
+	}
+	if entry.Key != key {
+		return nil, false
+	}
+	return entry.Matrix, true
+}
+
+func (s *diskStore) set(key string, m promql.Matrix) {
+	if err := s.write(key, m); err != nil {
+		log.Warn("msg", "Writing a cached query result failed", "err", err)
+	}
+}
+
+// write writes the entry to a temporary file which is renamed, so that
+// incomplete entries are never read.
+func (s *diskStore) write(key string, m promql.Matrix) error {
+	f, err := ioutil.TempFile(s.dir, diskTmpEntryPrefix)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(&diskEntry{Key: key, Matrix: m}); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+
+	name := diskEntryName(key)
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if err := os.Rename(f.Name(), filepath.Join(s.dir, name)); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if _, ok := s.index[name]; !ok {
+		s.files = append(s.files, name)
+		s.index[name] = struct{}{}
+		s.prune()
+	}
+	return nil
+}
+
+// prune removes the oldest entries beyond maxEntries. It must be called with
+// the lock held, or before the store is shared.
+func (s *diskStore) prune() {
+	for len(s.files) > s.maxEntries {
+		s.remove(s.files[0])
+		s.files = s.files[1:]
+	}
+}
+
+func (s *diskStore) remove(name string) {
+	delete(s.index, name)
+	if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !os.IsNotExist(err) {
+		log.Warn("msg", "Removing a cached query result failed", "err", err)
+	}
+}
+
+func (s *diskStore) reset() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for _, name := range s.files {
+		s.remove(name)
+	}
+	s.files = nil
+}
@@ -0,0 +1,88 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package querycache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/promql"
+)
+
+func testMatrix(v float64) promql.Matrix {
+	return promql.Matrix{
+		{Metric: labels.FromStrings("__name__", "m", "job", "a"), Points: []promql.Point{{T: 1000, V: v}, {T: 2000, V: v}}},
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	s := newMemoryStore(10)
+	_, ok := s.get("a")
+	require.False(t, ok)
+
+	s.set("a", testMatrix(1))
+	m, ok := s.get("a")
+	require.True(t, ok)
+	require.Equal(t, testMatrix(1), m)
+
+	s.reset()
+	_, ok = s.get("a")
+	require.False(t, ok)
+}
+
+func TestDiskStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "query_cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := newDiskStore(dir, 2)
+	require.NoError(t, err)
+	_, ok := s.get("a")
+	require.False(t, ok)
+
+	s.set("a", testMatrix(1))
+	s.set("b", testMatrix(2))
+	s.set("a", testMatrix(3))
+	m, ok := s.get("a")
+	require.True(t, ok)
+	require.Equal(t, testMatrix(3), m)
+
+	// The oldest entry is removed beyond the maximum.
+	s.set("c", testMatrix(4))
+	_, ok = s.get("a")
+	require.False(t, ok)
+	for key, v := range map[string]float64{"b": 2, "c": 4} {
+		m, ok = s.get(key)
+		require.True(t, ok, key)
+		require.Equal(t, testMatrix(v), m)
+	}
+
+	// The entries are kept across runs, and incomplete entries are removed.
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, diskTmpEntryPrefix+"1"), []byte("partial"), 0600))
+	s, err = newDiskStore(dir, 2)
+	require.NoError(t, err)
+	m, ok = s.get("c")
+	require.True(t, ok)
+	require.Equal(t, testMatrix(4), m)
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+
+	// Reopening with a lower maximum prunes the entries.
+	s, err = newDiskStore(dir, 1)
+	require.NoError(t, err)
+	require.Len(t, s.files, 1)
+
+	s.reset()
+	files, err = ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, files)
+	_, ok = s.get("b")
+	require.False(t, ok)
+}
@@ -0,0 +1,99 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package relabel
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/labels"
+	promrelabel "github.com/prometheus/prometheus/pkg/relabel"
+	"github.com/timescale/promscale/pkg/prompb"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+const (
+	reasonDropped      = "dropped"
+	reasonNoMetricName = "no_metric_name"
+)
+
+var droppedSeries = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: util.PromNamespace,
+		Name:      "relabel_dropped_series_total",
+		Help:      "Total number of series dropped at ingest by the write relabeling rules, either dropped by a rule or left without a metric name.",
+	},
+	[]string{"reason"},
+)
+
+func init() {
+	prometheus.MustRegister(droppedSeries)
+}
+
+// Relabeler is a write preprocessor applying Prometheus relabeling rules to
+// every incoming series. Series whose labels are dropped by the rules, or
+// whose metric name is removed or emptied by them, are removed from the write
+// request.
+type Relabeler struct {
+	configs []*promrelabel.Config
+}
+
+// NewRelabeler returns a new write relabeling preprocessor. Returns nil if
+// there are no relabeling rules, so nothing gets added to the write path.
+func NewRelabeler(configs []*promrelabel.Config) *Relabeler {
+	if len(configs) == 0 {
+		return nil
+	}
+	return &Relabeler{configs: configs}
+}
+
+// Process implements the Preprocessor interface.
+func (r *Relabeler) Process(_ *http.Request, wr *prompb.WriteRequest) error {
+	var (
+		dropped, noMetricName int
+		kept                  = wr.Timeseries[:0]
+	)
+	for _, ts := range wr.Timeseries {
+		lset := promrelabel.Process(toLabels(ts.Labels), r.configs...)
+		if lset == nil {
+			dropped++
+			continue
+		}
+		if lset.Get(labels.MetricName) == "" {
+			noMetricName++
+			continue
+		}
+		ts.Labels = fromLabels(lset, ts.Labels[:0])
+		kept = append(kept, ts)
+	}
+	// Clear the tail so that the dropped series can be garbage collected
+	// while the write request sits in the pool.
+	for i := len(kept); i < len(wr.Timeseries); i++ {
+		wr.Timeseries[i] = prompb.TimeSeries{}
+	}
+	wr.Timeseries = kept
+	droppedSeries.WithLabelValues(reasonDropped).Add(float64(dropped))
+	droppedSeries.WithLabelValues(reasonNoMetricName).Add(float64(noMetricName))
+	return nil
+}
+
+func toLabels(ls []prompb.Label) labels.Labels {
+	lset := make(labels.Labels, len(ls))
+	for i, l := range ls {
+		lset[i] = labels.Label{Name: l.Name, Value: l.Value}
+	}
+	sort.Sort(lset)
+	return lset
+}
+
+// fromLabels converts the labels back into protobuf labels, reusing the
+// given buffer if it is large enough.
+func fromLabels(lset labels.Labels, buf []prompb.Label) []prompb.Label {
+	for _, l := range lset {
+		buf = append(buf, prompb.Label{Name: l.Name, Value: l.Value})
+	}
+	return buf
+}
@@ -0,0 +1,187 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package relabel
+
+import (
+	"testing"
+
+	"github.com/prometheus/common/model"
+	promrelabel "github.com/prometheus/prometheus/pkg/relabel"
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/prompb"
+	"gopkg.in/yaml.v2"
+)
+
+func TestNewRelabelerNoConfigs(t *testing.T) {
+	require.Nil(t, NewRelabeler(nil))
+}
+
+func TestRelabelerProcess(t *testing.T) {
+	series := func(lbls ...string) prompb.TimeSeries {
+		ts := prompb.TimeSeries{Samples: []prompb.Sample{{Timestamp: 1, Value: 1}}}
+		for i := 0; i < len(lbls); i += 2 {
+			ts.Labels = append(ts.Labels, prompb.Label{Name: lbls[i], Value: lbls[i+1]})
+		}
+		return ts
+	}
+
+	testCases := []struct {
+		name     string
+		configs  string
+		input    []prompb.TimeSeries
+		expected []prompb.TimeSeries
+	}{
+		{
+			name: "drop metrics",
+			configs: `
+- source_labels: [__name__]
+  regex: noisy_.*
+  action: drop`,
+			input: []prompb.TimeSeries{
+				series("__name__", "noisy_metric", "job", "a"),
+				series("__name__", "up", "job", "a"),
+				series("__name__", "noisy_other", "job", "b"),
+			},
+			expected: []prompb.TimeSeries{
+				series("__name__", "up", "job", "a"),
+			},
+		},
+		{
+			name: "keep metrics",
+			configs: `
+- source_labels: [job]
+  regex: a
+  action: keep`,
+			input: []prompb.TimeSeries{
+				series("__name__", "up", "job", "a"),
+				series("__name__", "up", "job", "b"),
+			},
+			expected: []prompb.TimeSeries{
+				series("__name__", "up", "job", "a"),
+			},
+		},
+		{
+			name: "replace",
+			configs: `
+- source_labels: [instance]
+  regex: '([^:]+):\d+'
+  target_label: host`,
+			input: []prompb.TimeSeries{
+				series("__name__", "up", "instance", "web01:9100"),
+			},
+			expected: []prompb.TimeSeries{
+				series("__name__", "up", "host", "web01", "instance", "web01:9100"),
+			},
+		},
+		{
+			name: "labeldrop on unsorted labels",
+			configs: `
+- regex: pod_uid|container_id
+  action: labeldrop`,
+			input: []prompb.TimeSeries{
+				series("pod_uid", "123", "__name__", "cpu", "pod", "p", "container_id", "c"),
+			},
+			expected: []prompb.TimeSeries{
+				series("__name__", "cpu", "pod", "p"),
+			},
+		},
+		{
+			name: "labelmap",
+			configs: `
+- regex: __meta_(.+)
+  action: labelmap`,
+			input: []prompb.TimeSeries{
+				series("__meta_zone", "eu", "__name__", "up"),
+			},
+			expected: []prompb.TimeSeries{
+				series("__meta_zone", "eu", "__name__", "up", "zone", "eu"),
+			},
+		},
+		{
+			name: "hashmod",
+			configs: `
+- source_labels: [instance]
+  modulus: 2
+  target_label: __tmp_shard
+  action: hashmod
+- source_labels: [__tmp_shard]
+  regex: "1"
+  action: keep
+- regex: __tmp_shard
+  action: labeldrop`,
+			input: []prompb.TimeSeries{
+				series("__name__", "up", "instance", "a"),
+				series("__name__", "up", "instance", "e"),
+				series("__name__", "up", "instance", "f"),
+			},
+			expected: []prompb.TimeSeries{
+				series("__name__", "up", "instance", "a"),
+				series("__name__", "up", "instance", "f"),
+			},
+		},
+		{
+			name: "metric name dropped",
+			configs: `
+- regex: __name__
+  action: labeldrop`,
+			input: []prompb.TimeSeries{
+				series("__name__", "up", "job", "a"),
+			},
+			expected: []prompb.TimeSeries{},
+		},
+		{
+			name: "metric name emptied",
+			configs: `
+- source_labels: [job]
+  regex: b
+  target_label: __name__
+  replacement: ""`,
+			input: []prompb.TimeSeries{
+				series("__name__", "up", "job", "a"),
+				series("__name__", "up", "job", "b"),
+			},
+			expected: []prompb.TimeSeries{
+				series("__name__", "up", "job", "a"),
+			},
+		},
+		{
+			name: "all series dropped",
+			configs: `
+- source_labels: [__name__]
+  regex: .+
+  action: drop`,
+			input: []prompb.TimeSeries{
+				series("__name__", "up"),
+			},
+			expected: []prompb.TimeSeries{},
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var configs []*promrelabel.Config
+			require.NoError(t, yaml.UnmarshalStrict([]byte(c.configs), &configs))
+
+			wr := &prompb.WriteRequest{Timeseries: c.input}
+			require.NoError(t, NewRelabeler(configs).Process(nil, wr))
+			require.Equal(t, c.expected, wr.Timeseries)
+		})
+	}
+}
+
+func TestRelabelerKeepsMetricName(t *testing.T) {
+	var configs []*promrelabel.Config
+	require.NoError(t, yaml.UnmarshalStrict([]byte(`
+- source_labels: [__name__]
+  regex: (.*)_total
+  target_label: __name__
+  replacement: ${1}_count`), &configs))
+
+	wr := &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{{
+		Labels: []prompb.Label{{Name: model.MetricNameLabel, Value: "requests_total"}},
+	}}}
+	require.NoError(t, NewRelabeler(configs).Process(nil, wr))
+	require.Equal(t, []prompb.Label{{Name: model.MetricNameLabel, Value: "requests_count"}}, wr.Timeseries[0].Labels)
+}
@@ -0,0 +1,83 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package rules
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Config holds the configuration of the rule evaluator.
+type Config struct {
+	RuleFiles           []string
+	EvaluationInterval  time.Duration
+	AlertmanagerURLs    []string
+	AlertmanagerTimeout time.Duration
+	// ResendDelay is the minimum delay before a firing alert is sent
+	// again to the Alertmanagers.
+	ResendDelay time.Duration
+	// UseGroupLeases makes connectors sharing a database coordinate
+	// through advisory locks, so that each group is evaluated by a single
+	// connector.
+	UseGroupLeases bool
+}
+
+// Enabled returns true if rule files are configured.
+func (cfg *Config) Enabled() bool {
+	return len(cfg.RuleFiles) > 0
+}
+
+// listFlag is a repeatable flag, also accepting comma separated values.
+type listFlag []string
+
+func (l *listFlag) Set(val string) error {
+	for _, v := range strings.Split(val, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.Var((*listFlag)(&cfg.RuleFiles), "rules-file", "Prometheus rule file to evaluate. Globs are supported, e.g. 'rules/*.yml'. Can be repeated. "+
+		"Rule evaluation is disabled if no rule file is set.")
+	fs.DurationVar(&cfg.EvaluationInterval, "rules-evaluation-interval", time.Minute, "Interval at which rule groups without an interval of their own are evaluated.")
+	fs.Var((*listFlag)(&cfg.AlertmanagerURLs), "rules-alertmanager-url", "URL of an Alertmanager to send the alerts to, e.g. 'http://alertmanager:9093'. Can be repeated. "+
+		"Alerts are evaluated and recorded in the ALERTS metric but not sent if no URL is set.")
+	fs.DurationVar(&cfg.AlertmanagerTimeout, "rules-alertmanager-timeout", 10*time.Second, "Timeout for sending alerts to an Alertmanager.")
+	fs.DurationVar(&cfg.ResendDelay, "rules-resend-delay", time.Minute, "Minimum amount of time to wait before resending a firing alert to the Alertmanagers.")
+	fs.BoolVar(&cfg.UseGroupLeases, "rules-use-group-leases", true, "Coordinate the connectors sharing the database with advisory locks, so that every rule group is evaluated by a single connector.")
+	return cfg
+}
+
+func Validate(cfg *Config) error {
+	if cfg.EvaluationInterval <= 0 {
+		return fmt.Errorf("rules evaluation interval must be positive")
+	}
+	for _, u := range cfg.AlertmanagerURLs {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return fmt.Errorf("invalid Alertmanager URL %q: %w", u, err)
+		}
+		if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			return fmt.Errorf("invalid Alertmanager URL %q: scheme must be http or https", u)
+		}
+	}
+	if !cfg.Enabled() {
+		return nil
+	}
+	if _, err := LoadGroups(cfg.RuleFiles, cfg.EvaluationInterval); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package rules
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/rulefmt"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/prompb"
+	"github.com/timescale/promscale/pkg/promql"
+)
+
+// Group is a group of rules evaluated sequentially at the same interval, as
+// defined in a Prometheus rule file.
+type Group struct {
+	name     string
+	file     string
+	interval time.Duration
+	limit    int
+	rules    []Rule
+
+	// seriesInPreviousEval holds the series written by each rule in the
+	// previous evaluation, to write staleness markers for the series which
+	// disappear.
+	seriesInPreviousEval []map[uint64]labels.Labels
+}
+
+func (g *Group) Name() string            { return g.name }
+func (g *Group) File() string            { return g.file }
+func (g *Group) Interval() time.Duration { return g.interval }
+func (g *Group) Rules() []Rule           { return g.rules }
+
+// Key identifies the group across connectors.
+func (g *Group) Key() string {
+	return groupKey(g.file, g.name)
+}
+
+// LoadGroups loads the rule groups of the rule files matching the given
+// patterns. Groups without an interval get the default interval.
+func LoadGroups(patterns []string, defaultInterval time.Duration) ([]*Group, error) {
+	var groups []*Group
+	for _, pattern := range patterns {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule file pattern %q: %w", pattern, err)
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no rule file matches %q", pattern)
+		}
+		for _, file := range files {
+			fileGroups, err := loadFile(file, defaultInterval)
+			if err != nil {
+				return nil, err
+			}
+			groups = append(groups, fileGroups...)
+		}
+	}
+	return groups, nil
+}
+
+func loadFile(file string, defaultInterval time.Duration) ([]*Group, error) {
+	rgs, errs := rulefmt.ParseFile(file)
+	if len(errs) > 0 {
+		msgs := make([]string, len(errs))
+		for i, err := range errs {
+			msgs[i] = err.Error()
+		}
+		return nil, fmt.Errorf("error loading rule file %s: %s", file, strings.Join(msgs, "; "))
+	}
+
+	groups := make([]*Group, 0, len(rgs.Groups))
+	for _, rg := range rgs.Groups {
+		interval := defaultInterval
+		if rg.Interval != 0 {
+			interval = time.Duration(rg.Interval)
+		}
+		g := &Group{
+			name:                 rg.Name,
+			file:                 file,
+			interval:             interval,
+			limit:                rg.Limit,
+			rules:                make([]Rule, 0, len(rg.Rules)),
+			seriesInPreviousEval: make([]map[uint64]labels.Labels, len(rg.Rules)),
+		}
+		for _, r := range rg.Rules {
+			expr, err := parser.ParseExpr(r.Expr.Value)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing expression of rule in group %s of %s: %w", rg.Name, file, err)
+			}
+			if r.Alert.Value != "" {
+				g.rules = append(g.rules, &alertingRule{
+					name:         r.Alert.Value,
+					expr:         expr,
+					holdDuration: time.Duration(r.For),
+					labels:       labelsFromMap(r.Labels),
+					annotations:  labelsFromMap(r.Annotations),
+					active:       make(map[uint64]*Alert),
+				})
+				continue
+			}
+			g.rules = append(g.rules, &recordingRule{
+				name:   r.Record.Value,
+				expr:   expr,
+				labels: labelsFromMap(r.Labels),
+			})
+		}
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
+
+// restoreForState makes the alerting rules of the group restore the state of
+// their for clauses in their next evaluation.
+func (g *Group) restoreForState() {
+	for _, rule := range g.rules {
+		if ar, ok := rule.(*alertingRule); ok {
+			ar.mu.Lock()
+			ar.restoreForState = true
+			ar.mu.Unlock()
+		}
+	}
+}
+
+// evalTimestamp returns the timestamp of the latest evaluation of the group
+// scheduled before now. Evaluations are spread across the interval by
+// offsetting them with the hash of the group key, like in Prometheus.
+func (g *Group) evalTimestamp(now time.Time) time.Time {
+	offset := int64(labels.FromStrings("group", g.Key()).Hash() % uint64(g.interval))
+	adjNow := now.UnixNano() - offset
+	base := adjNow - (adjNow % int64(g.interval))
+	return time.Unix(0, base+offset)
+}
+
+// Eval evaluates all the rules of the group at the given time, writing the
+// recorded series and sending the alerts.
+func (g *Group) Eval(ctx context.Context, ts time.Time, opts *ManagerOptions) {
+	for i, rule := range g.rules {
+		kind := ruleKind(rule)
+		evaluations.WithLabelValues(kind).Inc()
+
+		vector, err := rule.Eval(ctx, ts, opts.QueryFunc)
+		if err == nil && g.limit > 0 && len(vector) > g.limit {
+			err = fmt.Errorf("exceeded limit of %d with %d series", g.limit, len(vector))
+		}
+		if err != nil {
+			evaluationFailures.WithLabelValues(kind).Inc()
+			log.Warn("msg", "Evaluating rule failed", "group", g.name, "file", g.file, "rule", rule.Name(), "err", err)
+			continue
+		}
+
+		if ar, ok := rule.(*alertingRule); ok && opts.Notifier != nil {
+			g.sendAlerts(ctx, ar, ts, opts)
+		}
+
+		seriesReturned := make(map[uint64]labels.Labels, len(vector))
+		for _, s := range vector {
+			seriesReturned[s.Metric.Hash()] = s.Metric
+		}
+		// Series which were written in the previous evaluation but not
+		// in this one are marked as stale.
+		for h, lset := range g.seriesInPreviousEval[i] {
+			if _, ok := seriesReturned[h]; !ok {
+				vector = append(vector, promql.Sample{
+					Metric: lset,
+					Point:  promql.Point{T: timestamp.FromTime(ts), V: math.Float64frombits(value.StaleNaN)},
+				})
+			}
+		}
+		g.seriesInPreviousEval[i] = seriesReturned
+
+		if len(vector) == 0 {
+			continue
+		}
+		// Recorded series are written after each rule, so that the next
+		// rules of the group can use them.
+		if _, _, err := opts.Ingestor.Ingest(ctx, writeRequest(vector)); err != nil {
+			log.Warn("msg", "Writing the result of rule failed", "group", g.name, "file", g.file, "rule", rule.Name(), "err", err)
+		}
+	}
+}
+
+func (g *Group) sendAlerts(ctx context.Context, rule *alertingRule, ts time.Time, opts *ManagerOptions) {
+	resendDelay := opts.ResendDelay
+	delta := resendDelay
+	if g.interval > resendDelay {
+		delta = g.interval
+	}
+	var alerts []*Alert
+	for _, a := range rule.ActiveAlerts() {
+		if !a.needsSending(ts, resendDelay) {
+			continue
+		}
+		a.LastSentAt = ts
+		// Alerts are valid for a few evaluation intervals, so that they
+		// are not resolved by the Alertmanagers if an evaluation fails.
+		a.ValidUntil = ts.Add(4 * delta)
+		copied := *a
+		alerts = append(alerts, &copied)
+	}
+	if len(alerts) == 0 {
+		return
+	}
+	if err := opts.Notifier.Send(ctx, alerts); err != nil {
+		log.Warn("msg", "Sending alerts failed", "group", g.name, "file", g.file, "rule", rule.Name(), "err", err)
+	}
+}
+
+// writeRequest converts the samples of a rule evaluation into a write request.
+func writeRequest(vector promql.Vector) *prompb.WriteRequest {
+	wr := &prompb.WriteRequest{Timeseries: make([]prompb.TimeSeries, 0, len(vector))}
+	for _, s := range vector {
+		ts := prompb.TimeSeries{
+			Labels:  make([]prompb.Label, 0, len(s.Metric)),
+			Samples: []prompb.Sample{{Timestamp: s.T, Value: s.V}},
+		}
+		for _, l := range s.Metric {
+			ts.Labels = append(ts.Labels, prompb.Label{Name: l.Name, Value: l.Value})
+		}
+		wr.Timeseries = append(wr.Timeseries, ts)
+	}
+	return wr
+}
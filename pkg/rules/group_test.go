@@ -0,0 +1,335 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package rules
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/prompb"
+	"github.com/timescale/promscale/pkg/promql"
+)
+
+const testRuleFile = `
+groups:
+- name: recording
+  interval: 30s
+  rules:
+  - record: job:up:sum
+    expr: sum by (job) (up)
+    labels:
+      source: promscale
+- name: alerting
+  rules:
+  - alert: InstanceDown
+    expr: up == 0
+    for: 2m
+    labels:
+      severity: page
+    annotations:
+      summary: "{{ $labels.instance }} is down"
+`
+
+type mockIngestor struct {
+	mu     sync.Mutex
+	series []prompb.TimeSeries
+}
+
+func (m *mockIngestor) Ingest(_ context.Context, r *prompb.WriteRequest) (uint64, uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.series = append(m.series, r.Timeseries...)
+	return uint64(len(r.Timeseries)), 0, nil
+}
+
+func (m *mockIngestor) reset() []prompb.TimeSeries {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	series := m.series
+	m.series = nil
+	return series
+}
+
+type mockNotifier struct {
+	alerts []*Alert
+}
+
+func (m *mockNotifier) Send(_ context.Context, alerts []*Alert) error {
+	m.alerts = append(m.alerts, alerts...)
+	return nil
+}
+
+func (m *mockNotifier) reset() []*Alert {
+	alerts := m.alerts
+	m.alerts = nil
+	return alerts
+}
+
+// mockQuerier returns the configured result of every query.
+type mockQuerier map[string]promql.Vector
+
+func (m mockQuerier) query(_ context.Context, q string, t time.Time) (promql.Vector, error) {
+	res := make(promql.Vector, 0, len(m[q]))
+	for _, s := range m[q] {
+		s.T = timestamp.FromTime(t)
+		res = append(res, s)
+	}
+	return res, nil
+}
+
+func writeRuleFile(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "promscale_rules")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	file := filepath.Join(dir, "rules.yml")
+	require.NoError(t, ioutil.WriteFile(file, []byte(content), 0600))
+	return file
+}
+
+func sample(v float64, lbls ...string) promql.Sample {
+	return promql.Sample{Metric: labels.FromStrings(lbls...), Point: promql.Point{V: v}}
+}
+
+func TestLoadGroups(t *testing.T) {
+	file := writeRuleFile(t, testRuleFile)
+
+	groups, err := LoadGroups([]string{filepath.Join(filepath.Dir(file), "*.yml")}, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, groups, 2)
+
+	require.Equal(t, "recording", groups[0].Name())
+	require.Equal(t, 30*time.Second, groups[0].Interval())
+	require.Len(t, groups[0].Rules(), 1)
+	require.Equal(t, "job:up:sum", groups[0].Rules()[0].Name())
+
+	require.Equal(t, "alerting", groups[1].Name())
+	require.Equal(t, time.Minute, groups[1].Interval())
+	require.Len(t, groups[1].Rules(), 1)
+	require.Equal(t, "alerting", ruleKind(groups[1].Rules()[0]))
+	require.NotEqual(t, groups[0].Key(), groups[1].Key())
+
+	_, err = LoadGroups([]string{filepath.Join(filepath.Dir(file), "*.yaml")}, time.Minute)
+	require.Error(t, err)
+
+	invalid := writeRuleFile(t, "groups:\n- name: invalid\n  rules:\n  - record: foo\n    expr: sum(\n")
+	_, err = LoadGroups([]string{invalid}, time.Minute)
+	require.Error(t, err)
+}
+
+func TestRecordingRuleEval(t *testing.T) {
+	groups, err := LoadGroups([]string{writeRuleFile(t, testRuleFile)}, time.Minute)
+	require.NoError(t, err)
+	g := groups[0]
+
+	querier := mockQuerier{
+		"sum by(job) (up)": {sample(2, "job", "api"), sample(1, "job", "db")},
+	}
+	ingestor := &mockIngestor{}
+	opts := &ManagerOptions{QueryFunc: querier.query, Ingestor: ingestor}
+
+	ts := time.Unix(1000, 0)
+	g.Eval(context.Background(), ts, opts)
+	series := ingestor.reset()
+	require.Len(t, series, 2)
+	for _, s := range series {
+		lbls := labelsFromProm(s.Labels)
+		require.Equal(t, "job:up:sum", lbls.Get(labels.MetricName))
+		require.Equal(t, "promscale", lbls.Get("source"))
+		require.Equal(t, []prompb.Sample{{Timestamp: timestamp.FromTime(ts), Value: map[string]float64{"api": 2, "db": 1}[lbls.Get("job")]}}, s.Samples)
+	}
+
+	// The series which disappear are marked as stale.
+	querier["sum by(job) (up)"] = promql.Vector{sample(3, "job", "api")}
+	ts = ts.Add(g.Interval())
+	g.Eval(context.Background(), ts, opts)
+	series = ingestor.reset()
+	require.Len(t, series, 2)
+	for _, s := range series {
+		require.Len(t, s.Samples, 1)
+		switch labelsFromProm(s.Labels).Get("job") {
+		case "api":
+			require.Equal(t, 3.0, s.Samples[0].Value)
+		case "db":
+			require.True(t, value.IsStaleNaN(s.Samples[0].Value))
+		default:
+			t.Fatalf("unexpected series %v", s.Labels)
+		}
+	}
+
+	// Nothing is written once the stale markers are written.
+	querier["sum by(job) (up)"] = nil
+	g.Eval(context.Background(), ts.Add(g.Interval()), opts)
+	require.Len(t, ingestor.reset(), 1)
+	g.Eval(context.Background(), ts.Add(2*g.Interval()), opts)
+	require.Len(t, ingestor.reset(), 0)
+}
+
+func TestAlertingRuleEval(t *testing.T) {
+	groups, err := LoadGroups([]string{writeRuleFile(t, testRuleFile)}, time.Minute)
+	require.NoError(t, err)
+	g := groups[1]
+
+	querier := mockQuerier{
+		"up == 0": {sample(0, "__name__", "up", "instance", "db:9100", "job", "db")},
+	}
+	ingestor := &mockIngestor{}
+	notifier := &mockNotifier{}
+	opts := &ManagerOptions{QueryFunc: querier.query, Ingestor: ingestor, Notifier: notifier, ResendDelay: time.Minute}
+
+	// alertState returns the state of the alert in the ALERTS series which
+	// is not stale.
+	alertState := func(series []prompb.TimeSeries) string {
+		var active []prompb.TimeSeries
+		for _, s := range series {
+			if labelsFromProm(s.Labels).Get(labels.MetricName) == alertMetricName && !value.IsStaleNaN(s.Samples[0].Value) {
+				active = append(active, s)
+			}
+		}
+		require.Len(t, active, 1)
+		lbls := labelsFromProm(active[0].Labels)
+		require.Equal(t, alertMetricName, lbls.Get(labels.MetricName))
+		require.Equal(t, "InstanceDown", lbls.Get(labels.AlertName))
+		require.Equal(t, "page", lbls.Get("severity"))
+		return lbls.Get(alertStateLabel)
+	}
+
+	// The alert is pending until the condition held for 2 minutes.
+	start := time.Unix(1000, 0)
+	g.Eval(context.Background(), start, opts)
+	require.Equal(t, "pending", alertState(ingestor.reset()))
+	require.Empty(t, notifier.reset())
+
+	g.Eval(context.Background(), start.Add(time.Minute), opts)
+	require.Equal(t, "pending", alertState(ingestor.reset()))
+	require.Empty(t, notifier.reset())
+
+	// The pending series becomes stale when the alert fires.
+	firedAt := start.Add(2 * time.Minute)
+	g.Eval(context.Background(), firedAt, opts)
+	series := ingestor.reset()
+	require.Len(t, series, 3)
+	require.Equal(t, "firing", alertState(series))
+	alerts := notifier.reset()
+	require.Len(t, alerts, 1)
+	require.Equal(t, StateFiring, alerts[0].State)
+	require.Equal(t, firedAt, alerts[0].FiredAt)
+	require.Equal(t, "db:9100 is down", alerts[0].Annotations.Get("summary"))
+	require.Equal(t, "", alerts[0].Labels.Get(labels.MetricName))
+	require.True(t, alerts[0].ValidUntil.After(firedAt))
+
+	// Firing alerts are resent after the resend delay.
+	g.Eval(context.Background(), firedAt.Add(time.Minute), opts)
+	require.Equal(t, "firing", alertState(ingestor.reset()))
+	require.Empty(t, notifier.reset())
+	g.Eval(context.Background(), firedAt.Add(2*time.Minute), opts)
+	require.Equal(t, "firing", alertState(ingestor.reset()))
+	require.Len(t, notifier.reset(), 1)
+
+	// Resolved alerts are sent once and the ALERTS and ALERTS_FOR_STATE
+	// series become stale.
+	querier["up == 0"] = nil
+	resolvedAt := firedAt.Add(3 * time.Minute)
+	g.Eval(context.Background(), resolvedAt, opts)
+	series = ingestor.reset()
+	require.Len(t, series, 2)
+	for _, s := range series {
+		require.True(t, value.IsStaleNaN(s.Samples[0].Value))
+	}
+	alerts = notifier.reset()
+	require.Len(t, alerts, 1)
+	require.Equal(t, StateInactive, alerts[0].State)
+	require.Equal(t, resolvedAt, alerts[0].ResolvedAt)
+
+	g.Eval(context.Background(), resolvedAt.Add(time.Minute), opts)
+	require.Empty(t, notifier.reset())
+
+	// Resolved alerts are forgotten after a while.
+	g.Eval(context.Background(), resolvedAt.Add(resolvedRetention+time.Minute), opts)
+	require.Empty(t, g.Rules()[0].(*alertingRule).ActiveAlerts())
+}
+
+func TestAlertingRuleRestoreForState(t *testing.T) {
+	groups, err := LoadGroups([]string{writeRuleFile(t, testRuleFile)}, time.Minute)
+	require.NoError(t, err)
+	g := groups[1]
+
+	activeAt := time.Unix(1000, 0)
+	alertLabels := []string{"alertname", "InstanceDown", "instance", "db:9100", "job", "db", "severity", "page"}
+	querier := mockQuerier{
+		"up == 0": {sample(0, "__name__", "up", "instance", "db:9100", "job", "db")},
+		`last_over_time(ALERTS_FOR_STATE{alertname="InstanceDown"}[1h])`: {sample(float64(activeAt.Unix()), alertLabels...)},
+	}
+	ingestor := &mockIngestor{}
+	opts := &ManagerOptions{QueryFunc: querier.query, Ingestor: ingestor}
+
+	forState := func(series []prompb.TimeSeries) float64 {
+		for _, s := range series {
+			if labelsFromProm(s.Labels).Get(labels.MetricName) == alertForStateMetricName {
+				return s.Samples[0].Value
+			}
+		}
+		require.Fail(t, "no ALERTS_FOR_STATE series")
+		return 0
+	}
+
+	// Without restoring, the alert starts over.
+	now := activeAt.Add(2 * time.Minute)
+	g.Eval(context.Background(), now, opts)
+	require.Equal(t, float64(now.Unix()), forState(ingestor.reset()))
+	require.Equal(t, StatePending, g.Rules()[0].(*alertingRule).ActiveAlerts()[0].State)
+
+	// Once restored, the alert fires as if it was active since activeAt.
+	groups, err = LoadGroups([]string{writeRuleFile(t, testRuleFile)}, time.Minute)
+	require.NoError(t, err)
+	g = groups[1]
+	g.restoreForState()
+	g.Eval(context.Background(), now, opts)
+	require.Equal(t, float64(activeAt.Unix()), forState(ingestor.reset()))
+	alerts := g.Rules()[0].(*alertingRule).ActiveAlerts()
+	require.Len(t, alerts, 1)
+	require.Equal(t, StateFiring, alerts[0].State)
+	require.Equal(t, activeAt, alerts[0].ActiveAt)
+}
+
+func TestRecordingRuleDuplicateLabelsets(t *testing.T) {
+	expr, err := parser.ParseExpr("up")
+	require.NoError(t, err)
+	rule := &recordingRule{name: "job:up", expr: expr, labels: labels.FromStrings("instance", "all")}
+	querier := mockQuerier{
+		"up": {sample(1, "__name__", "up", "instance", "a"), sample(1, "__name__", "up", "instance", "b")},
+	}
+
+	_, err = rule.Eval(context.Background(), time.Unix(1000, 0), querier.query)
+	require.Error(t, err)
+}
+
+func TestGroupEvalTimestamp(t *testing.T) {
+	g := &Group{name: "group", file: "rules.yml", interval: time.Minute}
+	now := time.Unix(10000, 0)
+
+	ts := g.evalTimestamp(now)
+	require.False(t, ts.After(now))
+	require.True(t, now.Sub(ts) < g.interval)
+	// The offset is stable across evaluations.
+	require.Equal(t, ts.Add(g.interval), g.evalTimestamp(now.Add(g.interval)))
+}
+
+func labelsFromProm(ls []prompb.Label) labels.Labels {
+	res := make(labels.Labels, 0, len(ls))
+	for _, l := range ls {
+		res = append(res, labels.Label{Name: l.Name, Value: l.Value})
+	}
+	return res
+}
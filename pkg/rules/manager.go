@@ -0,0 +1,140 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package rules
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/prompb"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+// groupLockPrefix prefixes the group keys hashed into advisory lock IDs, so
+// that they do not collide with the other advisory locks.
+const groupLockPrefix = "promscale-rule-group;"
+
+// Ingestor writes the series recorded by the rules.
+type Ingestor interface {
+	Ingest(ctx context.Context, r *prompb.WriteRequest) (uint64, uint64, error)
+}
+
+// ManagerOptions holds what the rule groups need to be evaluated.
+type ManagerOptions struct {
+	QueryFunc QueryFunc
+	Ingestor  Ingestor
+	// Notifier receives the alerts. Alerts are not sent if nil.
+	Notifier    Notifier
+	ResendDelay time.Duration
+	// NewElection returns the election deciding which connector evaluates
+	// the group with the given key. Every group is evaluated if nil.
+	NewElection func(groupKey string) (util.Election, error)
+}
+
+// Manager evaluates rule groups at their intervals.
+type Manager struct {
+	groups []*Group
+	opts   *ManagerOptions
+}
+
+// NewManager returns a manager for the given rule groups.
+func NewManager(groups []*Group, opts *ManagerOptions) *Manager {
+	return &Manager{groups: groups, opts: opts}
+}
+
+// Groups returns the rule groups of the manager.
+func (m *Manager) Groups() []*Group {
+	return m.groups
+}
+
+// Run evaluates the rule groups until the context is canceled.
+func (m *Manager) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, g := range m.groups {
+		var election util.Election
+		if m.opts.NewElection != nil {
+			var err error
+			if election, err = m.opts.NewElection(g.Key()); err != nil {
+				log.Error("msg", "Creating the election of rule group failed, the group will not be evaluated", "group", g.Name(), "file", g.File(), "err", err)
+				continue
+			}
+		}
+		wg.Add(1)
+		go func(g *Group) {
+			defer wg.Done()
+			m.runGroup(ctx, g, election)
+		}(g)
+	}
+	wg.Wait()
+}
+
+func (m *Manager) runGroup(ctx context.Context, g *Group, election util.Election) {
+	leader := false
+	defer func() {
+		if leader {
+			groupsEvaluated.Dec()
+			if err := election.Resign(); err != nil {
+				log.Warn("msg", "Resigning from rule group evaluation failed", "group", g.Name(), "err", err)
+			}
+		}
+	}()
+
+	next := g.evalTimestamp(time.Now()).Add(g.interval)
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		isLeader := true
+		if election != nil {
+			var err error
+			isLeader, err = election.IsLeader()
+			if err != nil {
+				log.Warn("msg", "Checking the rule group lease failed", "group", g.Name(), "file", g.File(), "err", err)
+				isLeader = false
+			}
+		}
+		if isLeader != leader {
+			if isLeader {
+				log.Info("msg", "Starting to evaluate rule group", "group", g.Name(), "file", g.File())
+				groupsEvaluated.Inc()
+				// The group may have been evaluated by another connector
+				// or before a restart.
+				g.restoreForState()
+			} else {
+				log.Info("msg", "Rule group is evaluated by another connector", "group", g.Name(), "file", g.File())
+				groupsEvaluated.Dec()
+			}
+			leader = isLeader
+		}
+		if leader {
+			g.Eval(ctx, next, m.opts)
+		}
+
+		// Only the latest of the evaluations which should already have
+		// happened is run.
+		next = next.Add(g.interval)
+		if missed := time.Since(next) / g.interval; missed > 0 {
+			groupIterationsMissed.Add(float64(missed))
+			next = next.Add(g.interval * missed)
+		}
+		timer.Reset(time.Until(next))
+	}
+}
+
+// GroupLockID returns the advisory lock ID used to coordinate the evaluation
+// of the group with the given key.
+func GroupLockID(groupKey string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(groupLockPrefix + groupKey))
+	return int64(h.Sum64())
+}
@@ -0,0 +1,72 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package rules
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+var (
+	evaluations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "rules",
+			Name:      "evaluations_total",
+			Help:      "Total number of rule evaluations, by kind of rule.",
+		}, []string{"kind"},
+	)
+	evaluationFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "rules",
+			Name:      "evaluation_failures_total",
+			Help:      "Total number of rule evaluations which failed, by kind of rule.",
+		}, []string{"kind"},
+	)
+	groupIterationsMissed = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "rules",
+			Name:      "group_iterations_missed_total",
+			Help:      "Total number of rule group evaluations missed because of slow evaluations.",
+		},
+	)
+	alertsSent = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "rules",
+			Name:      "alerts_sent_total",
+			Help:      "Total number of alerts sent to the Alertmanagers.",
+		},
+	)
+	alertsSendFailures = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "rules",
+			Name:      "alerts_send_failures_total",
+			Help:      "Total number of alerts which could not be sent to an Alertmanager.",
+		},
+	)
+	groupsEvaluated = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "rules",
+			Name:      "groups_evaluated",
+			Help:      "Number of rule groups evaluated by this connector.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		evaluations,
+		evaluationFailures,
+		groupIterationsMissed,
+		alertsSent,
+		alertsSendFailures,
+		groupsEvaluated,
+	)
+}
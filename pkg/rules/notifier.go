@@ -0,0 +1,103 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package rules
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const alertmanagerAlertsPath = "/api/v2/alerts"
+
+// Notifier sends the alerts which are firing or were resolved.
+type Notifier interface {
+	Send(ctx context.Context, alerts []*Alert) error
+}
+
+// alertmanagerAlert is an alert in the format of the Alertmanager API v2.
+type alertmanagerAlert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt,omitempty"`
+	EndsAt       time.Time         `json:"endsAt,omitempty"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+// AlertmanagerNotifier sends the alerts to all the configured Alertmanagers.
+type AlertmanagerNotifier struct {
+	urls   []string
+	client *http.Client
+}
+
+// NewAlertmanagerNotifier returns a notifier sending the alerts to the
+// Alertmanagers listening at the given URLs.
+func NewAlertmanagerNotifier(urls []string, timeout time.Duration) *AlertmanagerNotifier {
+	return &AlertmanagerNotifier{
+		urls:   urls,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Send implements the Notifier interface. An error is returned if no
+// Alertmanager received the alerts.
+func (n *AlertmanagerNotifier) Send(ctx context.Context, alerts []*Alert) error {
+	payload := make([]alertmanagerAlert, 0, len(alerts))
+	for _, a := range alerts {
+		am := alertmanagerAlert{
+			Labels:      a.Labels.Map(),
+			Annotations: a.Annotations.Map(),
+			StartsAt:    a.FiredAt,
+			EndsAt:      a.ValidUntil,
+		}
+		if !a.ResolvedAt.IsZero() {
+			am.EndsAt = a.ResolvedAt
+		}
+		payload = append(payload, am)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("encoding alerts: %w", err)
+	}
+
+	var (
+		numSent int
+		lastErr error
+	)
+	for _, u := range n.urls {
+		if err := n.post(ctx, strings.TrimSuffix(u, "/")+alertmanagerAlertsPath, body); err != nil {
+			alertsSendFailures.Add(float64(len(alerts)))
+			lastErr = fmt.Errorf("sending alerts to %s: %w", u, err)
+			continue
+		}
+		alertsSent.Add(float64(len(alerts)))
+		numSent++
+	}
+	if numSent == 0 {
+		return lastErr
+	}
+	return nil
+}
+
+func (n *AlertmanagerNotifier) post(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("bad response status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlertmanagerNotifier(t *testing.T) {
+	var received []alertmanagerAlert
+	alertmanager := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, alertmanagerAlertsPath, r.URL.Path)
+		require.Equal(t, http.MethodPost, r.Method)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer alertmanager.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	firedAt := time.Unix(1000, 0).UTC()
+	alerts := []*Alert{
+		{
+			State:       StateFiring,
+			Labels:      labels.FromStrings("alertname", "InstanceDown", "instance", "db:9100"),
+			Annotations: labels.FromStrings("summary", "db:9100 is down"),
+			FiredAt:     firedAt,
+			ValidUntil:  firedAt.Add(4 * time.Minute),
+		},
+		{
+			State:      StateInactive,
+			Labels:     labels.FromStrings("alertname", "InstanceDown", "instance", "api:9100"),
+			FiredAt:    firedAt,
+			ResolvedAt: firedAt.Add(time.Minute),
+			ValidUntil: firedAt.Add(4 * time.Minute),
+		},
+	}
+
+	// Sending succeeds if one of the Alertmanagers received the alerts.
+	n := NewAlertmanagerNotifier([]string{failing.URL, alertmanager.URL + "/"}, time.Second)
+	require.NoError(t, n.Send(context.Background(), alerts))
+	require.Len(t, received, 2)
+	require.Equal(t, map[string]string{"alertname": "InstanceDown", "instance": "db:9100"}, received[0].Labels)
+	require.Equal(t, map[string]string{"summary": "db:9100 is down"}, received[0].Annotations)
+	require.True(t, firedAt.Equal(received[0].StartsAt))
+	require.True(t, firedAt.Add(4*time.Minute).Equal(received[0].EndsAt))
+	// Resolved alerts end when they were resolved.
+	require.True(t, firedAt.Add(time.Minute).Equal(received[1].EndsAt))
+
+	n = NewAlertmanagerNotifier([]string{failing.URL}, time.Second)
+	require.Error(t, n.Send(context.Background(), alerts))
+}
@@ -0,0 +1,357 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package rules
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	prompromql "github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/template"
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/promql"
+)
+
+const (
+	// alertMetricName is the metric recording the active alerts, as in
+	// Prometheus.
+	alertMetricName = "ALERTS"
+	alertStateLabel = "alertstate"
+	// alertForStateMetricName is the metric recording the activation time
+	// of the active alerts, as in Prometheus. It is used to restore the
+	// state of for clauses.
+	alertForStateMetricName = "ALERTS_FOR_STATE"
+
+	// resolvedRetention is how long resolved alerts are kept around, so that
+	// they are reported as resolved to the Alertmanagers.
+	resolvedRetention = 15 * time.Minute
+	// forOutageTolerance is how far back the activation time of the alerts
+	// is looked for when their state is restored.
+	forOutageTolerance = time.Hour
+)
+
+// QueryFunc evaluates an instant query at the given time.
+type QueryFunc func(ctx context.Context, q string, t time.Time) (promql.Vector, error)
+
+// EngineQueryFunc returns a QueryFunc evaluating the queries with the engine
+// on the queryable.
+func EngineQueryFunc(engine *promql.Engine, queryable promql.Queryable) QueryFunc {
+	return func(ctx context.Context, qs string, t time.Time) (promql.Vector, error) {
+		q, err := engine.NewInstantQuery(queryable, qs, t)
+		if err != nil {
+			return nil, err
+		}
+		defer q.Close()
+		res := q.Exec(ctx)
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		switch v := res.Value.(type) {
+		case promql.Vector:
+			return v, nil
+		case promql.Scalar:
+			return promql.Vector{promql.Sample{Point: promql.Point{T: v.T, V: v.V}}}, nil
+		default:
+			return nil, fmt.Errorf("rule result is not a vector or scalar")
+		}
+	}
+}
+
+// Rule is a recording or alerting rule.
+type Rule interface {
+	Name() string
+	// Eval evaluates the rule at the given time and returns the samples
+	// to record.
+	Eval(ctx context.Context, ts time.Time, query QueryFunc) (promql.Vector, error)
+}
+
+type recordingRule struct {
+	name   string
+	expr   parser.Expr
+	labels labels.Labels
+}
+
+func (r *recordingRule) Name() string {
+	return r.name
+}
+
+func (r *recordingRule) Eval(ctx context.Context, ts time.Time, query QueryFunc) (promql.Vector, error) {
+	vector, err := query(ctx, r.expr.String(), ts)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[uint64]struct{}, len(vector))
+	for i := range vector {
+		lb := labels.NewBuilder(vector[i].Metric).Set(labels.MetricName, r.name)
+		for _, l := range r.labels {
+			lb.Set(l.Name, l.Value)
+		}
+		vector[i].Metric = lb.Labels()
+		vector[i].T = timestamp.FromTime(ts)
+
+		h := vector[i].Metric.Hash()
+		if _, ok := seen[h]; ok {
+			return nil, fmt.Errorf("vector contains metrics with the same labelset after applying rule labels")
+		}
+		seen[h] = struct{}{}
+	}
+	return vector, nil
+}
+
+// AlertState is the state of an alert.
+type AlertState int
+
+const (
+	StateInactive AlertState = iota
+	StatePending
+	StateFiring
+)
+
+func (s AlertState) String() string {
+	switch s {
+	case StatePending:
+		return "pending"
+	case StateFiring:
+		return "firing"
+	default:
+		return "inactive"
+	}
+}
+
+// Alert is an alert of an alerting rule, identified by its labels.
+type Alert struct {
+	State       AlertState
+	Labels      labels.Labels
+	Annotations labels.Labels
+	Value       float64
+
+	ActiveAt   time.Time
+	FiredAt    time.Time
+	ResolvedAt time.Time
+	LastSentAt time.Time
+	ValidUntil time.Time
+}
+
+func (a *Alert) needsSending(ts time.Time, resendDelay time.Duration) bool {
+	if a.State == StatePending {
+		return false
+	}
+	// Resolved alerts are sent once.
+	if a.ResolvedAt.After(a.LastSentAt) {
+		return true
+	}
+	return a.LastSentAt.Add(resendDelay).Before(ts)
+}
+
+type alertingRule struct {
+	name         string
+	expr         parser.Expr
+	holdDuration time.Duration
+	labels       labels.Labels
+	annotations  labels.Labels
+
+	mu     sync.Mutex
+	active map[uint64]*Alert
+	// restoreForState is set when the state of the for clause must be
+	// restored from ALERTS_FOR_STATE in the next evaluation.
+	restoreForState bool
+}
+
+func (r *alertingRule) Name() string {
+	return r.name
+}
+
+func (r *alertingRule) Eval(ctx context.Context, ts time.Time, query QueryFunc) (promql.Vector, error) {
+	res, err := query(ctx, r.expr.String(), ts)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	alerts := make(map[uint64]*Alert, len(res))
+	for _, smpl := range res {
+		l := make(map[string]string, len(smpl.Metric))
+		for _, lbl := range smpl.Metric {
+			l[lbl.Name] = lbl.Value
+		}
+		expand := r.expander(ctx, ts, query, template.AlertTemplateData(l, nil, "", smpl.V))
+
+		lb := labels.NewBuilder(smpl.Metric).Del(labels.MetricName)
+		for _, l := range r.labels {
+			lb.Set(l.Name, expand(l.Value))
+		}
+		lb.Set(labels.AlertName, r.name)
+
+		annotations := make(labels.Labels, 0, len(r.annotations))
+		for _, a := range r.annotations {
+			annotations = append(annotations, labels.Label{Name: a.Name, Value: expand(a.Value)})
+		}
+
+		lbs := lb.Labels()
+		h := lbs.Hash()
+		if _, ok := alerts[h]; ok {
+			return nil, fmt.Errorf("vector contains metrics with the same labelset after applying alert labels")
+		}
+		alerts[h] = &Alert{
+			Labels:      lbs,
+			Annotations: annotations,
+			ActiveAt:    ts,
+			State:       StatePending,
+			Value:       smpl.V,
+		}
+	}
+
+	for h, a := range alerts {
+		// Keep the state of alerts which are already active, only the
+		// value and annotations are updated.
+		if alert, ok := r.active[h]; ok && alert.State != StateInactive {
+			alert.Value = a.Value
+			alert.Annotations = a.Annotations
+			continue
+		}
+		r.active[h] = a
+	}
+	if r.restoreForState {
+		r.restoreForState = false
+		if r.holdDuration > 0 {
+			r.restore(ctx, ts, query)
+		}
+	}
+
+	var vec promql.Vector
+	for h, a := range r.active {
+		if _, ok := alerts[h]; !ok {
+			// Firing alerts are kept around for a while, so that they are
+			// reported as resolved to the Alertmanagers.
+			if a.State == StatePending || (!a.ResolvedAt.IsZero() && ts.Sub(a.ResolvedAt) > resolvedRetention) {
+				delete(r.active, h)
+			}
+			if a.State != StateInactive {
+				a.State = StateInactive
+				a.ResolvedAt = ts
+			}
+			continue
+		}
+
+		if a.State == StatePending && ts.Sub(a.ActiveAt) >= r.holdDuration {
+			a.State = StateFiring
+			a.FiredAt = ts
+		}
+		vec = append(vec, alertSample(a, ts), alertForStateSample(a, ts))
+	}
+	return vec, nil
+}
+
+// restore sets the activation time of the pending alerts to the one recorded
+// in ALERTS_FOR_STATE by the previous evaluations, which may have been run by
+// another connector or before a restart.
+func (r *alertingRule) restore(ctx context.Context, ts time.Time, query QueryFunc) {
+	q := fmt.Sprintf("last_over_time(%s{%s=%q}[%s])", alertForStateMetricName, labels.AlertName, r.name, model.Duration(forOutageTolerance))
+	res, err := query(ctx, q, ts)
+	if err != nil {
+		log.Warn("msg", "Restoring the for state of alerting rule failed", "alert", r.name, "err", err)
+		return
+	}
+	for _, s := range res {
+		a, ok := r.active[s.Metric.Hash()]
+		if !ok || a.State != StatePending {
+			continue
+		}
+		if activeAt := time.Unix(int64(s.V), 0); activeAt.Before(a.ActiveAt) {
+			a.ActiveAt = activeAt
+		}
+	}
+}
+
+// expander returns a function expanding the templates of the labels and
+// annotations of an alert.
+func (r *alertingRule) expander(ctx context.Context, ts time.Time, query QueryFunc, data interface{}) func(string) string {
+	// Convenience variables, as in Prometheus.
+	defs := "{{$labels := .Labels}}{{$externalLabels := .ExternalLabels}}{{$externalURL := .ExternalURL}}{{$value := .Value}}"
+	return func(text string) string {
+		tmpl := template.NewTemplateExpander(ctx, defs+text, "__alert_"+r.name, data,
+			model.Time(timestamp.FromTime(ts)), templateQueryFunc(query), nil, nil)
+		result, err := tmpl.Expand()
+		if err != nil {
+			log.Warn("msg", "Expanding alert template failed", "alert", r.name, "err", err)
+			return fmt.Sprintf("<error expanding template: %s>", err)
+		}
+		return result
+	}
+}
+
+// ActiveAlerts returns the pending, firing and recently resolved alerts of the
+// rule.
+func (r *alertingRule) ActiveAlerts() []*Alert {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	alerts := make([]*Alert, 0, len(r.active))
+	for _, a := range r.active {
+		alerts = append(alerts, a)
+	}
+	sort.Slice(alerts, func(i, j int) bool { return labels.Compare(alerts[i].Labels, alerts[j].Labels) < 0 })
+	return alerts
+}
+
+func alertSample(a *Alert, ts time.Time) promql.Sample {
+	lb := labels.NewBuilder(a.Labels).
+		Set(labels.MetricName, alertMetricName).
+		Set(alertStateLabel, a.State.String())
+	return promql.Sample{
+		Metric: lb.Labels(),
+		Point:  promql.Point{T: timestamp.FromTime(ts), V: 1},
+	}
+}
+
+func alertForStateSample(a *Alert, ts time.Time) promql.Sample {
+	lb := labels.NewBuilder(a.Labels).Set(labels.MetricName, alertForStateMetricName)
+	return promql.Sample{
+		Metric: lb.Labels(),
+		Point:  promql.Point{T: timestamp.FromTime(ts), V: float64(a.ActiveAt.Unix())},
+	}
+}
+
+// templateQueryFunc adapts a QueryFunc for the query function of alert
+// templates.
+func templateQueryFunc(query QueryFunc) template.QueryFunc {
+	return func(ctx context.Context, q string, t time.Time) (prompromql.Vector, error) {
+		v, err := query(ctx, q, t)
+		if err != nil {
+			return nil, err
+		}
+		res := make(prompromql.Vector, 0, len(v))
+		for _, s := range v {
+			res = append(res, prompromql.Sample{Point: prompromql.Point{T: s.T, V: s.V}, Metric: s.Metric})
+		}
+		return res, nil
+	}
+}
+
+func labelsFromMap(m map[string]string) labels.Labels {
+	ls := labels.FromMap(m)
+	sort.Sort(ls)
+	return ls
+}
+
+func ruleKind(r Rule) string {
+	if _, ok := r.(*alertingRule); ok {
+		return "alerting"
+	}
+	return "recording"
+}
+
+func groupKey(file, name string) string {
+	return strings.Join([]string{file, name}, ";")
+}
@@ -5,22 +5,36 @@
 package runner
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/peterbourgon/ff/v3"
 	"github.com/peterbourgon/ff/v3/ffyaml"
+	"github.com/prometheus/prometheus/pkg/relabel"
 	"github.com/timescale/promscale/pkg/api"
+	"github.com/timescale/promscale/pkg/downsample"
+	"github.com/timescale/promscale/pkg/graphite"
 	"github.com/timescale/promscale/pkg/limits"
 	"github.com/timescale/promscale/pkg/log"
 	"github.com/timescale/promscale/pkg/pgclient"
+	"github.com/timescale/promscale/pkg/querycache"
+	"github.com/timescale/promscale/pkg/rules"
 	"github.com/timescale/promscale/pkg/tenancy"
 	"github.com/timescale/promscale/pkg/util"
+	"gopkg.in/yaml.v2"
 )
 
+// relabelConfigsKey is the config file key holding the write relabeling
+// rules. Unlike the other keys it is not a flag, since its value is a list
+// of Prometheus relabel_configs.
+const relabelConfigsKey = "relabel_configs"
+
 type Config struct {
 	ListenAddr                  string
 	ThanosStoreAPIListenAddr    string
@@ -28,7 +42,11 @@ type Config struct {
 	PgmodelCfg                  pgclient.Config
 	LogCfg                      log.Config
 	APICfg                      api.Config
+	DownsampleCfg               downsample.Config
+	GraphiteCfg                 graphite.Config
 	LimitsCfg                   limits.Config
+	QueryCacheCfg               querycache.Config
+	RulesCfg                    rules.Config
 	TenancyCfg                  tenancy.Config
 	ConfigFile                  string
 	TLSCertFile                 string
@@ -57,13 +75,17 @@ func ParseFlags(cfg *Config, args []string) (*Config, error) {
 	pgclient.ParseFlags(fs, &cfg.PgmodelCfg)
 	log.ParseFlags(fs, &cfg.LogCfg)
 	api.ParseFlags(fs, &cfg.APICfg)
+	downsample.ParseFlags(fs, &cfg.DownsampleCfg)
+	graphite.ParseFlags(fs, &cfg.GraphiteCfg)
 	limits.ParseFlags(fs, &cfg.LimitsCfg)
+	querycache.ParseFlags(fs, &cfg.QueryCacheCfg)
+	rules.ParseFlags(fs, &cfg.RulesCfg)
 	tenancy.ParseFlags(fs, &cfg.TenancyCfg)
 
 	fs.StringVar(&cfg.ConfigFile, "config", "config.yml", "YAML configuration file path for Promscale.")
 	fs.StringVar(&cfg.ListenAddr, "web-listen-address", ":9201", "Address to listen on for web endpoints.")
 	fs.StringVar(&cfg.ThanosStoreAPIListenAddr, "thanos-store-api-listen-address", "", "Address to listen on for Thanos Store API endpoints.")
-	fs.StringVar(&cfg.OTLPGRPCListenAddr, "otlp-grpc-server-listen-address", "", "Address to listen on for OTLP GRPC server.")
+	fs.StringVar(&cfg.OTLPGRPCListenAddr, "otlp-grpc-server-listen-address", "", "Address to listen on for OTLP GRPC server. The server accepts both OTLP traces and metrics.")
 	fs.StringVar(&corsOriginFlag, "web-cors-origin", ".*", `Regex for CORS origin. It is fully anchored. Example: 'https?://(domain1|domain2)\.com'`)
 	fs.Int64Var(&cfg.HaGroupLockID, "leader-election-pg-advisory-lock-id", 0, "(DEPRECATED) Leader-election based high-availability. It is based on PostgreSQL advisory lock and requires a unique advisory lock ID per high-availability group. Only a single connector in each high-availability group will write data at one time. A value of 0 disables leader election.")
 	fs.DurationVar(&cfg.ThroughputInterval, "tput-report", time.Second, "Duration interval at which throughput should be reported. Setting duration to `0` will disable reporting throughput, otherwise, an interval with unit must be provided, e.g. `10s` or `3m`.")
@@ -91,7 +113,7 @@ func ParseFlags(cfg *Config, args []string) (*Config, error) {
 
 	if err := ff.Parse(fs, args,
 		ff.WithConfigFileFlag("config"),
-		ff.WithConfigFileParser(ffyaml.Parser),
+		ff.WithConfigFileParser(configFileParser(cfg)),
 		ff.WithAllowMissingConfigFile(true),
 	); err != nil {
 		return nil, fmt.Errorf("configuration error: %w", err)
@@ -107,6 +129,7 @@ func ParseFlags(cfg *Config, args []string) (*Config, error) {
 		return nil, fmt.Errorf("could not compile CORS regex string %v: %w", corsOriginFlag, err)
 	}
 	cfg.APICfg.AllowedOrigin = corsOriginRegex
+	cfg.PgmodelCfg.AsyncAcks = cfg.AsyncAcks
 
 	if err := validate(cfg); err != nil {
 		return nil, fmt.Errorf("validate config: %w", err)
@@ -136,6 +159,15 @@ func ParseFlags(cfg *Config, args []string) (*Config, error) {
 		if flagset["install-extensions"] && cfg.InstallExtensions {
 			return nil, fmt.Errorf("Cannot install or update TimescaleDB extension in read-only mode")
 		}
+		if cfg.GraphiteCfg.Enabled() {
+			return nil, fmt.Errorf("Graphite listeners are not supported in read-only mode")
+		}
+		if cfg.RulesCfg.Enabled() {
+			return nil, fmt.Errorf("Rule evaluation is not supported in read-only mode")
+		}
+		if cfg.DownsampleCfg.Enabled() {
+			return nil, fmt.Errorf("Downsampling configuration is not supported in read-only mode")
+		}
 		cfg.Migrate = false
 		cfg.StopAfterMigrate = false
 		cfg.UseVersionLease = false
@@ -154,9 +186,21 @@ func validate(cfg *Config) error {
 	if err := api.Validate(&cfg.APICfg); err != nil {
 		return fmt.Errorf("error validating API configuration: %w", err)
 	}
+	if err := graphite.Validate(&cfg.GraphiteCfg); err != nil {
+		return fmt.Errorf("error validating Graphite configuration: %w", err)
+	}
+	if err := rules.Validate(&cfg.RulesCfg); err != nil {
+		return fmt.Errorf("error validating rules configuration: %w", err)
+	}
 	if err := limits.Validate(&cfg.LimitsCfg); err != nil {
 		return fmt.Errorf("error validating limits configuration: %w", err)
 	}
+	if err := querycache.Validate(&cfg.QueryCacheCfg); err != nil {
+		return fmt.Errorf("error validating query cache configuration: %w", err)
+	}
+	if err := downsample.Validate(&cfg.DownsampleCfg); err != nil {
+		return fmt.Errorf("error validating downsampling configuration: %w", err)
+	}
 	if err := pgclient.Validate(&cfg.PgmodelCfg, cfg.LimitsCfg); err != nil {
 		return fmt.Errorf("error validating client configuration: %w", err)
 	}
@@ -165,3 +209,35 @@ func validate(cfg *Config) error {
 	}
 	return nil
 }
+
+// configFileParser parses the YAML config file, setting the write relabeling
+// rules directly on the config. All the other keys are handled as flags.
+func configFileParser(cfg *Config) ff.ConfigFileParser {
+	return func(r io.Reader, set func(name, value string) error) error {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		var m map[string]interface{}
+		if err = yaml.Unmarshal(data, &m); err != nil {
+			return ffyaml.ParseError{Inner: err}
+		}
+		if v, ok := m[relabelConfigsKey]; ok {
+			raw, err := yaml.Marshal(v)
+			if err != nil {
+				return ffyaml.ParseError{Inner: err}
+			}
+			var relabelConfigs []*relabel.Config
+			if err = yaml.UnmarshalStrict(raw, &relabelConfigs); err != nil {
+				return fmt.Errorf("invalid %s: %w", relabelConfigsKey, err)
+			}
+			cfg.APICfg.WriteRelabelConfigs = relabelConfigs
+
+			delete(m, relabelConfigsKey)
+			if data, err = yaml.Marshal(m); err != nil {
+				return ffyaml.ParseError{Inner: err}
+			}
+		}
+		return ffyaml.Parser(bytes.NewReader(data), set)
+	}
+}
@@ -9,6 +9,9 @@ import (
 	"os"
 	"reflect"
 	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/relabel"
 )
 
 func TestParseFlags(t *testing.T) {
@@ -117,6 +120,65 @@ func TestParseFlags(t *testing.T) {
 			},
 			shouldError: true,
 		},
+		{
+			name: "Graphite listener with templates",
+			args: []string{
+				"-graphite-listen-address", ":2003",
+				"-graphite-template", "servers.* .host.measurement*",
+				"-graphite-template", "measurement* env=prod",
+			},
+			result: func(c Config) Config {
+				c.GraphiteCfg.ListenAddr = ":2003"
+				c.GraphiteCfg.Templates = []string{"servers.* .host.measurement*", "measurement* env=prod"}
+				return c
+			},
+		},
+		{
+			name:        "Invalid Graphite template",
+			args:        []string{"-graphite-template", "host.service"},
+			shouldError: true,
+		},
+		{
+			name: "Running Graphite listener and read-only error",
+			args: []string{
+				"-graphite-listen-address", ":2003",
+				"-read-only",
+			},
+			shouldError: true,
+		},
+		{
+			name:        "Missing rule file",
+			args:        []string{"-rules-file", "does-not-exist.yml"},
+			shouldError: true,
+		},
+		{
+			name:        "Invalid Alertmanager URL",
+			args:        []string{"-rules-alertmanager-url", "alertmanager:9093"},
+			shouldError: true,
+		},
+		{
+			name:        "Invalid query cache backend",
+			args:        []string{"-query-cache-backend", "redis"},
+			shouldError: true,
+		},
+		{
+			name:        "Query cache disk backend without directory",
+			args:        []string{"-query-cache-backend", "disk"},
+			shouldError: true,
+		},
+		{
+			name:        "Invalid downsampling resolution",
+			args:        []string{"-downsample", "5m"},
+			shouldError: true,
+		},
+		{
+			name: "Downsampling and read-only error",
+			args: []string{
+				"-downsample", "5m:90d",
+				"-read-only",
+			},
+			shouldError: true,
+		},
 		{
 			name: "invalid TLS setup, missing key file",
 			args: []string{
@@ -209,6 +271,37 @@ func TestParseFlagsConfigPrecedence(t *testing.T) {
 				return c
 			},
 		},
+		{
+			name: "Config file with relabel configs",
+			configFileContents: `
+web-listen-address: localhost:9201
+relabel_configs:
+  - source_labels: [__name__]
+    regex: noisy_.*
+    action: drop
+  - regex: pod_uid
+    action: labeldrop
+`,
+			result: func(c Config) Config {
+				c.ListenAddr = "localhost:9201"
+				c.APICfg.WriteRelabelConfigs = []*relabel.Config{
+					{
+						SourceLabels: model.LabelNames{"__name__"},
+						Separator:    ";",
+						Regex:        relabel.MustNewRegexp("noisy_.*"),
+						Replacement:  "$1",
+						Action:       relabel.Drop,
+					},
+					{
+						Separator:   ";",
+						Regex:       relabel.MustNewRegexp("pod_uid"),
+						Replacement: "$1",
+						Action:      relabel.LabelDrop,
+					},
+				}
+				return c
+			},
+		},
 		{
 			name: "Env variable only, TS_PROM prefix",
 			env: map[string]string{
@@ -318,3 +411,22 @@ func TestParseFlagsConfigPrecedence(t *testing.T) {
 		})
 	}
 }
+
+func TestParseFlagsInvalidRelabelConfigs(t *testing.T) {
+	os.Clearenv()
+	f, err := ioutil.TempFile("", "promscale.yml")
+	if err != nil {
+		t.Fatalf("unexpected error when creating config file: %s", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write([]byte("relabel_configs:\n  - action: unknown\n")); err != nil {
+		t.Fatalf("unexpected error while writing configuration file: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("unexpected error while closing configuration file: %s", err)
+	}
+
+	if _, err := ParseFlags(&Config{}, []string{"-config=" + f.Name()}); err == nil {
+		t.Fatal("Unexpected error result, should not be nil")
+	}
+}
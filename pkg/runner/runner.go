@@ -17,8 +17,15 @@ import (
 	"github.com/jaegertracing/jaeger/plugin/storage/grpc/shared"
 	"github.com/thanos-io/thanos/pkg/store/storepb"
 	"github.com/timescale/promscale/pkg/api"
+	"github.com/timescale/promscale/pkg/downsample"
+	"github.com/timescale/promscale/pkg/graphite"
 	"github.com/timescale/promscale/pkg/jaeger/query"
+	"github.com/timescale/promscale/pkg/limits"
 	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgclient"
+	promQuery "github.com/timescale/promscale/pkg/query"
+	"github.com/timescale/promscale/pkg/querycache"
+	"github.com/timescale/promscale/pkg/rules"
 	"github.com/timescale/promscale/pkg/thanos"
 	"github.com/timescale/promscale/pkg/util"
 	tput "github.com/timescale/promscale/pkg/util/throughput"
@@ -78,7 +85,22 @@ func Run(cfg *Config) error {
 
 	defer client.Close()
 
-	router, err := api.GenerateRouter(&cfg.APICfg, client, elector)
+	if cfg.DownsampleCfg.Enabled() {
+		if err = downsample.Apply(client.Connection, &cfg.DownsampleCfg); err != nil {
+			log.Error("msg", "aborting startup due to error", "err", fmt.Sprintf("apply downsampling configuration: %s", err.Error()))
+			return fmt.Errorf("apply downsampling configuration: %w", err)
+		}
+	}
+
+	cfg.APICfg.IngestLimiter = limits.NewIngestLimiter(cfg.LimitsCfg)
+	if cfg.QueryCacheCfg.Enabled() {
+		if cfg.APICfg.QueryCache, err = querycache.New(&cfg.QueryCacheCfg, client.QuerierConnection); err != nil {
+			log.Error("msg", "aborting startup due to error", "err", fmt.Sprintf("create query cache: %s", err.Error()))
+			return fmt.Errorf("create query cache: %w", err)
+		}
+	}
+	dataParser := api.NewWriteParser(&cfg.APICfg, client)
+	router, err := api.GenerateRouter(&cfg.APICfg, client, dataParser, elector)
 	if err != nil {
 		log.Error("msg", "aborting startup due to error", "err", fmt.Sprintf("generate router: %s", err.Error()))
 		return fmt.Errorf("generate router: %w", err)
@@ -132,6 +154,9 @@ func Run(cfg *Config) error {
 		}
 		grpcServer := grpc.NewServer(options...)
 		otlpgrpc.RegisterTracesServer(grpcServer, api.NewTraceServer(client))
+		if !cfg.APICfg.ReadOnly {
+			otlpgrpc.RegisterMetricsServer(grpcServer, api.NewMetricsServer(client))
+		}
 
 		queryPlugin := shared.StorageGRPCPlugin{
 			Impl: query.New(client.QuerierConnection),
@@ -158,6 +183,45 @@ func Run(cfg *Config) error {
 		}()
 	}
 
+	if cfg.GraphiteCfg.Enabled() {
+		graphiteServer, err := graphite.NewServer(&cfg.GraphiteCfg, client, dataParser)
+		if err != nil {
+			log.Error("msg", "Creating Graphite server failed", "err", err)
+			return err
+		}
+		// The connections are closed, and the metrics read from them are
+		// ingested, before the client is closed.
+		defer graphiteServer.Close()
+		serveGraphite := func(protocol, addr string, serve func(net.Listener) error) {
+			log.Info("msg", fmt.Sprintf("Start listening for Graphite %s protocol on %s", protocol, addr))
+			listener, err := net.Listen("tcp", addr)
+			if err != nil {
+				log.Error("msg", "Listening for Graphite metrics failed", "protocol", protocol, "err", err)
+				return
+			}
+			if err := serve(listener); err != nil {
+				log.Error("msg", "Serving the Graphite listener failed", "protocol", protocol, "err", err)
+			}
+		}
+		if len(cfg.GraphiteCfg.ListenAddr) > 0 {
+			go serveGraphite("plaintext", cfg.GraphiteCfg.ListenAddr, graphiteServer.ServePlaintext)
+		}
+		if len(cfg.GraphiteCfg.PickleListenAddr) > 0 {
+			go serveGraphite("pickle", cfg.GraphiteCfg.PickleListenAddr, graphiteServer.ServePickle)
+		}
+	}
+
+	if cfg.RulesCfg.Enabled() {
+		ctx, cancel := context.WithCancel(context.Background())
+		// The rule groups stop being evaluated, and their leases are
+		// released, when the connector stops serving.
+		defer cancel()
+		if err := startRules(ctx, cfg, client); err != nil {
+			log.Error("msg", "Starting the rule evaluation failed", "err", err)
+			return err
+		}
+	}
+
 	mux := http.NewServeMux()
 	mux.Handle("/", router)
 
@@ -174,3 +238,43 @@ func Run(cfg *Config) error {
 
 	return nil
 }
+
+// startRules starts evaluating the configured rule groups in the background,
+// until the context is canceled. When group leases are used, each group is
+// evaluated by the connector holding the advisory lock of the group. The locks
+// of all the groups are held on a single database session.
+func startRules(ctx context.Context, cfg *Config, client *pgclient.Client) error {
+	groups, err := rules.LoadGroups(cfg.RulesCfg.RuleFiles, cfg.RulesCfg.EvaluationInterval)
+	if err != nil {
+		return err
+	}
+	engine, err := promQuery.NewEngine(log.GetLogger(), cfg.APICfg.MaxQueryTimeout, cfg.APICfg.LookBackDelta, cfg.APICfg.SubQueryStepInterval, cfg.APICfg.MaxSamples, cfg.APICfg.EnabledFeaturesList)
+	if err != nil {
+		return fmt.Errorf("creating query engine for rules: %w", err)
+	}
+
+	opts := &rules.ManagerOptions{
+		QueryFunc:   rules.EngineQueryFunc(engine, client.Queryable()),
+		Ingestor:    client,
+		ResendDelay: cfg.RulesCfg.ResendDelay,
+	}
+	if len(cfg.RulesCfg.AlertmanagerURLs) > 0 {
+		opts.Notifier = rules.NewAlertmanagerNotifier(cfg.RulesCfg.AlertmanagerURLs, cfg.RulesCfg.AlertmanagerTimeout)
+	}
+	var leases *util.PgLeaderLockSession
+	if cfg.RulesCfg.UseGroupLeases {
+		leases = util.NewPgLeaderLockSession(cfg.PgmodelCfg.GetConnectionStr(), getSchemaLease)
+		opts.NewElection = func(groupKey string) (util.Election, error) {
+			return leases.NewLeaderLock(rules.GroupLockID(groupKey)), nil
+		}
+	}
+
+	log.Info("msg", "Starting rule evaluation", "groups", len(groups))
+	go func() {
+		rules.NewManager(groups, opts).Run(ctx)
+		if leases != nil {
+			leases.Close()
+		}
+	}()
+	return nil
+}
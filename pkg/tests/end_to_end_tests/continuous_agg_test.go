@@ -512,3 +512,91 @@ WITH (timescaledb.continuous) AS
 		require.Equal(t, 0, int(cnt), "Expected for cagg to have no chunks, all outside of data retention period")
 	})
 }
+
+func TestMetricViewResolutionQueryRewrite(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	if !*useTimescaleDB {
+		t.Skip("downsampled views need TimescaleDB support")
+	}
+	if *useMultinode {
+		t.Skip("metric views not supported in multinode TimescaleDB setup")
+	}
+
+	withDB(t, *testDatabase, func(db *pgxpool.Pool, t testing.TB) {
+		ingestQueryTestDataset(db, t, generateLargeTimeseries())
+
+		_, err := db.Exec(context.Background(), "CALL _prom_catalog.finalize_metric_creation()")
+		require.NoError(t, err)
+		_, err = db.Exec(context.Background(), "CREATE SCHEMA downsampled")
+		require.NoError(t, err)
+		_, err = db.Exec(context.Background(),
+			`CREATE VIEW downsampled.metric_2_1h(time, series_id, value, max) AS
+  SELECT time_bucket('1hour', time), series_id, avg(value), max(value)
+    FROM prom_data.metric_2
+    GROUP BY time_bucket('1hour', time), series_id`)
+		require.NoError(t, err)
+
+		_, err = db.Exec(context.Background(), "SELECT prom_api.set_metric_view_resolution('downsampled', 'metric_2_1h', '1 hour', 'max')")
+		require.Error(t, err, "the view must be registered first")
+		_, err = db.Exec(context.Background(), "SELECT prom_api.register_metric_view('downsampled', 'metric_2_1h')")
+		require.NoError(t, err)
+		_, err = db.Exec(context.Background(), "SELECT prom_api.set_metric_view_resolution('downsampled', 'metric_2_1h', '1 hour', 'max', 'nonexistant')")
+		require.Error(t, err)
+		_, err = db.Exec(context.Background(), "SELECT prom_api.set_metric_view_resolution('downsampled', 'metric_2_1h', '1 hour', 'avg', 'value')")
+		require.Error(t, err, "avg is not a supported aggregate")
+		_, err = db.Exec(context.Background(), "SELECT prom_api.set_metric_view_resolution('downsampled', 'metric_2_1h', '1 hour', 'max')")
+		require.NoError(t, err)
+
+		readOnly := testhelpers.GetReadOnlyConnection(t, *testDatabase)
+		defer readOnly.Close()
+		newQueryable := func() promql.Queryable {
+			mCache := &cache.MetricNameCache{Metrics: clockcache.WithMax(cache.DefaultMetricCacheSize)}
+			dbConn := pgxconn.NewPgxConn(readOnly)
+			labelsReader := lreader.NewLabelsReader(dbConn, clockcache.WithMax(100))
+			return query.NewQueryable(querier.NewQuerier(dbConn, mCache, labelsReader, nil, nil), labelsReader)
+		}
+		queryEngine, err := query.NewEngine(log.GetLogger(), time.Minute, time.Minute*5, time.Minute, 50000000, []string{})
+		require.NoError(t, err)
+		rangeQuery := func(queryable promql.Queryable, qs string, step time.Duration) promql.Matrix {
+			qry, err := queryEngine.NewRangeQuery(queryable, qs, model.Time(startTime).Time(), model.Time(endTime).Time(), step)
+			require.NoError(t, err)
+			res := qry.Exec(context.Background())
+			require.NoError(t, res.Err)
+			m, err := res.Matrix()
+			require.NoError(t, err)
+			return m
+		}
+		points := func(m promql.Matrix) [][]promql.Point {
+			p := make([][]promql.Point, 0, len(m))
+			for _, s := range m {
+				p = append(p, s.Points)
+			}
+			return p
+		}
+
+		queryable := newQueryable()
+
+		// A coarse step is served by the view, with the labels of the raw metric.
+		rewritten := rangeQuery(queryable, `max_over_time(metric_2{instance="1"}[2h])`, time.Hour)
+		fromView := rangeQuery(queryable, `max_over_time(metric_2_1h{__schema__="downsampled", __column__="max", instance="1"}[2h])`, time.Hour)
+		require.NotEmpty(t, rewritten)
+		require.Equal(t, points(fromView), points(rewritten))
+		require.Equal(t, labels.FromStrings("foo", "bat", "instance", "1"), rewritten[0].Metric)
+
+		// Functions not matching the aggregate of the column are served by the raw metric.
+		raw := rangeQuery(queryable, `rate(metric_2{__schema__="prom_data", instance="1"}[2h])`, time.Hour)
+		require.Equal(t, raw, rangeQuery(queryable, `rate(metric_2{instance="1"}[2h])`, time.Hour))
+
+		// A fine step is served by the raw metric.
+		raw = rangeQuery(queryable, `max_over_time(metric_2{__schema__="prom_data", instance="1"}[2h])`, 30*time.Minute)
+		require.Equal(t, raw, rangeQuery(queryable, `max_over_time(metric_2{instance="1"}[2h])`, 30*time.Minute))
+
+		_, err = db.Exec(context.Background(), "SELECT prom_api.reset_metric_view_resolution('downsampled', 'metric_2_1h')")
+		require.NoError(t, err)
+		queryable = newQueryable()
+		raw = rangeQuery(queryable, `max_over_time(metric_2{__schema__="prom_data", instance="1"}[2h])`, time.Hour)
+		require.Equal(t, raw, rangeQuery(queryable, `max_over_time(metric_2{instance="1"}[2h])`, time.Hour))
+	})
+}
@@ -0,0 +1,155 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package end_to_end_tests
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/clockcache"
+	"github.com/timescale/promscale/pkg/internal/testhelpers"
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgmodel/cache"
+	ingstr "github.com/timescale/promscale/pkg/pgmodel/ingestor"
+	"github.com/timescale/promscale/pkg/pgmodel/lreader"
+	"github.com/timescale/promscale/pkg/pgmodel/querier"
+	"github.com/timescale/promscale/pkg/pgxconn"
+	"github.com/timescale/promscale/pkg/prompb"
+	"github.com/timescale/promscale/pkg/query"
+)
+
+func TestDownsampling(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	withDB(t, *testDatabase, func(db *pgxpool.Pool, t testing.TB) {
+		start := time.Now().Truncate(time.Hour).Add(-3 * time.Hour)
+		samples := []prompb.Sample{
+			// Stale markers are not aggregated.
+			{Timestamp: int64(model.TimeFromUnixNano(start.Add(30 * time.Second).UnixNano())), Value: math.Float64frombits(value.StaleNaN)},
+		}
+		for i := 0; i < 120; i++ {
+			samples = append(samples, prompb.Sample{Timestamp: int64(model.TimeFromUnixNano(start.Add(time.Duration(i) * time.Minute).UnixNano())), Value: float64(i)})
+		}
+		ts := []prompb.TimeSeries{
+			{
+				Labels:  []prompb.Label{{Name: model.MetricNameLabel, Value: "ds_test"}, {Name: "job", Value: "a"}},
+				Samples: samples,
+			},
+			{
+				Labels:  []prompb.Label{{Name: model.MetricNameLabel, Value: "ds_other"}, {Name: "job", Value: "a"}},
+				Samples: samples[1:],
+			},
+		}
+		ingestor, err := ingstr.NewPgxIngestorForTests(pgxconn.NewPgxConn(db), nil)
+		require.NoError(t, err)
+		defer ingestor.Close()
+		_, _, err = ingestor.Ingest(context.Background(), newWriteRequestWithTs(copyMetrics(ts)))
+		require.NoError(t, err)
+
+		_, err = db.Exec(context.Background(), "SELECT prom_api.set_downsampling('1 hour', '1 hour')")
+		require.Error(t, err, "the retention period must be longer than the resolution")
+		_, err = db.Exec(context.Background(), "SELECT prom_api.set_downsampling('1 month', '1 year')")
+		require.Error(t, err, "the resolution cannot be expressed in months")
+		_, err = db.Exec(context.Background(), "SELECT prom_api.set_downsampling('1 hour', '7 days')")
+		require.NoError(t, err)
+		_, err = db.Exec(context.Background(), "SELECT prom_api.set_metric_downsampling('ds_other', '5 minutes')")
+		require.Error(t, err, "a new resolution needs a retention period")
+		_, err = db.Exec(context.Background(), "SELECT prom_api.set_metric_downsampling('ds_other', '5 minutes', '1 day')")
+		require.NoError(t, err)
+		_, err = db.Exec(context.Background(), "SELECT prom_api.disable_metric_downsampling('ds_other', '1 hour')")
+		require.NoError(t, err)
+
+		dbJob := testhelpers.PgxPoolWithRole(t, *testDatabase, "prom_maintenance")
+		defer dbJob.Close()
+		// Running the maintenance twice does not change the downsampled data.
+		for i := 0; i < 2; i++ {
+			_, err = dbJob.Exec(context.Background(), "CALL prom_api.execute_maintenance(log_verbose=>true)")
+			require.NoError(t, err)
+		}
+
+		downsampled := func(metric string) []string {
+			rows, err := db.Query(context.Background(), `SELECT m.table_schema
+				FROM _prom_catalog.metric m
+				WHERE m.metric_name = $1 AND m.table_schema LIKE 'ds\_%'
+				ORDER BY m.table_schema`, metric)
+			require.NoError(t, err)
+			defer rows.Close()
+			schemas := []string{}
+			for rows.Next() {
+				var s string
+				require.NoError(t, rows.Scan(&s))
+				schemas = append(schemas, s)
+			}
+			require.NoError(t, rows.Err())
+			return schemas
+		}
+		require.Equal(t, []string{"ds_1h"}, downsampled("ds_test"))
+		require.Equal(t, []string{"ds_5m"}, downsampled("ds_other"))
+
+		type bucket struct {
+			time                       time.Time
+			min, max, sum, count, last float64
+		}
+		rows, err := db.Query(context.Background(), `SELECT time, min, max, sum, count, last FROM ds_1h.ds_test ORDER BY time`)
+		require.NoError(t, err)
+		buckets := []bucket{}
+		for rows.Next() {
+			var b bucket
+			require.NoError(t, rows.Scan(&b.time, &b.min, &b.max, &b.sum, &b.count, &b.last))
+			b.time = b.time.UTC()
+			buckets = append(buckets, b)
+		}
+		rows.Close()
+		require.NoError(t, rows.Err())
+		require.Equal(t, []bucket{
+			{time: start.Add(time.Hour).UTC(), min: 0, max: 59, sum: 1770, count: 60, last: 59},
+			{time: start.Add(2 * time.Hour).UTC(), min: 60, max: 119, sum: 5370, count: 60, last: 119},
+		}, buckets)
+
+		var retentionS float64
+		err = db.QueryRow(context.Background(), "SELECT EXTRACT(epoch FROM _prom_catalog.get_metric_retention_period('ds_1h', 'ds_test'))").Scan(&retentionS)
+		require.NoError(t, err)
+		require.Equal(t, (7 * 24 * time.Hour).Seconds(), retentionS)
+		err = db.QueryRow(context.Background(), "SELECT EXTRACT(epoch FROM _prom_catalog.get_metric_retention_period('ds_5m', 'ds_other'))").Scan(&retentionS)
+		require.NoError(t, err)
+		require.Equal(t, (24 * time.Hour).Seconds(), retentionS)
+
+		// The downsampled data is queried with the schema and column labels.
+		readOnly := testhelpers.GetReadOnlyConnection(t, *testDatabase)
+		defer readOnly.Close()
+		mCache := &cache.MetricNameCache{Metrics: clockcache.WithMax(cache.DefaultMetricCacheSize)}
+		dbConn := pgxconn.NewPgxConn(readOnly)
+		labelsReader := lreader.NewLabelsReader(dbConn, clockcache.WithMax(100))
+		queryable := query.NewQueryable(querier.NewQuerier(dbConn, mCache, labelsReader, nil, nil), labelsReader)
+		queryEngine, err := query.NewEngine(log.GetLogger(), time.Minute, time.Minute*5, time.Minute, 50000000, []string{})
+		require.NoError(t, err)
+		qry, err := queryEngine.NewInstantQuery(queryable, `ds_test{__schema__="ds_1h", __column__="max"}`, start.Add(2*time.Hour))
+		require.NoError(t, err)
+		res := qry.Exec(context.Background())
+		require.NoError(t, res.Err)
+		vector, err := res.Vector()
+		require.NoError(t, err)
+		require.Len(t, vector, 1)
+		require.Equal(t, labels.FromStrings(model.MetricNameLabel, "ds_test", "__schema__", "ds_1h", "__column__", "max", "job", "a"), vector[0].Metric)
+		require.Equal(t, float64(119), vector[0].V)
+
+		_, err = db.Exec(context.Background(), "SELECT prom_api.remove_downsampling('5 minutes')")
+		require.NoError(t, err)
+		require.Empty(t, downsampled("ds_other"))
+		var exists bool
+		err = db.QueryRow(context.Background(), "SELECT EXISTS (SELECT FROM pg_namespace WHERE nspname = 'ds_5m')").Scan(&exists)
+		require.NoError(t, err)
+		require.False(t, exists)
+	})
+}
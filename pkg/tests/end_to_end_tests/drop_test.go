@@ -58,7 +58,7 @@ func TestSQLRetentionPeriod(t *testing.T) {
 			t.Fatal(err)
 		}
 		defer ingestor.Close()
-		_, _, err = ingestor.Ingest(newWriteRequestWithTs(copyMetrics(ts)))
+		_, _, err = ingestor.Ingest(context.Background(), newWriteRequestWithTs(copyMetrics(ts)))
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -168,7 +168,7 @@ func TestSQLDropChunk(t *testing.T) {
 			t.Fatal(err)
 		}
 		defer ingestor.Close()
-		_, _, err = ingestor.Ingest(newWriteRequestWithTs(copyMetrics(ts)))
+		_, _, err = ingestor.Ingest(context.Background(), newWriteRequestWithTs(copyMetrics(ts)))
 		if err != nil {
 			t.Error(err)
 		}
@@ -257,7 +257,7 @@ func TestSQLDropChunkWithLocked(t *testing.T) {
 		ingestor, err := ingstr.NewPgxIngestorForTests(pgxconn.NewPgxConn(db), nil)
 		require.NoError(t, err)
 		defer ingestor.Close()
-		_, _, err = ingestor.Ingest(newWriteRequestWithTs(copyMetrics(ts)))
+		_, _, err = ingestor.Ingest(context.Background(), newWriteRequestWithTs(copyMetrics(ts)))
 		require.NoError(t, err)
 
 		var tableName string
@@ -360,7 +360,7 @@ func TestSQLDropDataWithoutTimescaleDB(t *testing.T) {
 			t.Fatal(err)
 		}
 		defer ingestor.Close()
-		_, _, err = ingestor.Ingest(newWriteRequestWithTs(copyMetrics(ts)))
+		_, _, err = ingestor.Ingest(context.Background(), newWriteRequestWithTs(copyMetrics(ts)))
 		if err != nil {
 			t.Error(err)
 		}
@@ -471,7 +471,7 @@ func TestSQLDropMetricChunk(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		_, _, err = ingestor.Ingest(newWriteRequestWithTs(copyMetrics(ts)))
+		_, _, err = ingestor.Ingest(context.Background(), newWriteRequestWithTs(copyMetrics(ts)))
 		if err != nil {
 			t.Error(err)
 		}
@@ -657,7 +657,7 @@ func TestSQLDropMetricChunk(t *testing.T) {
 			},
 		}
 
-		_, _, err = ingestor.Ingest(newWriteRequestWithTs(copyMetrics(resurrected)))
+		_, _, err = ingestor.Ingest(context.Background(), newWriteRequestWithTs(copyMetrics(resurrected)))
 		if err == nil {
 			t.Error("expected ingest to fail due to old epoch")
 		}
@@ -671,7 +671,7 @@ func TestSQLDropMetricChunk(t *testing.T) {
 		}
 		defer ingestor2.Close()
 
-		_, _, err = ingestor2.Ingest(newWriteRequestWithTs(copyMetrics(resurrected)))
+		_, _, err = ingestor2.Ingest(context.Background(), newWriteRequestWithTs(copyMetrics(resurrected)))
 		if err != nil {
 			t.Error(err)
 		}
@@ -725,7 +725,7 @@ func TestSQLDropAllMetricData(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		_, _, err = ingestor.Ingest(newWriteRequestWithTs(copyMetrics(ts)))
+		_, _, err = ingestor.Ingest(context.Background(), newWriteRequestWithTs(copyMetrics(ts)))
 		if err != nil {
 			t.Error(err)
 		}
@@ -799,7 +799,7 @@ func TestSQLDropAllMetricData(t *testing.T) {
 		}
 
 		defer ingestor2.Close()
-		_, _, err = ingestor2.Ingest(newWriteRequestWithTs(copyMetrics(ts)))
+		_, _, err = ingestor2.Ingest(context.Background(), newWriteRequestWithTs(copyMetrics(ts)))
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -0,0 +1,93 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package end_to_end_tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stretchr/testify/require"
+	ingstr "github.com/timescale/promscale/pkg/pgmodel/ingestor"
+	"github.com/timescale/promscale/pkg/pgmodel/model"
+	"github.com/timescale/promscale/pkg/pgxconn"
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+func TestDuplicatePolicy(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	withDB(t, *testDatabase, func(db *pgxpool.Pool, t testing.TB) {
+		ingestor, err := ingstr.NewPgxIngestorForTests(pgxconn.NewPgxConn(db), nil)
+		require.NoError(t, err)
+		defer ingestor.Close()
+
+		ingest := func(samples ...prompb.Sample) {
+			ts := []prompb.TimeSeries{
+				{
+					Labels: []prompb.Label{
+						{Name: model.MetricNameLabelName, Value: "dup_metric"},
+						{Name: "job", Value: "test"},
+					},
+					Samples: samples,
+				},
+			}
+			_, _, err := ingestor.Ingest(context.Background(), newWriteRequestWithTs(copyMetrics(ts)))
+			require.NoError(t, err)
+		}
+		setPolicy := func(sql string, args ...interface{}) {
+			_, err := db.Exec(context.Background(), sql, args...)
+			require.NoError(t, err)
+		}
+		values := func() map[int64]float64 {
+			rows, err := db.Query(context.Background(), "SELECT (extract(epoch FROM time) * 1000)::BIGINT, value FROM prom_data.dup_metric")
+			require.NoError(t, err)
+			defer rows.Close()
+			res := make(map[int64]float64)
+			for rows.Next() {
+				var (
+					ts int64
+					v  float64
+				)
+				require.NoError(t, rows.Scan(&ts, &v))
+				res[ts] = v
+			}
+			require.NoError(t, rows.Err())
+			return res
+		}
+
+		var policy string
+		err = db.QueryRow(context.Background(), "SELECT _prom_catalog.get_metric_duplicate_policy('dup_metric')").Scan(&policy)
+		require.NoError(t, err)
+		require.Equal(t, "keep_first", policy)
+
+		ingest(prompb.Sample{Timestamp: 10, Value: 1}, prompb.Sample{Timestamp: 20, Value: 1})
+
+		setPolicy("SELECT prom_api.set_metric_duplicate_policy('dup_metric', 'keep_last')")
+		ingest(prompb.Sample{Timestamp: 10, Value: 2})
+		require.Equal(t, map[int64]float64{10: 2, 20: 1}, values())
+
+		setPolicy("SELECT prom_api.set_metric_duplicate_policy('dup_metric', 'reject')")
+		ingest(prompb.Sample{Timestamp: 5, Value: 3}, prompb.Sample{Timestamp: 20, Value: 3}, prompb.Sample{Timestamp: 30, Value: 3})
+		require.Equal(t, map[int64]float64{10: 2, 20: 1, 30: 3}, values())
+		// Out of order and duplicate samples within a batch are rejected too.
+		ingest(prompb.Sample{Timestamp: 40, Value: 6}, prompb.Sample{Timestamp: 35, Value: 6}, prompb.Sample{Timestamp: 40, Value: 7}, prompb.Sample{Timestamp: 50, Value: 6})
+		require.Equal(t, map[int64]float64{10: 2, 20: 1, 30: 3, 40: 6, 50: 6}, values())
+
+		setPolicy("SELECT prom_api.reset_metric_duplicate_policy('dup_metric')")
+		ingest(prompb.Sample{Timestamp: 1, Value: 4}, prompb.Sample{Timestamp: 20, Value: 4})
+		require.Equal(t, map[int64]float64{1: 4, 10: 2, 20: 1, 30: 3, 40: 6, 50: 6}, values())
+
+		setPolicy("SELECT prom_api.set_default_duplicate_policy('keep_last')")
+		ingest(prompb.Sample{Timestamp: 1, Value: 5})
+		require.Equal(t, map[int64]float64{1: 5, 10: 2, 20: 1, 30: 3, 40: 6, 50: 6}, values())
+
+		_, err = db.Exec(context.Background(), "SELECT prom_api.set_metric_duplicate_policy('dup_metric', 'overwrite')")
+		require.Error(t, err)
+		_, err = db.Exec(context.Background(), "SELECT prom_api.set_default_duplicate_policy('overwrite')")
+		require.Error(t, err)
+	})
+}
@@ -8,8 +8,10 @@ import (
 	"time"
 
 	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/jaegertracing/jaeger/model"
 	"github.com/jaegertracing/jaeger/storage/spanstore"
 	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/internal/testhelpers"
 	"github.com/timescale/promscale/pkg/jaeger/query"
 	ingstr "github.com/timescale/promscale/pkg/pgmodel/ingestor"
 	"github.com/timescale/promscale/pkg/pgxconn"
@@ -394,3 +396,71 @@ func findTraceTest(t testing.TB, q *query.Query) {
 	require.NoError(t, err)
 	require.Equal(t, 1, len(traces))
 }
+
+func TestQueryDependencies(t *testing.T) {
+	withDB(t, *testDatabase, func(db *pgxpool.Pool, t testing.TB) {
+		ingestor, err := ingstr.NewPgxIngestorForTests(pgxconn.NewPgxConn(db), nil)
+		require.NoError(t, err)
+		defer ingestor.Close()
+
+		start := time.Now().Truncate(time.Hour).Add(-3 * time.Hour)
+		td := pdata.NewTraces()
+		parentRS := td.ResourceSpans().AppendEmpty()
+		initResourceAttributes(parentRS.Resource().Attributes(), 0)
+		parentSpans := parentRS.InstrumentationLibrarySpans().AppendEmpty()
+		initInstLib(parentSpans, 0)
+		childRS := td.ResourceSpans().AppendEmpty()
+		initResourceAttributes(childRS.Resource().Attributes(), 1)
+		childSpans := childRS.InstrumentationLibrarySpans().AppendEmpty()
+		initInstLib(childSpans, 0)
+		// One call from service-name-0 to service-name-1 every 30 minutes,
+		// the calls within service-name-0 are not dependencies.
+		for i := 0; i < 4; i++ {
+			ts := pdata.NewTimestampFromTime(start.Add(time.Duration(i) * 30 * time.Minute))
+			parent := parentSpans.Spans().AppendEmpty()
+			parent.SetTraceID(pdata.NewTraceID(traceID1))
+			parent.SetSpanID(pdata.NewSpanID(generateRandSpanID()))
+			parent.SetName("operationA")
+			parent.SetStartTimestamp(ts)
+			parent.SetEndTimestamp(ts)
+			for _, spans := range []pdata.SpanSlice{parentSpans.Spans(), childSpans.Spans()} {
+				child := spans.AppendEmpty()
+				child.SetTraceID(pdata.NewTraceID(traceID1))
+				child.SetSpanID(pdata.NewSpanID(generateRandSpanID()))
+				child.SetParentSpanID(parent.SpanID())
+				child.SetName("operationB")
+				child.SetStartTimestamp(ts)
+				child.SetEndTimestamp(ts)
+			}
+		}
+		err = ingestor.IngestTraces(context.Background(), td)
+		require.NoError(t, err)
+
+		q := query.New(pgxconn.NewQueryLoggingPgxConn(db))
+		checkDependencies := func() {
+			deps, err := q.GetDependencies(context.Background(), time.Now(), 4*time.Hour)
+			require.NoError(t, err)
+			require.Equal(t, []model.DependencyLink{{Parent: "service-name-0", Child: "service-name-1", CallCount: 4}}, deps)
+
+			deps, err = q.GetDependencies(context.Background(), start.Add(45*time.Minute), 30*time.Minute)
+			require.NoError(t, err)
+			require.Equal(t, []model.DependencyLink{{Parent: "service-name-0", Child: "service-name-1", CallCount: 1}}, deps)
+
+			deps, err = q.GetDependencies(context.Background(), start, time.Hour)
+			require.NoError(t, err)
+			require.Empty(t, deps)
+		}
+		// The dependencies are computed from the spans until the aggregate
+		// is refreshed by the maintenance jobs, and from the aggregate after.
+		checkDependencies()
+		dbJob := testhelpers.PgxPoolWithRole(t, *testDatabase, "prom_maintenance")
+		defer dbJob.Close()
+		_, err = dbJob.Exec(context.Background(), "CALL prom_api.execute_maintenance(log_verbose=>true)")
+		require.NoError(t, err)
+		var buckets int
+		err = db.QueryRow(context.Background(), "SELECT count(*) FROM _ps_trace.service_dependency").Scan(&buckets)
+		require.NoError(t, err)
+		require.Equal(t, 2, buckets)
+		checkDependencies()
+	})
+}
@@ -27,7 +27,7 @@ func TestInsertInCompressedChunks(t *testing.T) {
 			ingestor, err := ingstr.NewPgxIngestorForTests(pgxconn.NewPgxConn(db), nil)
 			require.NoError(t, err)
 			defer ingestor.Close()
-			_, _, err = ingestor.Ingest(newWriteRequestWithTs(copyMetrics(ts)))
+			_, _, err = ingestor.Ingest(context.Background(), newWriteRequestWithTs(copyMetrics(ts)))
 			require.NoError(t, err)
 			r, err := db.Query(context.Background(), "SELECT * from prom_data.\"firstMetric\";")
 			require.NoError(t, err)
@@ -60,7 +60,7 @@ func TestInsertInCompressedChunks(t *testing.T) {
 		ingestor, err := ingstr.NewPgxIngestorForTests(pgxconn.NewPgxConn(db), nil)
 		require.NoError(t, err)
 		defer ingestor.Close()
-		_, _, err = ingestor.Ingest(newWriteRequestWithTs(copyMetrics(ts)))
+		_, _, err = ingestor.Ingest(context.Background(), newWriteRequestWithTs(copyMetrics(ts)))
 		require.NoError(t, err)
 		err = ingestor.CompleteMetricCreation()
 		if err != nil {
@@ -70,7 +70,7 @@ func TestInsertInCompressedChunks(t *testing.T) {
 		require.NoError(t, err)
 
 		// Insert data into compressed chunk.
-		_, _, err = ingestor.Ingest(newWriteRequestWithTs(copyMetrics(sample)))
+		_, _, err = ingestor.Ingest(context.Background(), newWriteRequestWithTs(copyMetrics(sample)))
 		require.NoError(t, err)
 
 		r, err := db.Query(context.Background(), "SELECT * from prom_data.\"firstMetric\";")
@@ -89,7 +89,7 @@ func TestInsertInCompressedChunks(t *testing.T) {
 		ingestor, err := ingstr.NewPgxIngestorForTests(pgxconn.NewPgxConn(db), &ingstr.Cfg{IgnoreCompressedChunks: true})
 		require.NoError(t, err)
 		defer ingestor.Close()
-		_, _, err = ingestor.Ingest(newWriteRequestWithTs(copyMetrics(ts)))
+		_, _, err = ingestor.Ingest(context.Background(), newWriteRequestWithTs(copyMetrics(ts)))
 		require.NoError(t, err)
 		err = ingestor.CompleteMetricCreation()
 		if err != nil {
@@ -99,7 +99,7 @@ func TestInsertInCompressedChunks(t *testing.T) {
 		require.NoError(t, err)
 
 		// Insert data into compressed chunk.
-		_, _, err = ingestor.Ingest(newWriteRequestWithTs(copyMetrics(sample)))
+		_, _, err = ingestor.Ingest(context.Background(), newWriteRequestWithTs(copyMetrics(sample)))
 		require.NoError(t, err)
 
 		r, err := db.Query(context.Background(), "SELECT * from prom_data.\"firstMetric\";")
@@ -0,0 +1,99 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package end_to_end_tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/clockcache"
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgmodel/cache"
+	ingstr "github.com/timescale/promscale/pkg/pgmodel/ingestor"
+	"github.com/timescale/promscale/pkg/pgmodel/lreader"
+	"github.com/timescale/promscale/pkg/pgmodel/model"
+	"github.com/timescale/promscale/pkg/pgmodel/querier"
+	"github.com/timescale/promscale/pkg/pgxconn"
+	"github.com/timescale/promscale/pkg/prompb"
+	"github.com/timescale/promscale/pkg/query"
+)
+
+func TestNativeHistograms(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	withDB(t, *testDatabase, func(db *pgxpool.Pool, t testing.TB) {
+		ingestor, err := ingstr.NewPgxIngestorForTests(pgxconn.NewPgxConn(db), nil)
+		require.NoError(t, err)
+		defer ingestor.Close()
+
+		ts := []prompb.TimeSeries{
+			{
+				Labels: []prompb.Label{
+					{Name: model.MetricNameLabelName, Value: "req_duration"},
+					{Name: "job", Value: "test"},
+				},
+				Histograms: []prompb.Histogram{
+					{
+						Count:          &prompb.Histogram_CountInt{CountInt: 2},
+						Sum:            3,
+						ZeroCount:      &prompb.Histogram_ZeroCountInt{},
+						PositiveSpans:  []prompb.BucketSpan{{Offset: 1, Length: 1}},
+						PositiveDeltas: []int64{2},
+						Timestamp:      1000,
+					},
+					{
+						Count:          &prompb.Histogram_CountInt{CountInt: 4},
+						Sum:            9,
+						ZeroCount:      &prompb.Histogram_ZeroCountInt{},
+						PositiveSpans:  []prompb.BucketSpan{{Offset: 1, Length: 2}},
+						PositiveDeltas: []int64{2, 0},
+						Timestamp:      2000,
+					},
+				},
+			},
+		}
+		numInserted, _, err := ingestor.Ingest(context.Background(), newWriteRequestWithTs(copyMetrics(ts)))
+		require.NoError(t, err)
+		require.Equal(t, uint64(2), numInserted)
+
+		var count int
+		err = db.QueryRow(context.Background(), "SELECT count(*) FROM prom_data_histogram.req_duration").Scan(&count)
+		require.NoError(t, err)
+		require.Equal(t, 2, count)
+
+		// Native histograms must not be stored as float samples.
+		err = db.QueryRow(context.Background(), "SELECT count(*) FROM prom_data.req_duration").Scan(&count)
+		require.NoError(t, err)
+		require.Equal(t, 0, count)
+
+		dbConn := pgxconn.NewPgxConn(db)
+		mCache := &cache.MetricNameCache{Metrics: clockcache.WithMax(cache.DefaultMetricCacheSize)}
+		labelsReader := lreader.NewLabelsReader(dbConn, clockcache.WithMax(100))
+		r := querier.NewQuerier(dbConn, mCache, labelsReader, nil, nil)
+		queryable := query.NewQueryable(r, labelsReader)
+		queryEngine, err := query.NewEngine(log.GetLogger(), time.Minute, time.Minute*5, time.Minute, 50000000, []string{})
+		require.NoError(t, err)
+
+		for q, expected := range map[string]float64{
+			"req_duration_count":                           4,
+			"req_duration_sum":                             9,
+			`req_duration_bucket{le="2"}`:                  2,
+			"histogram_quantile(0.5, req_duration_bucket)": 2,
+		} {
+			qry, err := queryEngine.NewInstantQuery(queryable, q, time.Unix(2, 0))
+			require.NoError(t, err)
+			res := qry.Exec(context.Background())
+			require.NoError(t, res.Err, q)
+			vector, err := res.Vector()
+			require.NoError(t, err, q)
+			require.Len(t, vector, 1, q)
+			require.Equal(t, expected, vector[0].V, q)
+		}
+	})
+}
@@ -26,12 +26,15 @@ type labelsResponse struct {
 	Data   []string
 }
 
-func getLabelNamesRequest(apiUrl string) (*http.Request, error) {
+func getLabelNamesRequest(apiUrl string, matchers ...string) (*http.Request, error) {
 	u, err := url.Parse(fmt.Sprintf("%s/labels", apiUrl))
 
 	if err != nil {
 		return nil, err
 	}
+	if len(matchers) > 0 {
+		u.RawQuery = url.Values{"match[]": matchers}.Encode()
+	}
 
 	return http.NewRequest(
 		"GET",
@@ -40,12 +43,15 @@ func getLabelNamesRequest(apiUrl string) (*http.Request, error) {
 	)
 }
 
-func getLabelValuesRequest(apiUrl string, labelName string) (*http.Request, error) {
+func getLabelValuesRequest(apiUrl string, labelName string, matchers ...string) (*http.Request, error) {
 	u, err := url.Parse(fmt.Sprintf("%s/label/%s/values", apiUrl, labelName))
 
 	if err != nil {
 		return nil, err
 	}
+	if len(matchers) > 0 {
+		u.RawQuery = url.Values{"match[]": matchers}.Encode()
+	}
 
 	return http.NewRequest(
 		"GET",
@@ -130,6 +136,36 @@ func TestPromQLLabelEndpoint(t *testing.T) {
 		}
 		testMethod = testRequestConcurrent(requestCases, client, labelsResultComparator, true)
 		tester.Run("test label endpoint", testMethod)
+
+		requestCases = nil
+		for _, matchers := range [][]string{
+			{"metric_2"},
+			{`{foo="bar"}`},
+			{"metric_1", "metric_3"},
+			{"unexisting_metric"},
+		} {
+			tsReq, err = getLabelNamesRequest(tsURL, matchers...)
+			if err != nil {
+				t.Fatalf("unable to create TS PromQL label names request: %v", err)
+			}
+			promReq, err = getLabelNamesRequest(promURL, matchers...)
+			if err != nil {
+				t.Fatalf("unable to create Prometheus PromQL label names request: %v", err)
+			}
+			requestCases = append(requestCases, requestCase{tsReq, promReq, fmt.Sprintf("get label names for %v", matchers)})
+
+			tsReq, err = getLabelValuesRequest(tsURL, "instance", matchers...)
+			if err != nil {
+				t.Fatalf("unable to create TS PromQL label values request: %v", err)
+			}
+			promReq, err = getLabelValuesRequest(promURL, "instance", matchers...)
+			if err != nil {
+				t.Fatalf("unable to create Prometheus PromQL label values request: %v", err)
+			}
+			requestCases = append(requestCases, requestCase{tsReq, promReq, fmt.Sprintf("get instance values for %v", matchers)})
+		}
+		testMethod = testRequestConcurrent(requestCases, client, labelsResultComparator, true)
+		tester.Run("test label endpoint with matchers", testMethod)
 	})
 }
 
@@ -2370,6 +2370,30 @@ func TestPromQLQueryEndpoint(t *testing.T) {
 			name:  "stddev_over_time",
 			query: `stddev_over_time(metric_2[5m])`,
 		},
+		{
+			name:  "sum by over_time",
+			query: `sum by (instance) (avg_over_time(metric_1[5m]))`,
+		},
+		{
+			name:  "max without over_time",
+			query: `max without (foo) (max_over_time(metric_3[5m]))`,
+		},
+		{
+			name:  "count over_time",
+			query: `count(count_over_time(metric_2[5m]))`,
+		},
+		{
+			name:  "min by non-existant over_time",
+			query: `min by (nonexistant) (sum_over_time(metric_3[5m]))`,
+		},
+		{
+			name:  "avg by rate",
+			query: `avg by (instance) (rate(metric_2[5m]))`,
+		},
+		{
+			name:  "sum by increase",
+			query: `sum by (foo, instance) (increase(metric_1[5m]))`,
+		},
 		{
 			name:  "delta",
 			query: `delta(metric_3[5m])`,
@@ -0,0 +1,93 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package end_to_end_tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/clockcache"
+	"github.com/timescale/promscale/pkg/internal/testhelpers"
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgmodel/cache"
+	ingstr "github.com/timescale/promscale/pkg/pgmodel/ingestor"
+	"github.com/timescale/promscale/pkg/pgmodel/lreader"
+	"github.com/timescale/promscale/pkg/pgmodel/querier"
+	"github.com/timescale/promscale/pkg/pgxconn"
+	"github.com/timescale/promscale/pkg/query"
+)
+
+func TestQueryStats(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	withDB(t, *testDatabase, func(db *pgxpool.Pool, t testing.TB) {
+		ingestor, err := ingstr.NewPgxIngestorForTests(pgxconn.NewPgxConn(db), nil)
+		require.NoError(t, err)
+		defer ingestor.Close()
+		_, _, err = ingestor.Ingest(context.Background(), newWriteRequestWithTs(copyMetrics(generateSmallTimeseries())))
+		require.NoError(t, err)
+
+		readOnly := testhelpers.GetReadOnlyConnection(t, *testDatabase)
+		defer readOnly.Close()
+		mCache := &cache.MetricNameCache{Metrics: clockcache.WithMax(cache.DefaultMetricCacheSize)}
+		dbConn := pgxconn.NewPgxConn(readOnly)
+		labelsReader := lreader.NewLabelsReader(dbConn, clockcache.WithMax(100))
+		queryable := query.NewQueryable(querier.NewQuerier(dbConn, mCache, labelsReader, nil, nil), labelsReader)
+		queryEngine, err := query.NewEngine(log.GetLogger(), time.Minute, time.Minute*5, time.Minute, 50000000, []string{})
+		require.NoError(t, err)
+
+		testCases := []struct {
+			name    string
+			query   string
+			explain bool
+			tables  []string
+			queries int
+			series  int
+		}{
+			{
+				name:    "single metric",
+				query:   `firstMetric{foo="bar"}`,
+				tables:  []string{"prom_data.firstMetric"},
+				queries: 1,
+				series:  1,
+			},
+			{
+				name:    "multiple metrics",
+				query:   `{common="tag"}`,
+				explain: true,
+				tables:  []string{"prom_data.firstMetric", "prom_data.secondMetric"},
+				queries: 3,
+				series:  2,
+			},
+		}
+		for _, c := range testCases {
+			qs := querier.NewQueryStats(c.explain)
+			qry, err := queryEngine.NewInstantQuery(queryable, c.query, model.Time(5).Time())
+			require.NoError(t, err, c.name)
+			res := qry.Exec(querier.WithQueryStats(context.Background(), qs))
+			require.NoError(t, res.Err, c.name)
+
+			selectors := qs.Selectors()
+			require.Len(t, selectors, 1, c.name)
+			s := selectors[0]
+			require.Equal(t, c.query, s.Selector, c.name)
+			require.ElementsMatch(t, c.tables, s.Tables, c.name)
+			require.Len(t, s.Queries, c.queries, c.name)
+			require.Equal(t, c.series, s.Series, c.name)
+			require.Equal(t, 5*c.series, s.Samples, c.name)
+			require.Empty(t, s.Pushdown, c.name)
+			for _, q := range s.Queries {
+				require.NotEmpty(t, q.SQL, c.name)
+				require.Equal(t, c.explain, len(q.Plan) > 0, c.name)
+			}
+		}
+	})
+}
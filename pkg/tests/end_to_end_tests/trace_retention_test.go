@@ -0,0 +1,118 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package end_to_end_tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/internal/testhelpers"
+	ingstr "github.com/timescale/promscale/pkg/pgmodel/ingestor"
+	"github.com/timescale/promscale/pkg/pgxconn"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+func TestTraceRetention(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	withDB(t, *testDatabase, func(db *pgxpool.Pool, t testing.TB) {
+		ingestor, err := ingstr.NewPgxIngestorForTests(pgxconn.NewPgxConn(db), nil)
+		require.NoError(t, err)
+		defer ingestor.Close()
+
+		// The spans of the test trace are from 2020, in service-name-0.
+		err = ingestor.IngestTraces(context.Background(), generateTestTrace())
+		require.NoError(t, err)
+		recent := pdata.NewTraces()
+		rs := recent.ResourceSpans().AppendEmpty()
+		initResourceAttributes(rs.Resource().Attributes(), 1)
+		libSpans := rs.InstrumentationLibrarySpans().AppendEmpty()
+		initInstLib(libSpans, 0)
+		for _, age := range []time.Duration{72 * time.Hour, 48 * time.Hour, time.Hour} {
+			ts := pdata.NewTimestampFromTime(time.Now().Add(-age))
+			span := libSpans.Spans().AppendEmpty()
+			span.SetTraceID(pdata.NewTraceID(traceID2))
+			span.SetSpanID(pdata.NewSpanID(generateRandSpanID()))
+			span.SetName("operationC")
+			span.SetStartTimestamp(ts)
+			span.SetEndTimestamp(ts)
+		}
+		err = ingestor.IngestTraces(context.Background(), recent)
+		require.NoError(t, err)
+
+		dbJob := testhelpers.PgxPoolWithRole(t, *testDatabase, "prom_maintenance")
+		defer dbJob.Close()
+		executeMaintenance := func() {
+			_, err := dbJob.Exec(context.Background(), "CALL prom_api.execute_maintenance(log_verbose=>true)")
+			require.NoError(t, err)
+		}
+		count := func(query string, args ...interface{}) int {
+			var c int
+			require.NoError(t, db.QueryRow(context.Background(), query, args...).Scan(&c), query)
+			return c
+		}
+
+		// Without a retention period, the traces are kept forever.
+		spans := count("SELECT count(*) FROM _ps_trace.span")
+		_, err = db.Exec(context.Background(), "SELECT ps_trace.reset_trace_retention_period()")
+		require.NoError(t, err)
+		require.Equal(t, 1, count("SELECT (ps_trace.get_trace_retention_period() IS NULL)::int"))
+		executeMaintenance()
+		require.Equal(t, spans, count("SELECT count(*) FROM _ps_trace.span"))
+
+		_, err = db.Exec(context.Background(), "SELECT ps_trace.set_trace_retention_period('-1 day')")
+		require.Error(t, err, "the retention period must be positive")
+		_, err = db.Exec(context.Background(), "SELECT ps_trace.set_trace_retention_period('7 days')")
+		require.NoError(t, err)
+		var retentionS float64
+		err = db.QueryRow(context.Background(), "SELECT EXTRACT(epoch FROM ps_trace.get_trace_retention_period())").Scan(&retentionS)
+		require.NoError(t, err)
+		require.Equal(t, (7 * 24 * time.Hour).Seconds(), retentionS)
+
+		executeMaintenance()
+		require.Equal(t, 3, count("SELECT count(*) FROM _ps_trace.span"))
+		require.Equal(t, 0, count("SELECT count(*) FROM _ps_trace.event"))
+		require.Equal(t, 0, count("SELECT count(*) FROM _ps_trace.link"))
+
+		if *useTimescaleDB && *useTimescale2 && !*useTimescaleOSS && !*useMultinode {
+			// The compression age replaces the compression policies.
+			const policies = `SELECT count(*) FROM timescaledb_information.jobs
+				WHERE proc_name = 'policy_compression' AND hypertable_schema = '_ps_trace'
+				AND (config->>'compress_after')::interval = $1::interval`
+			require.Equal(t, 3, count(policies, "1 hour"))
+			_, err = db.Exec(context.Background(), "SELECT ps_trace.set_trace_compress_after('1 day')")
+			require.NoError(t, err)
+			require.Equal(t, 0, count(policies, "1 hour"))
+			require.Equal(t, 3, count(policies, "1 day"))
+		}
+
+		// The tags and operations of the dropped spans are deleted by the
+		// maintenance jobs an hour after the spans are dropped, unless they
+		// are fetched again in the meantime.
+		const serviceOperations = `SELECT count(*)
+			FROM _ps_trace.operation o
+			INNER JOIN _ps_trace.tag t ON (t.id = o.service_name_id)
+			WHERE t.key = 'service.name' AND t.value = to_jsonb($1::text)`
+		require.Equal(t, 2, count(serviceOperations, "service-name-0"))
+		_, err = db.Exec(context.Background(), "SELECT ps_trace.put_tag('span-attr', to_jsonb('span-attr-val'::text), ps_trace.span_tag_type())")
+		require.NoError(t, err)
+		_, err = db.Exec(context.Background(), "UPDATE _ps_trace.orphan_cleanup SET marked_at = marked_at - INTERVAL '1 hour'")
+		require.NoError(t, err)
+		executeMaintenance()
+		require.Equal(t, 0, count("SELECT count(*) FROM _ps_trace.orphan_cleanup"))
+		require.Equal(t, 0, count("SELECT count(*) FROM _ps_trace.orphan_cleanup_kept_tag"))
+		require.Equal(t, 0, count(serviceOperations, "service-name-0"))
+		require.Equal(t, 1, count(serviceOperations, "service-name-1"))
+		require.Equal(t, 1, count("SELECT count(*) FROM _ps_trace.tag WHERE key = 'span-attr'"))
+		require.Equal(t, 0, count("SELECT count(*) FROM _ps_trace.tag WHERE key IN ('span-event-attr', 'span-link-attr')"))
+		require.Equal(t, 0, count("SELECT count(*) FROM _ps_trace.tag WHERE key = 'service.name' AND value = to_jsonb('service-name-0'::text)"))
+		require.Equal(t, 3, count("SELECT count(*) FROM _ps_trace.span"))
+	})
+}
@@ -0,0 +1,74 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package end_to_end_tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/internal/testhelpers"
+	"github.com/timescale/promscale/pkg/pgmodel/cardinality"
+	ingstr "github.com/timescale/promscale/pkg/pgmodel/ingestor"
+	"github.com/timescale/promscale/pkg/pgxconn"
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+func TestTSDBStatus(t *testing.T) {
+	series := func(name, job, instance string) prompb.TimeSeries {
+		return prompb.TimeSeries{
+			Labels: []prompb.Label{
+				{Name: "__name__", Value: name},
+				{Name: "instance", Value: instance},
+				{Name: "job", Value: job},
+			},
+			Samples: []prompb.Sample{{Timestamp: 1000, Value: 1}},
+		}
+	}
+	ts := []prompb.TimeSeries{
+		series("cpu", "a", "1"),
+		series("cpu", "a", "2"),
+		series("cpu", "b", "1"),
+		series("mem", "a", "1"),
+	}
+
+	withDB(t, *testDatabase, func(dbOwner *pgxpool.Pool, t testing.TB) {
+		db := testhelpers.PgxPoolWithRole(t, *testDatabase, "prom_writer")
+		defer db.Close()
+
+		ingestor, err := ingstr.NewPgxIngestorForTests(pgxconn.NewPgxConn(db), nil)
+		require.NoError(t, err)
+		defer ingestor.Close()
+		_, _, err = ingestor.Ingest(context.Background(), newWriteRequestWithTs(ts))
+		require.NoError(t, err)
+
+		readerDB := testhelpers.PgxPoolWithRole(t, *testDatabase, "prom_reader")
+		defer readerDB.Close()
+		conn := pgxconn.NewPgxConn(readerDB)
+
+		status, err := cardinality.Status(context.Background(), conn, 10, nil)
+		require.NoError(t, err)
+		require.Equal(t, cardinality.HeadStats{NumSeries: 4, NumLabelPairs: 6}, status.HeadStats)
+		require.Equal(t, []cardinality.Stat{{Name: "cpu", Value: 3}, {Name: "mem", Value: 1}}, status.SeriesCountByMetricName)
+		require.Equal(t, []cardinality.Stat{{Name: "__name__", Value: 2}, {Name: "instance", Value: 2}, {Name: "job", Value: 2}}, status.LabelValueCountByLabelName)
+		require.Equal(t, []cardinality.Stat{{Name: "__name__", Value: 12}, {Name: "instance", Value: 4}, {Name: "job", Value: 4}}, status.MemoryInBytesByLabelName)
+		require.Equal(t, []cardinality.Stat{
+			{Name: "__name__=cpu", Value: 3},
+			{Name: "instance=1", Value: 3},
+			{Name: "job=a", Value: 3},
+			{Name: "__name__=mem", Value: 1},
+			{Name: "instance=2", Value: 1},
+			{Name: "job=b", Value: 1},
+		}, status.SeriesCountByLabelValuePair)
+
+		status, err = cardinality.Status(context.Background(), conn, 1, nil)
+		require.NoError(t, err)
+		require.Equal(t, []cardinality.Stat{{Name: "cpu", Value: 3}}, status.SeriesCountByMetricName)
+		require.Equal(t, []cardinality.Stat{{Name: "__name__", Value: 2}}, status.LabelValueCountByLabelName)
+		require.Equal(t, []cardinality.Stat{{Name: "__name__", Value: 12}}, status.MemoryInBytesByLabelName)
+		require.Equal(t, []cardinality.Stat{{Name: "__name__=cpu", Value: 3}}, status.SeriesCountByLabelValuePair)
+	})
+}
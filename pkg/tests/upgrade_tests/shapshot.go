@@ -44,6 +44,7 @@ var schemas = []string{
 	"prom_api",
 	"prom_data",
 	"prom_data_exemplar",
+	"prom_data_histogram",
 	"prom_data_series",
 	"prom_info",
 	"prom_metric",
@@ -66,6 +67,7 @@ var schemasWOTimescaleDB = []string{
 	"prom_api",
 	"prom_data",
 	"prom_data_exemplar",
+	"prom_data_histogram",
 	"prom_data_series",
 	"prom_info",
 	"prom_metric",
@@ -83,6 +85,7 @@ var ourSchemas = []string{
 	"prom_api",
 	"prom_data",
 	"prom_data_exemplar",
+	"prom_data_histogram",
 	"prom_data_series",
 	"prom_info",
 	"prom_metric",
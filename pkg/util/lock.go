@@ -322,3 +322,120 @@ func checkConnection(ctx context.Context, conn *pgx.Conn) error {
 	}
 	return nil
 }
+
+// PgLeaderLockSession holds the advisory locks of several leader elections on
+// a single database session, instead of a connection per election. All the
+// locks are lost together when the session is.
+type PgLeaderLockSession struct {
+	lock PgAdvisoryLock
+	held map[int64]bool
+
+	mutex sync.Mutex
+}
+
+// NewPgLeaderLockSession returns a new session. The connection is opened on
+// the first lock attempt.
+func NewPgLeaderLockSession(connStr string, afterConnect AfterConnectFunc) *PgLeaderLockSession {
+	if afterConnect == nil {
+		afterConnect = checkConnection
+	}
+	return &PgLeaderLockSession{
+		lock: PgAdvisoryLock{
+			connStr:      connStr,
+			afterConnect: afterConnect,
+		},
+		held: make(map[int64]bool),
+	}
+}
+
+// NewLeaderLock returns the election for the given lock ID on the session.
+func (s *PgLeaderLockSession) NewLeaderLock(groupLockID int64) *PgSessionLeaderLock {
+	return &PgSessionLeaderLock{session: s, groupLockID: groupLockID}
+}
+
+// Close closes the session, releasing all the locks.
+func (s *PgLeaderLockSession) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.reset()
+}
+
+func (s *PgLeaderLockSession) tryLock(groupLockID int64) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.lock.ensureConnInit(); err != nil {
+		s.reset()
+		return false, err
+	}
+	if s.held[groupLockID] {
+		// we already hold the lock verify the connection
+		if err := s.lock.conn.QueryRow(context.Background(), "SELECT").Scan(); err != nil {
+			s.reset()
+			return false, err
+		}
+		return true, nil
+	}
+	gotLock, err := runLockFunction(context.Background(), s.lock.conn, "SELECT pg_try_advisory_lock($1)", groupLockID)
+	if err != nil {
+		s.reset()
+		return false, err
+	}
+	if gotLock {
+		s.held[groupLockID] = true
+	}
+	return gotLock, nil
+}
+
+func (s *PgLeaderLockSession) release(groupLockID int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.held[groupLockID] {
+		return fmt.Errorf("can't release while not holding the lock")
+	}
+	delete(s.held, groupLockID)
+	unlocked, err := runLockFunction(context.Background(), s.lock.conn, "SELECT pg_advisory_unlock($1)", groupLockID)
+	if err != nil {
+		s.reset()
+		return err
+	}
+	if !unlocked {
+		log.Debug("msg", fmt.Sprintf("release for a lock that was not held: group id %d", groupLockID))
+	}
+	return nil
+}
+
+// reset closes the connection. The locks of a new session have to be
+// obtained again.
+func (s *PgLeaderLockSession) reset() {
+	s.lock.connCleanUp()
+	s.held = make(map[int64]bool)
+}
+
+// PgSessionLeaderLock is a leader election based on an advisory lock held on
+// a PgLeaderLockSession.
+type PgSessionLeaderLock struct {
+	session     *PgLeaderLockSession
+	groupLockID int64
+}
+
+// ID returns the group lock ID for this instance.
+func (l *PgSessionLeaderLock) ID() string {
+	return strconv.FormatInt(l.groupLockID, 10)
+}
+
+// BecomeLeader tries to become a leader by acquiring the lock.
+func (l *PgSessionLeaderLock) BecomeLeader() (bool, error) {
+	return l.session.tryLock(l.groupLockID)
+}
+
+// IsLeader returns the current leader status for this instance.
+func (l *PgSessionLeaderLock) IsLeader() (bool, error) {
+	return l.session.tryLock(l.groupLockID)
+}
+
+// Resign releases the leader status of this instance.
+func (l *PgSessionLeaderLock) Resign() error {
+	return l.session.release(l.groupLockID)
+}
@@ -62,3 +62,42 @@ func ParseEnv(p string, fs *flag.FlagSet) error {
 
 	return err
 }
+
+// SanitizeName replaces all the characters which are not valid in Prometheus
+// metric (or label, if allowColon is false) names with underscores, and
+// prefixes the names starting with a digit with an underscore.
+func SanitizeName(name string, allowColon bool) string {
+	if name == "" {
+		return name
+	}
+	sanitized := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		case r == ':' && allowColon:
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+	if sanitized[0] >= '0' && sanitized[0] <= '9' {
+		sanitized = "_" + sanitized
+	}
+	return sanitized
+}
+
+// SanitizeLabelName returns the label name of a key coming from another data
+// model, or an empty string if the key has no valid characters. The keys which
+// would start with "__" once sanitized are prefixed with "key", as label names
+// starting with "__" are reserved for internal use and could override the
+// metric name.
+func SanitizeLabelName(key string) string {
+	name := SanitizeName(key, false)
+	if strings.Trim(name, "_") == "" {
+		return ""
+	}
+	if strings.HasPrefix(name, "__") {
+		name = "key" + name
+	}
+	return name
+}
@@ -125,3 +125,38 @@ func TestParseEnv(t *testing.T) {
 	}
 
 }
+
+func TestSanitizeName(t *testing.T) {
+	testCases := []struct {
+		name       string
+		allowColon bool
+		expected   string
+	}{
+		{name: "http.server.duration", allowColon: true, expected: "http_server_duration"},
+		{name: "a:b", allowColon: true, expected: "a:b"},
+		{name: "a:b", expected: "a_b"},
+		{name: "0abc", expected: "_0abc"},
+		{name: "", expected: ""},
+	}
+	for _, c := range testCases {
+		if got := SanitizeName(c.name, c.allowColon); got != c.expected {
+			t.Errorf("unexpected result for %q: got %q, expected %q", c.name, got, c.expected)
+		}
+	}
+}
+
+func TestSanitizeLabelName(t *testing.T) {
+	testCases := map[string]string{
+		"http.method": "http_method",
+		"_private":    "_private",
+		"__name__":    "key__name__",
+		".-a":         "key__a",
+		"":            "",
+		"...":         "",
+	}
+	for key, expected := range testCases {
+		if got := SanitizeLabelName(key); got != expected {
+			t.Errorf("unexpected result for %q: got %q, expected %q", key, got, expected)
+		}
+	}
+}